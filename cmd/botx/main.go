@@ -36,6 +36,10 @@ func main() {
 						Usage:   "Config file path",
 						Value:   "./botx.yaml",
 					},
+					&cli.StringFlag{
+						Name:  "test-driver",
+						Usage: "Also generate a typed test driver at this path, e.g. ./botx_driver_test.go",
+					},
 				},
 				Action: func(c *cli.Context) error {
 					configPath := c.String("config")
@@ -60,7 +64,18 @@ func main() {
 					if err := os.MkdirAll(filepath.Dir(resolved), 0o755); err != nil {
 						return err
 					}
-					return os.WriteFile(resolved, content, 0o644)
+					if err := os.WriteFile(resolved, content, 0o644); err != nil {
+						return err
+					}
+					driverPath := c.String("test-driver")
+					if driverPath == "" {
+						return nil
+					}
+					driver, err := codegen.GenerateTestDriver(doc)
+					if err != nil {
+						return err
+					}
+					return os.WriteFile(driverPath, driver, 0o644)
 				},
 			},
 		},
@@ -0,0 +1,417 @@
+package codegen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// GenerateTestDriver generates a typed test driver for doc. The driver is built on bottest and meant to be
+// written to a _test.go file next to the generated code.
+func GenerateTestDriver(doc *Doc) ([]byte, error) {
+	gen := newGeneratorContext(doc)
+	if err := gen.prepare(); err != nil {
+		return nil, err
+	}
+	content, err := gen.renderTestDriver()
+	if err != nil {
+		return nil, err
+	}
+	return format.Source(content)
+}
+
+func (g *generatorContext) renderTestDriver() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := &codeWriter{buf: buf}
+	packageName := strings.TrimSpace(g.doc.Package)
+	if packageName == "" {
+		packageName = "botx"
+	}
+	w.line("package %s", packageName)
+	w.line("")
+	w.line("import (")
+	w.line("\t\"context\"")
+	w.line("\t\"errors\"")
+	w.line("\t\"fmt\"")
+	w.line("\t\"net/url\"")
+	w.line("\t\"sync\"")
+	w.line("\t\"testing\"")
+	w.line("")
+	w.line("\t\"github.com/anclax/botx/pkg/core/bot\"")
+	w.line("\t\"github.com/anclax/botx/pkg/core/bot/bottest\"")
+	w.line("\t\"github.com/anclax/botx/pkg/core/session\"")
+	w.line(")")
+	w.line("")
+	if err := renderTemplate(w, "testDriver", testDriverTemplate, nil, nil); err != nil {
+		return nil, err
+	}
+	g.renderTestDriverState(w)
+	g.renderTestDriverPages(w)
+	if err := g.renderSnapshots(w); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+const testDriverTemplate = `// TestDriver drives the bot through an in-memory connector. It has one method per page and form, so
+// tests stop compiling when botx.yaml changes.
+type TestDriver struct {
+	Conn *bottest.Connector
+	Chat *bottest.Chat
+
+	states *testDriverStates
+}
+
+// NewTestDriver registers the bot on a bottest connector with a memory session manager and returns a
+// driver for chat 1.
+func NewTestDriver(t testing.TB, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler) *TestDriver {
+	t.Helper()
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		t.Fatalf("session manager: %v", err)
+	}
+	conn, err := bottest.New(sm)
+	if err != nil {
+		t.Fatalf("bottest connector: %v", err)
+	}
+	states := &testDriverStates{StateProvider: stateProvider, last: make(map[int64]testDriverState)}
+	Register(conn, sm, states, formValidator, handler, commandHandler)
+	return &TestDriver{Conn: conn, Chat: conn.Chat(1), states: states}
+}
+
+// WithChat returns a driver for another chat of the same bot.
+func (d *TestDriver) WithChat(chatID int64) *TestDriver {
+	return &TestDriver{Conn: d.Conn, Chat: d.Conn.Chat(chatID), states: d.states}
+}
+
+// Back presses the back button.
+func (d *TestDriver) Back(ctx context.Context) error {
+	return d.route(ctx, "back")
+}
+
+func (d *TestDriver) route(ctx context.Context, route string) error {
+	return d.do(func() error {
+		return d.Chat.SendCallbackData(ctx, bot.RouteCallbackData(route))
+	})
+}
+
+// do runs fn and returns the first error the handler returned meanwhile.
+func (d *TestDriver) do(fn func() error) error {
+	before := len(d.Chat.Errors())
+	if err := fn(); err != nil {
+		return err
+	}
+	if errs := d.Chat.Errors(); len(errs) > before {
+		return errs[before]
+	}
+	return nil
+}
+
+func (d *TestDriver) lastState(page string) any {
+	d.states.mu.Lock()
+	defer d.states.mu.Unlock()
+	last, ok := d.states.last[d.Chat.ID()]
+	if !ok || last.page != page {
+		return nil
+	}
+	return last.state
+}
+
+type testDriverState struct {
+	page  string
+	state any
+}
+
+// testDriverStates records the last state provided in each chat.
+type testDriverStates struct {
+	StateProvider
+
+	mu   sync.Mutex
+	last map[int64]testDriverState
+}
+
+func (s *testDriverStates) record(chatID int64, page string, state any, err error) {
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[chatID] = testDriverState{page: page, state: state}
+}
+
+`
+
+func (g *generatorContext) renderTestDriverState(w *codeWriter) {
+	for _, page := range g.pages {
+		if page.Page.Form != nil {
+			w.line("func (s *testDriverStates) Provide%sState(ctx context.Context, chatID int64, form *Form%s, parameters *ParametersPage%s) (*StatePage%s, error) {", page.Name, page.Name, page.Name, page.Name)
+			w.line("\tstate, err := s.StateProvider.Provide%sState(ctx, chatID, form, parameters)", page.Name)
+		} else {
+			w.line("func (s *testDriverStates) Provide%sState(ctx context.Context, chatID int64, parameters *ParametersPage%s) (*StatePage%s, error) {", page.Name, page.Name, page.Name)
+			w.line("\tstate, err := s.StateProvider.Provide%sState(ctx, chatID, parameters)", page.Name)
+		}
+		w.line("\ts.record(chatID, %q, state, err)", page.Path)
+		w.line("\treturn state, err")
+		w.line("}")
+		w.line("")
+	}
+}
+
+func (g *generatorContext) renderTestDriverPages(w *codeWriter) {
+	for _, page := range g.pages {
+		args, routeExpr := testDriverRoute(page)
+		params := append([]string{"ctx context.Context"}, args...)
+		if len(page.QueryParams) != 0 {
+			params = append(params, fmt.Sprintf("params ...%sParams", page.Name))
+		}
+
+		if page.Page.Form != nil {
+			w.line("// Open%s opens the form of %s.", page.Name, page.Path)
+		} else {
+			w.line("// Open%s opens %s.", page.Name, page.Path)
+		}
+		w.line("func (d *TestDriver) Open%s(%s) error {", page.Name, strings.Join(params, ", "))
+		w.line("\treturn d.route(ctx, %s)", routeExpr)
+		w.line("}")
+		w.line("")
+
+		if page.Page.Form != nil {
+			form := page.Page.Form
+			submitParams := append([]string{"ctx context.Context"}, args...)
+			submitParams = append(submitParams, fmt.Sprintf("form Form%s", page.Name))
+			if len(page.QueryParams) != 0 {
+				submitParams = append(submitParams, fmt.Sprintf("params ...%sParams", page.Name))
+			}
+			openArgs := []string{"ctx"}
+			for _, arg := range pathArgs(page) {
+				openArgs = append(openArgs, arg.GoName)
+			}
+			if len(page.QueryParams) != 0 {
+				openArgs = append(openArgs, "params...")
+			}
+			w.line("// Submit%s opens the form of %s and fills in every field.", page.Name, page.Path)
+			w.line("func (d *TestDriver) Submit%s(%s) error {", page.Name, strings.Join(submitParams, ", "))
+			w.line("\tif err := d.Open%s(%s); err != nil {", page.Name, strings.Join(openArgs, ", "))
+			w.line("\t\treturn err")
+			w.line("\t}")
+			w.line("\treturn d.do(func() error {")
+			w.line("\t\treturn d.Chat.FillForm(ctx, bot.FormValues{")
+			for _, field := range sortedFormFields(form) {
+				w.line("\t\t\t%q: form.%s,", field.name, field.goName)
+			}
+			w.line("\t\t})")
+			w.line("\t})")
+			w.line("}")
+			w.line("")
+		}
+
+		w.line("// LastPage%s returns the state of %s if it is the last page provided in the chat, or nil.", page.Name, page.Path)
+		w.line("func (d *TestDriver) LastPage%s() *StatePage%s {", page.Name, page.Name)
+		w.line("\tstate, _ := d.lastState(%q).(*StatePage%s)", page.Path, page.Name)
+		w.line("\treturn state")
+		w.line("}")
+		w.line("")
+	}
+}
+
+func testDriverRoute(page pageInfo) ([]string, string) {
+	var args, names []string
+	for _, arg := range pathArgs(page) {
+		args = append(args, fmt.Sprintf("%s %s", arg.GoName, arg.GoType))
+		names = append(names, arg.GoName)
+	}
+	if len(page.QueryParams) != 0 {
+		names = append(names, "params...")
+	}
+	return args, fmt.Sprintf("Route%s(%s)", page.Name, strings.Join(names, ", "))
+}
+
+const snapshotsTemplate = `// snapshots
+
+// SnapshotFixture is a state a page is rendered with for snapshots. Route fills the page parameters and
+// defaults to the page path.
+type SnapshotFixture[S any] struct {
+	Name  string
+	Route string
+	State *S
+}
+
+// SnapshotFixtures holds the fixtures of each page. Pages without fixtures are rendered once with a zero
+// state; form pages render their form, followed by the view when State is set.
+type SnapshotFixtures struct {
+{{- range .Pages }}
+	{{ .Name }} []SnapshotFixture[StatePage{{ .Name }}]
+{{- end }}
+}
+
+var snapshotLanguages = []string{ {{- range $i, $lang := .Languages }}{{ if $i }}, {{ end }}{{ printf "%q" $lang }}{{ end -}} }
+
+// RenderSnapshots renders every page in every language and returns the snapshots keyed by file name,
+// e.g. "TodoID.default.en.txt".
+func RenderSnapshots(ctx context.Context, fixtures SnapshotFixtures) (map[string]string, error) {
+	snapshots := make(map[string]string)
+	for _, lang := range snapshotLanguages {
+		ctx := bot.WithLanguage(ctx, lang)
+{{- range .Pages }}
+		for _, fixture := range snapshotDefaults(fixtures.{{ .Name }}, {{ printf "%q" .Route }}, {{ not .HasForm }}) {
+			if err := renderSnapshot(snapshots, {{ printf "%q" .Name }}, {{ printf "%q" .Path }}, lang, fixture.Name, fixture.Route, func(p *PageRenderer, u *url.URL) error {
+				return snapshotPage{{ .Name }}(ctx, p, u, fixture.State)
+			}); err != nil {
+				return nil, err
+			}
+		}
+{{- end }}
+		if err := renderSnapshot(snapshots, "Error", "/error", lang, "default", "/error", func(p *PageRenderer, _ *url.URL) error {
+			return p.pageError(ctx, 1, errors.New("something went wrong"))
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return snapshots, nil
+}
+
+// AssertSnapshots compares RenderSnapshots with the golden files in dir. Run the tests with
+// BOTX_UPDATE_SNAPSHOTS=1 to write them.
+func AssertSnapshots(t testing.TB, dir string, fixtures SnapshotFixtures) {
+	t.Helper()
+	snapshots, err := RenderSnapshots(context.Background(), fixtures)
+	if err != nil {
+		t.Fatalf("render snapshots: %v", err)
+	}
+	bottest.AssertGoldenDir(t, dir, snapshots)
+}
+
+func snapshotDefaults[S any](fixtures []SnapshotFixture[S], route string, zeroState bool) []SnapshotFixture[S] {
+	if len(fixtures) == 0 {
+		fixtures = make([]SnapshotFixture[S], 1)
+	}
+	result := make([]SnapshotFixture[S], 0, len(fixtures))
+	for _, fixture := range fixtures {
+		if fixture.Name == "" {
+			fixture.Name = "default"
+		}
+		if fixture.Route == "" {
+			fixture.Route = route
+		}
+		if fixture.State == nil && zeroState {
+			fixture.State = new(S)
+		}
+		result = append(result, fixture)
+	}
+	return result
+}
+
+func renderSnapshot(snapshots map[string]string, page string, path string, lang string, name string, route string, render func(p *PageRenderer, u *url.URL) error) error {
+	u, err := url.Parse(route)
+	if err != nil {
+		return fmt.Errorf("snapshot %s %s: %w", path, name, err)
+	}
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		return err
+	}
+	conn, err := bottest.New(sm)
+	if err != nil {
+		return err
+	}
+	if err := render(&PageRenderer{b: bot.NewBot(conn, bot.WithBotSessionManager(sm))}, u); err != nil {
+		return fmt.Errorf("snapshot %s %s: %w", path, name, err)
+	}
+	title := path + " " + name + " " + route
+	file := page + "." + name
+	if lang != "" {
+		title += " [" + lang + "]"
+		file += "." + lang
+	}
+	chat := conn.Chat(1)
+	snapshots[file+".txt"] = bottest.Snapshot(title, chat.Messages(), chat.Form())
+	return nil
+}
+
+`
+
+type snapshotTemplatePage struct {
+	Name    string
+	Path    string
+	Route   string
+	HasForm bool
+}
+
+func (g *generatorContext) renderSnapshots(w *codeWriter) error {
+	pages := make([]snapshotTemplatePage, 0, len(g.pages))
+	for _, page := range g.pages {
+		pages = append(pages, snapshotTemplatePage{
+			Name:    page.Name,
+			Path:    page.Path,
+			Route:   snapshotRoute(page),
+			HasForm: page.Page.Form != nil,
+		})
+	}
+	languages := i18nLanguages(g.i18n)
+	if len(languages) == 0 {
+		languages = []string{""}
+	}
+	data := struct {
+		Pages     []snapshotTemplatePage
+		Languages []string
+	}{Pages: pages, Languages: languages}
+	if err := renderTemplate(w, "snapshots", snapshotsTemplate, data, nil); err != nil {
+		return err
+	}
+
+	for _, page := range g.pages {
+		w.line("func snapshotPage%s(ctx context.Context, p *PageRenderer, u *url.URL, state *StatePage%s) error {", page.Name, page.Name)
+		args := "u"
+		if pathHasParams(page.Path) {
+			params := "_"
+			if len(page.PathParams) != 0 {
+				params = "params"
+				args = "params"
+				if len(page.QueryParams) != 0 {
+					args = "u, params"
+				}
+			}
+			w.line("	pattern, %s, ok := pageRouter.Match(u.Path)", params)
+			w.line("	if !ok || pattern != %q {", page.Path)
+			w.line("		return fmt.Errorf(\"route %%s does not match %%s\", u, %q)", page.Path)
+			w.line("	}")
+		}
+		w.line("	parameters, err := ParseParametersPage%s(%s)", page.Name, args)
+		w.line("	if err != nil {")
+		w.line("		return err")
+		w.line("	}")
+		if page.Page.Form != nil {
+			if page.Page.Form.State != nil {
+				// forms are rendered with a zero form state, so defaults show their empty values
+				w.line("	if err := p.form%s(ctx, 1, u, parameters, new(StateForm%s)); err != nil {", page.Name, page.Name)
+			} else {
+				w.line("	if err := p.form%s(ctx, 1, u, parameters); err != nil {", page.Name)
+			}
+			w.line("		return err")
+			w.line("	}")
+			w.line("	if state == nil {")
+			w.line("		return nil")
+			w.line("	}")
+		}
+		w.line("	return p.page%s(ctx, 1, state, parameters)", page.Name)
+		w.line("}")
+		w.line("")
+	}
+	return nil
+}
+
+// snapshotRoute returns the route a page is rendered at without a fixture: its path with placeholders
+// set to a value their parameter accepts, such as 0 for numbers and the parameter name for strings.
+func snapshotRoute(page pageInfo) string {
+	args := pathArgs(page)
+	var route strings.Builder
+	last := 0
+	for i, placeholder := range pathPlaceholders(page.Path) {
+		route.WriteString(page.Path[last:placeholder.start])
+		route.WriteString(args[i].Scalar.sampleValue(args[i].Name))
+		last = placeholder.end
+	}
+	route.WriteString(page.Path[last:])
+	return route.String()
+}
@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"go/format"
+	"math"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 	"unicode"
 
+	"github.com/anclax/botx/pkg/core/routepath"
 	"github.com/getkin/kin-openapi/openapi3"
 )
 
@@ -61,21 +66,19 @@ type generatorContext struct {
 	components map[string]schemaInfo
 	validators []validatorInfo
 	handlers   []handlerInfo
+	deepLinks  []deepLinkInfo
 	api        []apiInfo
 	i18n       *I18n
 	i18nKeys   map[string]struct{}
 }
 
 type pageInfo struct {
-	Path         string
-	Name         string
-	Page         Page
-	Params       []paramInfo
-	PathParams   []paramInfo
-	QueryParams  []paramInfo
-	MatcherName  string
-	MatcherOk    string
-	MatcherParam string
+	Path        string
+	Name        string
+	Page        Page
+	Params      []paramInfo
+	PathParams  []paramInfo
+	QueryParams []paramInfo
 }
 
 type paramInfo struct {
@@ -84,8 +87,12 @@ type paramInfo struct {
 	GetterName     string
 	In             string
 	GoType         string
+	Scalar         paramScalar
+	Array          bool
 	Required       bool
 	DefaultLiteral string
+	// DefaultVar is set when the default cannot be a constant, such as a date or an array.
+	DefaultVar bool
 }
 
 type fieldInfo struct {
@@ -111,6 +118,16 @@ type handlerInfo struct {
 	MatchType   string
 	HandlerType string
 	MethodName  string
+	Description StringExpr
+	Scopes      []string
+}
+
+type deepLinkInfo struct {
+	Payload     string
+	Route       string
+	MatcherName string
+	BuilderName string
+	Args        []paramInfo
 }
 
 type apiInfo struct {
@@ -130,6 +147,9 @@ func (g *generatorContext) prepare() error {
 	if len(g.doc.Pages) == 0 {
 		return fmt.Errorf("no pages defined")
 	}
+	if g.doc.Router != nil && g.doc.Router.MaxHistory < 0 {
+		return fmt.Errorf("router.maxHistory must not be negative, got %d", g.doc.Router.MaxHistory)
+	}
 	if err := g.prepareI18n(); err != nil {
 		return err
 	}
@@ -145,6 +165,9 @@ func (g *generatorContext) prepare() error {
 	if err := g.prepareHandlers(); err != nil {
 		return err
 	}
+	if err := g.prepareDeepLinks(); err != nil {
+		return err
+	}
 	if err := g.prepareAPI(); err != nil {
 		return err
 	}
@@ -215,12 +238,24 @@ func (g *generatorContext) preparePages() error {
 			Name: name,
 			Page: page,
 		}
-		params, err := parseParameters(page.Parameters)
+		params, err := parseParameters(name, page.Parameters)
 		if err != nil {
 			return fmt.Errorf("page %s: %w", path, err)
 		}
 		info.Params = params
+		if page.Form != nil {
+			if err := validateForm(page.Form); err != nil {
+				return fmt.Errorf("page %s: %w", path, err)
+			}
+		}
+		if err := validatePageKind(page); err != nil {
+			return fmt.Errorf("page %s: %w", path, err)
+		}
+		placeholders := placeholderNames(normalized)
 		for _, param := range params {
+			if param.In == "path" && !slices.Contains(placeholders, param.Name) {
+				return fmt.Errorf("page %s: path parameter %s does not appear in the path", path, param.Name)
+			}
 			switch strings.ToLower(param.In) {
 			case "path":
 				info.PathParams = append(info.PathParams, param)
@@ -230,18 +265,32 @@ func (g *generatorContext) preparePages() error {
 				info.QueryParams = append(info.QueryParams, param)
 			}
 		}
-		if pathHasParams(path) {
-			info.MatcherName = lowerFirst(name) + "Matcher"
-			info.MatcherOk = "ok" + name
-			info.MatcherParam = "params" + name
-		}
 		g.pages = append(g.pages, info)
 	}
+	// conflicting page paths would panic when the generated pageRouter is built
+	patterns := make([]string, 0, len(g.pages))
+	for _, page := range g.pages {
+		patterns = append(patterns, page.Path)
+	}
+	if _, err := routepath.NewRouter(patterns...); err != nil {
+		return err
+	}
 	return nil
 }
 
+// prepareValidators collects one FormValidator method per validator name. The names must not collide with
+// each other or with the `ValidateForm<Page>` hooks of forms with `validate`.
 func (g *generatorContext) prepareValidators() error {
 	validators := map[string]validatorInfo{}
+	methods := map[string]string{}
+	for _, page := range g.pages {
+		if page.Page.Form == nil {
+			continue
+		}
+		if page.Page.Form.Validate {
+			methods[formValidateMethodName(page.Name)] = "form " + page.Path
+		}
+	}
 	for _, page := range g.pages {
 		if page.Page.Form == nil {
 			continue
@@ -257,9 +306,14 @@ func (g *generatorContext) prepareValidators() error {
 			if _, ok := validators[name]; ok {
 				continue
 			}
+			method := validatorMethodName(name)
+			if other, ok := methods[method]; ok {
+				return fmt.Errorf("validator %s: method %s is already generated for %s", name, method, other)
+			}
+			methods[method] = "validator " + name
 			validators[name] = validatorInfo{
 				Name:       name,
-				MethodName: validatorMethodName(page.Name),
+				MethodName: method,
 			}
 		}
 	}
@@ -285,12 +339,96 @@ func (g *generatorContext) prepareHandlers() error {
 			MatchType:   strings.ToLower(strings.TrimSpace(handler.MatchType)),
 			HandlerType: strings.ToLower(strings.TrimSpace(handler.Type)),
 			MethodName:  handlerMethodName(match, handler.Type),
+			Description: handler.Description,
+		}
+		if info.Description != "" {
+			if info.MatchType == "prefix" || !strings.HasPrefix(match, "/") {
+				return fmt.Errorf("handler %s: description requires an exact /command match", match)
+			}
+			for _, scope := range handler.Scopes {
+				scope = strings.ToLower(strings.TrimSpace(scope))
+				switch scope {
+				case "default", "private", "group", "admins":
+				default:
+					return fmt.Errorf("handler %s: unknown command scope %q", match, scope)
+				}
+				info.Scopes = append(info.Scopes, scope)
+			}
+			if len(info.Scopes) == 0 {
+				info.Scopes = []string{"default"}
+			}
 		}
 		g.handlers = append(g.handlers, info)
 	}
 	return nil
 }
 
+func (g *generatorContext) prepareDeepLinks() error {
+	builders := make(map[string]struct{})
+	for _, link := range g.doc.DeepLinks {
+		payload := strings.TrimSpace(link.Payload)
+		route := normalizePathPattern(strings.TrimSpace(link.Route))
+		if payload == "" || route == "" {
+			return fmt.Errorf("deep link requires both payload and route")
+		}
+		routePath, _, _ := strings.Cut(route, "?")
+		var page *pageInfo
+		for i := range g.pages {
+			if stripPathConstraints(g.pages[i].Path) == stripPathConstraints(routePath) {
+				page = &g.pages[i]
+				break
+			}
+		}
+		if page == nil {
+			return fmt.Errorf("deep link %s: route %s does not match any page", payload, route)
+		}
+		payloadParams := placeholderNames(payload)
+		known := make(map[string]struct{}, len(payloadParams))
+		for _, name := range payloadParams {
+			known[name] = struct{}{}
+		}
+		for _, name := range placeholderNames(route) {
+			if _, ok := known[name]; !ok {
+				return fmt.Errorf("deep link %s: route parameter %s is not captured by the payload", payload, name)
+			}
+		}
+		name := strings.TrimSpace(link.Name)
+		if name == "" {
+			name = page.Name
+		}
+		info := deepLinkInfo{
+			Payload:     payload,
+			Route:       route,
+			MatcherName: "deepLink" + toCamel(name) + "Matcher",
+			BuilderName: "DeepLink" + toCamel(name),
+		}
+		if _, ok := builders[info.BuilderName]; ok {
+			return fmt.Errorf("deep link %s: duplicated builder %s, set a distinct name", payload, info.BuilderName)
+		}
+		builders[info.BuilderName] = struct{}{}
+		for _, paramName := range payloadParams {
+			arg := paramInfo{Name: paramName, GoType: "string"}
+			for _, param := range page.Params {
+				if param.Name == paramName {
+					if param.Array || param.Scalar.Base == "time.Time" {
+						return fmt.Errorf("deep link %s: parameter %s of type %s cannot be captured by a payload", payload, paramName, param.GoType)
+					}
+					arg.GoType = param.GoType
+					arg.Scalar = param.Scalar
+					break
+				}
+			}
+			arg.GoName = lowerFirst(goFieldName(paramName))
+			info.Args = append(info.Args, arg)
+		}
+		if err := checkDeepLinkPayload(payload, info.Args); err != nil {
+			return fmt.Errorf("deep link %s: %w", payload, err)
+		}
+		g.deepLinks = append(g.deepLinks, info)
+	}
+	return nil
+}
+
 func (g *generatorContext) prepareAPI() error {
 	if len(g.doc.API) == 0 {
 		return nil
@@ -345,9 +483,16 @@ func (g *generatorContext) render() ([]byte, error) {
 	if err := g.renderPagesDispatch(writer); err != nil {
 		return nil, err
 	}
+	if err := g.renderDeepLinks(writer); err != nil {
+		return nil, err
+	}
+	if err := g.renderCommandMenus(writer); err != nil {
+		return nil, err
+	}
 	if err := g.renderParameterParsers(writer); err != nil {
 		return nil, err
 	}
+	g.renderRouteBuilders(writer)
 	if err := g.renderForms(writer); err != nil {
 		return nil, err
 	}
@@ -368,11 +513,6 @@ type codeWriter struct {
 }
 
 func (w *codeWriter) line(format string, args ...any) {
-	if len(args) == 0 {
-		w.buf.WriteString(format)
-		w.buf.WriteByte('\n')
-		return
-	}
 	fmt.Fprintf(w.buf, format, args...)
 	w.buf.WriteByte('\n')
 }
@@ -397,6 +537,7 @@ type exprContext struct {
 	stateExprs     map[string]string
 	itemExprs      map[string]string
 	stateItemsExpr string
+	valuesExpr     string
 	errExpr        string
 	i18nKeys       map[string]struct{}
 	i18nFunc       string
@@ -405,10 +546,13 @@ type exprContext struct {
 func (g *generatorContext) renderImports(w *codeWriter) error {
 	w.line("import (")
 	w.line("\t\"context\"")
-	w.line("\t\"encoding/json\"")
 	w.line("\t\"fmt\"")
 	w.line("\t\"net/url\"")
+	w.line("\t\"strconv\"")
 	w.line("\t\"strings\"")
+	if hasFormTimeouts(g.pages) || hasDateParams(g.pages) {
+		w.line("\t\"time\"")
+	}
 	w.line("")
 	w.line("\t\"github.com/anclax/botx/pkg/core/bot\"")
 	w.line("\t\"github.com/anclax/botx/pkg/core/routepath\"")
@@ -466,6 +610,8 @@ func (g *generatorContext) renderSchemas(w *codeWriter) error {
 type coreTemplateData struct {
 	Handlers   []handlerInfo
 	Validators []validatorInfo
+	DeepLinks  bool
+	MaxHistory int
 }
 
 const coreTemplate = `// Core architecture components
@@ -495,8 +641,9 @@ type CommandHandler interface {
 }
 
 // Register bot handler to bot. the param bot and param stateProvider is implemented by user
-func Register(connector bot.BotConnector, sm session.SessionManager, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler) {
-	wrapped := bot.NewBot(connector)
+// Options such as bot.WithCallbackSigner configure the bot passed to the handlers.
+func Register(connector bot.BotConnector, sm session.SessionManager, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler, opts ...bot.BotOption) {
+	wrapped := bot.NewBot(connector, append([]bot.BotOption{bot.WithBotSessionManager(sm)}, opts...)...)
 	botxHandler := &BotxHandler{
 		renderer:       &PageRenderer{wrapped},
 		bot:            wrapped,
@@ -521,10 +668,13 @@ func (h *BotxHandler) getRouter(ctx context.Context, chatID int64) (*bot.Router,
 		if !errors.Is(err, session.ErrKeyNotFound) {
 			return nil, errors.Wrap(err, "failed to get router")
 		}
-		router, err = bot.CreateRouter(ctx, chatID, sess)
+		router, err = bot.CreateRouter(ctx, chatID, sess{{ if .MaxHistory }}, bot.WithMaxHistory({{ .MaxHistory }}){{ end }})
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create router")
 		}
+		if err := sess.Set(ctx, bot.SessionKeyRouter, router); err != nil {
+			return nil, errors.Wrap(err, "failed to store router")
+		}
 	}
 	return router.(*bot.Router), nil
 }
@@ -569,15 +719,38 @@ func (h *BotxHandler) handleRoute(ctx context.Context, chatID int64, data string
 	}
 
 	routeURL := strings.TrimPrefix(data, fmt.Sprintf("%s:", bot.CallbackPrefixRoute))
+	mode, target, ok := strings.Cut(routeURL, ":")
+	if ok && (mode == bot.RouteModeReplace || mode == bot.RouteModeReset) {
+		routeURL = target
+	} else {
+		mode = ""
+	}
 
-	if routeURL == "back" {
+	switch {
+	case routeURL == "back":
 		last, err := router.Back(ctx)
 		if err != nil {
 			return errors.Wrap(err, "failed to go back")
 		}
 		routeURL = last
-	} else {
-		if err := router.Push(ctx, routeURL); err != nil {
+	case isActionRoute(routeURL):
+		if err := router.PushAction(ctx, routeURL); err != nil {
+			return errors.Wrap(err, "failed to push action route")
+		}
+	case mode == bot.RouteModeReset:
+		if err := router.Reset(ctx, routeURL); err != nil {
+			return errors.Wrap(err, "failed to reset route")
+		}
+	default:
+		current, err := router.Current(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get current route")
+		}
+		if mode == bot.RouteModeReplace || isReplaceHistoryRoute(routeURL, current) {
+			if err := router.Replace(ctx, routeURL); err != nil {
+				return errors.Wrap(err, "failed to replace route")
+			}
+		} else if err := router.Push(ctx, routeURL); err != nil {
 			return errors.Wrap(err, "failed to push route")
 		}
 	}
@@ -611,6 +784,14 @@ func (h *BotxHandler) handleSubmit(ctx context.Context, chatID int64, data strin
 	return nil
 }
 
+func (h *BotxHandler) handleConfirm(ctx context.Context, chatID int64, data string, b bot.BotConnector) (string, error) {
+	sess, err := h.sm.Get(ctx, chatID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get session")
+	}
+	return bot.HandleConfirm(ctx, b, sess, chatID, data)
+}
+
 func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data string) error {
 	lang := strings.TrimSpace(strings.TrimPrefix(data, "lang:"))
 	if lang == "" {
@@ -631,13 +812,9 @@ func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data str
 	if err != nil {
 		return errors.Wrap(err, "failed to get router")
 	}
-	hist, err := router.History(ctx)
+	current, err := router.Current(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to get router history")
-	}
-	current := "/"
-	if len(hist) != 0 {
-		current = hist[len(hist)-1]
+		return errors.Wrap(err, "failed to get current route")
 	}
 	url, err := url.Parse(current)
 	if err != nil {
@@ -650,6 +827,18 @@ func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data str
 }
 
 func (h *BotxHandler) HandleTextMessage(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+{{- if .DeepLinks }}
+	if payload, ok := strings.CutPrefix(data, bot.DeepLinkCommand+" "); ok {
+		handled, err := h.handleDeepLink(ctx, chatID, strings.TrimSpace(payload))
+		if err != nil {
+			return errors.Wrap(err, "failed to handle deep link")
+		}
+		if handled {
+			return nil
+		}
+		data = bot.DeepLinkCommand
+	}
+{{- end }}
 {{- if .Handlers }}
 {{- range .Handlers }}
 	if {{ handlerCondition . }} && h.commandHandler != nil {
@@ -672,6 +861,10 @@ func (h *BotxHandler) HandleTextMessage(ctx context.Context, data string, chatID
 }
 
 func (h *BotxHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	data, err := h.bot.VerifyCallbackData(ctx, chatID, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify callback data")
+	}
 	if strings.HasPrefix(data, "lang:") {
 		if err := h.handleLanguage(ctx, chatID, data); err != nil {
 			return errors.Wrap(err, "failed to handle language switch")
@@ -690,6 +883,16 @@ func (h *BotxHandler) HandleCallbackData(ctx context.Context, data string, chatI
 		}
 		return nil
 	}
+	if strings.HasPrefix(data, fmt.Sprintf("%s:", bot.CallbackPrefixConfirm)) {
+		callback, err := h.handleConfirm(ctx, chatID, data, b)
+		if err != nil {
+			return errors.Wrap(err, "failed to handle confirmation")
+		}
+		if callback == "" {
+			return nil
+		}
+		return h.HandleCallbackData(ctx, callback, chatID, b)
+	}
 	if err := h.defaultHandler.HandleCallbackData(ctx, data, chatID, h.bot); err != nil {
 		return errors.Wrap(err, "failed to handle callback data in default handler")
 	}
@@ -710,10 +913,15 @@ func (h *BotxHandler) HandleError(ctx context.Context, err error, chatID int64,
 }
 
 func (h *BotxHandler) Validate(ctx context.Context, chatID int64, url *url.URL, validator string, input string) (*bot.ValidateResult, error) {
+	return h.ValidateFormField(ctx, chatID, url, validator, "", input, nil)
+}
+
+// ValidateFormField runs validator on the input of field, given the values entered before it.
+func (h *BotxHandler) ValidateFormField(ctx context.Context, chatID int64, url *url.URL, validator string, field string, input string, values bot.FormValues) (*bot.ValidateResult, error) {
 	switch validator {
 {{- range .Validators }}
 	case {{ printf "%q" .Name }}:
-		return h.formValidator.{{ .MethodName }}(ctx, chatID, url, input)
+		return h.formValidator.{{ .MethodName }}(ctx, chatID, url, field, input, values)
 {{- end }}
 	default:
 		return nil, errors.Wrapf(bot.ErrNotFound, "unknown validator: %s", validator)
@@ -725,6 +933,10 @@ func (g *generatorContext) renderCore(w *codeWriter) error {
 	data := coreTemplateData{
 		Handlers:   g.handlers,
 		Validators: g.validators,
+		DeepLinks:  len(g.deepLinks) != 0,
+	}
+	if g.doc.Router != nil {
+		data.MaxHistory = g.doc.Router.MaxHistory
 	}
 	return renderTemplate(w, "core", coreTemplate, data, template.FuncMap{
 		"handlerCondition": handlerCondition,
@@ -735,55 +947,77 @@ func (g *generatorContext) renderPagesDispatch(w *codeWriter) error {
 	w.line("// code generated for pages")
 	w.line("")
 
-	paramPages := make([]pageInfo, 0)
+	patterns := make([]string, 0, len(g.pages))
 	for _, page := range g.pages {
-		if len(page.PathParams) != 0 {
-			paramPages = append(paramPages, page)
-		}
+		patterns = append(patterns, strconv.Quote(page.Path))
 	}
-	if len(paramPages) != 0 {
-		w.line("var (")
-		for _, page := range paramPages {
-			w.line("\t%s = routepath.MustCompile(%q)", page.MatcherName, page.Path)
-		}
-		w.line(")")
-		w.line("")
+	w.line("// pageRouter matches a route against the page paths; static segments win over params.")
+	w.line("var pageRouter = routepath.MustNewRouter(")
+	for _, pattern := range patterns {
+		w.line("\t%s,", pattern)
 	}
+	w.line(")")
+	w.line("")
 
-	w.line("func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) error {")
-	for _, page := range paramPages {
-		w.line("\t%s, %s := %s.Match(url.Path)", page.MatcherParam, page.MatcherOk, page.MatcherName)
-	}
-	if len(paramPages) != 0 {
-		w.line("")
+	w.line("// actionPages are the pages with side effects. They stay out of the router history, so going back,")
+	w.line("// switching language or re-rendering never runs them again.")
+	w.line("var actionPages = map[string]bool{")
+	for _, page := range g.pages {
+		if isActionPage(page.Page) {
+			w.line("\t%q: true,", page.Path)
+		}
 	}
-	w.line("\tswitch {")
+	w.line("}")
+	w.line("")
+	w.line("func isActionRoute(route string) bool {")
+	w.line("\tpath, _, _ := strings.Cut(route, \"?\")")
+	w.line("\tpattern, _, ok := pageRouter.Match(path)")
+	w.line("\treturn ok && actionPages[pattern]")
+	w.line("}")
+	w.line("")
 
-	staticPages, matcherPages := splitPagesForDispatch(g.pages)
-	for _, page := range staticPages {
-		w.line("\tcase url.Path == %q:", page.Path)
-		renderRouteCase(w, page)
+	w.line("// replaceHistoryPages are the pages with `history: replace`. Opening one from itself with other query")
+	w.line("// params, e.g. the next page of a list, replaces its history entry instead of adding one.")
+	w.line("var replaceHistoryPages = map[string]bool{")
+	for _, page := range g.pages {
+		if strings.EqualFold(page.Page.History, "replace") {
+			w.line("\t%q: true,", page.Path)
+		}
 	}
-	for _, page := range matcherPages {
-		w.line("\tcase %s:", page.MatcherOk)
+	w.line("}")
+	w.line("")
+	w.line("func isReplaceHistoryRoute(route string, current string) bool {")
+	w.line("\tpath, _, _ := strings.Cut(route, \"?\")")
+	w.line("\tcurrentPath, _, _ := strings.Cut(current, \"?\")")
+	w.line("\tif path != currentPath {")
+	w.line("\t\treturn false")
+	w.line("\t}")
+	w.line("\tpattern, _, ok := pageRouter.Match(path)")
+	w.line("\treturn ok && replaceHistoryPages[pattern]")
+	w.line("}")
+	w.line("")
+
+	w.line("func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) error {")
+	renderRouteMatch(w, g.pages, "ok")
+	w.line("\tif !ok {")
+	w.line("\t\treturn errors.Wrapf(bot.ErrNotFound, \"unknown route: %%s\", url.String())")
+	w.line("\t}")
+	w.line("\tswitch pattern {")
+	for _, page := range g.pages {
+		w.line("\tcase %q:", page.Path)
 		renderRouteCase(w, page)
 	}
-	w.line("\tdefault:")
-	w.line("\t\treturn errors.Wrapf(bot.ErrNotFound, \"unknown route: %s\", url.String())")
 	w.line("\t}")
 	w.line("\treturn nil")
 	w.line("}")
 	w.line("")
 
 	w.line("func (h *BotxHandler) onSubmit(ctx context.Context, chatID int64, url *url.URL) error {")
-	w.line("\traw := url.Query().Get(\"values\")")
-	w.line("\tif raw == \"\" {")
+	w.line("\t// the values come from the form engine, never from the callback data")
+	w.line("\tvalues, ok := bot.FormValuesFromContext(ctx)")
+	w.line("\tif !ok {")
 	w.line("\t\treturn errors.Wrap(bot.ErrBadRequest, \"missing form values\")")
 	w.line("\t}")
-	w.line("\tvar values bot.FormValues")
-	w.line("\tif err := json.Unmarshal([]byte(raw), &values); err != nil {")
-	w.line("\t\treturn errors.Wrap(err, \"failed to unmarshal form values\")")
-	w.line("\t}")
 	w.line("")
 
 	formPages := make([]pageInfo, 0)
@@ -794,25 +1028,10 @@ func (g *generatorContext) renderPagesDispatch(w *codeWriter) error {
 		formPages = append(formPages, page)
 	}
 
-	formMatcherPages := make([]pageInfo, 0)
+	renderRouteMatch(w, formPages, "_")
+	w.line("\tswitch pattern {")
 	for _, page := range formPages {
-		if len(page.PathParams) != 0 {
-			formMatcherPages = append(formMatcherPages, page)
-			w.line("\t%s, %s := %s.Match(url.Path)", page.MatcherParam, page.MatcherOk, page.MatcherName)
-		}
-	}
-	if len(formMatcherPages) != 0 {
-		w.line("")
-	}
-
-	w.line("\tswitch {")
-	staticForms, matcherForms := splitPagesForDispatch(formPages)
-	for _, page := range staticForms {
-		w.line("\tcase url.Path == %q:", page.Path)
-		renderSubmitCase(w, page)
-	}
-	for _, page := range matcherForms {
-		w.line("\tcase %s:", page.MatcherOk)
+		w.line("\tcase %q:", page.Path)
 		renderSubmitCase(w, page)
 	}
 	w.line("\tdefault:")
@@ -821,7 +1040,7 @@ func (g *generatorContext) renderPagesDispatch(w *codeWriter) error {
 		groupName = "form"
 	}
 	if groupName == "form" {
-		w.line("\t\treturn errors.Wrapf(bot.ErrNotFound, \"unknown form: %s\", url.Path)")
+		w.line("\t\treturn errors.Wrapf(bot.ErrNotFound, \"unknown form: %%s\", url.Path)")
 	} else {
 		w.line("\t\treturn errors.Wrapf(bot.ErrNotFound, \"unknown form %s: %%s\", url.Path)", groupName)
 	}
@@ -831,6 +1050,274 @@ func (g *generatorContext) renderPagesDispatch(w *codeWriter) error {
 	return nil
 }
 
+func (g *generatorContext) renderDeepLinks(w *codeWriter) error {
+	if len(g.deepLinks) == 0 {
+		return nil
+	}
+	w.line("// deep links")
+	w.line("")
+	w.line("var (")
+	for _, link := range g.deepLinks {
+		w.line("\t%s = routepath.MustCompile(%q)", link.MatcherName, "/"+link.Payload)
+	}
+	w.line(")")
+	w.line("")
+
+	w.line("func (h *BotxHandler) handleDeepLink(ctx context.Context, chatID int64, payload string) (bool, error) {")
+	for _, link := range g.deepLinks {
+		w.line("\tif params, ok := %s.Match(\"/\" + payload); ok {", link.MatcherName)
+		w.line("\t\troute, err := routepath.Expand(%q, params)", link.Route)
+		w.line("\t\tif err != nil {")
+		w.line("\t\t\treturn false, errors.Wrap(err, \"failed to expand deep link route %s\")", link.Route)
+		w.line("\t\t}")
+		w.line("\t\treturn true, h.handleRoute(ctx, chatID, bot.RouteCallbackData(route))")
+		w.line("\t}")
+	}
+	w.line("\treturn false, nil")
+	w.line("}")
+	w.line("")
+
+	for _, link := range g.deepLinks {
+		args := []string{"botUsername string"}
+		for _, arg := range link.Args {
+			args = append(args, fmt.Sprintf("%s %s", arg.GoName, arg.GoType))
+		}
+		w.line("// %s builds a Telegram deep link that opens %s.", link.BuilderName, link.Route)
+		w.line("func %s(%s) string {", link.BuilderName, strings.Join(args, ", "))
+		format, values := deepLinkPayloadFormat(link)
+		if len(values) == 0 {
+			w.line("\treturn bot.TelegramDeepLink(botUsername, %q)", format)
+		} else {
+			w.line("\treturn bot.TelegramDeepLink(botUsername, fmt.Sprintf(%q, %s))", format, strings.Join(values, ", "))
+		}
+		w.line("}")
+		w.line("")
+	}
+	return nil
+}
+
+func (g *generatorContext) renderCommandMenus(w *codeWriter) error {
+	scopes := make(map[string][]handlerInfo)
+	for _, handler := range g.handlers {
+		for _, scope := range handler.Scopes {
+			scopes[scope] = append(scopes[scope], handler)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	ctx := exprContext{i18nKeys: g.i18nKeys, i18nFunc: "i18n(ctx, 0, %q)"}
+	w.line("// command menus")
+	w.line("")
+	w.line("// CommandMenus lists the commands with a description for every i18n language and scope. Connectors")
+	w.line("// register them with the platform, e.g. Telegram setMyCommands. An empty language is the fallback menu.")
+	w.line("func (h *BotxHandler) CommandMenus(ctx context.Context) []bot.CommandMenu {")
+	w.line("\tvar menus []bot.CommandMenu")
+	if g.i18n != nil {
+		w.line("\tfor _, lang := range append([]string{\"\"}, i18nLanguages...) {")
+		w.line("\t\tctx := bot.WithLanguage(ctx, cond(lang == \"\", i18nDefault, lang))")
+	} else {
+		w.line("\tfor _, lang := range []string{\"\"} {")
+	}
+	for _, scope := range []string{"default", "private", "group", "admins"} {
+		handlers, ok := scopes[scope]
+		if !ok {
+			continue
+		}
+		w.line("\t\tmenus = append(menus, bot.CommandMenu{")
+		w.line("\t\t\tLanguage: lang,")
+		w.line("\t\t\tScope:    %s,", commandScopeConst(scope))
+		w.line("\t\t\tCommands: []bot.Command{")
+		for _, handler := range handlers {
+			w.line("\t\t\t\t{Command: %q, Description: %s},", strings.TrimPrefix(handler.Match, "/"), stringExprToGo(handler.Description, ctx))
+		}
+		w.line("\t\t\t},")
+		w.line("\t\t})")
+	}
+	w.line("\t}")
+	w.line("\treturn menus")
+	w.line("}")
+	w.line("")
+	return nil
+}
+
+func commandScopeConst(scope string) string {
+	switch scope {
+	case "private":
+		return "bot.CommandScopePrivate"
+	case "group":
+		return "bot.CommandScopeGroup"
+	case "admins":
+		return "bot.CommandScopeAdmins"
+	default:
+		return "bot.CommandScopeDefault"
+	}
+}
+
+// telegramMaxPayloadLen is the longest `/start` payload Telegram accepts.
+const telegramMaxPayloadLen = 64
+
+// checkDeepLinkPayload checks that a payload pattern only produces the `A-Za-z0-9_-` characters Telegram
+// accepts, within 64 characters. String args are unbounded, so only their literal parts are counted.
+func checkDeepLinkPayload(payload string, args []paramInfo) error {
+	scalars := make(map[string]paramScalar, len(args))
+	for _, arg := range args {
+		scalars[arg.Name] = arg.Scalar
+	}
+	size, last := 0, 0
+	for _, placeholder := range pathPlaceholders(payload) {
+		if err := checkDeepLinkChars(payload[last:placeholder.start]); err != nil {
+			return err
+		}
+		n, err := deepLinkValueLen(placeholder.name, scalars[placeholder.name])
+		if err != nil {
+			return err
+		}
+		size += placeholder.start - last + n
+		last = placeholder.end
+	}
+	if err := checkDeepLinkChars(payload[last:]); err != nil {
+		return err
+	}
+	size += len(payload) - last
+	if size > telegramMaxPayloadLen {
+		return fmt.Errorf("payloads are limited to %d characters, this one can reach %d", telegramMaxPayloadLen, size)
+	}
+	return nil
+}
+
+func checkDeepLinkChars(text string) error {
+	for _, r := range text {
+		if r != '_' && r != '-' && (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return fmt.Errorf("%q is not allowed in a payload, only A-Z, a-z, 0-9, _ and -", r)
+		}
+	}
+	return nil
+}
+
+// deepLinkValueLen returns the longest formatted value of a payload arg, 0 for unbounded strings.
+func deepLinkValueLen(name string, scalar paramScalar) (int, error) {
+	if len(scalar.Enum) != 0 {
+		longest := 0
+		for _, enum := range scalar.Enum {
+			value := enum.Literal
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			if err := checkDeepLinkChars(value); err != nil {
+				return 0, fmt.Errorf("parameter %s: %w", name, err)
+			}
+			longest = max(longest, len(value))
+		}
+		return longest, nil
+	}
+	switch {
+	case scalar.Format == "uuid":
+		return 36, nil
+	case scalar.Base == "int" || scalar.Base == "int64":
+		return len(strconv.FormatInt(math.MinInt64, 10)), nil
+	case scalar.Base == "int32":
+		return len(strconv.FormatInt(math.MinInt32, 10)), nil
+	case scalar.Base == "bool":
+		return len("false"), nil
+	case scalar.Base == "float32" || scalar.Base == "float64":
+		return 0, fmt.Errorf("parameter %s: numbers are formatted with '.' and '+', which a payload cannot hold", name)
+	default:
+		return 0, nil
+	}
+}
+
+func deepLinkPayloadFormat(link deepLinkInfo) (string, []string) {
+	return patternFormat(link.Payload, link.Args)
+}
+
+// patternFormat turns a pattern with `{name}` placeholders into a fmt format string and the Go names of
+// the args filling the placeholders.
+func patternFormat(pattern string, args []paramInfo) (string, []string) {
+	goNames := make(map[string]string, len(args))
+	for _, arg := range args {
+		goNames[arg.Name] = arg.GoName
+	}
+	var format strings.Builder
+	var values []string
+	last := 0
+	for _, placeholder := range pathPlaceholders(pattern) {
+		format.WriteString(escapeFormatLiteral(pattern[last:placeholder.start]))
+		format.WriteString("%v")
+		values = append(values, goNames[placeholder.name])
+		last = placeholder.end
+	}
+	format.WriteString(escapeFormatLiteral(pattern[last:]))
+	return format.String(), values
+}
+
+// pathPlaceholder is a `{name}`, `{name:constraint}` or `{name...}` placeholder spanning
+// pattern[start:end].
+type pathPlaceholder struct {
+	start    int
+	end      int
+	name     string
+	catchAll bool
+}
+
+// pathPlaceholders returns the placeholders of pattern. Constraints may contain braces, as in
+// `{code:[0-9]{3}}`.
+func pathPlaceholders(pattern string) []pathPlaceholder {
+	var placeholders []pathPlaceholder
+	start, depth := -1, 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			depth--
+			if depth == 0 {
+				placeholder := pathPlaceholder{start: start, end: i + 1}
+				placeholder.name, placeholder.catchAll = pathParamName(pattern[start+1 : i])
+				placeholders = append(placeholders, placeholder)
+			}
+		}
+	}
+	return placeholders
+}
+
+// pathParamName returns the param name of a placeholder body and whether it is a catch-all.
+func pathParamName(body string) (string, bool) {
+	if name, ok := strings.CutSuffix(body, "..."); ok {
+		return name, true
+	}
+	name, _, _ := strings.Cut(body, ":")
+	return name, false
+}
+
+func placeholderNames(pattern string) []string {
+	var names []string
+	for _, placeholder := range pathPlaceholders(pattern) {
+		names = append(names, placeholder.name)
+	}
+	return names
+}
+
+// stripPathConstraints drops the constraints from the placeholders of pattern, so `/todo/{ID:int}`
+// becomes `/todo/{ID}`.
+func stripPathConstraints(pattern string) string {
+	var sb strings.Builder
+	last := 0
+	for _, placeholder := range pathPlaceholders(pattern) {
+		sb.WriteString(pattern[last:placeholder.start])
+		sb.WriteString("{" + placeholder.name + "}")
+		last = placeholder.end
+	}
+	sb.WriteString(pattern[last:])
+	return sb.String()
+}
+
 func (g *generatorContext) renderParameterParsers(w *codeWriter) error {
 	w.line("// url to params")
 	w.line("")
@@ -878,7 +1365,7 @@ func (g *generatorContext) renderForms(w *codeWriter) error {
 			continue
 		}
 		form := page.Page.Form
-		fields := sortedFormFields(form.Fields, form.Required)
+		fields := sortedFormFields(form)
 		w.line("type Form%s struct {", page.Name)
 		for _, field := range fields {
 			w.line("\t%s string", field.goName)
@@ -893,7 +1380,8 @@ func (g *generatorContext) renderForms(w *codeWriter) error {
 		}
 		w.line("func unmarshalForm%s(values bot.FormValues) (*Form%s, error) {", page.Name, page.Name)
 		for _, field := range fields {
-			if field.required {
+			// a hidden field is left out of the values, so only unconditional fields can be required
+			if field.required && len(field.conditions) == 0 {
 				w.line("\t%s, ok := values[%q]", field.goName, field.name)
 				w.line("\tif !ok {")
 				w.line("\t\treturn nil, errors.Wrap(bot.ErrBadRequest, %q)", fmt.Sprintf("%s is required", field.name))
@@ -910,30 +1398,142 @@ func (g *generatorContext) renderForms(w *codeWriter) error {
 		w.line("}")
 		w.line("")
 	}
+	g.renderFormConditions(w)
+	g.renderFormValidate(w)
 	return nil
 }
 
+// renderFormValidate emits ValidateFormValues, which makes BotxHandler a bot.FormValuesValidator and runs
+// the `ValidateForm<Page>` hooks of forms with `validate`.
+func (g *generatorContext) renderFormValidate(w *codeWriter) {
+	validatedPages := make([]pageInfo, 0)
+	for _, page := range g.pages {
+		if page.Page.Form != nil && page.Page.Form.Validate {
+			validatedPages = append(validatedPages, page)
+		}
+	}
+	if len(validatedPages) == 0 {
+		return
+	}
+	w.line("// ValidateFormValues runs the validate hook of a filled form before it is reviewed or submitted.")
+	w.line("func (h *BotxHandler) ValidateFormValues(ctx context.Context, chatID int64, url *url.URL, values bot.FormValues) (*bot.ValidateResult, error) {")
+	renderRouteMatch(w, validatedPages, "_")
+	w.line("\tswitch pattern {")
+	renderCase := func(page pageInfo) {
+		w.line("\t\tparams, err := %s", parseParametersCall(page))
+		w.line("\t\tif err != nil {")
+		w.line("\t\t\treturn nil, errors.Wrap(err, \"invalid parameters for form %s\")", page.Path)
+		w.line("\t\t}")
+		w.line("\t\tform, err := unmarshalForm%s(values)", page.Name)
+		w.line("\t\tif err != nil {")
+		w.line("\t\t\treturn nil, errors.Wrap(err, \"invalid values for form %s\")", page.Path)
+		w.line("\t\t}")
+		w.line("\t\treturn h.formValidator.%s(ctx, chatID, form, params)", formValidateMethodName(page.Name))
+	}
+	for _, page := range validatedPages {
+		w.line("\tcase %q:", page.Path)
+		renderCase(page)
+	}
+	w.line("\t}")
+	w.line("\treturn &bot.ValidateResult{Valid: true}, nil")
+	w.line("}")
+	w.line("")
+}
+
+// renderFormConditions emits ShowFormField, which makes BotxHandler a bot.FormFieldFilter, and a
+// condition function per form with `showIf` fields.
+func (g *generatorContext) renderFormConditions(w *codeWriter) {
+	conditionalPages := make([]pageInfo, 0)
+	for _, page := range g.pages {
+		for _, field := range sortedFormFields(page.Page.Form) {
+			if len(field.conditions) != 0 {
+				conditionalPages = append(conditionalPages, page)
+				break
+			}
+		}
+	}
+	if len(conditionalPages) == 0 {
+		return
+	}
+	for _, page := range conditionalPages {
+		ctx := g.pageExprContext(page, "")
+		ctx.valuesExpr = "values"
+		w.line("func showFormField%s(ctx context.Context, chatID int64, field string, values bot.FormValues, parameters *ParametersPage%s) bool {", page.Name, page.Name)
+		w.line("\tswitch field {")
+		for _, field := range sortedFormFields(page.Page.Form) {
+			if len(field.conditions) == 0 {
+				continue
+			}
+			exprs := make([]string, 0, len(field.conditions))
+			for _, condition := range field.conditions {
+				expr := codeExprToGo(condition, ctx)
+				if len(field.conditions) > 1 {
+					expr = "(" + expr + ")"
+				}
+				exprs = append(exprs, expr)
+			}
+			w.line("\tcase %q:", field.name)
+			w.line("\t\treturn %s", strings.Join(exprs, " && "))
+		}
+		w.line("\t}")
+		w.line("\treturn true")
+		w.line("}")
+		w.line("")
+	}
+
+	w.line("// ShowFormField evaluates the showIf conditions of form fields against the values entered so far.")
+	w.line("func (h *BotxHandler) ShowFormField(ctx context.Context, chatID int64, url *url.URL, field string, values bot.FormValues) (bool, error) {")
+	renderRouteMatch(w, conditionalPages, "_")
+	w.line("\tswitch pattern {")
+	renderCase := func(page pageInfo) {
+		w.line("\t\tparams, err := %s", parseParametersCall(page))
+		w.line("\t\tif err != nil {")
+		w.line("\t\t\treturn false, errors.Wrap(err, \"invalid parameters for form %s\")", page.Path)
+		w.line("\t\t}")
+		w.line("\t\treturn showFormField%s(ctx, chatID, field, values, params), nil", page.Name)
+	}
+	for _, page := range conditionalPages {
+		w.line("\tcase %q:", page.Path)
+		renderCase(page)
+	}
+	w.line("\t}")
+	w.line("\treturn true, nil")
+	w.line("}")
+	w.line("")
+}
+
 type interfacesTemplateData struct {
 	Validators []validatorInfo
 	Pages      []stateProviderTemplatePage
 }
 
 type stateProviderTemplatePage struct {
-	Name    string
-	HasForm bool
+	Name         string
+	HasForm      bool
+	HasFormState bool
+	// HasFormValidate adds the form `validate` hook to FormValidator.
+	HasFormValidate bool
 }
 
 const interfacesTemplate = `// FormValidator
 
 type FormValidator interface {
 {{- range .Validators }}
-	{{ .MethodName }}(ctx context.Context, chatID int64, url *url.URL, input string) (*bot.ValidateResult, error)
+	{{ .MethodName }}(ctx context.Context, chatID int64, url *url.URL, field string, input string, values bot.FormValues) (*bot.ValidateResult, error)
+{{- end }}
+{{- range .Pages }}
+{{- if .HasFormValidate }}
+	ValidateForm{{ .Name }}(ctx context.Context, chatID int64, form *Form{{ .Name }}, parameters *ParametersPage{{ .Name }}) (*bot.ValidateResult, error)
+{{- end }}
 {{- end }}
 }
 
 // StateProvider provides state views.
 type StateProvider interface {
 {{- range .Pages }}
+{{- if .HasFormState }}
+	Provide{{ .Name }}FormState(ctx context.Context, chatID int64, parameters *ParametersPage{{ .Name }}) (*StateForm{{ .Name }}, error)
+{{- end }}
 {{- if .HasForm }}
 	Provide{{ .Name }}State(ctx context.Context, chatID int64, form *Form{{ .Name }}, parameters *ParametersPage{{ .Name }}) (*StatePage{{ .Name }}, error)
 {{- else }}
@@ -947,8 +1547,10 @@ func (g *generatorContext) renderInterfaces(w *codeWriter) error {
 	pages := make([]stateProviderTemplatePage, 0, len(g.pages))
 	for _, page := range g.pages {
 		pages = append(pages, stateProviderTemplatePage{
-			Name:    page.Name,
-			HasForm: page.Page.Form != nil,
+			Name:            page.Name,
+			HasForm:         page.Page.Form != nil,
+			HasFormState:    page.Page.Form != nil && page.Page.Form.State != nil,
+			HasFormValidate: page.Page.Form != nil && page.Page.Form.Validate,
 		})
 	}
 	data := interfacesTemplateData{
@@ -966,7 +1568,10 @@ func (g *generatorContext) renderPageRenderer(w *codeWriter) error {
 
 	for _, page := range g.pages {
 		g.renderParametersStruct(w, page)
-		g.renderStateStruct(w, page)
+		g.renderStateStruct(w, "StatePage"+page.Name, page.Page.State)
+		if page.Page.Form != nil && page.Page.Form.State != nil {
+			g.renderStateStruct(w, "StateForm"+page.Name, page.Page.Form.State)
+		}
 		g.renderPageView(w, page)
 		if page.Page.Form != nil {
 			g.renderFormView(w, page)
@@ -1026,78 +1631,50 @@ func (g *generatorContext) renderHelpers(w *codeWriter) error {
 	}
 
 	defaults := defaultConstants(g.pages)
-	if len(defaults) != 0 {
-		w.line("const (")
+	for _, keyword := range []string{"const", "var"} {
+		var items []defaultConstant
 		for _, item := range defaults {
+			if item.isVar == (keyword == "var") {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			continue
+		}
+		w.line("%s (", keyword)
+		for _, item := range items {
 			w.line("\t%s = %s", item.name, item.value)
 		}
 		w.line(")")
 		w.line("")
 	}
 
-	w.line("func parseOptionalIntQuery(url *url.URL, key string, defaultValue int) (int, error) {")
-	w.line("\tvalue := url.Query().Get(key)")
-	w.line("\tif value == \"\" {")
-	w.line("\t\treturn defaultValue, nil")
-	w.line("\t}")
-	w.line("\treturn ToInt(value)")
-	w.line("}")
-	w.line("")
-
-	w.line("func parseOptionalInt64Query(url *url.URL, key string, defaultValue int64) (int64, error) {")
-	w.line("\tvalue := url.Query().Get(key)")
-	w.line("\tif value == \"\" {")
-	w.line("\t\treturn defaultValue, nil")
-	w.line("\t}")
-	w.line("\treturn ToInt64(value)")
-	w.line("}")
-	w.line("")
-
-	w.line("func parseOptionalStringQuery(url *url.URL, key string, defaultValue string) string {")
-	w.line("\tvalue := url.Query().Get(key)")
-	w.line("\tif value == \"\" {")
-	w.line("\t\treturn defaultValue")
-	w.line("\t}")
-	w.line("\treturn value")
-	w.line("}")
-	w.line("")
+	renderParamHelpers(w, g.pages)
 
-	w.line("func parseParamInt64(params routepath.Params, key string) (int64, error) {")
-	w.line("\tvalue, ok := params.Get(key)")
-	w.line("\tif !ok || value == \"\" {")
-	w.line("\t\treturn 0, errors.Wrapf(bot.ErrBadRequest, \"missing %s parameter\", key)")
+	w.line("// parseInt parses a base 10 integer of bitSize bits. A leading \"+\" is rejected, so each value has")
+	w.line("// one spelling.")
+	w.line("func parseInt(s string, bitSize int) (int64, error) {")
+	w.line("\tif strings.HasPrefix(s, \"+\") {")
+	w.line("\t\treturn 0, errors.Errorf(\"invalid integer %%q\", s)")
 	w.line("\t}")
-	w.line("\treturn ToInt64(value)")
+	w.line("\treturn strconv.ParseInt(s, 10, bitSize)")
 	w.line("}")
 	w.line("")
 
 	w.line("func ToInt(s string) (int, error) {")
-	w.line("\tvar i int")
-	w.line("\t_, err := fmt.Sscanf(s, \"%d\", &i)")
-	w.line("\tif err != nil {")
-	w.line("\t\treturn 0, err")
-	w.line("\t}")
-	w.line("\treturn i, nil")
+	w.line("\ti, err := parseInt(s, strconv.IntSize)")
+	w.line("\treturn int(i), err")
 	w.line("}")
 	w.line("")
 
 	w.line("func ToInt32(s string) (int32, error) {")
-	w.line("\tvar i int32")
-	w.line("\t_, err := fmt.Sscanf(s, \"%d\", &i)")
-	w.line("\tif err != nil {")
-	w.line("\t\treturn 0, err")
-	w.line("\t}")
-	w.line("\treturn i, nil")
+	w.line("\ti, err := parseInt(s, 32)")
+	w.line("\treturn int32(i), err")
 	w.line("}")
 	w.line("")
 
 	w.line("func ToInt64(s string) (int64, error) {")
-	w.line("\tvar i int64")
-	w.line("\t_, err := fmt.Sscanf(s, \"%d\", &i)")
-	w.line("\tif err != nil {")
-	w.line("\t\treturn 0, err")
-	w.line("\t}")
-	w.line("\treturn i, nil")
+	w.line("\treturn parseInt(s, 64)")
 	w.line("}")
 	w.line("")
 
@@ -1122,6 +1699,12 @@ func (g *generatorContext) renderI18n(w *codeWriter) {
 
 	w.line("const i18nDefault = %q", defaultLang)
 	w.line("")
+	languages := make([]string, 0)
+	for _, lang := range i18nLanguages(g.i18n) {
+		languages = append(languages, strconv.Quote(strings.ToLower(lang)))
+	}
+	w.line("var i18nLanguages = []string{%s}", strings.Join(languages, ", "))
+	w.line("")
 	w.line("var i18nEntries = map[string]map[string]string{")
 	keys := sortedI18nKeys(g.i18n)
 	for _, key := range keys {
@@ -1182,9 +1765,17 @@ func (g *generatorContext) renderI18n(w *codeWriter) {
 }
 
 func firstI18nLanguage(i18n *I18n) string {
-	if i18n == nil {
+	langs := i18nLanguages(i18n)
+	if len(langs) == 0 {
 		return ""
 	}
+	return langs[0]
+}
+
+func i18nLanguages(i18n *I18n) []string {
+	if i18n == nil {
+		return nil
+	}
 	langs := map[string]struct{}{}
 	for _, entry := range i18n.Entries {
 		for lang := range entry {
@@ -1194,15 +1785,12 @@ func firstI18nLanguage(i18n *I18n) string {
 			langs[lang] = struct{}{}
 		}
 	}
-	if len(langs) == 0 {
-		return ""
-	}
 	sorted := make([]string, 0, len(langs))
 	for key := range langs {
 		sorted = append(sorted, key)
 	}
 	sort.Strings(sorted)
-	return sorted[0]
+	return sorted
 }
 
 func sortedI18nKeys(i18n *I18n) []string {
@@ -1311,7 +1899,7 @@ func pageNameFromPath(path string) string {
 			if i != len(parts)-1 {
 				continue
 			}
-			param := strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")
+			param, _ := pathParamName(part[1 : len(part)-1])
 			nameParts = append(nameParts, toCamel(param))
 			continue
 		}
@@ -1323,49 +1911,6 @@ func pageNameFromPath(path string) string {
 	return strings.Join(nameParts, "")
 }
 
-func parseParameters(params Parameters) ([]paramInfo, error) {
-	if len(params) == 0 {
-		return nil, nil
-	}
-	keys := make([]string, 0, len(params))
-	for key := range params {
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
-
-	var result []paramInfo
-	for _, key := range keys {
-		param := params[key]
-		if param == nil {
-			continue
-		}
-		name := param.Name
-		if name == "" {
-			name = key
-		}
-		goType := schemaRefToGoType(param.Schema)
-		in := strings.ToLower(strings.TrimSpace(param.In))
-		if in == "" {
-			in = "query"
-		}
-		required := param.Required
-		defaultLiteral := ""
-		if param.Schema != nil && param.Schema.Value != nil && param.Schema.Value.Default != nil {
-			defaultLiteral = defaultLiteralFor(goType, param.Schema.Value.Default)
-		}
-		result = append(result, paramInfo{
-			Name:           name,
-			GoName:         paramFieldName(name),
-			GetterName:     getterName(name),
-			In:             in,
-			GoType:         goType,
-			Required:       required,
-			DefaultLiteral: defaultLiteral,
-		})
-	}
-	return result, nil
-}
-
 func pathHasParams(path string) bool {
 	path = normalizePathPattern(path)
 	return strings.Contains(path, "{") && strings.Contains(path, "}")
@@ -1405,7 +1950,17 @@ func lowerFirst(s string) string {
 	return string(runes)
 }
 
-func validatorMethodName(pageName string) string {
+// validatorMethodName names the FormValidator method of a validator, e.g. validateTitle → ValidateTitle
+// and title → ValidateTitle.
+func validatorMethodName(name string) string {
+	method := toCamel(name)
+	if !strings.HasPrefix(method, "Validate") {
+		method = "Validate" + method
+	}
+	return method
+}
+
+func formValidateMethodName(pageName string) string {
 	return "ValidateForm" + pageName
 }
 
@@ -1541,17 +2096,6 @@ func paramFieldName(name string) string {
 	return exported
 }
 
-func defaultLiteralFor(goType string, value any) string {
-	switch v := value.(type) {
-	case string:
-		return strconv.Quote(v)
-	case bool:
-		return strconv.FormatBool(v)
-	default:
-		return fmt.Sprintf("%v", v)
-	}
-}
-
 func constructorArgs(fields []fieldInfo) string {
 	parts := make([]string, 0, len(fields))
 	for _, field := range fields {
@@ -1570,20 +2114,17 @@ func handlerCondition(handler handlerInfo) string {
 	}
 }
 
-func splitPagesForDispatch(pages []pageInfo) ([]pageInfo, []pageInfo) {
-	staticPages := make([]pageInfo, 0)
-	matcherPages := make([]pageInfo, 0)
+// renderRouteMatch emits the pageRouter lookup of a switch over the patterns of pages. routeParams is only
+// named when one of the pages parses path params from it.
+func renderRouteMatch(w *codeWriter, pages []pageInfo, ok string) {
+	params := "_"
 	for _, page := range pages {
-		if len(page.PathParams) == 0 {
-			staticPages = append(staticPages, page)
-		} else {
-			matcherPages = append(matcherPages, page)
+		if len(page.PathParams) != 0 {
+			params = "routeParams"
+			break
 		}
 	}
-	sort.SliceStable(matcherPages, func(i, j int) bool {
-		return len(matcherPages[i].Path) > len(matcherPages[j].Path)
-	})
-	return staticPages, matcherPages
+	w.line("\tpattern, %s, %s := pageRouter.Match(url.Path)", params, ok)
 }
 
 func renderRouteCase(w *codeWriter, page pageInfo) {
@@ -1593,7 +2134,15 @@ func renderRouteCase(w *codeWriter, page pageInfo) {
 		w.line("\t\tif err != nil {")
 		w.line("\t\t\treturn errors.Wrap(err, \"invalid parameters for page %s\")", page.Path)
 		w.line("\t\t}")
-		w.line("\t\tif err := h.renderer.form%s(ctx, chatID, url, params); err != nil {", page.Name)
+		if page.Page.Form.State != nil {
+			w.line("\t\tstate, err := h.sp.Provide%sFormState(ctx, chatID, params)", page.Name)
+			w.line("\t\tif err != nil {")
+			w.line("\t\t\treturn errors.Wrap(err, \"failed to provide form state for page %s\")", page.Path)
+			w.line("\t\t}")
+			w.line("\t\tif err := h.renderer.form%s(ctx, chatID, url, params, state); err != nil {", page.Name)
+		} else {
+			w.line("\t\tif err := h.renderer.form%s(ctx, chatID, url, params); err != nil {", page.Name)
+		}
 		w.line("\t\t\treturn errors.Wrap(err, \"failed to render form for page %s\")", page.Path)
 		w.line("\t\t}")
 		return
@@ -1630,128 +2179,265 @@ func renderSubmitCase(w *codeWriter, page pageInfo) {
 	w.line("\t\t}")
 }
 
-func parseParametersCall(page pageInfo) string {
-	funcName := fmt.Sprintf("ParseParametersPage%s", page.Name)
-	if len(page.PathParams) != 0 {
-		if len(page.QueryParams) != 0 {
-			return fmt.Sprintf("%s(url, %s)", funcName, page.MatcherParam)
+// renderRouteBuilders emits a Route<Page> function per page. Path parameters are arguments in path order
+// and query parameters are passed as an optional <Page>Params struct.
+func (g *generatorContext) renderRouteBuilders(w *codeWriter) {
+	w.line("// route builders")
+	w.line("")
+	for _, page := range g.pages {
+		args := pathArgs(page)
+		params := make([]string, 0, len(args)+1)
+		var parts []string
+		literal := ""
+		for _, segment := range strings.Split(strings.TrimPrefix(normalizePathPattern(page.Path), "/"), "/") {
+			literal += "/"
+			if !isPathParam(segment) {
+				literal += segment
+				continue
+			}
+			arg := args[len(params)]
+			params = append(params, fmt.Sprintf("%s %s", arg.GoName, arg.GoType))
+			parts = append(parts, strconv.Quote(literal))
+			literal = ""
+			parts = append(parts, paramPathExpr(arg, strings.HasSuffix(segment, "...}")))
+		}
+		if literal != "" {
+			parts = append(parts, strconv.Quote(literal))
+		}
+		path := strings.Join(parts, " + ")
+		pathWithQuery := path + " + \"?\""
+		if literal != "" {
+			pathWithQuery = strings.Join(append(parts[:len(parts)-1:len(parts)-1], strconv.Quote(literal+"?")), " + ")
+		}
+
+		if len(page.QueryParams) == 0 {
+			w.line("// Route%s returns the route of page %s.", page.Name, page.Path)
+			w.line("func Route%s(%s) string {", page.Name, strings.Join(params, ", "))
+			w.line("\treturn %s", path)
+			w.line("}")
+			w.line("")
+			continue
+		}
+
+		w.line("// %sParams holds the query parameters of Route%s; zero values are left out. Parameters with a", page.Name, page.Name)
+		w.line("// default are pointers, set with bot.Ptr, so their zero value can be sent.")
+		w.line("type %sParams struct {", page.Name)
+		for _, param := range page.QueryParams {
+			w.line("\t%s %s", goFieldName(param.Name), param.builderType())
+		}
+		w.line("}")
+		w.line("")
+		params = append(params, fmt.Sprintf("params ...%sParams", page.Name))
+		w.line("// Route%s returns the route of page %s.", page.Name, page.Path)
+		w.line("func Route%s(%s) string {", page.Name, strings.Join(params, ", "))
+		w.line("\tquery := url.Values{}")
+		w.line("\tfor _, p := range params {")
+		for _, param := range page.QueryParams {
+			renderQueryParamSet(w, param, "p."+goFieldName(param.Name))
 		}
-		return fmt.Sprintf("%s(%s)", funcName, page.MatcherParam)
+		w.line("\t}")
+		w.line("\tif len(query) == 0 {")
+		w.line("\t\treturn %s", path)
+		w.line("\t}")
+		w.line("\treturn %s + query.Encode()", pathWithQuery)
+		w.line("}")
+		w.line("")
 	}
-	return fmt.Sprintf("%s(url)", funcName)
 }
 
-func parseParam(w *codeWriter, param paramInfo, page pageInfo) {
-	paramName := strings.ToLower(param.Name)
-	goVar := lowerFirst(param.GoName)
-	switch strings.ToLower(param.In) {
-	case "path":
-		switch param.GoType {
-		case "int64":
-			w.line("\t%s, err := parseParamInt64(params, %q)", goVar, param.Name)
-			w.line("\tif err != nil {")
-			w.line("\t\treturn nil, errors.Wrapf(bot.ErrBadRequest, \"invalid %s parameter: %%s\", err.Error())", paramName)
-			w.line("\t}")
-		default:
-			w.line("\t%s, ok := params.Get(%q)", goVar, param.Name)
-			w.line("\tif !ok || %s == \"\" {", goVar)
-			w.line("\t\treturn nil, errors.Wrap(bot.ErrBadRequest, %q)", fmt.Sprintf("missing %s parameter", paramName))
-			w.line("\t}")
-		}
-	default:
-		switch param.GoType {
-		case "int":
-			if !param.Required {
-				defaultValue := param.DefaultLiteral
-				if defaultValue == "" {
-					defaultValue = "0"
-				} else {
-					defaultValue = defaultConstName(page.Name, param.Name, param.DefaultLiteral)
-				}
-				w.line("\t%s, err := parseOptionalIntQuery(url, %q, %s)", goVar, param.Name, defaultValue)
-				w.line("\tif err != nil {")
-				w.line("\t\treturn nil, errors.Wrapf(bot.ErrBadRequest, \"invalid %s parameter: %%s\", err.Error())", paramName)
-				w.line("\t}")
-				break
-			}
-			w.line("\t%sValue := url.Query().Get(%q)", goVar, param.Name)
-			w.line("\tif %sValue == \"\" {", goVar)
-			w.line("\t\treturn nil, errors.Wrap(bot.ErrBadRequest, %q)", fmt.Sprintf("missing %s parameter", paramName))
-			w.line("\t}")
-			w.line("\t%s, err := ToInt(%sValue)", goVar, goVar)
-			w.line("\tif err != nil {")
-			w.line("\t\treturn nil, errors.Wrapf(bot.ErrBadRequest, \"invalid %s parameter: %%s\", err.Error())", paramName)
-			w.line("\t}")
-		case "int64":
-			if !param.Required {
-				defaultValue := param.DefaultLiteral
-				if defaultValue == "" {
-					defaultValue = "0"
-				} else {
-					defaultValue = defaultConstName(page.Name, param.Name, param.DefaultLiteral)
-				}
-				w.line("\t%s, err := parseOptionalInt64Query(url, %q, %s)", goVar, param.Name, defaultValue)
-				w.line("\tif err != nil {")
-				w.line("\t\treturn nil, errors.Wrapf(bot.ErrBadRequest, \"invalid %s parameter: %%s\", err.Error())", paramName)
-				w.line("\t}")
-				break
-			}
-			w.line("\t%sValue := url.Query().Get(%q)", goVar, param.Name)
-			w.line("\tif %sValue == \"\" {", goVar)
-			w.line("\t\treturn nil, errors.Wrap(bot.ErrBadRequest, %q)", fmt.Sprintf("missing %s parameter", paramName))
-			w.line("\t}")
-			w.line("\t%s, err := ToInt64(%sValue)", goVar, goVar)
-			w.line("\tif err != nil {")
-			w.line("\t\treturn nil, errors.Wrapf(bot.ErrBadRequest, \"invalid %s parameter: %%s\", err.Error())", paramName)
-			w.line("\t}")
-		default:
-			if !param.Required {
-				if param.DefaultLiteral != "" {
-					w.line("\t%s := parseOptionalStringQuery(url, %q, %s)", goVar, param.Name, param.DefaultLiteral)
-					break
-				}
-				w.line("\t%s := url.Query().Get(%q)", goVar, param.Name)
-				break
+// pathArgs returns the path parameters of page in the order they appear in its path.
+func pathArgs(page pageInfo) []paramInfo {
+	byName := make(map[string]paramInfo, len(page.PathParams))
+	for _, param := range page.PathParams {
+		byName[param.Name] = param
+	}
+	var args []paramInfo
+	for _, placeholder := range pathPlaceholders(page.Path) {
+		name := placeholder.name
+		param, ok := byName[name]
+		if !ok {
+			// undeclared params are strings, or int64 for an `int` constraint
+			scalar := paramScalar{GoType: "string", Base: "string"}
+			if page.Path[placeholder.start:placeholder.end] == "{"+name+":int}" {
+				scalar = paramScalar{GoType: "int64", Base: "int64"}
 			}
-			w.line("\t%s := url.Query().Get(%q)", goVar, param.Name)
-			w.line("\tif %s == \"\" {", goVar)
-			w.line("\t\treturn nil, errors.Wrap(bot.ErrBadRequest, %q)", fmt.Sprintf("missing %s parameter", paramName))
-			w.line("\t}")
+			param = paramInfo{Name: name, GoName: paramFieldName(name), GoType: scalar.GoType, Scalar: scalar}
+		}
+		param.GoName = lowerFirst(param.GoName)
+		args = append(args, param)
+	}
+	return args
+}
+
+func parseParametersCall(page pageInfo) string {
+	funcName := fmt.Sprintf("ParseParametersPage%s", page.Name)
+	if len(page.PathParams) != 0 {
+		if len(page.QueryParams) != 0 {
+			return fmt.Sprintf("%s(url, routeParams)", funcName)
 		}
+		return fmt.Sprintf("%s(routeParams)", funcName)
 	}
+	return fmt.Sprintf("%s(url)", funcName)
 }
 
 type formFieldInfo struct {
 	name     string
 	goName   string
 	required bool
+	// conditions are the `showIf` expressions of the field and its groups, all of which must hold.
+	conditions []Code
 }
 
-func sortedFormFields(fields map[string]FormField, required []string) []formFieldInfo {
-	if len(fields) == 0 {
+// sortedFormFields returns the fields of form in the order they are asked: the fields listed in
+// `order` first, then the rest by name.
+func sortedFormFields(form *Form) []formFieldInfo {
+	if form == nil || len(form.Fields) == 0 {
 		return nil
 	}
-	keys := make([]string, 0, len(fields))
-	for key := range fields {
+	keys := make([]string, 0, len(form.Fields))
+	listed := make(map[string]struct{}, len(form.Order))
+	for _, key := range form.Order {
+		if _, ok := form.Fields[key]; !ok {
+			continue
+		}
+		if _, ok := listed[key]; ok {
+			continue
+		}
+		listed[key] = struct{}{}
 		keys = append(keys, key)
 	}
-	sort.Strings(keys)
-	requiredSet := make(map[string]struct{}, len(required))
-	for _, name := range required {
+	rest := make([]string, 0, len(form.Fields)-len(keys))
+	for key := range form.Fields {
+		if _, ok := listed[key]; !ok {
+			rest = append(rest, key)
+		}
+	}
+	sort.Strings(rest)
+	keys = append(keys, rest...)
+
+	requiredSet := make(map[string]struct{}, len(form.Required))
+	for _, name := range form.Required {
 		requiredSet[name] = struct{}{}
 	}
+	groupNames := make([]string, 0, len(form.Groups))
+	for name := range form.Groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
 	result := make([]formFieldInfo, 0, len(keys))
 	for _, key := range keys {
 		_, ok := requiredSet[key]
+		var conditions []Code
+		for _, name := range groupNames {
+			group := form.Groups[name]
+			if strings.TrimSpace(string(group.ShowIf)) != "" && slices.Contains(group.Fields, key) {
+				conditions = append(conditions, group.ShowIf)
+			}
+		}
+		if strings.TrimSpace(string(form.Fields[key].ShowIf)) != "" {
+			conditions = append(conditions, form.Fields[key].ShowIf)
+		}
 		result = append(result, formFieldInfo{
-			name:     key,
-			goName:   lowerFirst(goFieldName(key)),
-			required: ok,
+			name:       key,
+			goName:     lowerFirst(goFieldName(key)),
+			required:   ok,
+			conditions: conditions,
 		})
 	}
 	return result
 }
 
+var formValueRefPattern = regexp.MustCompile(`\bvalues\.([A-Za-z_][A-Za-z0-9_]*)`)
+
+// validateForm checks the timeout, that `order` and `groups` name existing fields and that `showIf` conditions only
+// read values entered before the field.
+func validateForm(form *Form) error {
+	if timeout := form.Timeout; timeout != nil {
+		after, err := time.ParseDuration(timeout.After)
+		if err != nil || after <= 0 {
+			return fmt.Errorf("form timeout: invalid duration %q", timeout.After)
+		}
+		if timeout.Route != "" && !strings.HasPrefix(timeout.Route, "/") {
+			return fmt.Errorf("form timeout: route %q must start with /", timeout.Route)
+		}
+	}
+	for _, name := range form.Order {
+		if _, ok := form.Fields[name]; !ok {
+			return fmt.Errorf("form order: unknown field %s", name)
+		}
+	}
+	for groupName, group := range form.Groups {
+		for _, name := range group.Fields {
+			if _, ok := form.Fields[name]; !ok {
+				return fmt.Errorf("form group %s: unknown field %s", groupName, name)
+			}
+		}
+	}
+	earlier := map[string]struct{}{}
+	for _, field := range sortedFormFields(form) {
+		for _, condition := range field.conditions {
+			for _, match := range formValueRefPattern.FindAllStringSubmatch(string(condition), -1) {
+				if _, ok := earlier[match[1]]; !ok {
+					return fmt.Errorf("form field %s: showIf reads %s, which is not an earlier field", field.name, match[0])
+				}
+			}
+		}
+		earlier[field.name] = struct{}{}
+	}
+	return nil
+}
+
+func validatePageKind(page Page) error {
+	switch strings.ToLower(page.Kind) {
+	case "", "page", "action":
+	default:
+		return fmt.Errorf("kind must be page or action, got %s", page.Kind)
+	}
+	switch strings.ToLower(page.Method) {
+	case "", "get", "post":
+	default:
+		return fmt.Errorf("method must be get or post, got %s", page.Method)
+	}
+	switch strings.ToLower(page.History) {
+	case "", "push":
+	case "replace":
+		if isActionPage(page) {
+			return fmt.Errorf("history: replace does not apply to action pages, which stay out of the history")
+		}
+	default:
+		return fmt.Errorf("history must be push or replace, got %s", page.History)
+	}
+	return nil
+}
+
+// isActionPage reports whether page runs a side effect when rendered: `kind: action` or `method: post`.
+func isActionPage(page Page) bool {
+	return strings.EqualFold(page.Kind, "action") || strings.EqualFold(page.Method, "post")
+}
+
+func hasFormTimeouts(pages []pageInfo) bool {
+	for _, page := range pages {
+		if page.Page.Form != nil && page.Page.Form.Timeout != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// durationToGo writes d, a duration validated by validateForm, in its largest whole unit.
+func durationToGo(d string) string {
+	after, _ := time.ParseDuration(d)
+	for _, unit := range []struct {
+		name string
+		d    time.Duration
+	}{{"Hour", time.Hour}, {"Minute", time.Minute}, {"Second", time.Second}, {"Millisecond", time.Millisecond}} {
+		if after%unit.d == 0 {
+			return fmt.Sprintf("%d * time.%s", after/unit.d, unit.name)
+		}
+	}
+	return fmt.Sprintf("%d * time.Nanosecond", after)
+}
+
 func hasForms(pages []pageInfo) bool {
 	for _, page := range pages {
 		if page.Page.Form != nil {
@@ -1771,6 +2457,7 @@ func hasRootPage(pages []pageInfo) bool {
 }
 
 func (g *generatorContext) renderParametersStruct(w *codeWriter, page pageInfo) {
+	renderParamEnums(w, page)
 	w.line("type ParametersPage%s struct {", page.Name)
 	for _, param := range page.Params {
 		w.line("\t%s %s", param.GoName, param.GoType)
@@ -1785,9 +2472,11 @@ func (g *generatorContext) renderParametersStruct(w *codeWriter, page pageInfo)
 	}
 }
 
-func (g *generatorContext) renderStateStruct(w *codeWriter, page pageInfo) {
-	stateInfo := g.stateInfo(page)
-	w.line("type StatePage%s struct {", page.Name)
+// renderStateStruct emits the state struct typeName of schema with its constructor and getters, e.g.
+// StatePageTodoID or StateFormTodoRename.
+func (g *generatorContext) renderStateStruct(w *codeWriter, typeName string, schema *openapi3.Schema) {
+	stateInfo := g.stateInfo(schema)
+	w.line("type %s struct {", typeName)
 	for _, field := range stateInfo.fields {
 		w.line("\t%s %s", field.GoName, field.GoType)
 	}
@@ -1795,8 +2484,8 @@ func (g *generatorContext) renderStateStruct(w *codeWriter, page pageInfo) {
 	w.line("")
 
 	if len(stateInfo.fields) != 0 {
-		w.line("func NewStatePage%s(%s) *StatePage%s {", page.Name, constructorArgs(stateInfo.fields), page.Name)
-		w.line("\treturn &StatePage%s{", page.Name)
+		w.line("func New%s(%s) *%s {", typeName, constructorArgs(stateInfo.fields), typeName)
+		w.line("\treturn &%s{", typeName)
 		for _, field := range stateInfo.fields {
 			w.line("\t\t%s: %s,", field.GoName, field.GoName)
 		}
@@ -1806,7 +2495,7 @@ func (g *generatorContext) renderStateStruct(w *codeWriter, page pageInfo) {
 	}
 
 	for _, getter := range stateInfo.getters {
-		w.line("func (s *StatePage%s) %s() %s {", page.Name, getter.name, getter.goType)
+		w.line("func (s *%s) %s() %s {", typeName, getter.name, getter.goType)
 		w.line("\treturn %s", getter.expr)
 		w.line("}")
 		w.line("")
@@ -1825,11 +2514,11 @@ type stateStructInfo struct {
 	itemRef string
 }
 
-func (g *generatorContext) stateInfo(page pageInfo) stateStructInfo {
-	if page.Page.State == nil {
+func (g *generatorContext) stateInfo(schema *openapi3.Schema) stateStructInfo {
+	if schema == nil {
 		return stateStructInfo{}
 	}
-	if refName, ok := schemaComponentRef(page.Page.State); ok {
+	if refName, ok := schemaComponentRef(schema); ok {
 		component := g.components[refName]
 		fieldName := lowerFirst(component.Name)
 		fields := []fieldInfo{{
@@ -1853,7 +2542,7 @@ func (g *generatorContext) stateInfo(page pageInfo) stateStructInfo {
 		return stateStructInfo{fields: fields, getters: getters}
 	}
 
-	fields, _ := schemaFields(page.Page.State)
+	fields, _ := schemaFields(schema)
 	getters := make([]stateGetterInfo, 0, len(fields))
 	for _, field := range fields {
 		getter := getterName(field.Name)
@@ -1906,11 +2595,14 @@ func (g *generatorContext) renderPageView(w *codeWriter, page pageInfo) {
 func (g *generatorContext) renderFormView(w *codeWriter, page pageInfo) {
 	ctx := g.pageExprContext(page, "")
 	form := page.Page.Form
-	fields := sortedFormFields(form.Fields, form.Required)
+	// `state` in a form is the form state, not the state of the page shown after submitting
+	ctx.stateExprs = g.stateExprs(form.State)
+	ctx.stateItemsExpr = ""
+	fields := sortedFormFields(form)
 	if len(fields) == 0 {
 		return
 	}
-	w.line("func (p *PageRenderer) form%s(ctx context.Context, chatID int64, url *url.URL, parameters *ParametersPage%s) error {", page.Name, page.Name)
+	w.line("func (p *PageRenderer) form%s(%s) error {", page.Name, formViewParams(page))
 	w.line("\tform := &bot.Form{")
 	w.line("\t\tURL: url,")
 	w.line("\t\tIdx: 0,")
@@ -1922,23 +2614,77 @@ func (g *generatorContext) renderFormView(w *codeWriter, page pageInfo) {
 		if definition.Label != "" {
 			w.line("\t\t\t\tLabel: %s,", stringExprToGo(definition.Label, ctx))
 		}
-		if definition.Input != nil {
+		if definition.Input != nil || definition.Default != "" {
 			w.line("\t\t\t\tInput: &bot.FormFieldInput{")
-			w.line("\t\t\t\t\tSchema: &bot.FormSchema{")
-			w.line("\t\t\t\t\t\tType:   %q,", definition.Input.Type)
-			w.line("\t\t\t\t\t\tFormat: %q,", definition.Input.Format)
-			w.line("\t\t\t\t\t},")
-			if definition.Input.Tip != "" {
-				w.line("\t\t\t\t\tTip: %s,", stringExprToGo(definition.Input.Tip, ctx))
+			if definition.Input != nil {
+				w.line("\t\t\t\t\tSchema: &bot.FormSchema{")
+				w.line("\t\t\t\t\t\tType:   %q,", definition.Input.Type)
+				w.line("\t\t\t\t\t\tFormat: %q,", definition.Input.Format)
+				if len(definition.Input.Enum) != 0 {
+					w.line("\t\t\t\t\t\tEnum:   %#v,", definition.Input.Enum)
+				}
+				w.line("\t\t\t\t\t},")
+				if definition.Input.Tip != "" {
+					w.line("\t\t\t\t\tTip: %s,", stringExprToGo(definition.Input.Tip, ctx))
+				}
+			}
+			if definition.Default != "" {
+				w.line("\t\t\t\t\tDefault: %s,", stringExprToGo(definition.Default, ctx))
 			}
 			w.line("\t\t\t\t},")
 		}
 		if definition.Validator != nil {
 			w.line("\t\t\t\tValidator: ptr(%q),", strings.TrimSpace(string(*definition.Validator)))
 		}
+		if len(field.conditions) != 0 {
+			w.line("\t\t\t\tConditional: true,")
+		}
 		w.line("\t\t\t},")
 	}
 	w.line("\t\t},")
+	if form.Current != "" {
+		w.line("\t\tCurrentLabel: %s,", stringExprToGo(form.Current, ctx))
+	}
+	if form.Keep != "" {
+		w.line("\t\tKeepLabel: %s,", stringExprToGo(form.Keep, ctx))
+	}
+	if review := form.Review; review != nil {
+		w.line("\t\tReview: &bot.FormReview{")
+		if review.Title != "" {
+			w.line("\t\t\tTitle: %s,", stringExprToGo(review.Title, ctx))
+		}
+		if review.Edit != "" {
+			w.line("\t\t\tEditLabel: %s,", stringExprToGo(review.Edit, ctx))
+		}
+		if review.Submit != "" {
+			w.line("\t\t\tSubmitLabel: %s,", stringExprToGo(review.Submit, ctx))
+		}
+		w.line("\t\t},")
+	}
+	if timeout := form.Timeout; timeout != nil {
+		w.line("\t\tTimeout: &bot.FormTimeout{")
+		w.line("\t\t\tAfter: %s,", durationToGo(timeout.After))
+		if timeout.Message != "" {
+			w.line("\t\t\tMessage: %s,", stringExprToGo(timeout.Message, ctx))
+		}
+		if timeout.Route != "" {
+			w.line("\t\t\tRoute: %q,", timeout.Route)
+		}
+		w.line("\t\t},")
+	}
+	if resume := form.Resumable; resume != nil {
+		w.line("\t\tResume: &bot.FormResume{")
+		if resume.Message != "" {
+			w.line("\t\t\tMessage: %s,", stringExprToGo(resume.Message, ctx))
+		}
+		if resume.Continue != "" {
+			w.line("\t\t\tContinueLabel: %s,", stringExprToGo(resume.Continue, ctx))
+		}
+		if resume.Restart != "" {
+			w.line("\t\t\tRestartLabel: %s,", stringExprToGo(resume.Restart, ctx))
+		}
+		w.line("\t\t},")
+	}
 	w.line("\t}")
 	w.line("\tif err := p.b.SendForm(ctx, chatID, form); err != nil {")
 	w.line("\t\treturn errors.Wrap(err, \"failed to send form%s\")", page.Name)
@@ -1948,6 +2694,16 @@ func (g *generatorContext) renderFormView(w *codeWriter, page pageInfo) {
 	w.line("")
 }
 
+// formViewParams returns the parameters of the form renderer of page, which takes the form state when the
+// form declares one.
+func formViewParams(page pageInfo) string {
+	params := fmt.Sprintf("ctx context.Context, chatID int64, url *url.URL, parameters *ParametersPage%s", page.Name)
+	if page.Page.Form.State != nil {
+		params += fmt.Sprintf(", state *StateForm%s", page.Name)
+	}
+	return params
+}
+
 func (g *generatorContext) renderErrorPageView(w *codeWriter, page pageInfo) {
 	ctx := exprContext{errExpr: "err.Error()", i18nKeys: g.i18nKeys, i18nFunc: "i18n(ctx, chatID, %q)"}
 	w.line("func (p *PageRenderer) pageError(ctx context.Context, chatID int64, err error) error {")
@@ -2018,9 +2774,7 @@ func buttonGridLines(grid ButtonGrid, ctx exprContext) []string {
 	for _, row := range grid.Rows {
 		lines = append(lines, "\t{")
 		for _, button := range row.Columns {
-			label := stringExprToGo(button.Label, ctx)
-			onClick := stringExprToGo(button.OnClick, ctx)
-			lines = append(lines, fmt.Sprintf("\t\t{Label: %s, CallbackData: bot.CallbackData(%s)},", label, onClick))
+			lines = append(lines, fmt.Sprintf("\t\t%s,", buttonLiteral(button, ctx)))
 		}
 		lines = append(lines, "\t},")
 	}
@@ -2061,9 +2815,17 @@ func (g *generatorContext) paginationLines(pagination Pagination, page pageInfo,
 	lines = append(lines, fmt.Sprintf("\tfunc(item %s) bot.Button {", itemType))
 	lines = append(lines, "\t\treturn bot.Button{")
 	lines = append(lines, fmt.Sprintf("\t\t\tLabel: %s,", stringExprToGo(pagination.Item.Label, itemCtx)))
-	lines = append(lines, fmt.Sprintf("\t\t\tCallbackData: bot.CallbackData(%s),", stringExprToGo(pagination.Item.OnClick, itemCtx)))
+	lines = append(lines, fmt.Sprintf("\t\t\tCallbackData: bot.CallbackData(%s),", onClickToGo(pagination.Item.OnClick, itemCtx)))
+	if confirm := pagination.Item.Confirm; confirm != nil {
+		lines = append(lines, fmt.Sprintf("\t\t\tConfirm: %s,", confirmLiteral(confirm, itemCtx)))
+	}
 	lines = append(lines, "\t\t}")
 	lines = append(lines, "\t},")
+	link, err := paginationLinkLines(page)
+	if err != nil {
+		return nil, err
+	}
+	lines = append(lines, link...)
 	if pagination.PrevLabel != "" {
 		lines = append(lines, fmt.Sprintf("\t%s,", stringExprToGo(pagination.PrevLabel, ctx)))
 	}
@@ -2074,6 +2836,51 @@ func (g *generatorContext) paginationLines(pagination Pagination, page pageInfo,
 	return lines, nil
 }
 
+// paginationLinkLines returns the function passed to the pagination helper that links to another page of
+// the list. The link keeps the other query parameters, such as a filter, and is built with the route
+// builder of the page.
+func paginationLinkLines(page pageInfo) ([]string, error) {
+	pageKey := paginationParamKey(page, "page")
+	var args, fields []string
+	for _, arg := range pathArgs(page) {
+		args = append(args, pageParamExpr(page, arg.Name))
+	}
+	found := false
+	for _, param := range page.QueryParams {
+		value := pageParamExpr(page, param.Name)
+		if param.Name == pageKey {
+			found = true
+			value = "page"
+			if param.GoType != "int" {
+				value = fmt.Sprintf("%s(page)", param.GoType)
+			}
+		}
+		if param.builderPointer() {
+			value = fmt.Sprintf("bot.Ptr(%s)", value)
+		}
+		fields = append(fields, fmt.Sprintf("%s: %s", goFieldName(param.Name), value))
+	}
+	if !found {
+		return nil, fmt.Errorf("page %s: pagination needs a %q query parameter", page.Path, pageKey)
+	}
+	args = append(args, fmt.Sprintf("%sParams{%s}", page.Name, strings.Join(fields, ", ")))
+	return []string{
+		"	func(page int) string {",
+		fmt.Sprintf("		return Route%s(%s)", page.Name, strings.Join(args, ", ")),
+		"	},",
+	}, nil
+}
+
+// pageParamExpr returns the expression reading parameter name of page in its handler.
+func pageParamExpr(page pageInfo, name string) string {
+	for _, param := range page.Params {
+		if param.Name == name {
+			return fmt.Sprintf("parameters.%s()", param.GetterName)
+		}
+	}
+	return name
+}
+
 func paginationItemType(page pageInfo) string {
 	if page.Page.State == nil {
 		return "any"
@@ -2103,7 +2910,7 @@ func paginationHelpers(pages []pageInfo) []paginationHelperInfo {
 				continue
 			}
 			seen[name] = struct{}{}
-			helpers = append(helpers, paginationHelperInfo{name: name, page: page})
+			helpers = append(helpers, paginationHelperInfo{name: name})
 		}
 	}
 	return helpers
@@ -2111,16 +2918,12 @@ func paginationHelpers(pages []pageInfo) []paginationHelperInfo {
 
 type paginationHelperInfo struct {
 	name string
-	page pageInfo
 }
 
+// renderPaginationHelper emits the helper laying out one page of items; link returns the route of
+// another page of the list.
 func (g *generatorContext) renderPaginationHelper(w *codeWriter, helper paginationHelperInfo) {
-	path := helper.page.Path
-	rowKey := paginationParamKey(helper.page, "row")
-	colKey := paginationParamKey(helper.page, "column")
-	pageKey := paginationParamKey(helper.page, "page")
-
-	w.line("func %s[T any](columns int, rows int, total int, page int, items []T, castFunc func(item T) bot.Button, prevLabel string, nextLabel string) [][]bot.Button {", helper.name)
+	w.line("func %s[T any](columns int, rows int, total int, page int, items []T, castFunc func(item T) bot.Button, link func(page int) string, prevLabel string, nextLabel string) [][]bot.Button {", helper.name)
 	w.line("\tvar grid [][]bot.Button")
 	w.line("\tif rows <= 0 || columns <= 0 {")
 	w.line("\t\treturn grid")
@@ -2149,14 +2952,14 @@ func (g *generatorContext) renderPaginationHelper(w *codeWriter, helper paginati
 	w.line("\tif page != 0 {")
 	w.line("\t\tctrlRow = append(ctrlRow, bot.Button{")
 	w.line("\t\t\tLabel:        prevLabel,")
-	w.line("\t\t\tCallbackData: bot.CallbackData(fmt.Sprintf(%q, rows, columns, page-1)),", fmt.Sprintf("%s?%s=%%d&%s=%%d&%s=%%d", path, rowKey, colKey, pageKey))
+	w.line("\t\t\tCallbackData: bot.CallbackData(link(page - 1)),")
 	w.line("\t\t})")
 	w.line("\t}")
 	w.line("")
 	w.line("\tif !isLastPage {")
 	w.line("\t\tctrlRow = append(ctrlRow, bot.Button{")
 	w.line("\t\t\tLabel:        nextLabel,")
-	w.line("\t\t\tCallbackData: bot.CallbackData(fmt.Sprintf(%q, rows, columns, page+1)),", fmt.Sprintf("%s?%s=%%d&%s=%%d&%s=%%d", path, rowKey, colKey, pageKey))
+	w.line("\t\t\tCallbackData: bot.CallbackData(link(page + 1)),")
 	w.line("\t\t})")
 	w.line("\t}")
 	w.line("")
@@ -2253,34 +3056,39 @@ func buttonRowLiteral(rows []ButtonGridRow, ctx exprContext) string {
 	row := rows[0]
 	parts := make([]string, 0, len(row.Columns))
 	for _, button := range row.Columns {
-		label := stringExprToGo(button.Label, ctx)
-		onClick := stringExprToGo(button.OnClick, ctx)
-		parts = append(parts, fmt.Sprintf("{Label: %s, CallbackData: bot.CallbackData(%s)}", label, onClick))
+		parts = append(parts, buttonLiteral(button, ctx))
 	}
 	return fmt.Sprintf("[]bot.Button{%s}", strings.Join(parts, ", "))
 }
 
+// buttonLiteral renders a bot.Button composite literal without its type.
+func buttonLiteral(button Button, ctx exprContext) string {
+	label := stringExprToGo(button.Label, ctx)
+	onClick := onClickToGo(button.OnClick, ctx)
+	if button.Confirm == nil {
+		return fmt.Sprintf("{Label: %s, CallbackData: bot.CallbackData(%s)}", label, onClick)
+	}
+	return fmt.Sprintf("{Label: %s, CallbackData: bot.CallbackData(%s), Confirm: %s}", label, onClick, confirmLiteral(button.Confirm, ctx))
+}
+
+func confirmLiteral(confirm *ButtonConfirm, ctx exprContext) string {
+	parts := []string{fmt.Sprintf("Message: %s", stringExprToGo(confirm.Message, ctx))}
+	if confirm.Yes != "" {
+		parts = append(parts, fmt.Sprintf("YesLabel: %s", stringExprToGo(confirm.Yes, ctx)))
+	}
+	if confirm.No != "" {
+		parts = append(parts, fmt.Sprintf("NoLabel: %s", stringExprToGo(confirm.No, ctx)))
+	}
+	return fmt.Sprintf("&bot.Confirm{%s}", strings.Join(parts, ", "))
+}
+
 func (g *generatorContext) pageExprContext(page pageInfo, itemType string) exprContext {
 	paramExprs := make(map[string]string)
 	for _, param := range page.Params {
 		paramExprs[param.Name] = fmt.Sprintf("parameters.%s()", param.GetterName)
 	}
 
-	stateExprs := make(map[string]string)
-	if page.Page.State != nil {
-		if refName, ok := schemaComponentRef(page.Page.State); ok {
-			if component, ok := g.components[refName]; ok {
-				for _, field := range component.Fields {
-					stateExprs[field.Name] = fmt.Sprintf("state.%s()", getterName(field.Name))
-				}
-			}
-		} else {
-			fields, _ := schemaFields(page.Page.State)
-			for _, field := range fields {
-				stateExprs[field.Name] = fmt.Sprintf("state.%s()", getterName(field.Name))
-			}
-		}
-	}
+	stateExprs := g.stateExprs(page.Page.State)
 
 	itemExprs := make(map[string]string)
 	if itemType != "" {
@@ -2314,6 +3122,45 @@ func (g *generatorContext) pageExprContext(page pageInfo, itemType string) exprC
 	}
 }
 
+// stateExprs maps the fields of a state schema to their getters on `state`.
+func (g *generatorContext) stateExprs(schema *openapi3.Schema) map[string]string {
+	stateExprs := make(map[string]string)
+	if schema == nil {
+		return stateExprs
+	}
+	if refName, ok := schemaComponentRef(schema); ok {
+		if component, ok := g.components[refName]; ok {
+			for _, field := range component.Fields {
+				stateExprs[field.Name] = fmt.Sprintf("state.%s()", getterName(field.Name))
+			}
+		}
+		return stateExprs
+	}
+	fields, _ := schemaFields(schema)
+	for _, field := range fields {
+		stateExprs[field.Name] = fmt.Sprintf("state.%s()", getterName(field.Name))
+	}
+	return stateExprs
+}
+
+// onClickToGo converts an onClick value to the argument of bot.CallbackData. `route:@TodoID(item.ID)` calls
+// the generated RouteTodoID builder, so a renamed or removed page fails to compile. `replace:@...` and
+// `reset:@...` do the same for the replace and reset navigation modes.
+func onClickToGo(expr StringExpr, ctx exprContext) string {
+	value := strings.TrimSpace(string(expr))
+	for _, mode := range []string{"route", "replace", "reset"} {
+		call, ok := strings.CutPrefix(value, mode+":@")
+		if !ok {
+			continue
+		}
+		if !strings.Contains(call, "(") {
+			call += "()"
+		}
+		return fmt.Sprintf("%q + Route%s", mode+":", rewriteExpr(call, ctx))
+	}
+	return stringExprToGo(expr, ctx)
+}
+
 func stringExprToGo(expr StringExpr, ctx exprContext) string {
 	raw := string(expr)
 	if raw == "" {
@@ -2469,6 +3316,16 @@ func rewriteExpr(expr string, ctx exprContext) string {
 					continue
 				}
 			}
+			if ident == "values" && ctx.valuesExpr != "" && i < len(expr) && expr[i] == '.' {
+				fieldStart := i + 1
+				fieldEnd := fieldStart
+				for fieldEnd < len(expr) && isIdentPart(expr[fieldEnd]) {
+					fieldEnd++
+				}
+				fmt.Fprintf(&sb, "%s[%q]", ctx.valuesExpr, expr[fieldStart:fieldEnd])
+				i = fieldEnd
+				continue
+			}
 			if ident == "err" && ctx.errExpr != "" {
 				sb.WriteString(ctx.errExpr)
 				continue
@@ -2552,6 +3409,8 @@ func defaultConstName(pageName string, paramName string, defaultLiteral string)
 type defaultConstant struct {
 	name  string
 	value string
+	// isVar is set for defaults that cannot be constants.
+	isVar bool
 }
 
 func defaultConstants(pages []pageInfo) []defaultConstant {
@@ -2567,7 +3426,7 @@ func defaultConstants(pages []pageInfo) []defaultConstant {
 				continue
 			}
 			seen[name] = struct{}{}
-			result = append(result, defaultConstant{name: name, value: param.DefaultLiteral})
+			result = append(result, defaultConstant{name: name, value: param.DefaultLiteral, isVar: param.DefaultVar})
 		}
 	}
 	sort.Slice(result, func(i, j int) bool { return result[i].name < result[j].name })
@@ -0,0 +1,672 @@
+package codegen
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// paramScalar is the type of a parameter value, or of each item of an array parameter.
+type paramScalar struct {
+	// GoType is the Go type of a value, e.g. int64, time.Time or an enum type.
+	GoType string
+	// Base is the underlying Go type: int, int32, int64, float32, float64, bool, string or time.Time.
+	Base string
+	// Format is "date", "date-time" or "uuid" for strings with such a format.
+	Format string
+	Enum   []enumValue
+}
+
+type enumValue struct {
+	ConstName string
+	Literal   string
+}
+
+// parseFunc returns the generated function converting a raw value to the scalar.
+func (s paramScalar) parseFunc() string {
+	if len(s.Enum) != 0 {
+		return "parse" + s.GoType
+	}
+	switch s.Format {
+	case "date":
+		return "ToDate"
+	case "date-time":
+		return "ToDateTime"
+	case "uuid":
+		return "ToUUID"
+	}
+	switch s.Base {
+	case "int":
+		return "ToInt"
+	case "int32":
+		return "ToInt32"
+	case "int64":
+		return "ToInt64"
+	case "float32":
+		return "ToFloat32"
+	case "float64":
+		return "ToFloat64"
+	case "bool":
+		return "ToBool"
+	default:
+		return "parseString"
+	}
+}
+
+// formatFunc returns the generated function turning a value of the scalar back into a raw value.
+func (s paramScalar) formatFunc() string {
+	switch s.Format {
+	case "date":
+		return "formatDate"
+	case "date-time":
+		return "formatDateTime"
+	}
+	return fmt.Sprintf("formatParam[%s]", s.GoType)
+}
+
+// formatExpr returns the raw value of expr, a value of the scalar.
+func (s paramScalar) formatExpr(expr string) string {
+	switch {
+	case s.Base == "time.Time":
+		return fmt.Sprintf("%s(%s)", s.formatFunc(), expr)
+	case s.GoType == "string":
+		return expr
+	case s.Base == "string":
+		return fmt.Sprintf("string(%s)", expr)
+	default:
+		return fmt.Sprintf("fmt.Sprint(%s)", expr)
+	}
+}
+
+// zeroLiteral returns the zero value of the scalar.
+func (s paramScalar) zeroLiteral() string {
+	switch s.Base {
+	case "string":
+		return `""`
+	case "bool":
+		return "false"
+	case "time.Time":
+		return "time.Time{}"
+	default:
+		return "0"
+	}
+}
+
+// sampleValue returns a raw value the scalar accepts, used for routes rendered without a fixture.
+func (s paramScalar) sampleValue(name string) string {
+	if len(s.Enum) != 0 {
+		value, _ := strconv.Unquote(s.Enum[0].Literal)
+		if value == "" {
+			value = s.Enum[0].Literal
+		}
+		return value
+	}
+	switch s.Format {
+	case "date":
+		return "1970-01-01"
+	case "date-time":
+		return "1970-01-01T00:00:00Z"
+	case "uuid":
+		return "00000000-0000-0000-0000-000000000000"
+	}
+	switch s.Base {
+	case "string":
+		return name
+	case "bool":
+		return "false"
+	default:
+		return "0"
+	}
+}
+
+// parseParameters returns the parameters of page pageName sorted by key. Enum parameters get a Go type
+// named after the page and the parameter, e.g. TodoListFilter.
+func parseParameters(pageName string, params Parameters) ([]paramInfo, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result []paramInfo
+	for _, key := range keys {
+		param := params[key]
+		if param == nil {
+			continue
+		}
+		name := param.Name
+		if name == "" {
+			name = key
+		}
+		in := strings.ToLower(strings.TrimSpace(param.In))
+		if in == "" {
+			in = "query"
+		}
+		info := paramInfo{
+			Name:       name,
+			GoName:     paramFieldName(name),
+			GetterName: getterName(name),
+			In:         in,
+			Required:   param.Required || in == "path",
+		}
+		scalar, array, err := paramScalarOf(pageName+goFieldName(name), param.Schema)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", name, err)
+		}
+		info.Scalar = scalar
+		info.Array = array
+		info.GoType = scalar.GoType
+		if array {
+			info.GoType = "[]" + scalar.GoType
+		}
+		if param.Schema != nil && param.Schema.Value != nil && param.Schema.Value.Default != nil {
+			if info.Required {
+				return nil, fmt.Errorf("parameter %s: a required parameter cannot have a default", name)
+			}
+			info.DefaultLiteral, info.DefaultVar, err = paramDefaultLiteral(info, param.Schema.Value.Default)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: default: %w", name, err)
+			}
+		}
+		result = append(result, info)
+	}
+	return result, nil
+}
+
+// paramScalarOf returns the scalar type of schema and whether the parameter is an array of it. enumType
+// names the Go type of enum values.
+func paramScalarOf(enumType string, schemaRef *openapi3.SchemaRef) (paramScalar, bool, error) {
+	if schemaRef == nil || schemaRef.Value == nil {
+		return paramScalar{GoType: "string", Base: "string"}, false, nil
+	}
+	if schemaRef.Ref != "" {
+		return paramScalar{}, false, fmt.Errorf("schema references are not supported, got %s", schemaRef.Ref)
+	}
+	schema := schemaRef.Value
+	if schema.Type != nil && schema.Type.Is("array") {
+		if schema.Items == nil || schema.Items.Value == nil {
+			return paramScalar{}, false, fmt.Errorf("array requires items")
+		}
+		if schema.Items.Value.Type != nil && schema.Items.Value.Type.Is("array") {
+			return paramScalar{}, false, fmt.Errorf("nested arrays are not supported")
+		}
+		scalar, _, err := paramScalarOf(enumType, schema.Items)
+		return scalar, true, err
+	}
+	base := "string"
+	if schema.Type != nil && len(*schema.Type) != 0 {
+		switch (*schema.Type)[0] {
+		case "string", "integer", "number", "boolean":
+			base = schemaToGoType(schema)
+		default:
+			return paramScalar{}, false, fmt.Errorf("unsupported type %s", (*schema.Type)[0])
+		}
+	}
+	scalar := paramScalar{GoType: base, Base: base}
+	if base == "string" {
+		switch schema.Format {
+		case "date", "date-time":
+			scalar = paramScalar{GoType: "time.Time", Base: "time.Time", Format: schema.Format}
+		case "uuid":
+			scalar.Format = schema.Format
+		}
+	}
+	if len(schema.Enum) == 0 {
+		return scalar, false, nil
+	}
+	if scalar.Base == "bool" || scalar.Base == "time.Time" {
+		return paramScalar{}, false, fmt.Errorf("enum is not supported for %s values", scalar.Base)
+	}
+	scalar.GoType = enumType
+	seen := map[string]struct{}{}
+	for _, value := range schema.Enum {
+		literal, err := scalarLiteral(scalar.Base, value)
+		if err != nil {
+			return paramScalar{}, false, fmt.Errorf("enum: %w", err)
+		}
+		constName := enumType + toCamel(fmt.Sprint(value))
+		if _, ok := seen[constName]; ok {
+			return paramScalar{}, false, fmt.Errorf("enum value %v collides with another value as %s", value, constName)
+		}
+		seen[constName] = struct{}{}
+		scalar.Enum = append(scalar.Enum, enumValue{ConstName: constName, Literal: literal})
+	}
+	return scalar, false, nil
+}
+
+// scalarLiteral returns the Go literal of value for a base type, checking that value fits it.
+func scalarLiteral(base string, value any) (string, error) {
+	switch base {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("%v is not a string", value)
+		}
+		return strconv.Quote(s), nil
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return "", fmt.Errorf("%v is not a boolean", value)
+		}
+		return strconv.FormatBool(b), nil
+	case "int", "int32", "int64":
+		switch v := value.(type) {
+		case int:
+			return strconv.Itoa(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		case float64:
+			if v != float64(int64(v)) {
+				return "", fmt.Errorf("%v is not an integer", value)
+			}
+			return strconv.FormatInt(int64(v), 10), nil
+		}
+		return "", fmt.Errorf("%v is not an integer", value)
+	case "float32", "float64":
+		switch v := value.(type) {
+		case int, int64:
+			return fmt.Sprint(v), nil
+		case float64:
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		}
+		return "", fmt.Errorf("%v is not a number", value)
+	}
+	return "", fmt.Errorf("unsupported type %s", base)
+}
+
+// paramDefaultLiteral returns the Go expression of the default of param and whether it must be a var
+// rather than a constant.
+func paramDefaultLiteral(param paramInfo, value any) (string, bool, error) {
+	if param.Array {
+		values, ok := value.([]any)
+		if !ok {
+			return "", false, fmt.Errorf("%v is not an array", value)
+		}
+		items := make([]string, 0, len(values))
+		for _, item := range values {
+			literal, err := scalarDefaultLiteral(param.Scalar, item)
+			if err != nil {
+				return "", false, err
+			}
+			items = append(items, literal)
+		}
+		return fmt.Sprintf("%s{%s}", param.GoType, strings.Join(items, ", ")), true, nil
+	}
+	literal, err := scalarDefaultLiteral(param.Scalar, value)
+	return literal, param.Scalar.Base == "time.Time", err
+}
+
+func scalarDefaultLiteral(scalar paramScalar, value any) (string, error) {
+	if scalar.Base == "time.Time" {
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("%v is not a string", value)
+		}
+		layout := time.DateOnly
+		if scalar.Format == "date-time" {
+			layout = time.RFC3339
+		}
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			return "", err
+		}
+		t = t.UTC()
+		return fmt.Sprintf("time.Date(%d, %d, %d, %d, %d, %d, %d, time.UTC)", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond()), nil
+	}
+	literal, err := scalarLiteral(scalar.Base, value)
+	if err != nil {
+		return "", err
+	}
+	for _, enum := range scalar.Enum {
+		if enum.Literal == literal {
+			return enum.ConstName, nil
+		}
+	}
+	if len(scalar.Enum) != 0 {
+		return "", fmt.Errorf("%v is not one of the enum values", value)
+	}
+	if scalar.Format == "uuid" && !isUUID(value.(string)) {
+		return "", fmt.Errorf("%v is not a uuid", value)
+	}
+	return literal, nil
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, r := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if r != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func hasDateParams(pages []pageInfo) bool {
+	for _, page := range pages {
+		for _, param := range page.Params {
+			if param.Scalar.Base == "time.Time" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// renderParamEnums emits the Go type, constants and parser of every enum parameter of page.
+func renderParamEnums(w *codeWriter, page pageInfo) {
+	for _, param := range page.Params {
+		scalar := param.Scalar
+		if len(scalar.Enum) == 0 {
+			continue
+		}
+		w.line("// %s is a value of the %s parameter of page %s.", scalar.GoType, param.Name, page.Path)
+		w.line("type %s %s", scalar.GoType, scalar.Base)
+		w.line("")
+		w.line("const (")
+		for _, value := range scalar.Enum {
+			w.line("\t%s %s = %s", value.ConstName, scalar.GoType, value.Literal)
+		}
+		w.line(")")
+		w.line("")
+		zero := scalar.zeroLiteral()
+		names := make([]string, 0, len(scalar.Enum))
+		for _, value := range scalar.Enum {
+			names = append(names, value.ConstName)
+		}
+		w.line("func %s(s string) (%s, error) {", scalar.parseFunc(), scalar.GoType)
+		raw := "s"
+		if scalar.Base != "string" {
+			base := paramScalar{GoType: scalar.Base, Base: scalar.Base}
+			w.line("\traw, err := %s(s)", base.parseFunc())
+			w.line("\tif err != nil {")
+			w.line("\t\treturn %s, err", zero)
+			w.line("\t}")
+			raw = "raw"
+		}
+		w.line("\tswitch value := %s(%s); value {", scalar.GoType, raw)
+		w.line("\tcase %s:", strings.Join(names, ", "))
+		w.line("\t\treturn value, nil")
+		w.line("\t}")
+		w.line("\treturn %s, fmt.Errorf(\"unknown value %%q\", s)", zero)
+		w.line("}")
+		w.line("")
+	}
+}
+
+// parseParam emits the parsing of param into a variable named after it. Missing and invalid values fail
+// with bot.ErrBadRequest.
+func parseParam(w *codeWriter, param paramInfo, page pageInfo) {
+	goVar := lowerFirst(param.GoName)
+	parse := param.Scalar.parseFunc()
+	if param.In == "path" {
+		helper := "parsePathParam"
+		if param.Array {
+			helper = "parsePathParams"
+		}
+		w.line("\t%s, err := %s(params, %q, %s)", goVar, helper, param.Name, parse)
+	} else {
+		helper := "parseQueryParam"
+		defaultValue := param.Scalar.zeroLiteral()
+		if param.Array {
+			helper = "parseQueryParams"
+			defaultValue = "nil"
+		}
+		if param.DefaultLiteral != "" {
+			defaultValue = defaultConstName(page.Name, param.Name, param.DefaultLiteral)
+		}
+		w.line("\t%s, err := %s(url, %q, %t, %s, %s)", goVar, helper, param.Name, param.Required, defaultValue, parse)
+	}
+	w.line("\tif err != nil {")
+	w.line("\t\treturn nil, err")
+	w.line("\t}")
+}
+
+// renderParamHelpers emits the generic parameter parsers and the scalar conversions they are given.
+func renderParamHelpers(w *codeWriter, pages []pageInfo) {
+	w.line("// parseQueryParam parses the query parameter key, falling back to defaultValue when it is absent.")
+	w.line("func parseQueryParam[T any](url *url.URL, key string, required bool, defaultValue T, parse func(string) (T, error)) (T, error) {")
+	w.line("\tvalue := url.Query().Get(key)")
+	w.line("\tif value == \"\" {")
+	w.line("\t\tif required {")
+	w.line("\t\t\treturn defaultValue, errors.Wrapf(bot.ErrBadRequest, \"missing %%s parameter\", strings.ToLower(key))")
+	w.line("\t\t}")
+	w.line("\t\treturn defaultValue, nil")
+	w.line("\t}")
+	w.line("\treturn parseParamValue(key, value, parse)")
+	w.line("}")
+	w.line("")
+
+	w.line("// parseQueryParams parses an array query parameter given as repeated or comma-separated values.")
+	w.line("func parseQueryParams[T any](url *url.URL, key string, required bool, defaultValue []T, parse func(string) (T, error)) ([]T, error) {")
+	w.line("\traw := url.Query()[key]")
+	w.line("\tvar values []string")
+	w.line("\tif len(raw) == 1 {")
+	w.line("\t\tvalues = splitParamValues(raw[0])")
+	w.line("\t} else {")
+	w.line("\t\t// repeated keys hold one item each, commas included")
+	w.line("\t\tfor _, value := range raw {")
+	w.line("\t\t\tif value != \"\" {")
+	w.line("\t\t\t\tvalues = append(values, value)")
+	w.line("\t\t\t}")
+	w.line("\t\t}")
+	w.line("\t}")
+	w.line("\tif len(values) == 0 {")
+	w.line("\t\tif required {")
+	w.line("\t\t\treturn defaultValue, errors.Wrapf(bot.ErrBadRequest, \"missing %%s parameter\", strings.ToLower(key))")
+	w.line("\t\t}")
+	w.line("\t\treturn defaultValue, nil")
+	w.line("\t}")
+	w.line("\treturn parseParamValues(key, values, parse)")
+	w.line("}")
+	w.line("")
+
+	w.line("func parsePathParam[T any](params routepath.Params, key string, parse func(string) (T, error)) (T, error) {")
+	w.line("\tvalue, ok := params.Get(key)")
+	w.line("\tif !ok || value == \"\" {")
+	w.line("\t\tvar zero T")
+	w.line("\t\treturn zero, errors.Wrapf(bot.ErrBadRequest, \"missing %%s parameter\", strings.ToLower(key))")
+	w.line("\t}")
+	w.line("\treturn parseParamValue(key, value, parse)")
+	w.line("}")
+	w.line("")
+
+	w.line("// parsePathParams parses an array path parameter given as comma-separated values.")
+	w.line("func parsePathParams[T any](params routepath.Params, key string, parse func(string) (T, error)) ([]T, error) {")
+	w.line("\tvalue, _ := params.Get(key)")
+	w.line("\tvalues := splitParamValues(value)")
+	w.line("\tif len(values) == 0 {")
+	w.line("\t\treturn nil, errors.Wrapf(bot.ErrBadRequest, \"missing %%s parameter\", strings.ToLower(key))")
+	w.line("\t}")
+	w.line("\treturn parseParamValues(key, values, parse)")
+	w.line("}")
+	w.line("")
+
+	w.line("func parseParamValue[T any](key string, value string, parse func(string) (T, error)) (T, error) {")
+	w.line("\tparsed, err := parse(value)")
+	w.line("\tif err != nil {")
+	w.line("\t\treturn parsed, errors.Wrapf(bot.ErrBadRequest, \"invalid %%s parameter: %%s\", strings.ToLower(key), err.Error())")
+	w.line("\t}")
+	w.line("\treturn parsed, nil")
+	w.line("}")
+	w.line("")
+
+	w.line("func parseParamValues[T any](key string, values []string, parse func(string) (T, error)) ([]T, error) {")
+	w.line("\tparsed := make([]T, 0, len(values))")
+	w.line("\tfor _, value := range values {")
+	w.line("\t\titem, err := parseParamValue(key, value, parse)")
+	w.line("\t\tif err != nil {")
+	w.line("\t\t\treturn nil, err")
+	w.line("\t\t}")
+	w.line("\t\tparsed = append(parsed, item)")
+	w.line("\t}")
+	w.line("\treturn parsed, nil")
+	w.line("}")
+	w.line("")
+
+	w.line("func splitParamValues(value string) []string {")
+	w.line("\tvar values []string")
+	w.line("\tfor _, item := range strings.Split(value, \",\") {")
+	w.line("\t\tif item != \"\" {")
+	w.line("\t\t\tvalues = append(values, item)")
+	w.line("\t\t}")
+	w.line("\t}")
+	w.line("\treturn values")
+	w.line("}")
+	w.line("")
+
+	w.line("func formatParam[T any](value T) string {")
+	w.line("\treturn fmt.Sprint(value)")
+	w.line("}")
+	w.line("")
+
+	w.line("// addParamValues adds the items of an array query parameter as repeated keys. A single item with a")
+	w.line("// comma gets an empty value after it, so it is not split like a comma-separated list.")
+	w.line("func addParamValues(query url.Values, key string, values []string) {")
+	w.line("\tfor _, value := range values {")
+	w.line("\t\tquery.Add(key, value)")
+	w.line("\t}")
+	w.line("\tif len(values) == 1 && strings.Contains(values[0], \",\") {")
+	w.line("\t\tquery.Add(key, \"\")")
+	w.line("\t}")
+	w.line("}")
+	w.line("")
+
+	w.line("// formatParamValues formats the items of an array parameter.")
+	w.line("func formatParamValues[T any](values []T, format func(T) string) []string {")
+	w.line("\tformatted := make([]string, 0, len(values))")
+	w.line("\tfor _, value := range values {")
+	w.line("\t\tformatted = append(formatted, format(value))")
+	w.line("\t}")
+	w.line("\treturn formatted")
+	w.line("}")
+	w.line("")
+
+	w.line("func parseString(s string) (string, error) {")
+	w.line("\treturn s, nil")
+	w.line("}")
+	w.line("")
+
+	w.line("func ToFloat32(s string) (float32, error) {")
+	w.line("\tf, err := strconv.ParseFloat(s, 32)")
+	w.line("\treturn float32(f), err")
+	w.line("}")
+	w.line("")
+
+	w.line("func ToFloat64(s string) (float64, error) {")
+	w.line("\treturn strconv.ParseFloat(s, 64)")
+	w.line("}")
+	w.line("")
+
+	w.line("func ToBool(s string) (bool, error) {")
+	w.line("\treturn strconv.ParseBool(s)")
+	w.line("}")
+	w.line("")
+
+	w.line("// ToUUID checks that s is a UUID such as 123e4567-e89b-12d3-a456-426614174000.")
+	w.line("func ToUUID(s string) (string, error) {")
+	w.line("\tif len(s) != 36 {")
+	w.line("\t\treturn \"\", fmt.Errorf(\"%%q is not a uuid\", s)")
+	w.line("\t}")
+	w.line("\tfor i, r := range s {")
+	w.line("\t\tswitch {")
+	w.line("\t\tcase i == 8 || i == 13 || i == 18 || i == 23:")
+	w.line("\t\t\tif r != '-' {")
+	w.line("\t\t\t\treturn \"\", fmt.Errorf(\"%%q is not a uuid\", s)")
+	w.line("\t\t\t}")
+	w.line("\t\tcase !strings.ContainsRune(\"0123456789abcdefABCDEF\", r):")
+	w.line("\t\t\treturn \"\", fmt.Errorf(\"%%q is not a uuid\", s)")
+	w.line("\t\t}")
+	w.line("\t}")
+	w.line("\treturn s, nil")
+	w.line("}")
+	w.line("")
+
+	if hasDateParams(pages) {
+		w.line("func ToDate(s string) (time.Time, error) {")
+		w.line("\treturn time.Parse(time.DateOnly, s)")
+		w.line("}")
+		w.line("")
+
+		w.line("func ToDateTime(s string) (time.Time, error) {")
+		w.line("\treturn time.Parse(time.RFC3339, s)")
+		w.line("}")
+		w.line("")
+
+		w.line("func formatDate(value time.Time) string {")
+		w.line("\treturn value.Format(time.DateOnly)")
+		w.line("}")
+		w.line("")
+
+		w.line("func formatDateTime(value time.Time) string {")
+		w.line("\treturn value.Format(time.RFC3339)")
+		w.line("}")
+		w.line("")
+	}
+}
+
+// paramPathExpr returns the path segment of arg, a path parameter of a route builder.
+func paramPathExpr(arg paramInfo, catchAll bool) string {
+	if arg.Array {
+		return fmt.Sprintf("strings.ReplaceAll(url.PathEscape(strings.Join(formatParamValues(%s, %s), \",\")), \"%%2C\", \",\")", arg.GoName, arg.Scalar.formatFunc())
+	}
+	value := arg.Scalar.formatExpr(arg.GoName)
+	if catchAll {
+		// catch-all params keep their slashes
+		return fmt.Sprintf("strings.ReplaceAll(url.PathEscape(%s), \"%%2F\", \"/\")", value)
+	}
+	return fmt.Sprintf("url.PathEscape(%s)", value)
+}
+
+// builderPointer reports whether param is a pointer in the <Page>Params of its route builder. A param
+// whose default is not its zero value needs one, or the zero value could never be sent.
+func (p paramInfo) builderPointer() bool {
+	return !p.Array && p.DefaultLiteral != "" && p.DefaultLiteral != p.Scalar.zeroLiteral()
+}
+
+// builderType is the Go type of param in the <Page>Params of its route builder.
+func (p paramInfo) builderType() string {
+	if p.builderPointer() {
+		return "*" + p.GoType
+	}
+	return p.GoType
+}
+
+// renderQueryParamSet emits the lines of a route builder adding field, the value of query parameter
+// param, to query unless it is zero, or nil for a builderPointer param.
+func renderQueryParamSet(w *codeWriter, param paramInfo, field string) {
+	scalar := param.Scalar
+	switch {
+	case param.builderPointer():
+		w.line("\t\tif %s != nil {", field)
+		w.line("\t\t\tquery.Set(%q, %s)", param.Name, scalar.formatExpr("*"+field))
+	case param.Array:
+		w.line("\t\taddParamValues(query, %q, formatParamValues(%s, %s))", param.Name, field, scalar.formatFunc())
+		return
+	case scalar.Base == "bool":
+		w.line("\t\tif %s {", field)
+		w.line("\t\t\tquery.Set(%q, \"true\")", param.Name)
+	case scalar.Base == "time.Time":
+		w.line("\t\tif !%s.IsZero() {", field)
+		w.line("\t\t\tquery.Set(%q, %s)", param.Name, scalar.formatExpr(field))
+	default:
+		w.line("\t\tif %s != %s {", field, scalar.zeroLiteral())
+		w.line("\t\t\tquery.Set(%q, %s)", param.Name, scalar.formatExpr(field))
+	}
+	w.line("\t\t}")
+}
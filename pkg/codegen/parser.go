@@ -237,6 +237,21 @@ func normalizeParameterGroups(params map[string]any) []any {
 }
 
 func normalizeForm(form map[string]any) {
+	if state, ok := form["state"]; ok {
+		form["state"] = normalizeSchemaValue(state)
+	}
+	for _, key := range []string{"review", "resumable"} {
+		if enabled, ok := form[key].(bool); ok {
+			if enabled {
+				form[key] = map[string]any{}
+			} else {
+				delete(form, key)
+			}
+		}
+	}
+	if after, ok := form["timeout"].(string); ok {
+		form["timeout"] = map[string]any{"after": after}
+	}
 	fields, ok := form["fields"].(map[string]any)
 	if !ok {
 		return
@@ -24,22 +24,85 @@ type ButtonGridRow struct {
 type Button struct {
 	Label   StringExpr `yaml:"label"`
 	OnClick StringExpr `yaml:"onClick"`
+	// Confirm asks before OnClick runs, e.g. before deleting something.
+	Confirm *ButtonConfirm `yaml:"confirm,omitempty"`
+}
+
+// ButtonConfirm is the question asked by a button with `confirm`. Yes and No default to "Yes" and "No".
+type ButtonConfirm struct {
+	Message StringExpr `yaml:"message"`
+	Yes     StringExpr `yaml:"yes,omitempty"`
+	No      StringExpr `yaml:"no,omitempty"`
 }
 
 type Form struct {
 	Required []string             `yaml:"required,omitempty"`
 	Fields   map[string]FormField `yaml:"fields,omitempty"`
+	// Order lists the fields in the order they are asked. Fields not listed follow in name order.
+	Order []string `yaml:"order,omitempty"`
+	// Groups share a `showIf` condition between fields.
+	Groups map[string]FormGroup `yaml:"groups,omitempty"`
+	Review *FormReview          `yaml:"review,omitempty"`
+	// State is loaded through Provide<Page>FormState before the form is sent, so field defaults can
+	// show current values.
+	State *openapi3.Schema `yaml:"state,omitempty"`
+	// Current precedes the current value in the prompt of a field with a default.
+	Current StringExpr `yaml:"current,omitempty"`
+	// Keep labels the button that keeps the current value.
+	Keep StringExpr `yaml:"keep,omitempty"`
+	// Timeout expires the form when the user stops answering. `timeout: 10m` only sets the duration.
+	Timeout *FormTimeout `yaml:"timeout,omitempty"`
+	// Validate adds a `ValidateForm<Page>` hook to FormValidator that checks the whole form before the
+	// review or the submit and can send the user back to a field.
+	Validate bool `yaml:"validate,omitempty"`
+	// Resumable keeps an expired or replaced form as a draft and offers to continue it. `resumable: true`
+	// uses the default labels.
+	Resumable *FormResume `yaml:"resumable,omitempty"`
+}
+
+// FormTimeout sends Message, or opens Route, when the form gets no input for After, a Go duration.
+type FormTimeout struct {
+	After   string     `yaml:"after"`
+	Message StringExpr `yaml:"message,omitempty"`
+	Route   string     `yaml:"route,omitempty"`
+}
+
+// FormResume labels the offer to continue a draft.
+type FormResume struct {
+	Message  StringExpr `yaml:"message,omitempty"`
+	Continue StringExpr `yaml:"continue,omitempty"`
+	Restart  StringExpr `yaml:"restart,omitempty"`
+}
+
+// FormGroup shows its fields only when ShowIf holds, on top of their own conditions.
+type FormGroup struct {
+	Fields []string `yaml:"fields"`
+	ShowIf Code     `yaml:"showIf,omitempty"`
+}
+
+// FormReview lists the entered values for confirmation before the form is submitted. `review: true`
+// uses the default labels.
+type FormReview struct {
+	Title  StringExpr `yaml:"title,omitempty"`
+	Edit   StringExpr `yaml:"edit,omitempty"`
+	Submit StringExpr `yaml:"submit,omitempty"`
 }
 
 type FormField struct {
 	Label     StringExpr      `yaml:"label,omitempty"`
 	Input     *FormFieldInput `yaml:"input,omitempty"`
 	Validator *StringExpr     `yaml:"validator,omitempty"`
+	// ShowIf is a boolean expression over `values.<field>` of earlier fields and `parameters.<name>`.
+	// The field is skipped, and left out of the form values, when it is false.
+	ShowIf Code `yaml:"showIf,omitempty"`
+	// Default is the current value of the field. It can read the form `state` and `parameters`.
+	Default StringExpr `yaml:"default,omitempty"`
 }
 
 type FormFieldInput struct {
 	Type   string     `yaml:"type,omitempty"`
 	Format string     `yaml:"format,omitempty"`
+	Enum   []string   `yaml:"enum,omitempty"`
 	Tip    StringExpr `yaml:"tip,omitempty"`
 }
 
@@ -49,13 +112,27 @@ type Doc struct {
 	Package    string          `yaml:"package,omitempty"`
 	I18n       *I18n           `yaml:"i18n,omitempty"`
 	Navbar     *Navbar         `yaml:"navbar,omitempty"`
+	Router     *RouterConfig   `yaml:"router,omitempty"`
 	Handlers   []Handler       `yaml:"handlers,omitempty"`
+	DeepLinks  []DeepLink      `yaml:"deepLinks,omitempty"`
 	Pages      map[string]Page `yaml:"pages"`
 	API        map[string]API  `yaml:"api"`
 	Components Components      `yaml:"components,omitempty"`
 }
 
+type RouterConfig struct {
+	// MaxHistory is the number of router history entries kept per chat, 50 by default.
+	MaxHistory int `yaml:"maxHistory,omitempty"`
+}
+
 type Page struct {
+	// Kind is "page" (default) or "action" for pages with side effects, which are never replayed.
+	Kind string `yaml:"kind,omitempty"`
+	// Method "post" marks an action like `kind: action`; "get" is the default.
+	Method string `yaml:"method,omitempty"`
+	// History "replace" replaces the history entry when the page is opened from itself with other query
+	// params, e.g. the next page of a list; "push" is the default.
+	History    string           `yaml:"history,omitempty"`
 	Parameters Parameters       `yaml:"parameters,omitempty"`
 	State      *openapi3.Schema `yaml:"state,omitempty"`
 	Form       *Form            `yaml:"form,omitempty"`
@@ -77,6 +154,18 @@ type Handler struct {
 	MatchType string     `yaml:"matchType"`
 	Type      string     `yaml:"type"`
 	Action    StringExpr `yaml:"action"`
+
+	// Description lists the command in the platform command menu (e.g. Telegram setMyCommands).
+	Description StringExpr `yaml:"description,omitempty"`
+	// Scopes limits the menu entry to chat kinds: default, private, group or admins. Defaults to default.
+	Scopes []string `yaml:"scopes,omitempty"`
+}
+
+// DeepLink maps a `/start` payload pattern (e.g. `todo_{ID}`) onto a page route (e.g. `/todo/{ID}`).
+type DeepLink struct {
+	Name    string `yaml:"name,omitempty"`
+	Payload string `yaml:"payload"`
+	Route   string `yaml:"route"`
 }
 
 type View struct {
@@ -55,6 +55,7 @@ func (f *FormFieldInput) UnmarshalYAML(node *yaml.Node) error {
 	var raw struct {
 		Type   string           `yaml:"type"`
 		Format string           `yaml:"format"`
+		Enum   []string         `yaml:"enum"`
 		Tip    StringExpr       `yaml:"tip"`
 		Schema *openapi3.Schema `yaml:"schema"`
 	}
@@ -69,8 +70,14 @@ func (f *FormFieldInput) UnmarshalYAML(node *yaml.Node) error {
 			raw.Format = raw.Schema.Format
 		}
 	}
+	if len(raw.Enum) == 0 && raw.Schema != nil {
+		for _, value := range raw.Schema.Enum {
+			raw.Enum = append(raw.Enum, fmt.Sprint(value))
+		}
+	}
 	f.Type = raw.Type
 	f.Format = raw.Format
+	f.Enum = raw.Enum
 	f.Tip = raw.Tip
 	return nil
 }
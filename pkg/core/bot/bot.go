@@ -4,8 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/anclax/botx/pkg/core/session"
 	"github.com/pkg/errors"
 )
 
@@ -19,20 +22,36 @@ var (
 // session keys
 
 const (
-	SessionKeyRouter     = "__router"
-	SessionKeyRouterHist = "__router_history"
-	SessionKeyLanguage   = "__language"
+	SessionKeyRouter       = "__router"
+	SessionKeyRouterHist   = "__router_history"
+	SessionKeyRouterAction = "__router_action"
+	SessionKeyLanguage     = "__language"
+	SessionKeyConfirms     = "__confirms"
 )
 
 const (
 	CallbackPrefixRoute  = "_route"
 	CallbackPrefixSubmit = "_submit"
+	// CallbackPrefixConfirm prefixes the callbacks of buttons that ask for confirmation, see Confirm.
+	CallbackPrefixConfirm = "_confirm"
+)
+
+// DeepLinkCommand is the command Telegram sends when a user opens a `t.me/<bot>?start=<payload>` link.
+// The payload arrives as the command argument, e.g. `/start todo_42`.
+const DeepLinkCommand = "/start"
+
+// route modes, written as `replace:/path` and `reset:/path` in onClick
+const (
+	RouteModeReplace = "replace"
+	RouteModeReset   = "reset"
 )
 
 type Button struct {
 	ID           string
 	Label        string
 	CallbackData string
+	// Confirm asks the user to confirm before CallbackData runs. Bot.SendMessage binds it to the session.
+	Confirm *Confirm
 }
 
 type Message struct {
@@ -45,6 +64,34 @@ type Form struct {
 	URL    *url.URL
 	Idx    int
 	Fields []FormField
+	// Review shows the entered values with buttons to change a field or submit before the form is
+	// submitted. Nil submits right after the last field.
+	Review *FormReview
+	// Reviewing is set by the FormEngine once the review is shown; changing a field returns to it.
+	Reviewing bool
+	// Correcting is set by the FormEngine when the form `validate` hook sends the user back to the field
+	// at Idx. Only that field is asked again before the form is checked once more.
+	Correcting bool
+	// CurrentLabel precedes the current value of a field with a default in its prompt. Defaults to
+	// "Current:".
+	CurrentLabel string
+	// KeepLabel is the button that keeps the current value of a field. Defaults to "Keep current".
+	KeepLabel string
+	// Timeout expires the form when the user stops answering. Nil keeps it until it is submitted.
+	Timeout *FormTimeout
+	// Resume keeps the form as a draft when it expires or another form replaces it, and offers to
+	// continue the draft when the form is sent again. Nil always starts over.
+	Resume *FormResume
+	// UpdatedAt is set by the FormEngine whenever the form is saved.
+	UpdatedAt time.Time
+	// Offering is set while the FormEngine asks whether to continue a draft.
+	Offering bool
+}
+
+// HasConditions reports whether a field of the form has a `showIf` condition. A condition depends on the
+// values before it, so native renderers ask such forms one field at a time.
+func (f *Form) HasConditions() bool {
+	return slices.ContainsFunc(f.Fields, func(field FormField) bool { return field.Conditional })
 }
 
 type FormField struct {
@@ -52,24 +99,84 @@ type FormField struct {
 	Label     string
 	Input     *FormFieldInput
 	Validator *string
+	// Conditional fields have a `showIf` condition, which the FormEngine evaluates through the
+	// handler's FormFieldFilter before asking for them.
+	Conditional bool
+	// Hidden is set by the FormEngine when the condition hides the field. Hidden fields are not asked
+	// for and left out of the submitted values.
+	Hidden bool
 }
 
 type FormFieldInput struct {
 	Schema *FormSchema
 	Tip    string
 	Value  string
+	// Default is the current value of the field, e.g. the title of the todo being renamed. It prefills
+	// Value and can be kept unchanged with the keep button.
+	Default string
 }
 
 type FormSchema struct {
 	Type   string
 	Format string
+	// Enum lists the allowed values. Connectors with native selects render them as options.
+	Enum []string
 }
 
 type FormValues map[string]string
 
+// command menu scopes
+
+const (
+	CommandScopeDefault = "default"
+	CommandScopePrivate = "private"
+	CommandScopeGroup   = "group"
+	CommandScopeAdmins  = "admins"
+)
+
+type Command struct {
+	Command     string
+	Description string
+}
+
+// CommandMenu is the list of commands shown to users of one language in one scope. An empty Language is
+// the fallback menu for users whose language has no dedicated menu.
+type CommandMenu struct {
+	Language string
+	Scope    string
+	Commands []Command
+}
+
+// CommandMenuProvider is implemented by generated handlers whose `handlers` declare a description.
+// Connectors that support command menus register them on start.
+type CommandMenuProvider interface {
+	CommandMenus(ctx context.Context) []CommandMenu
+}
+
+// FormFieldFilter is implemented by generated handlers whose forms have `showIf` fields. values holds
+// the shown fields entered before field.
+type FormFieldFilter interface {
+	ShowFormField(ctx context.Context, chatID int64, url *url.URL, field string, values FormValues) (bool, error)
+}
+
+// FormFieldValidator is implemented by generated handlers to give validators the field they check and
+// the shown values entered before it. The FormEngine prefers it over Validate.
+type FormFieldValidator interface {
+	ValidateFormField(ctx context.Context, chatID int64, url *url.URL, validator string, field string, input string, values FormValues) (*ValidateResult, error)
+}
+
+// FormValuesValidator is implemented by generated handlers whose forms declare a `validate` hook. It
+// checks the shown values of a filled form before the review or the submit.
+type FormValuesValidator interface {
+	ValidateFormValues(ctx context.Context, chatID int64, url *url.URL, values FormValues) (*ValidateResult, error)
+}
+
 type ValidateResult struct {
 	Valid        bool
 	ErrorMessage string
+	// Field is the ID of the field a failed form validation sends the user back to. Empty means the
+	// first shown field.
+	Field string
 }
 
 // BotConnector is an abstraction of the real bot implementation.
@@ -84,16 +191,63 @@ type BotConnector interface {
 	RegisterBotxHandler(handler BotxHandler)
 }
 
+// MessageEditor is implemented by connectors that can change a sent message. EditMessage replaces the
+// message whose button sent the callback being handled and reports false when there is none, e.g. when
+// the callback did not come from a button.
+type MessageEditor interface {
+	EditMessage(ctx context.Context, chatID int64, message *Message) (bool, error)
+}
+
 // Bot is a user-facing wrapper around BotConnector.
 type Bot struct {
 	connector BotConnector
+	sm        session.SessionManager
+	signer    *CallbackSigner
+}
+
+type BotOption func(*Bot)
+
+// WithBotSessionManager keeps the confirmations of the buttons sent in the chat sessions. Messages with
+// Confirm buttons cannot be sent without it.
+func WithBotSessionManager(sm session.SessionManager) BotOption {
+	return func(b *Bot) {
+		b.sm = sm
+	}
 }
 
-func NewBot(connector BotConnector) *Bot {
-	return &Bot{connector: connector}
+// WithCallbackSigner signs the route and submit callbacks of the buttons sent, and of the callbacks sent
+// with SendCallbackData. The handler checks them with VerifyCallbackData.
+func WithCallbackSigner(signer *CallbackSigner) BotOption {
+	return func(b *Bot) {
+		b.signer = signer
+	}
+}
+
+func NewBot(connector BotConnector, opts ...BotOption) *Bot {
+	b := &Bot{connector: connector}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
 }
 
 func (b *Bot) SendMessage(ctx context.Context, chatID int64, messages *Message) error {
+	// signed before the confirmations, which keep the signed callbacks
+	if b.signer != nil {
+		messages = b.signer.SignMessage(chatID, messages)
+	}
+	if hasConfirms(messages) {
+		if b.sm == nil {
+			return errors.New("buttons with a confirmation need a session manager")
+		}
+		sess, err := b.sm.Get(ctx, chatID)
+		if err != nil {
+			return errors.Wrap(err, "failed to get session")
+		}
+		if messages, err = BindConfirms(ctx, sess, messages); err != nil {
+			return err
+		}
+	}
 	return b.connector.SendMessage(ctx, chatID, messages)
 }
 
@@ -102,9 +256,25 @@ func (b *Bot) SendForm(ctx context.Context, chatID int64, form *Form) error {
 }
 
 func (b *Bot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	if b.signer != nil {
+		data = b.signer.Sign(chatID, data)
+	}
 	return b.connector.SendCallbackData(ctx, chatID, data)
 }
 
+// VerifyCallbackData checks the signature of a route or submit callback received in chatID and returns
+// the callback without it. The callbacks of the form engine, its `_submit:` with the values in ctx and
+// its timeout route, need no signature. Without a CallbackSigner data is returned as is.
+func (b *Bot) VerifyCallbackData(ctx context.Context, chatID int64, data string) (string, error) {
+	if b.signer == nil || isTrustedCallback(ctx, data) {
+		return data, nil
+	}
+	if _, ok := FormValuesFromContext(ctx); ok && strings.HasPrefix(data, CallbackPrefixSubmit+":") {
+		return data, nil
+	}
+	return b.signer.Verify(chatID, data)
+}
+
 func (b *Bot) Route(ctx context.Context, chatID int64, url string) error {
 	return b.SendCallbackData(ctx, chatID, RouteCallbackData(url))
 }
@@ -124,6 +294,16 @@ func RouteCallbackData(url string) string {
 	return fmt.Sprintf("%s:%s", CallbackPrefixRoute, url)
 }
 
+// ReplaceCallbackData opens url in place of the page on screen in the router history.
+func ReplaceCallbackData(url string) string {
+	return RouteCallbackData(RouteModeReplace + ":" + url)
+}
+
+// ResetCallbackData opens url and starts the router history over at it.
+func ResetCallbackData(url string) string {
+	return RouteCallbackData(RouteModeReset + ":" + url)
+}
+
 func CallbackData(value string) string {
 	if value == "" {
 		return value
@@ -144,6 +324,22 @@ func SubmitForm(url string) string {
 	return fmt.Sprintf("%s:%s", CallbackPrefixSubmit, url)
 }
 
+// FormSubmitCallbackData returns the submit callback of a completed form and its field values, which are
+// passed with WithFormValues rather than in the callback. The form is left unchanged.
+func FormSubmitCallbackData(form *Form) (string, FormValues, error) {
+	if form.URL == nil {
+		return "", nil, errors.New("form has no url")
+	}
+	return SubmitForm(form.URL.String()), formValues(form, len(form.Fields)), nil
+}
+
+// TelegramDeepLink builds a `t.me` link that starts the bot with the given payload. Telegram only accepts
+// up to 64 characters from `A-Z`, `a-z`, `0-9`, `_` and `-` in the payload.
+func TelegramDeepLink(botUsername string, payload string) string {
+	botUsername = strings.TrimPrefix(strings.TrimSpace(botUsername), "@")
+	return fmt.Sprintf("https://t.me/%s?start=%s", botUsername, url.QueryEscape(payload))
+}
+
 type languageContextKey struct{}
 
 func WithLanguage(ctx context.Context, language string) context.Context {
@@ -163,3 +359,8 @@ func LanguageFromContext(ctx context.Context) string {
 	}
 	return ""
 }
+
+// Ptr returns a pointer to v, e.g. for the optional parameters of generated route builders.
+func Ptr[T any](v T) *T {
+	return &v
+}
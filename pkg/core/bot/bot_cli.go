@@ -26,6 +26,7 @@ type CLIBot struct {
 	frontend CLIFrontend
 	handler  BotxHandler
 	sm       session.SessionManager
+	forms    *FormEngine
 }
 
 func NewCLIBot(sm session.SessionManager, frontend CLIFrontend) (*CLIBot, error) {
@@ -35,10 +36,12 @@ func NewCLIBot(sm session.SessionManager, frontend CLIFrontend) (*CLIBot, error)
 	if frontend == nil {
 		return nil, errors.New("cli frontend is required")
 	}
-	return &CLIBot{
+	b := &CLIBot{
 		frontend: frontend,
 		sm:       sm,
-	}, nil
+	}
+	b.forms = NewFormEngine(b, sm, CliSessionKeyInputState, func() BotxHandler { return b.handler })
+	return b, nil
 }
 
 func (b *CLIBot) RegisterBotxHandler(handler BotxHandler) {
@@ -72,20 +75,7 @@ func (b *CLIBot) SendMessage(ctx context.Context, chatID int64, message *Message
 }
 
 func (b *CLIBot) SendForm(ctx context.Context, chatID int64, form *Form) error {
-	if len(form.Fields) == 0 {
-		return errors.New("form has no fields")
-	}
-	if err := b.sendFormField(ctx, chatID, &form.Fields[0]); err != nil {
-		return errors.Wrap(err, "failed to send first form field")
-	}
-	sess, err := b.sm.Get(ctx, chatID)
-	if err != nil {
-		return errors.Wrap(err, "failed to get session")
-	}
-	if err := sess.Set(ctx, CliSessionKeyInputState, form); err != nil {
-		return errors.Wrap(err, "failed to set input state in session")
-	}
-	return nil
+	return b.forms.Start(ctx, chatID, form)
 }
 
 func (b *CLIBot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
@@ -95,6 +85,9 @@ func (b *CLIBot) SendCallbackData(ctx context.Context, chatID int64, data string
 	if data == "" {
 		return errors.New("callback data is required")
 	}
+	if ok, err := b.forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
 	if err := b.handler.HandleCallbackData(ctx, data, chatID, b); err != nil {
 		return errors.Wrap(err, "failed to handle callback data")
 	}
@@ -105,11 +98,6 @@ func (b *CLIBot) handleUpdate(ctx context.Context, chatID int64, update *CLIUpda
 	if b.handler == nil {
 		return errors.New("botx handler is not registered")
 	}
-	sess, err := b.sm.Get(ctx, chatID)
-	if err != nil {
-		return errors.Wrap(err, "failed to get session")
-	}
-
 	if update.CallbackData != "" {
 		return b.SendCallbackData(ctx, chatID, update.CallbackData)
 	}
@@ -118,32 +106,12 @@ func (b *CLIBot) handleUpdate(ctx context.Context, chatID int64, update *CLIUpda
 		return errors.Wrap(ErrEmptyMessage, "cli update has no text or callback data")
 	}
 
-	val, err := sess.Get(ctx, CliSessionKeyInputState)
-	if err == nil {
-		form, ok := val.(*Form)
-		if !ok {
-			return errors.Errorf("invalid input state type: %T", val)
-		}
-		for {
-			validation, err := b.handleInProgressForm(ctx, chatID, sess, update.Text, form)
-			if err != nil {
-				return errors.Wrap(err, "failed to handle form input")
-			}
-			if validation.Valid {
-				break
-			}
-			if err := b.SendMessage(ctx, chatID, &Message{
-				Text:       validation.ErrorMessage,
-				ParseMode:  "HTML",
-				ButtonGrid: [][]Button{},
-			}); err != nil {
-				return errors.Wrap(err, "failed to send validation error message")
-			}
-		}
-		return nil
+	result, err := b.forms.HandleText(ctx, chatID, update.Text)
+	if err != nil {
+		return errors.Wrap(err, "failed to handle form input")
 	}
-	if !errors.Is(err, session.ErrKeyNotFound) {
-		return errors.Wrap(err, "failed to get input state from session")
+	if result != nil {
+		return nil
 	}
 
 	if err := b.handler.HandleTextMessage(ctx, update.Text, chatID, b); err != nil {
@@ -151,59 +119,3 @@ func (b *CLIBot) handleUpdate(ctx context.Context, chatID int64, update *CLIUpda
 	}
 	return nil
 }
-
-func (b *CLIBot) sendFormField(ctx context.Context, chatID int64, field *FormField) error {
-	if field.Input == nil {
-		return errors.Errorf("field has no type, should have `input`: %+v", field)
-	}
-	msg := &Message{
-		Text:      field.Input.Tip,
-		ParseMode: "HTML",
-	}
-	if err := b.SendMessage(ctx, chatID, msg); err != nil {
-		return errors.Wrap(err, "failed to send form field prompt")
-	}
-	return nil
-}
-
-func (b *CLIBot) handleInProgressForm(ctx context.Context, chatID int64, sess session.Session, text string, form *Form) (*ValidateResult, error) {
-	field := form.Fields[form.Idx]
-	if field.Validator != nil {
-		result, err := b.handler.Validate(ctx, chatID, form.URL, *field.Validator, text)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to validate form input")
-		}
-		if !result.Valid {
-			return result, nil
-		}
-	}
-	if field.Input != nil {
-		field.Input.Value = text
-	}
-	form.Fields[form.Idx] = field
-	form.Idx++
-	if form.Idx == len(form.Fields) {
-		if err := sess.Delete(ctx, CliSessionKeyInputState); err != nil {
-			return nil, errors.Wrap(err, "failed to clear input state from session")
-		}
-		raw, err := marshalFormValues(form)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to marshal form values to json")
-		}
-		query := form.URL.Query()
-		query.Add("values", string(raw))
-		form.URL.RawQuery = query.Encode()
-		if err := b.SendCallbackData(ctx, chatID, SubmitForm(form.URL.String())); err != nil {
-			return nil, errors.Wrap(err, "failed to submit form data")
-		}
-	} else {
-		if err := sess.Set(ctx, CliSessionKeyInputState, form); err != nil {
-			return nil, errors.Wrap(err, "failed to save input state to session")
-		}
-		if err := b.sendFormField(ctx, chatID, &form.Fields[form.Idx]); err != nil {
-			return nil, errors.Wrap(err, "failed to send next form field")
-		}
-	}
-
-	return &ValidateResult{Valid: true}, nil
-}
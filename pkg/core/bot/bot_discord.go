@@ -0,0 +1,759 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	DiscordSessionKeyModalForm = "__discord_modal_form"
+
+	DefaultDiscordAPIURL = "https://discord.com/api/v10/"
+
+	// DiscordOpenFormID is the custom_id of the button that (re)opens the pending form modal.
+	DiscordOpenFormID = "_botx_form"
+	// DiscordFormModalID is the custom_id of form modals.
+	DiscordFormModalID = "_botx_form_modal"
+
+	discordMaxContent       = 2000
+	discordMaxButtonLabel   = 80
+	discordMaxCustomID      = 100
+	discordMaxRowButtons    = 5
+	discordMaxMessageRows   = 5
+	discordMaxModalFields   = 5
+	discordMaxModalTitle    = 45
+	discordMaxLabel         = 45
+	discordMaxDescription   = 100
+	discordMaxSelectOptions = 25
+)
+
+// interaction types
+const (
+	discordInteractionPing               = 1
+	discordInteractionApplicationCommand = 2
+	discordInteractionMessageComponent   = 3
+	discordInteractionModalSubmit        = 5
+)
+
+// interaction callback types
+const (
+	discordCallbackPong                   = 1
+	discordCallbackChannelMessage         = 4
+	discordCallbackDeferredChannelMessage = 5
+	discordCallbackDeferredUpdateMessage  = 6
+	discordCallbackModal                  = 9
+)
+
+// component types
+const (
+	discordComponentActionRow = 1
+	discordComponentButton    = 2
+	discordComponentSelect    = 3
+	discordComponentTextInput = 4
+	discordComponentLabel     = 18
+)
+
+type DiscordConfig struct {
+	// ApplicationID is used to register slash commands.
+	ApplicationID string
+	// BotToken authenticates REST calls.
+	BotToken string
+	// PublicKey verifies the Ed25519 signature of interaction requests.
+	PublicKey ed25519.PublicKey
+	// FormButtonLabel labels the button that opens a form modal when it cannot be opened directly.
+	// Defaults to "Open form".
+	FormButtonLabel string
+	// ModalTitle is the title of form modals. Defaults to "Form".
+	ModalTitle string
+}
+
+// DiscordBot is a BotConnector backed by Discord's HTTP interactions endpoint. Slash commands, button
+// presses and modal submissions are received by ServeHTTP; chat IDs are channel IDs.
+type DiscordBot struct {
+	config DiscordConfig
+	apiURL string
+	client *http.Client
+	log    *zap.Logger
+
+	handler BotxHandler
+
+	sm    session.SessionManager
+	forms *FormEngine
+}
+
+type DiscordOption func(*DiscordBot)
+
+// WithDiscordAPIURL points the connector at a different REST API base URL, e.g. a discordtest server.
+func WithDiscordAPIURL(apiURL string) DiscordOption {
+	return func(b *DiscordBot) {
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		b.apiURL = apiURL
+	}
+}
+
+func WithDiscordHTTPClient(client *http.Client) DiscordOption {
+	return func(b *DiscordBot) {
+		b.client = client
+	}
+}
+
+func NewDiscordBot(config DiscordConfig, sm session.SessionManager, log *zap.Logger, opts ...DiscordOption) (*DiscordBot, error) {
+	if config.BotToken == "" {
+		return nil, errors.New("discord bot token is required")
+	}
+	if len(config.PublicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("discord public key is required")
+	}
+	if sm == nil {
+		return nil, errors.New("session manager is required")
+	}
+	if config.FormButtonLabel == "" {
+		config.FormButtonLabel = "Open form"
+	}
+	if config.ModalTitle == "" {
+		config.ModalTitle = "Form"
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+	b := &DiscordBot{
+		config: config,
+		apiURL: DefaultDiscordAPIURL,
+		client: http.DefaultClient,
+		log:    log,
+		sm:     sm,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.forms = NewFormEngine(b, sm, DiscordSessionKeyModalForm, func() BotxHandler { return b.handler }, WithFormPrompt(b.openForm))
+	return b, nil
+}
+
+func (b *DiscordBot) RegisterBotxHandler(handler BotxHandler) {
+	b.handler = handler
+}
+
+// discordInteraction collects the response to the interaction being handled. The first message or form
+// sent while handling it becomes the interaction response; later ones are posted to the channel once the
+// response is written, so they show after it.
+type discordInteraction struct {
+	kind      int
+	response  map[string]any
+	followUps []discordFollowUp
+}
+
+type discordFollowUp struct {
+	chatID  int64
+	message map[string]any
+}
+
+type discordInteractionKey struct{}
+
+func withDiscordInteraction(ctx context.Context, interaction *discordInteraction) context.Context {
+	return context.WithValue(ctx, discordInteractionKey{}, interaction)
+}
+
+func discordInteractionFromContext(ctx context.Context) *discordInteraction {
+	interaction, _ := ctx.Value(discordInteractionKey{}).(*discordInteraction)
+	return interaction
+}
+
+// SendMessage maps each ButtonGrid row onto action rows of up to five buttons. Messages carry at most five
+// action rows, so larger grids are split over several messages, posted after the interaction response.
+func (b *DiscordBot) SendMessage(ctx context.Context, chatID int64, message *Message) error {
+	parts, err := toDiscordMessages(toDiscordMarkdown(message.Text, message.ParseMode), message.ButtonGrid)
+	if err != nil {
+		return err
+	}
+	interaction := discordInteractionFromContext(ctx)
+	for _, part := range parts {
+		switch {
+		case interaction == nil:
+			if err := b.postMessage(ctx, chatID, part); err != nil {
+				return err
+			}
+		case interaction.response == nil:
+			interaction.response = map[string]any{"type": discordCallbackChannelMessage, "data": part}
+		default:
+			interaction.followUps = append(interaction.followUps, discordFollowUp{chatID: chatID, message: part})
+		}
+	}
+	return nil
+}
+
+func (b *DiscordBot) postMessage(ctx context.Context, chatID int64, message map[string]any) error {
+	if err := b.call(ctx, http.MethodPost, fmt.Sprintf("channels/%d/messages", chatID), message); err != nil {
+		return errors.Wrap(err, "failed to post discord message")
+	}
+	return nil
+}
+
+func (b *DiscordBot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if data == "" {
+		return errors.New("callback data is required")
+	}
+	if ok, err := b.forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
+	if err := b.handler.HandleCallbackData(ctx, data, chatID, b); err != nil {
+		return errors.Wrap(err, "failed to handle callback data")
+	}
+	return nil
+}
+
+// SendForm opens the form as a modal of up to five fields. Discord only opens modals in response to a
+// slash command or button press, so otherwise a button that opens it is sent instead. Forms with more than
+// five fields are split over several modals. Forms with conditions ask one field per modal, so each
+// condition is evaluated on the values before it.
+func (b *DiscordBot) SendForm(ctx context.Context, chatID int64, form *Form) error {
+	return b.forms.Start(ctx, chatID, form)
+}
+
+func (b *DiscordBot) openForm(ctx context.Context, chatID int64, form *Form) error {
+	interaction := discordInteractionFromContext(ctx)
+	if interaction != nil && interaction.response == nil && interaction.kind != discordInteractionModalSubmit {
+		modal, err := b.formModal(form)
+		if err != nil {
+			return err
+		}
+		interaction.response = map[string]any{"type": discordCallbackModal, "data": modal}
+		return nil
+	}
+	return b.SendMessage(ctx, chatID, &Message{
+		ButtonGrid: [][]Button{{{Label: b.config.FormButtonLabel, CallbackData: DiscordOpenFormID}}},
+	})
+}
+
+func (b *DiscordBot) formModal(form *Form) (map[string]any, error) {
+	end := discordModalEnd(form)
+	components := make([]any, 0, end-form.Idx)
+	for _, field := range form.Fields[form.Idx:end] {
+		label := field.Label
+		if label == "" {
+			label = field.ID
+		}
+		if len(field.ID) > discordMaxCustomID {
+			return nil, errors.Errorf("form field id %q exceeds %d bytes", field.ID, discordMaxCustomID)
+		}
+		value := ""
+		if field.Input != nil {
+			value = field.Input.Value
+		}
+		var input map[string]any
+		if field.Input != nil && field.Input.Schema != nil && len(field.Input.Schema.Enum) != 0 {
+			if len(field.Input.Schema.Enum) > discordMaxSelectOptions {
+				return nil, errors.Errorf("form field %s has more than %d options", field.ID, discordMaxSelectOptions)
+			}
+			options := make([]any, 0, len(field.Input.Schema.Enum))
+			for _, option := range field.Input.Schema.Enum {
+				options = append(options, map[string]any{
+					"label":   truncateRunes(option, 100),
+					"value":   option,
+					"default": option == value,
+				})
+			}
+			input = map[string]any{
+				"type":      discordComponentSelect,
+				"custom_id": field.ID,
+				"options":   options,
+				"required":  true,
+			}
+		} else {
+			input = map[string]any{
+				"type":      discordComponentTextInput,
+				"custom_id": field.ID,
+				"style":     1,
+				"required":  true,
+			}
+			if value != "" {
+				input["value"] = value
+			}
+		}
+		component := map[string]any{
+			"type":      discordComponentLabel,
+			"label":     truncateRunes(stripHTML(label), discordMaxLabel),
+			"component": input,
+		}
+		if field.Input != nil && field.Input.Tip != "" {
+			component["description"] = truncateRunes(stripHTML(field.Input.Tip), discordMaxDescription)
+		}
+		components = append(components, component)
+	}
+	title := b.config.ModalTitle
+	if pages := (len(form.Fields) + discordMaxModalFields - 1) / discordMaxModalFields; pages > 1 && !form.HasConditions() {
+		title = fmt.Sprintf("%s (%d/%d)", title, form.Idx/discordMaxModalFields+1, pages)
+	}
+	return map[string]any{
+		"custom_id":  DiscordFormModalID,
+		"title":      truncateRunes(title, discordMaxModalTitle),
+		"components": components,
+	}, nil
+}
+
+// discordModalEnd returns the end of the fields shown in the modal from form.Idx on.
+func discordModalEnd(form *Form) int {
+	if form.HasConditions() {
+		return form.Idx + 1
+	}
+	return min(form.Idx+discordMaxModalFields, len(form.Fields))
+}
+
+// handleModalSubmit validates the fields of the submitted modal. Invalid input keeps the form in the
+// session and answers with the errors and a button to reopen the modal with the entered values.
+func (b *DiscordBot) handleModalSubmit(ctx context.Context, chatID int64, values map[string]string) error {
+	form, err := b.forms.Pending(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if form == nil {
+		return errors.Wrap(ErrBadRequest, "no form is pending")
+	}
+	end := discordModalEnd(form)
+	var validationErrors []string
+	for i := form.Idx; i < end; i++ {
+		value := values[form.Fields[i].ID]
+		setFormValue(&form.Fields[i], value)
+		result, err := b.forms.Validate(ctx, chatID, form, i, value)
+		if err != nil {
+			return err
+		}
+		if !result.Valid {
+			validationErrors = append(validationErrors, result.ErrorMessage)
+		}
+	}
+	if len(validationErrors) != 0 {
+		if err := b.forms.Save(ctx, chatID, form); err != nil {
+			return err
+		}
+		return b.SendMessage(ctx, chatID, &Message{
+			Text:       strings.Join(validationErrors, "\n"),
+			ParseMode:  "HTML",
+			ButtonGrid: [][]Button{{{Label: b.config.FormButtonLabel, CallbackData: DiscordOpenFormID}}},
+		})
+	}
+
+	form.Idx, err = b.forms.next(ctx, chatID, form, end)
+	if err != nil {
+		return err
+	}
+	if form.Idx < len(form.Fields) {
+		if err := b.forms.Save(ctx, chatID, form); err != nil {
+			return err
+		}
+		return b.openForm(ctx, chatID, form)
+	}
+	return b.forms.Complete(ctx, chatID, form)
+}
+
+type discordInteractionPayload struct {
+	Type      int    `json:"type"`
+	ChannelID string `json:"channel_id"`
+	Data      struct {
+		Name    string `json:"name"`
+		Options []struct {
+			Name  string `json:"name"`
+			Value any    `json:"value"`
+		} `json:"options"`
+		CustomID   string             `json:"custom_id"`
+		Components []discordComponent `json:"components"`
+	} `json:"data"`
+}
+
+type discordComponent struct {
+	Type       int                `json:"type"`
+	CustomID   string             `json:"custom_id"`
+	Value      string             `json:"value"`
+	Values     []string           `json:"values"`
+	Component  *discordComponent  `json:"component"`
+	Components []discordComponent `json:"components"`
+}
+
+// ServeHTTP receives interactions. Every request must carry a valid Ed25519 signature. The interaction is
+// handled before responding, so handlers must answer within Discord's three second deadline.
+func (b *DiscordBot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	signature := r.Header.Get("X-Signature-Ed25519")
+	timestamp := r.Header.Get("X-Signature-Timestamp")
+	if err := VerifyDiscordSignature(b.config.PublicKey, signature, timestamp, body); err != nil {
+		b.log.Warn("rejected discord request", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var payload discordInteractionPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if payload.Type == discordInteractionPing {
+		writeDiscordResponse(w, map[string]any{"type": discordCallbackPong})
+		return
+	}
+	chatID, err := strconv.ParseInt(payload.ChannelID, 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	interaction := &discordInteraction{kind: payload.Type}
+	ctx := withDiscordInteraction(r.Context(), interaction)
+	if err := b.handleInteraction(ctx, chatID, &payload); err != nil {
+		b.handleError(ctx, err, chatID)
+	}
+	if interaction.response == nil {
+		kind := discordCallbackDeferredUpdateMessage
+		if payload.Type == discordInteractionApplicationCommand {
+			kind = discordCallbackDeferredChannelMessage
+		}
+		interaction.response = map[string]any{"type": kind}
+	}
+	writeDiscordResponse(w, interaction.response)
+	if len(interaction.followUps) == 0 {
+		return
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	for _, followUp := range interaction.followUps {
+		if err := b.postMessage(ctx, followUp.chatID, followUp.message); err != nil {
+			b.log.Error("failed to post discord follow-up message", zap.Error(err))
+			return
+		}
+	}
+}
+
+func writeDiscordResponse(w http.ResponseWriter, response map[string]any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func (b *DiscordBot) handleInteraction(ctx context.Context, chatID int64, payload *discordInteractionPayload) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	switch payload.Type {
+	case discordInteractionApplicationCommand:
+		text := "/" + payload.Data.Name
+		for _, option := range payload.Data.Options {
+			if value := strings.TrimSpace(fmt.Sprint(option.Value)); value != "" {
+				text += " " + value
+			}
+		}
+		if err := b.handler.HandleTextMessage(ctx, text, chatID, b); err != nil {
+			return errors.Wrap(err, "failed to handle slash command")
+		}
+		return nil
+	case discordInteractionMessageComponent:
+		if payload.Data.CustomID == DiscordOpenFormID {
+			form, err := b.forms.Pending(ctx, chatID)
+			if err != nil {
+				return err
+			}
+			if form == nil || form.Idx >= len(form.Fields) {
+				return errors.Wrap(ErrBadRequest, "no form is pending")
+			}
+			return b.openForm(ctx, chatID, form)
+		}
+		return b.SendCallbackData(ctx, chatID, payload.Data.CustomID)
+	case discordInteractionModalSubmit:
+		if payload.Data.CustomID != DiscordFormModalID {
+			return nil
+		}
+		values := map[string]string{}
+		collectDiscordValues(payload.Data.Components, values)
+		return b.handleModalSubmit(ctx, chatID, values)
+	default:
+		return errors.Wrapf(ErrEmptyMessage, "unsupported discord interaction type %d", payload.Type)
+	}
+}
+
+func collectDiscordValues(components []discordComponent, values map[string]string) {
+	for _, component := range components {
+		switch component.Type {
+		case discordComponentTextInput:
+			values[component.CustomID] = component.Value
+		case discordComponentSelect:
+			if len(component.Values) != 0 {
+				values[component.CustomID] = component.Values[0]
+			}
+		}
+		if component.Component != nil {
+			collectDiscordValues([]discordComponent{*component.Component}, values)
+		}
+		collectDiscordValues(component.Components, values)
+	}
+}
+
+func (b *DiscordBot) handleError(ctx context.Context, err error, chatID int64) {
+	if b.handler == nil {
+		b.log.Error("failed to handle discord interaction", zap.Error(err))
+		return
+	}
+	if handleErr := b.handler.HandleError(ctx, err, chatID, b); handleErr != nil {
+		b.log.Error("failed to handle discord error", zap.Error(err), zap.NamedError("handleError", handleErr))
+	}
+}
+
+// RegisterCommands overwrites the application's global slash commands with the command menus of the
+// registered handler. Each command takes an optional `args` string, passed on after the command name.
+func (b *DiscordBot) RegisterCommands(ctx context.Context) error {
+	if b.config.ApplicationID == "" {
+		return errors.New("discord application id is required to register commands")
+	}
+	provider, ok := b.handler.(CommandMenuProvider)
+	if !ok {
+		return nil
+	}
+	type command struct {
+		name         string
+		description  string
+		contexts     map[int]bool
+		localization map[string]string
+	}
+	commands := map[string]*command{}
+	for _, menu := range provider.CommandMenus(ctx) {
+		for _, c := range menu.Commands {
+			name := strings.ToLower(strings.TrimPrefix(c.Command, "/"))
+			if !discordCommandNamePattern.MatchString(name) {
+				b.log.Warn("skipped command with invalid discord name", zap.String("command", c.Command))
+				continue
+			}
+			cmd, ok := commands[name]
+			if !ok {
+				cmd = &command{name: name, contexts: map[int]bool{}, localization: map[string]string{}}
+				commands[name] = cmd
+			}
+			description := truncateRunes(c.Description, discordMaxDescription)
+			if menu.Language == "" {
+				cmd.description = description
+				for _, kind := range discordCommandContexts(menu.Scope) {
+					cmd.contexts[kind] = true
+				}
+			} else if locale := discordLocale(menu.Language); locale != "" {
+				cmd.localization[locale] = description
+			}
+		}
+	}
+
+	names := make([]string, 0, len(commands))
+	for name, cmd := range commands {
+		if cmd.description != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	payload := make([]any, 0, len(names))
+	for _, name := range names {
+		cmd := commands[name]
+		contexts := make([]int, 0, len(cmd.contexts))
+		for kind := range cmd.contexts {
+			contexts = append(contexts, kind)
+		}
+		sort.Ints(contexts)
+		entry := map[string]any{
+			"name":        cmd.name,
+			"description": cmd.description,
+			"type":        1,
+			"contexts":    contexts,
+			"options": []any{map[string]any{
+				"type":        3,
+				"name":        "args",
+				"description": "Arguments",
+				"required":    false,
+			}},
+		}
+		if len(cmd.localization) != 0 {
+			entry["description_localizations"] = cmd.localization
+		}
+		payload = append(payload, entry)
+	}
+	if err := b.call(ctx, http.MethodPut, "applications/"+b.config.ApplicationID+"/commands", payload); err != nil {
+		return errors.Wrap(err, "failed to register discord commands")
+	}
+	return nil
+}
+
+var discordCommandNamePattern = regexp.MustCompile(`^[-_\p{L}\p{N}]{1,32}$`)
+
+// discordCommandContexts maps a command menu scope onto Discord interaction contexts
+// (0 guild, 1 bot DM, 2 private channel).
+func discordCommandContexts(scope string) []int {
+	switch scope {
+	case CommandScopePrivate:
+		return []int{1, 2}
+	case CommandScopeGroup, CommandScopeAdmins:
+		return []int{0}
+	default:
+		return []int{0, 1, 2}
+	}
+}
+
+var discordLocales = []string{
+	"id", "da", "de", "en-GB", "en-US", "es-ES", "es-419", "fr", "hr", "it", "lt", "hu", "nl", "no", "pl",
+	"pt-BR", "ro", "fi", "sv-SE", "vi", "tr", "cs", "el", "bg", "ru", "uk", "hi", "th", "zh-CN", "ja",
+	"zh-TW", "ko",
+}
+
+var discordLocaleAliases = map[string]string{
+	"en": "en-US", "es": "es-ES", "pt": "pt-BR", "sv": "sv-SE", "nb": "no",
+	"zh": "zh-CN", "zh-hans": "zh-CN", "zh-sg": "zh-CN", "zh-hant": "zh-TW", "zh-hk": "zh-TW",
+}
+
+// discordLocale maps an i18n language onto a Discord locale, or returns "" when Discord has none.
+func discordLocale(language string) string {
+	language = strings.ToLower(language)
+	for _, locale := range discordLocales {
+		if strings.ToLower(locale) == language {
+			return locale
+		}
+	}
+	if locale, ok := discordLocaleAliases[language]; ok {
+		return locale
+	}
+	primary, _, _ := strings.Cut(language, "-")
+	for _, locale := range discordLocales {
+		if locale == primary {
+			return locale
+		}
+	}
+	return discordLocaleAliases[primary]
+}
+
+func (b *DiscordBot) call(ctx context.Context, method string, path string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal discord request")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, b.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create discord request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bot "+b.config.BotToken)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to call discord api")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		raw, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("discord api returned status %d: %s", resp.StatusCode, string(raw))
+	}
+	return nil
+}
+
+// VerifyDiscordSignature checks the `X-Signature-Ed25519` header of an interaction request against the
+// application's public key.
+func VerifyDiscordSignature(publicKey ed25519.PublicKey, signature string, timestamp string, body []byte) error {
+	if signature == "" || timestamp == "" {
+		return errors.Wrap(ErrBadRequest, "missing discord signature headers")
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.Wrap(ErrBadRequest, "invalid discord signature encoding")
+	}
+	if !ed25519.Verify(publicKey, append([]byte(timestamp), body...), sig) {
+		return errors.Wrap(ErrBadRequest, "discord signature mismatch")
+	}
+	return nil
+}
+
+// toDiscordMessages splits text and buttons into Discord message payloads: content is chunked at 2000
+// characters, grid rows at five buttons and messages at five action rows.
+func toDiscordMessages(text string, grid [][]Button) ([]map[string]any, error) {
+	var rows []any
+	for _, row := range grid {
+		for start := 0; start < len(row); start += discordMaxRowButtons {
+			buttons := make([]any, 0, discordMaxRowButtons)
+			for _, btn := range row[start:min(start+discordMaxRowButtons, len(row))] {
+				if len(btn.CallbackData) > discordMaxCustomID {
+					return nil, errors.Errorf("callback data of button %q exceeds %d bytes", btn.Label, discordMaxCustomID)
+				}
+				buttons = append(buttons, map[string]any{
+					"type":      discordComponentButton,
+					"style":     2,
+					"label":     truncateRunes(btn.Label, discordMaxButtonLabel),
+					"custom_id": btn.CallbackData,
+				})
+			}
+			rows = append(rows, map[string]any{"type": discordComponentActionRow, "components": buttons})
+		}
+	}
+
+	var messages []map[string]any
+	if strings.TrimSpace(text) != "" {
+		for _, chunk := range chunkRunes(text, discordMaxContent) {
+			messages = append(messages, map[string]any{"content": chunk})
+		}
+	}
+	for start := 0; start < len(rows); start += discordMaxMessageRows {
+		components := rows[start:min(start+discordMaxMessageRows, len(rows))]
+		if start == 0 && len(messages) != 0 {
+			messages[len(messages)-1]["components"] = components
+			continue
+		}
+		messages = append(messages, map[string]any{"components": components})
+	}
+	return messages, nil
+}
+
+var (
+	discordHTMLReplacer = strings.NewReplacer(
+		"<b>", "**", "</b>", "**", "<strong>", "**", "</strong>", "**",
+		"<i>", "*", "</i>", "*", "<em>", "*", "</em>", "*",
+		"<u>", "__", "</u>", "__", "<ins>", "__", "</ins>", "__",
+		"<s>", "~~", "</s>", "~~", "<strike>", "~~", "</strike>", "~~", "<del>", "~~", "</del>", "~~",
+		"<pre>", "```", "</pre>", "```", "<code>", "`", "</code>", "`",
+	)
+	telegramBoldPattern   = regexp.MustCompile(`\*([^*\n]+)\*`)
+	telegramStrikePattern = regexp.MustCompile(`~([^~\n]+)~`)
+)
+
+// toDiscordMarkdown converts text in a Telegram parse mode into Discord markdown, which doubles the bold
+// and strike-through markers and supports `[label](url)` links.
+func toDiscordMarkdown(text string, parseMode string) string {
+	switch strings.ToLower(parseMode) {
+	case "html":
+		text = replaceHTMLLinks(text, func(href string, label string) string {
+			return "[" + label + "](" + href + ")"
+		})
+		text = discordHTMLReplacer.Replace(text)
+		return stripHTML(text)
+	case "markdown":
+		return telegramBoldPattern.ReplaceAllString(text, "**$1**")
+	case "markdownv2":
+		escaped := strings.NewReplacer(`\*`, "\x00", `\~`, "\x01").Replace(text)
+		escaped = telegramBoldPattern.ReplaceAllString(escaped, "**$1**")
+		escaped = telegramStrikePattern.ReplaceAllString(escaped, "~~$1~~")
+		return strings.NewReplacer("\x00", `\*`, "\x01", `\~`).Replace(unescapeMarkdownV2(escaped))
+	default:
+		return text
+	}
+}
@@ -0,0 +1,235 @@
+package bot_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/discordtest"
+)
+
+// discordTestHandler adds a command menu and a form with a select to testHandler.
+type discordTestHandler struct {
+	testHandler
+}
+
+func (h *discordTestHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if data != bot.RouteCallbackData("/color") {
+		return h.testHandler.HandleCallbackData(ctx, data, chatID, b)
+	}
+	u, _ := url.Parse("/add")
+	return b.SendForm(ctx, chatID, &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{{
+			ID:    "title",
+			Label: "Color",
+			Input: &bot.FormFieldInput{Schema: &bot.FormSchema{Type: "string", Enum: []string{"red", "green"}}},
+		}},
+	})
+}
+
+func (h *discordTestHandler) CommandMenus(context.Context) []bot.CommandMenu {
+	return []bot.CommandMenu{
+		{Scope: bot.CommandScopePrivate, Commands: []bot.Command{{Command: "/start", Description: "Open the home page"}}},
+		{Language: "zh-hans", Scope: bot.CommandScopePrivate, Commands: []bot.Command{{Command: "/start", Description: "打开主页"}}},
+	}
+}
+
+func newDiscordTestBot(t *testing.T) (*bot.DiscordBot, *discordtest.Server, *discordtest.Client) {
+	t.Helper()
+	server := discordtest.NewServer()
+	t.Cleanup(server.Close)
+	sm := newTestSessionManager(t)
+	client, publicKey := discordtest.NewClient(nil)
+	discordBot, err := bot.NewDiscordBot(bot.DiscordConfig{
+		ApplicationID: "1",
+		BotToken:      "token",
+		PublicKey:     publicKey,
+	}, sm, nil, bot.WithDiscordAPIURL(server.APIURL()))
+	if err != nil {
+		t.Fatalf("discord bot: %v", err)
+	}
+	discordBot.RegisterBotxHandler(&discordTestHandler{})
+	client.Handler = discordBot
+	return discordBot, server, client
+}
+
+func TestDiscordBotModalForm(t *testing.T) {
+	_, _, client := newDiscordTestBot(t)
+
+	resp, err := client.Command("100", "start", "")
+	if err != nil {
+		t.Fatalf("command: %v", err)
+	}
+	if resp.Message == nil || resp.Message.Content != "**Hello** /start" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if resp.Message.Buttons[0][0].CustomID != "_route:/add" {
+		t.Fatalf("unexpected buttons: %+v", resp.Message.Buttons)
+	}
+
+	resp, err = client.Click("100", "_route:/add")
+	if err != nil {
+		t.Fatalf("click: %v", err)
+	}
+	if resp.Modal == nil || len(resp.Modal.Fields) != 1 || resp.Modal.Fields[0].ID != "title" {
+		t.Fatalf("expected modal with title input, got %+v", resp)
+	}
+	modal := *resp.Modal
+
+	resp, err = client.SubmitModal("100", modal, map[string]string{"title": " "})
+	if err != nil {
+		t.Fatalf("submit invalid modal: %v", err)
+	}
+	if resp.Message == nil || resp.Message.Content != "title is required" || resp.Message.Buttons[0][0].CustomID != bot.DiscordOpenFormID {
+		t.Fatalf("expected validation error with reopen button, got %+v", resp.Message)
+	}
+	resp, err = client.Click("100", bot.DiscordOpenFormID)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if resp.Modal == nil || resp.Modal.Fields[0].Value != " " {
+		t.Fatalf("expected reopened modal with previous value, got %+v", resp)
+	}
+
+	resp, err = client.SubmitModal("100", modal, map[string]string{"title": "milk"})
+	if err != nil {
+		t.Fatalf("submit modal: %v", err)
+	}
+	if resp.Message == nil || resp.Message.Content != "added milk" {
+		t.Fatalf("expected submit result, got %+v", resp.Message)
+	}
+}
+
+func TestDiscordBotSelect(t *testing.T) {
+	_, _, client := newDiscordTestBot(t)
+
+	resp, err := client.Click("101", bot.RouteCallbackData("/color"))
+	if err != nil {
+		t.Fatalf("click: %v", err)
+	}
+	if resp.Modal == nil || resp.Modal.Fields[0].Kind != "select" || len(resp.Modal.Fields[0].Options) != 2 {
+		t.Fatalf("expected modal with select, got %+v", resp)
+	}
+	resp, err = client.SubmitModal("101", *resp.Modal, map[string]string{"title": "green"})
+	if err != nil {
+		t.Fatalf("submit modal: %v", err)
+	}
+	if resp.Message == nil || resp.Message.Content != "added green" {
+		t.Fatalf("expected submit result, got %+v", resp.Message)
+	}
+}
+
+func TestDiscordBotConditionalFormAsksOneFieldPerModal(t *testing.T) {
+	discordBot, _, client := newDiscordTestBot(t)
+	discordBot.RegisterBotxHandler(&deliveryTestHandler{})
+
+	resp, err := client.Click("103", bot.RouteCallbackData("/add"))
+	if err != nil {
+		t.Fatalf("click: %v", err)
+	}
+	if resp.Modal == nil || len(resp.Modal.Fields) != 1 || resp.Modal.Fields[0].ID != "delivery" {
+		t.Fatalf("expected modal with the delivery input only, got %+v", resp)
+	}
+	if resp, err = client.SubmitModal("103", *resp.Modal, map[string]string{"delivery": "no"}); err != nil {
+		t.Fatalf("submit modal: %v", err)
+	}
+	if resp.Message == nil || resp.Message.Buttons[0][0].CustomID != bot.DiscordOpenFormID {
+		t.Fatalf("expected a button to open the next modal, got %+v", resp)
+	}
+	if resp, err = client.Click("103", bot.DiscordOpenFormID); err != nil {
+		t.Fatalf("open next modal: %v", err)
+	}
+	if resp.Modal == nil || len(resp.Modal.Fields) != 1 || resp.Modal.Fields[0].ID != "note" {
+		t.Fatalf("expected the hidden address to be skipped, got %+v", resp)
+	}
+}
+
+func TestDiscordBotSplitsActionRows(t *testing.T) {
+	discordBot, server, _ := newDiscordTestBot(t)
+
+	var grid [][]bot.Button
+	for r := 0; r < 4; r++ {
+		var row []bot.Button
+		for c := 0; c < 7; c++ {
+			row = append(row, bot.Button{Label: fmt.Sprintf("%d-%d", r, c), CallbackData: bot.RouteCallbackData(fmt.Sprintf("/cell/%d/%d", r, c))})
+		}
+		grid = append(grid, row)
+	}
+	if err := discordBot.SendMessage(context.Background(), 102, &bot.Message{Text: "grid", ButtonGrid: grid}); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	messages := server.Messages("102")
+	if len(messages) != 2 || messages[0].Content != "grid" || len(messages[0].Buttons) != 5 || len(messages[1].Buttons) != 3 {
+		t.Fatalf("expected 8 action rows split over two messages, got %+v", messages)
+	}
+	if len(messages[0].Buttons[0]) != 5 || len(messages[0].Buttons[1]) != 2 {
+		t.Fatalf("expected rows of 5 and 2 buttons, got %+v", messages[0].Buttons)
+	}
+}
+
+// responseOrderRecorder records the REST calls the server had received when the interaction response
+// was written.
+type responseOrderRecorder struct {
+	*httptest.ResponseRecorder
+	server       *discordtest.Server
+	callsAtWrite int
+}
+
+func (w *responseOrderRecorder) Write(p []byte) (int, error) {
+	w.callsAtWrite = len(w.server.Calls())
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestDiscordBotPostsFollowUpsAfterTheResponse(t *testing.T) {
+	discordBot, server, client := newDiscordTestBot(t)
+	discordBot.RegisterBotxHandler(&wideRowHandler{})
+	var recorder *responseOrderRecorder
+	client.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder = &responseOrderRecorder{ResponseRecorder: w.(*httptest.ResponseRecorder), server: server}
+		discordBot.ServeHTTP(recorder, r)
+	})
+
+	resp, err := client.Command("104", "start", "")
+	if err != nil {
+		t.Fatalf("command: %v", err)
+	}
+	if resp.Message == nil || resp.Message.Content != "pick" || len(resp.Message.Buttons) != 5 {
+		t.Fatalf("expected the first five rows in the response, got %+v", resp)
+	}
+	if recorder.callsAtWrite != 0 {
+		t.Fatalf("expected the response to be written before the follow-up, got %d calls first", recorder.callsAtWrite)
+	}
+	if messages := server.Messages("104"); len(messages) != 1 || len(messages[0].Buttons) != 1 || messages[0].Buttons[0][4].CustomID != bot.RouteCallbackData("/todo/29") {
+		t.Fatalf("expected the last row in a follow-up, got %+v", messages)
+	}
+}
+
+func TestDiscordBotRegisterCommands(t *testing.T) {
+	discordBot, server, _ := newDiscordTestBot(t)
+
+	if err := discordBot.RegisterCommands(context.Background()); err != nil {
+		t.Fatalf("register commands: %v", err)
+	}
+	commands := server.Commands()
+	if len(commands) != 1 {
+		t.Fatalf("expected one command, got %+v", commands)
+	}
+	command := commands[0].(map[string]any)
+	localizations, _ := command["description_localizations"].(map[string]any)
+	if command["name"] != "start" || localizations["zh-CN"] != "打开主页" {
+		t.Fatalf("unexpected command: %+v", command)
+	}
+}
+
+func TestVerifyDiscordSignature(t *testing.T) {
+	_, _, client := newDiscordTestBot(t)
+	other, _ := discordtest.NewClient(client.Handler)
+	if _, err := other.Command("103", "start", ""); err == nil {
+		t.Fatalf("expected interaction with a bad signature to be rejected")
+	}
+}
@@ -0,0 +1,301 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+)
+
+// MuxSessionKeyOrigin stores `namespace:chatID` of the connector chat a namespaced chat belongs to, so
+// replies can be routed after the in-memory table is lost, e.g. with a persistent session manager.
+const MuxSessionKeyOrigin = "__mux_origin"
+
+// Mux runs one BotxHandler across several connectors. Pass it to the generated Register in place of a
+// single connector:
+//
+//	mux, _ := bot.NewMux(sm)
+//	_ = mux.Add("telegram", telegramBot)
+//	_ = mux.Add("slack", slackBot)
+//	Register(mux, sm, stateProvider, formValidator, handler, commandHandler)
+//
+// Chat IDs seen by the handler, the state provider and the session manager are namespaced per connector
+// (see MuxChatID), and every reply is sent through the connector the chat belongs to.
+type Mux struct {
+	sm session.SessionManager
+
+	mu         sync.RWMutex
+	handler    BotxHandler
+	connectors map[string]BotConnector
+	origins    map[int64]muxOrigin
+}
+
+type muxOrigin struct {
+	namespace string
+	chatID    int64
+}
+
+func NewMux(sm session.SessionManager) (*Mux, error) {
+	if sm == nil {
+		return nil, errors.New("session manager is required")
+	}
+	return &Mux{
+		sm:         sm,
+		connectors: make(map[string]BotConnector),
+		origins:    make(map[int64]muxOrigin),
+	}, nil
+}
+
+// Add registers connector under namespace, e.g. "telegram". Namespaces must not contain ':'.
+func (m *Mux) Add(namespace string, connector BotConnector) error {
+	if namespace == "" || strings.Contains(namespace, ":") {
+		return errors.Errorf("invalid mux namespace %q", namespace)
+	}
+	if connector == nil {
+		return errors.New("connector is required")
+	}
+	m.mu.Lock()
+	if _, ok := m.connectors[namespace]; ok {
+		m.mu.Unlock()
+		return errors.Errorf("mux namespace %q is already registered", namespace)
+	}
+	m.connectors[namespace] = connector
+	handler := m.handler
+	m.mu.Unlock()
+	if handler != nil {
+		connector.RegisterBotxHandler(&muxHandler{mux: m, namespace: namespace})
+	}
+	return nil
+}
+
+func (m *Mux) RegisterBotxHandler(handler BotxHandler) {
+	m.mu.Lock()
+	m.handler = handler
+	connectors := make(map[string]BotConnector, len(m.connectors))
+	for namespace, connector := range m.connectors {
+		connectors[namespace] = connector
+	}
+	m.mu.Unlock()
+	for namespace, connector := range connectors {
+		connector.RegisterBotxHandler(&muxHandler{mux: m, namespace: namespace})
+	}
+}
+
+// botxHandler returns the registered handler. It may be replaced while connectors deliver updates.
+func (m *Mux) botxHandler() BotxHandler {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.handler
+}
+
+// MuxChatID returns the namespaced chat ID of chatID on the connector registered under namespace.
+func MuxChatID(namespace string, chatID int64) int64 {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%s:%d", namespace, chatID)
+	return int64(h.Sum64() & (1<<63 - 1))
+}
+
+// ChatID returns the namespaced chat ID of chatID on the connector registered under namespace and
+// remembers its origin, so messages can be sent to chats that have not written yet.
+func (m *Mux) ChatID(ctx context.Context, namespace string, chatID int64) (int64, error) {
+	id := MuxChatID(namespace, chatID)
+	m.mu.RLock()
+	_, known := m.origins[id]
+	m.mu.RUnlock()
+	if known {
+		return id, nil
+	}
+	sess, err := m.sm.Get(ctx, id)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get session")
+	}
+	if err := sess.Set(ctx, MuxSessionKeyOrigin, fmt.Sprintf("%s:%d", namespace, chatID)); err != nil {
+		return 0, errors.Wrap(err, "failed to set chat origin in session")
+	}
+	m.mu.Lock()
+	m.origins[id] = muxOrigin{namespace: namespace, chatID: chatID}
+	m.mu.Unlock()
+	return id, nil
+}
+
+// Origin returns the namespace and connector chat ID of a namespaced chat ID.
+func (m *Mux) Origin(ctx context.Context, chatID int64) (string, int64, error) {
+	m.mu.RLock()
+	origin, ok := m.origins[chatID]
+	m.mu.RUnlock()
+	if ok {
+		return origin.namespace, origin.chatID, nil
+	}
+	sess, err := m.sm.Get(ctx, chatID)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "failed to get session")
+	}
+	val, err := sess.Get(ctx, MuxSessionKeyOrigin)
+	if err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return "", 0, errors.Wrapf(ErrNotFound, "unknown mux chat %d", chatID)
+		}
+		return "", 0, errors.Wrap(err, "failed to get chat origin from session")
+	}
+	text, _ := val.(string)
+	namespace, raw, found := strings.Cut(text, ":")
+	local, err := strconv.ParseInt(raw, 10, 64)
+	if !found || err != nil {
+		return "", 0, errors.Errorf("invalid mux chat origin: %q", text)
+	}
+	m.mu.Lock()
+	m.origins[chatID] = muxOrigin{namespace: namespace, chatID: local}
+	m.mu.Unlock()
+	return namespace, local, nil
+}
+
+func (m *Mux) route(ctx context.Context, chatID int64) (BotConnector, int64, error) {
+	namespace, local, err := m.Origin(ctx, chatID)
+	if err != nil {
+		return nil, 0, err
+	}
+	m.mu.RLock()
+	connector, ok := m.connectors[namespace]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, 0, errors.Wrapf(ErrNotFound, "no connector registered for mux namespace %q", namespace)
+	}
+	return connector, local, nil
+}
+
+func (m *Mux) SendMessage(ctx context.Context, chatID int64, message *Message) error {
+	connector, local, err := m.route(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	return connector.SendMessage(ctx, local, message)
+}
+
+// EditMessage edits in place where the connector of the chat is a MessageEditor.
+func (m *Mux) EditMessage(ctx context.Context, chatID int64, message *Message) (bool, error) {
+	connector, local, err := m.route(ctx, chatID)
+	if err != nil {
+		return false, err
+	}
+	editor, ok := connector.(MessageEditor)
+	if !ok {
+		return false, nil
+	}
+	return editor.EditMessage(ctx, local, message)
+}
+
+func (m *Mux) SendForm(ctx context.Context, chatID int64, form *Form) error {
+	connector, local, err := m.route(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	return connector.SendForm(ctx, local, form)
+}
+
+func (m *Mux) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	handler := m.botxHandler()
+	if handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if data == "" {
+		return errors.New("callback data is required")
+	}
+	if err := handler.HandleCallbackData(ctx, data, chatID, m); err != nil {
+		return errors.Wrap(err, "failed to handle callback data")
+	}
+	return nil
+}
+
+// muxHandler is registered on each connector of a Mux. It namespaces the connector's chat IDs before
+// passing updates on to the shared handler.
+type muxHandler struct {
+	mux       *Mux
+	namespace string
+}
+
+func (h *muxHandler) HandleTextMessage(ctx context.Context, data string, chatID int64, _ BotConnector) error {
+	id, err := h.mux.ChatID(ctx, h.namespace, chatID)
+	if err != nil {
+		return err
+	}
+	return h.mux.botxHandler().HandleTextMessage(ctx, data, id, h.mux)
+}
+
+func (h *muxHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, _ BotConnector) error {
+	id, err := h.mux.ChatID(ctx, h.namespace, chatID)
+	if err != nil {
+		return err
+	}
+	return h.mux.botxHandler().HandleCallbackData(ctx, data, id, h.mux)
+}
+
+func (h *muxHandler) HandleError(ctx context.Context, err error, chatID int64, _ BotConnector) error {
+	id, idErr := h.mux.ChatID(ctx, h.namespace, chatID)
+	if idErr != nil {
+		return idErr
+	}
+	return h.mux.botxHandler().HandleError(ctx, err, id, h.mux)
+}
+
+func (h *muxHandler) Validate(ctx context.Context, chatID int64, url *url.URL, validator string, input string) (*ValidateResult, error) {
+	id, err := h.mux.ChatID(ctx, h.namespace, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return h.mux.botxHandler().Validate(ctx, id, url, validator, input)
+}
+
+// ValidateFormField forwards the field validators of the shared handler, falling back to Validate.
+func (h *muxHandler) ValidateFormField(ctx context.Context, chatID int64, url *url.URL, validator string, field string, input string, values FormValues) (*ValidateResult, error) {
+	id, err := h.mux.ChatID(ctx, h.namespace, chatID)
+	if err != nil {
+		return nil, err
+	}
+	handler := h.mux.botxHandler()
+	if fieldValidator, ok := handler.(FormFieldValidator); ok {
+		return fieldValidator.ValidateFormField(ctx, id, url, validator, field, input, values)
+	}
+	return handler.Validate(ctx, id, url, validator, input)
+}
+
+// ValidateFormValues forwards the form `validate` hooks of the shared handler. Without hooks every form
+// is valid.
+func (h *muxHandler) ValidateFormValues(ctx context.Context, chatID int64, url *url.URL, values FormValues) (*ValidateResult, error) {
+	formValidator, ok := h.mux.botxHandler().(FormValuesValidator)
+	if !ok {
+		return &ValidateResult{Valid: true}, nil
+	}
+	id, err := h.mux.ChatID(ctx, h.namespace, chatID)
+	if err != nil {
+		return nil, err
+	}
+	return formValidator.ValidateFormValues(ctx, id, url, values)
+}
+
+// ShowFormField forwards the `showIf` conditions of the shared handler. Without conditions every field
+// is shown.
+func (h *muxHandler) ShowFormField(ctx context.Context, chatID int64, url *url.URL, field string, values FormValues) (bool, error) {
+	filter, ok := h.mux.botxHandler().(FormFieldFilter)
+	if !ok {
+		return true, nil
+	}
+	id, err := h.mux.ChatID(ctx, h.namespace, chatID)
+	if err != nil {
+		return false, err
+	}
+	return filter.ShowFormField(ctx, id, url, field, values)
+}
+
+// CommandMenus forwards the command menus of the shared handler, so connectors register them on start.
+func (h *muxHandler) CommandMenus(ctx context.Context) []CommandMenu {
+	if provider, ok := h.mux.botxHandler().(CommandMenuProvider); ok {
+		return provider.CommandMenus(ctx)
+	}
+	return nil
+}
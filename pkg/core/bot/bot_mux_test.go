@@ -0,0 +1,151 @@
+package bot_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/slacktest"
+)
+
+type recordingFrontend struct {
+	messages map[int64][]*bot.Message
+}
+
+func (f *recordingFrontend) SendMessage(_ context.Context, chatID int64, message *bot.Message) error {
+	if f.messages == nil {
+		f.messages = make(map[int64][]*bot.Message)
+	}
+	f.messages[chatID] = append(f.messages[chatID], message)
+	return nil
+}
+
+func (f *recordingFrontend) last(chatID int64) string {
+	messages := f.messages[chatID]
+	if len(messages) == 0 {
+		return ""
+	}
+	return messages[len(messages)-1].Text
+}
+
+// chatRecorder records the chat IDs the shared handler sees.
+type chatRecorder struct {
+	testHandler
+	chats map[int64]bool
+}
+
+func (h *chatRecorder) HandleTextMessage(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	h.chats[chatID] = true
+	return h.testHandler.HandleTextMessage(ctx, data, chatID, b)
+}
+
+func newMuxTestBot(t *testing.T) (*bot.Mux, *chatRecorder, *slacktest.Server, *slacktest.Client, *bot.CLIBot, *recordingFrontend) {
+	t.Helper()
+	server := slacktest.NewServer()
+	t.Cleanup(server.Close)
+	sm := newTestSessionManager(t)
+	slackBot, err := bot.NewSlackBot("xoxb-test", "secret", sm, nil, bot.WithSlackAPIURL(server.APIURL()))
+	if err != nil {
+		t.Fatalf("slack bot: %v", err)
+	}
+	frontend := &recordingFrontend{}
+	cliBot, err := bot.NewCLIBot(sm, frontend)
+	if err != nil {
+		t.Fatalf("cli bot: %v", err)
+	}
+	mux, err := bot.NewMux(sm)
+	if err != nil {
+		t.Fatalf("mux: %v", err)
+	}
+	if err := mux.Add("slack", slackBot); err != nil {
+		t.Fatalf("add slack: %v", err)
+	}
+	if err := mux.Add("cli", cliBot); err != nil {
+		t.Fatalf("add cli: %v", err)
+	}
+	handler := &chatRecorder{chats: make(map[int64]bool)}
+	mux.RegisterBotxHandler(handler)
+	return mux, handler, server, &slacktest.Client{Handler: slackBot, SigningSecret: "secret"}, cliBot, frontend
+}
+
+func TestMuxRoutesRepliesToOriginatingConnector(t *testing.T) {
+	ctx := context.Background()
+	mux, handler, server, client, cliBot, frontend := newMuxTestBot(t)
+
+	if err := client.SendText("C1", "from slack"); err != nil {
+		t.Fatalf("send slack text: %v", err)
+	}
+	if err := cliBot.HandleUpdate(ctx, &bot.CLIUpdate{ChatID: 1, Text: "from cli"}); err != nil {
+		t.Fatalf("send cli text: %v", err)
+	}
+
+	msg, ok := server.LastMessage("C1")
+	if !ok || !strings.Contains(msg.Text, "from slack") {
+		t.Fatalf("expected slack reply, got %+v", msg)
+	}
+	if got := frontend.last(1); !strings.Contains(got, "from cli") {
+		t.Fatalf("expected cli reply, got %q", got)
+	}
+	if len(server.Messages("C1")) != 1 {
+		t.Fatalf("expected the cli reply to stay off slack, got %+v", server.Messages("C1"))
+	}
+
+	cliChat := bot.MuxChatID("cli", 1)
+	if len(handler.chats) != 2 || !handler.chats[cliChat] {
+		t.Fatalf("expected two namespaced chats, got %v", handler.chats)
+	}
+	namespace, local, err := mux.Origin(ctx, cliChat)
+	if err != nil || namespace != "cli" || local != 1 {
+		t.Fatalf("unexpected origin: %q %d %v", namespace, local, err)
+	}
+
+	// Proactive messages to a namespaced chat go through its connector.
+	if err := mux.SendMessage(ctx, cliChat, &bot.Message{Text: "reminder"}); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if got := frontend.last(1); got != "reminder" {
+		t.Fatalf("expected reminder on cli, got %q", got)
+	}
+}
+
+func TestMuxForm(t *testing.T) {
+	ctx := context.Background()
+	_, _, _, _, cliBot, frontend := newMuxTestBot(t)
+
+	if err := cliBot.HandleUpdate(ctx, &bot.CLIUpdate{ChatID: 1, CallbackData: bot.RouteCallbackData("/add")}); err != nil {
+		t.Fatalf("open form: %v", err)
+	}
+	if got := frontend.last(1); got != "Enter a title" {
+		t.Fatalf("expected form prompt, got %q", got)
+	}
+	if err := cliBot.HandleUpdate(ctx, &bot.CLIUpdate{ChatID: 1, Text: "milk"}); err != nil {
+		t.Fatalf("submit form: %v", err)
+	}
+	if got := frontend.last(1); got != "added milk" {
+		t.Fatalf("expected submit result, got %q", got)
+	}
+}
+
+func TestMuxOriginFromSession(t *testing.T) {
+	ctx := context.Background()
+	sm := newTestSessionManager(t)
+	first, _ := bot.NewMux(sm)
+	id, err := first.ChatID(ctx, "telegram", -100123)
+	if err != nil {
+		t.Fatalf("chat id: %v", err)
+	}
+
+	// A new mux sharing the session manager, e.g. after a restart, still knows where the chat lives.
+	second, _ := bot.NewMux(sm)
+	namespace, local, err := second.Origin(ctx, id)
+	if err != nil || namespace != "telegram" || local != -100123 {
+		t.Fatalf("unexpected origin: %q %d %v", namespace, local, err)
+	}
+	if _, _, err := second.Origin(ctx, 42); err == nil {
+		t.Fatal("expected unknown chat error")
+	}
+	if err := second.Add("a:b", &bot.CLIBot{}); err == nil {
+		t.Fatal("expected invalid namespace error")
+	}
+}
@@ -0,0 +1,761 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	SlackSessionKeyInputState = "__slack_input_state"
+	SlackSessionKeyModalForm  = "__slack_modal_form"
+	// SlackSessionKeyChannel stores the Slack channel ID of a chat, so replies can be sent after the
+	// in-memory table is lost, e.g. with a persistent session manager.
+	SlackSessionKeyChannel = "__slack_channel"
+	// SlackSessionKeyEvents stores the IDs of the last events handled in a chat, space-separated, so
+	// redelivered events are handled once.
+	SlackSessionKeyEvents = "__slack_events"
+
+	DefaultSlackAPIURL = "https://slack.com/api/"
+
+	slackFormCallbackID     = "botx_form"
+	slackMaxSectionText     = 3000
+	slackMaxButtonText      = 75
+	slackMaxActionsElements = 25
+	slackSignatureMaxAge    = 5 * time.Minute
+	slackMaxSeenEvents      = 32
+)
+
+// SlackBot is a BotConnector backed by the Slack Web API. Incoming traffic is received by ServeHTTP, which
+// accepts Events API callbacks, interactivity payloads and slash commands on a single request URL. Socket
+// Mode is not supported.
+type SlackBot struct {
+	token         string
+	signingSecret string
+	apiURL        string
+	client        *http.Client
+	log           *zap.Logger
+	modalForms    bool
+
+	handler BotxHandler
+
+	sm     session.SessionManager
+	forms  *FormEngine
+	modals *FormEngine
+
+	mu       sync.RWMutex
+	channels map[int64]string
+
+	eventsMu sync.Mutex
+	updates  chatQueue
+}
+
+type SlackOption func(*SlackBot)
+
+// WithSlackAPIURL points the connector at a different Web API base URL, e.g. a slacktest server.
+func WithSlackAPIURL(apiURL string) SlackOption {
+	return func(b *SlackBot) {
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		b.apiURL = apiURL
+	}
+}
+
+func WithSlackHTTPClient(client *http.Client) SlackOption {
+	return func(b *SlackBot) {
+		b.client = client
+	}
+}
+
+// WithSlackModalForms sends forms as modals when the update carries a trigger_id (button clicks and slash
+// commands). Forms sent without a trigger_id fall back to the step-by-step flow.
+func WithSlackModalForms() SlackOption {
+	return func(b *SlackBot) {
+		b.modalForms = true
+	}
+}
+
+func NewSlackBot(token string, signingSecret string, sm session.SessionManager, log *zap.Logger, opts ...SlackOption) (*SlackBot, error) {
+	if token == "" {
+		return nil, errors.New("slack bot token is required")
+	}
+	if signingSecret == "" {
+		return nil, errors.New("slack signing secret is required")
+	}
+	if sm == nil {
+		return nil, errors.New("session manager is required")
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+	b := &SlackBot{
+		token:         token,
+		signingSecret: signingSecret,
+		apiURL:        DefaultSlackAPIURL,
+		client:        http.DefaultClient,
+		log:           log,
+		sm:            sm,
+		channels:      make(map[int64]string),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	handler := func() BotxHandler { return b.handler }
+	b.forms = NewFormEngine(b, sm, SlackSessionKeyInputState, handler)
+	b.modals = NewFormEngine(b, sm, SlackSessionKeyModalForm, handler, WithFormPrompt(b.openFormModal))
+	return b, nil
+}
+
+// SlackChatID maps a Slack channel ID onto the int64 chat ID used by sessions and handlers.
+func SlackChatID(channel string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(channel))
+	return int64(h.Sum64() & (1<<63 - 1))
+}
+
+func (b *SlackBot) RegisterBotxHandler(handler BotxHandler) {
+	b.handler = handler
+}
+
+// chatID returns the chat ID of channel and remembers the channel in the session of the chat.
+func (b *SlackBot) chatID(ctx context.Context, channel string) (int64, error) {
+	chatID := SlackChatID(channel)
+	b.mu.RLock()
+	_, known := b.channels[chatID]
+	b.mu.RUnlock()
+	if known {
+		return chatID, nil
+	}
+	sess, err := b.sm.Get(ctx, chatID)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to get session")
+	}
+	if err := sess.Set(ctx, SlackSessionKeyChannel, channel); err != nil {
+		return 0, errors.Wrap(err, "failed to set slack channel in session")
+	}
+	b.mu.Lock()
+	b.channels[chatID] = channel
+	b.mu.Unlock()
+	return chatID, nil
+}
+
+func (b *SlackBot) channel(ctx context.Context, chatID int64) (string, error) {
+	b.mu.RLock()
+	channel, ok := b.channels[chatID]
+	b.mu.RUnlock()
+	if ok {
+		return channel, nil
+	}
+	sess, err := b.sm.Get(ctx, chatID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get session")
+	}
+	val, err := sess.Get(ctx, SlackSessionKeyChannel)
+	if err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return "", errors.Wrapf(ErrNotFound, "no slack channel seen for chat %d", chatID)
+		}
+		return "", errors.Wrap(err, "failed to get slack channel from session")
+	}
+	channel, _ = val.(string)
+	if channel == "" {
+		return "", errors.Errorf("invalid slack channel %v for chat %d", val, chatID)
+	}
+	b.mu.Lock()
+	b.channels[chatID] = channel
+	b.mu.Unlock()
+	return channel, nil
+}
+
+// seenEvent records eventID in the session of chatID and reports whether it was already handled, e.g.
+// when Slack redelivers an event whose acknowledgement got lost.
+func (b *SlackBot) seenEvent(ctx context.Context, chatID int64, eventID string) (bool, error) {
+	if eventID == "" {
+		return false, nil
+	}
+	b.eventsMu.Lock()
+	defer b.eventsMu.Unlock()
+	sess, err := b.sm.Get(ctx, chatID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get session")
+	}
+	var seen []string
+	val, err := sess.Get(ctx, SlackSessionKeyEvents)
+	if err != nil && !errors.Is(err, session.ErrKeyNotFound) {
+		return false, errors.Wrap(err, "failed to get slack events from session")
+	}
+	if text, _ := val.(string); text != "" {
+		seen = strings.Fields(text)
+	}
+	if slices.Contains(seen, eventID) {
+		return true, nil
+	}
+	seen = append(seen, eventID)
+	if len(seen) > slackMaxSeenEvents {
+		seen = seen[len(seen)-slackMaxSeenEvents:]
+	}
+	if err := sess.Set(ctx, SlackSessionKeyEvents, strings.Join(seen, " ")); err != nil {
+		return false, errors.Wrap(err, "failed to set slack events in session")
+	}
+	return false, nil
+}
+
+// process runs fn after the request has been answered, since Slack gives up on requests that are not
+// acknowledged within 3 seconds. The updates of a channel run one at a time, in the order received.
+func (b *SlackBot) process(ctx context.Context, channel string, fn func(ctx context.Context)) {
+	ctx = context.WithoutCancel(ctx)
+	b.updates.Go(SlackChatID(channel), func() {
+		fn(ctx)
+	})
+}
+
+// Wait blocks until the updates received so far are processed, e.g. before shutting down.
+func (b *SlackBot) Wait() {
+	b.updates.Wait()
+}
+
+func (b *SlackBot) SendMessage(ctx context.Context, chatID int64, message *Message) error {
+	channel, err := b.channel(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	text := toSlackMrkdwn(message.Text, message.ParseMode)
+	params := map[string]any{
+		"channel": channel,
+		"text":    text,
+		"blocks":  toSlackBlocks(text, message.ButtonGrid),
+	}
+	if err := b.call(ctx, "chat.postMessage", params, nil); err != nil {
+		return errors.Wrap(err, "failed to post slack message")
+	}
+	return nil
+}
+
+func (b *SlackBot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if data == "" {
+		return errors.New("callback data is required")
+	}
+	// both engines claim every `_form:` callback, so it goes to the one holding the pending form
+	forms := b.forms
+	modal, err := b.modals.Pending(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if modal != nil {
+		forms = b.modals
+	}
+	if ok, err := forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
+	if err := b.handler.HandleCallbackData(ctx, data, chatID, b); err != nil {
+		return errors.Wrap(err, "failed to handle callback data")
+	}
+	return nil
+}
+
+// SendForm opens a modal when modal forms are enabled and the update carries a trigger_id. Otherwise it
+// asks for the fields one message at a time, like the Telegram connector. Forms with conditions are always
+// asked one message at a time, since a modal cannot evaluate a condition on the values before it.
+func (b *SlackBot) SendForm(ctx context.Context, chatID int64, form *Form) error {
+	if b.modalForms && slackTriggerFromContext(ctx) != "" && !form.HasConditions() {
+		return b.modals.Start(ctx, chatID, form)
+	}
+	return b.forms.Start(ctx, chatID, form)
+}
+
+type slackFormMetadata struct {
+	Channel string `json:"channel"`
+}
+
+// openFormModal opens form as a modal with the values entered so far. Slack only opens modals in response
+// to an interaction that carries a trigger_id.
+func (b *SlackBot) openFormModal(ctx context.Context, chatID int64, form *Form) error {
+	triggerID := slackTriggerFromContext(ctx)
+	if triggerID == "" {
+		return errors.New("slack modal forms can only be opened from a button or slash command")
+	}
+	channel, err := b.channel(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	metadata, err := json.Marshal(slackFormMetadata{Channel: channel})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal form metadata")
+	}
+	blocks := make([]map[string]any, 0, len(form.Fields))
+	for _, field := range form.Fields {
+		label := field.Label
+		if label == "" {
+			label = field.ID
+		}
+		element := map[string]any{
+			"type":      "plain_text_input",
+			"action_id": field.ID,
+		}
+		if field.Input != nil && field.Input.Value != "" {
+			element["initial_value"] = field.Input.Value
+		}
+		block := map[string]any{
+			"type":     "input",
+			"block_id": field.ID,
+			"label":    slackPlainText(label, 2000),
+			"element":  element,
+		}
+		if field.Input != nil && field.Input.Tip != "" {
+			block["hint"] = slackPlainText(stripHTML(field.Input.Tip), 2000)
+		}
+		blocks = append(blocks, block)
+	}
+	title := "Form"
+	if form.URL != nil {
+		title = form.URL.Path
+	}
+	params := map[string]any{
+		"trigger_id": triggerID,
+		"view": map[string]any{
+			"type":             "modal",
+			"callback_id":      slackFormCallbackID,
+			"private_metadata": string(metadata),
+			"title":            slackPlainText(title, 24),
+			"submit":           slackPlainText("Submit", 24),
+			"close":            slackPlainText("Cancel", 24),
+			"blocks":           blocks,
+		},
+	}
+	if err := b.call(ctx, "views.open", params, nil); err != nil {
+		return errors.Wrap(err, "failed to open slack form modal")
+	}
+	return nil
+}
+
+// ServeHTTP receives Events API callbacks (JSON), interactivity payloads (`payload=` form field) and slash
+// commands (`command=` form field). Every request must carry a valid Slack signature. Requests are
+// acknowledged before the handler runs; use Wait to let pending updates finish.
+func (b *SlackBot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := VerifySlackSignature(b.signingSecret, r.Header, body, time.Now()); err != nil {
+		b.log.Warn("rejected slack request", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	ctx := r.Context()
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		b.serveEvent(ctx, w, body)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if payload := form.Get("payload"); payload != "" {
+		b.serveInteraction(ctx, w, []byte(payload))
+		return
+	}
+	if command := form.Get("command"); command != "" {
+		text := strings.TrimSpace(command + " " + form.Get("text"))
+		ctx = withSlackTrigger(ctx, form.Get("trigger_id"))
+		w.WriteHeader(http.StatusOK)
+		channel := form.Get("channel_id")
+		b.process(ctx, channel, func(ctx context.Context) {
+			b.dispatchText(ctx, channel, text)
+		})
+		return
+	}
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+type slackEventEnvelope struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	EventID   string `json:"event_id"`
+	Event     struct {
+		Type    string `json:"type"`
+		Subtype string `json:"subtype"`
+		BotID   string `json:"bot_id"`
+		User    string `json:"user"`
+		Channel string `json:"channel"`
+		Text    string `json:"text"`
+	} `json:"event"`
+}
+
+func (b *SlackBot) serveEvent(ctx context.Context, w http.ResponseWriter, body []byte) {
+	var envelope slackEventEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch envelope.Type {
+	case "url_verification":
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(envelope.Challenge))
+		return
+	case "event_callback":
+		w.WriteHeader(http.StatusOK)
+		event := envelope.Event
+		if event.Type != "message" || event.Subtype != "" || event.BotID != "" || event.Channel == "" {
+			return
+		}
+		b.process(ctx, event.Channel, func(ctx context.Context) {
+			chatID, err := b.chatID(ctx, event.Channel)
+			if err != nil {
+				b.log.Error("failed to resolve slack chat", zap.Error(err))
+				return
+			}
+			// Slack redelivers an event, with the same event_id, when it missed the acknowledgement
+			seen, err := b.seenEvent(ctx, chatID, envelope.EventID)
+			if err != nil {
+				b.handleError(ctx, err, chatID)
+				return
+			}
+			if seen {
+				return
+			}
+			if err := b.handleText(ctx, chatID, event.Text); err != nil {
+				b.handleError(ctx, err, chatID)
+			}
+		})
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+type slackInteraction struct {
+	Type      string `json:"type"`
+	TriggerID string `json:"trigger_id"`
+	Channel   struct {
+		ID string `json:"id"`
+	} `json:"channel"`
+	Actions []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+	View struct {
+		CallbackID      string `json:"callback_id"`
+		PrivateMetadata string `json:"private_metadata"`
+		State           struct {
+			Values map[string]map[string]struct {
+				Value string `json:"value"`
+			} `json:"values"`
+		} `json:"state"`
+	} `json:"view"`
+}
+
+func (b *SlackBot) serveInteraction(ctx context.Context, w http.ResponseWriter, payload []byte) {
+	var interaction slackInteraction
+	if err := json.Unmarshal(payload, &interaction); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ctx = withSlackTrigger(ctx, interaction.TriggerID)
+	switch interaction.Type {
+	case "block_actions":
+		w.WriteHeader(http.StatusOK)
+		if len(interaction.Actions) == 0 || interaction.Channel.ID == "" {
+			return
+		}
+		channel, data := interaction.Channel.ID, interaction.Actions[0].Value
+		b.process(ctx, channel, func(ctx context.Context) {
+			chatID, err := b.chatID(ctx, channel)
+			if err != nil {
+				b.log.Error("failed to resolve slack chat", zap.Error(err))
+				return
+			}
+			if err := b.handleCallback(ctx, chatID, data); err != nil {
+				b.handleError(ctx, err, chatID)
+			}
+		})
+	case "view_submission":
+		if interaction.View.CallbackID != slackFormCallbackID {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		b.serveFormSubmission(ctx, w, &interaction)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (b *SlackBot) serveFormSubmission(ctx context.Context, w http.ResponseWriter, interaction *slackInteraction) {
+	var metadata slackFormMetadata
+	if err := json.Unmarshal([]byte(interaction.View.PrivateMetadata), &metadata); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	chatID, err := b.chatID(ctx, metadata.Channel)
+	if err != nil {
+		b.log.Error("failed to resolve slack chat", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	values := FormValues{}
+	for field, actions := range interaction.View.State.Values {
+		for _, action := range actions {
+			values[field] = action.Value
+		}
+	}
+	// validation errors can only be shown in the response, so the values are checked before answering
+	// and the form is submitted afterwards
+	form, validationErrors, err := b.modals.checkValues(ctx, chatID, values)
+	if err != nil {
+		w.WriteHeader(http.StatusOK)
+		b.process(ctx, metadata.Channel, func(ctx context.Context) {
+			b.handleError(ctx, err, chatID)
+		})
+		return
+	}
+	if len(validationErrors) != 0 {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"response_action": "errors",
+			"errors":          validationErrors,
+		})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	b.process(ctx, metadata.Channel, func(ctx context.Context) {
+		if err := b.modals.finish(ctx, chatID, form); err != nil {
+			b.handleError(ctx, err, chatID)
+		}
+	})
+}
+
+func (b *SlackBot) dispatchText(ctx context.Context, channel string, text string) {
+	if channel == "" {
+		return
+	}
+	chatID, err := b.chatID(ctx, channel)
+	if err != nil {
+		b.log.Error("failed to resolve slack chat", zap.Error(err))
+		return
+	}
+	if err := b.handleText(ctx, chatID, text); err != nil {
+		b.handleError(ctx, err, chatID)
+	}
+}
+
+func (b *SlackBot) handleError(ctx context.Context, err error, chatID int64) {
+	if b.handler == nil {
+		b.log.Error("failed to handle slack update", zap.Error(err))
+		return
+	}
+	if handleErr := b.handler.HandleError(ctx, err, chatID, b); handleErr != nil {
+		b.log.Error("failed to handle slack error", zap.Error(err), zap.NamedError("handleError", handleErr))
+	}
+}
+
+func (b *SlackBot) handleCallback(ctx context.Context, chatID int64, data string) error {
+	return b.SendCallbackData(ctx, chatID, data)
+}
+
+func (b *SlackBot) handleText(ctx context.Context, chatID int64, text string) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if text == "" {
+		return errors.Wrap(ErrEmptyMessage, "slack message has no text")
+	}
+	result, err := b.forms.HandleText(ctx, chatID, text)
+	if err != nil {
+		return errors.Wrap(err, "failed to handle form input")
+	}
+	if result != nil {
+		return nil
+	}
+
+	if err := b.handler.HandleTextMessage(ctx, text, chatID, b); err != nil {
+		return errors.Wrap(err, "failed to handle text message")
+	}
+	return nil
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+func (b *SlackBot) call(ctx context.Context, method string, params any, result any) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s params", method)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.apiURL+method, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "failed to create %s request", method)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to call %s", method)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s response", method)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s returned status %d: %s", method, resp.StatusCode, string(raw))
+	}
+	var status slackResponse
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return errors.Wrapf(err, "failed to decode %s response", method)
+	}
+	if !status.OK {
+		return errors.Errorf("%s failed: %s", method, status.Error)
+	}
+	if result != nil {
+		if err := json.Unmarshal(raw, result); err != nil {
+			return errors.Wrapf(err, "failed to decode %s result", method)
+		}
+	}
+	return nil
+}
+
+// VerifySlackSignature checks the `X-Slack-Signature` header against the signing secret, rejecting requests
+// whose timestamp is older than five minutes.
+func VerifySlackSignature(signingSecret string, header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	signature := header.Get("X-Slack-Signature")
+	if timestamp == "" || signature == "" {
+		return errors.Wrap(ErrBadRequest, "missing slack signature headers")
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrap(ErrBadRequest, "invalid slack request timestamp")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > slackSignatureMaxAge || age < -slackSignatureMaxAge {
+		return errors.Wrap(ErrBadRequest, "stale slack request timestamp")
+	}
+	expected := SlackSignature(signingSecret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.Wrap(ErrBadRequest, "slack signature mismatch")
+	}
+	return nil
+}
+
+// SlackSignature computes the `v0=` signature Slack sends for a request body.
+func SlackSignature(signingSecret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type slackTriggerContextKey struct{}
+
+func withSlackTrigger(ctx context.Context, triggerID string) context.Context {
+	if triggerID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, slackTriggerContextKey{}, triggerID)
+}
+
+func slackTriggerFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	value, _ := ctx.Value(slackTriggerContextKey{}).(string)
+	return value
+}
+
+func slackPlainText(text string, limit int) map[string]any {
+	if text == "" {
+		text = " "
+	}
+	return map[string]any{
+		"type":  "plain_text",
+		"text":  truncateRunes(text, limit),
+		"emoji": true,
+	}
+}
+
+func toSlackBlocks(text string, grid [][]Button) []map[string]any {
+	var blocks []map[string]any
+	for _, chunk := range chunkRunes(text, slackMaxSectionText) {
+		if strings.TrimSpace(chunk) == "" {
+			continue
+		}
+		blocks = append(blocks, map[string]any{
+			"type": "section",
+			"text": map[string]any{"type": "mrkdwn", "text": chunk},
+		})
+	}
+	// an actions block holds up to 25 buttons, so longer rows continue in further blocks
+	for r, row := range grid {
+		for start := 0; start < len(row); start += slackMaxActionsElements {
+			end := min(start+slackMaxActionsElements, len(row))
+			elements := make([]map[string]any, 0, end-start)
+			for c := start; c < end; c++ {
+				elements = append(elements, map[string]any{
+					"type":      "button",
+					"action_id": fmt.Sprintf("botx_%d_%d", r, c),
+					"text":      slackPlainText(row[c].Label, slackMaxButtonText),
+					"value":     row[c].CallbackData,
+				})
+			}
+			blockID := fmt.Sprintf("botx_row_%d", r)
+			if start > 0 {
+				blockID += fmt.Sprintf("_%d", start/slackMaxActionsElements)
+			}
+			blocks = append(blocks, map[string]any{
+				"type":     "actions",
+				"block_id": blockID,
+				"elements": elements,
+			})
+		}
+	}
+	return blocks
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// toSlackMrkdwn converts text in a Telegram parse mode into Slack mrkdwn. HTML tags are mapped onto their
+// mrkdwn equivalents and unknown tags are dropped; Markdown keeps its markers and converts links.
+func toSlackMrkdwn(text string, parseMode string) string {
+	switch strings.ToLower(parseMode) {
+	case "html":
+		text = replaceHTMLLinks(text, func(href string, label string) string {
+			return "\x00" + href + "|" + label + "\x01"
+		})
+		text = slackEscaper.Replace(htmlToMarkers(text))
+		return strings.NewReplacer("\x00", "<", "\x01", ">").Replace(text)
+	case "markdown", "markdownv2":
+		text = slackEscaper.Replace(text)
+		if strings.EqualFold(parseMode, "markdownv2") {
+			text = unescapeMarkdownV2(text)
+		}
+		return markdownLinkPattern.ReplaceAllString(text, "<$2|$1>")
+	default:
+		return slackEscaper.Replace(text)
+	}
+}
@@ -0,0 +1,337 @@
+package bot_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/slacktest"
+)
+
+func newSlackTestBot(t *testing.T, opts ...bot.SlackOption) (*slacktest.Server, *slacktest.Client) {
+	t.Helper()
+	server := slacktest.NewServer()
+	t.Cleanup(server.Close)
+	sm := newTestSessionManager(t)
+	slackBot, err := bot.NewSlackBot("xoxb-test", "secret", sm, nil, append(opts, bot.WithSlackAPIURL(server.APIURL()))...)
+	if err != nil {
+		t.Fatalf("slack bot: %v", err)
+	}
+	slackBot.RegisterBotxHandler(&testHandler{})
+	return server, &slacktest.Client{Handler: slackBot, SigningSecret: "secret"}
+}
+
+func TestSlackBotStepByStepForm(t *testing.T) {
+	server, client := newSlackTestBot(t)
+
+	if err := client.SendText("C1", "a &amp; b"); err != nil {
+		t.Fatalf("send text: %v", err)
+	}
+	msg, ok := server.LastMessage("C1")
+	if !ok || msg.Text != "*Hello* a &amp; b" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	if len(msg.Buttons) != 1 || msg.Buttons[0][0].Value != "_route:/add" {
+		t.Fatalf("unexpected buttons: %+v", msg.Buttons)
+	}
+
+	if err := client.Click("C1", msg.Buttons[0][0].Value); err != nil {
+		t.Fatalf("click: %v", err)
+	}
+	if msg, _ := server.LastMessage("C1"); msg.Text != "Enter a title" {
+		t.Fatalf("expected form prompt, got %+v", msg)
+	}
+	if err := client.SendText("C1", " "); err != nil {
+		t.Fatalf("send blank title: %v", err)
+	}
+	if msg, _ := server.LastMessage("C1"); msg.Text != "title is required" {
+		t.Fatalf("expected validation error, got %+v", msg)
+	}
+	if err := client.SendText("C1", "milk"); err != nil {
+		t.Fatalf("send title: %v", err)
+	}
+	if msg, _ := server.LastMessage("C1"); msg.Text != "added milk" {
+		t.Fatalf("expected submit result, got %+v", msg)
+	}
+}
+
+func TestSlackBotModalForm(t *testing.T) {
+	server, client := newSlackTestBot(t, bot.WithSlackModalForms())
+
+	if err := client.Command("C2", "/start", ""); err != nil {
+		t.Fatalf("command: %v", err)
+	}
+	if err := client.Click("C2", bot.RouteCallbackData("/add")); err != nil {
+		t.Fatalf("click: %v", err)
+	}
+	view, ok := server.LastView()
+	if !ok || len(view.BlockIDs) != 1 || view.BlockIDs[0] != "title" {
+		t.Fatalf("expected modal with title input, got %+v", view)
+	}
+
+	resp, err := client.SubmitView(view, map[string]string{"title": ""})
+	if err != nil {
+		t.Fatalf("submit invalid view: %v", err)
+	}
+	if resp["response_action"] != "errors" {
+		t.Fatalf("expected validation errors, got %+v", resp)
+	}
+	if _, err := client.SubmitView(view, map[string]string{"title": "eggs"}); err != nil {
+		t.Fatalf("submit view: %v", err)
+	}
+	if msg, _ := server.LastMessage("C2"); msg.Text != "added eggs" {
+		t.Fatalf("expected submit result, got %+v", msg)
+	}
+}
+
+func TestSlackBotModalFormReview(t *testing.T) {
+	server := slacktest.NewServer()
+	t.Cleanup(server.Close)
+	slackBot, err := bot.NewSlackBot("xoxb-test", "secret", newTestSessionManager(t), nil,
+		bot.WithSlackAPIURL(server.APIURL()), bot.WithSlackModalForms())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slackBot.RegisterBotxHandler(&reviewTestHandler{})
+	client := &slacktest.Client{Handler: slackBot, SigningSecret: "secret"}
+
+	if err := client.Click("C11", bot.RouteCallbackData("/add")); err != nil {
+		t.Fatal(err)
+	}
+	view, _ := server.LastView()
+	if _, err := client.SubmitView(view, map[string]string{"title": "milk", "note": "2l"}); err != nil {
+		t.Fatal(err)
+	}
+	review, _ := server.LastMessage("C11")
+	if len(review.Buttons) != 3 || review.Buttons[1][0].Text != "Edit Note" || review.Buttons[2][0].Text != "Save" {
+		t.Fatalf("expected the review, got %+v", review)
+	}
+
+	// editing reopens the modal, and submitting it returns to the review
+	if err := client.Click("C11", review.Buttons[1][0].Value); err != nil {
+		t.Fatal(err)
+	}
+	if edit, _ := server.LastView(); edit.TriggerID == view.TriggerID {
+		t.Fatalf("expected the modal to open again, got %+v", edit)
+	}
+	view, _ = server.LastView()
+	if _, err := client.SubmitView(view, map[string]string{"title": "milk", "note": "1l"}); err != nil {
+		t.Fatal(err)
+	}
+	review, _ = server.LastMessage("C11")
+	if err := client.Click("C11", review.Buttons[2][0].Value); err != nil {
+		t.Fatal(err)
+	}
+	if msg, _ := server.LastMessage("C11"); msg.Text != "added milk 1l" {
+		t.Fatalf("expected the reviewed form to be submitted, got %+v", msg)
+	}
+}
+
+func TestSlackBotConditionalFormAsksStepByStep(t *testing.T) {
+	server := slacktest.NewServer()
+	t.Cleanup(server.Close)
+	slackBot, err := bot.NewSlackBot("xoxb-test", "secret", newTestSessionManager(t), nil,
+		bot.WithSlackAPIURL(server.APIURL()), bot.WithSlackModalForms())
+	if err != nil {
+		t.Fatal(err)
+	}
+	slackBot.RegisterBotxHandler(&deliveryTestHandler{})
+	client := &slacktest.Client{Handler: slackBot, SigningSecret: "secret"}
+
+	if err := client.Click("C12", bot.RouteCallbackData("/add")); err != nil {
+		t.Fatal(err)
+	}
+	if view, ok := server.LastView(); ok {
+		t.Fatalf("expected no modal for a form with conditions, got %+v", view)
+	}
+	for _, step := range []struct{ text, prompt string }{
+		{"", "Deliver?"},
+		{"yes", "Where to?"},
+		{"Main St", "Any note?"},
+	} {
+		if step.text != "" {
+			if err := client.SendText("C12", step.text); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if msg, _ := server.LastMessage("C12"); msg.Text != step.prompt {
+			t.Fatalf("expected %q, got %+v", step.prompt, msg)
+		}
+	}
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	server, client := newSlackTestBot(t)
+	client.SigningSecret = "wrong"
+	if err := client.SendText("C3", "hi"); err == nil {
+		t.Fatalf("expected request with a bad signature to be rejected")
+	}
+	if len(server.Calls()) != 0 {
+		t.Fatalf("expected no api calls, got %+v", server.Calls())
+	}
+}
+
+func newBlockingSlackTestBot(t *testing.T) (*bot.SlackBot, *blockingTestHandler, *slacktest.Server) {
+	t.Helper()
+	server := slacktest.NewServer()
+	t.Cleanup(server.Close)
+	slackBot, err := bot.NewSlackBot("xoxb-test", "secret", newTestSessionManager(t), nil, bot.WithSlackAPIURL(server.APIURL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &blockingTestHandler{started: make(chan string, 10), release: make(chan struct{})}
+	slackBot.RegisterBotxHandler(handler)
+	return slackBot, handler, server
+}
+
+// sendSlackEvent delivers a message event without waiting for the bot to handle it.
+func sendSlackEvent(t *testing.T, slackBot *bot.SlackBot, eventID string, channel string, text string) {
+	t.Helper()
+	body := []byte(fmt.Sprintf(`{"type":"event_callback","event_id":%q,"event":{"type":"message","channel":%q,"text":%q}}`, eventID, channel, text))
+	req := httptest.NewRequest(http.MethodPost, "/slack", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", bot.SlackSignature("secret", timestamp, body))
+	rec := httptest.NewRecorder()
+	slackBot.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the event to be acknowledged, got %d", rec.Code)
+	}
+}
+
+func TestSlackBotAcknowledgesBeforeHandling(t *testing.T) {
+	slackBot, handler, server := newBlockingSlackTestBot(t)
+	sendSlackEvent(t, slackBot, "Ev1", "C4", "hi")
+	if len(server.Calls()) != 0 {
+		t.Fatalf("expected the handler to run after the acknowledgement, got %+v", server.Calls())
+	}
+	close(handler.release)
+	slackBot.Wait()
+	if msg, _ := server.LastMessage("C4"); msg.Text != "*Hello* hi" {
+		t.Fatalf("expected the event to be handled, got %+v", msg)
+	}
+}
+
+func TestSlackBotHandlesChatUpdatesInOrder(t *testing.T) {
+	slackBot, handler, server := newBlockingSlackTestBot(t)
+	sendSlackEvent(t, slackBot, "Ev1", "C8", "one")
+	sendSlackEvent(t, slackBot, "Ev2", "C8", "two")
+	sendSlackEvent(t, slackBot, "Ev3", "C9", "other")
+
+	started := map[string]bool{}
+	for range 2 {
+		select {
+		case text := <-handler.started:
+			started[text] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for handlers, started %v", started)
+		}
+	}
+	// the second update of C8 waits for the first, another channel does not
+	if !started["one"] || !started["other"] {
+		t.Fatalf("expected the first update of each channel to run, started %v", started)
+	}
+	select {
+	case text := <-handler.started:
+		t.Fatalf("expected %q to wait for the update before it", text)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(handler.release)
+	slackBot.Wait()
+	messages := server.Messages("C8")
+	if len(messages) != 2 || messages[0].Text != "*Hello* one" || messages[1].Text != "*Hello* two" {
+		t.Fatalf("expected the updates in order, got %+v", messages)
+	}
+}
+
+func TestSlackBotHandlesRedeliveredEventOnce(t *testing.T) {
+	server, client := newSlackTestBot(t)
+	if err := client.SendText("C5", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RedeliverText(); err != nil {
+		t.Fatal(err)
+	}
+	if messages := server.Messages("C5"); len(messages) != 1 {
+		t.Fatalf("expected one reply, got %+v", messages)
+	}
+
+	// a new event is still handled
+	if err := client.SendText("C5", "again"); err != nil {
+		t.Fatal(err)
+	}
+	if messages := server.Messages("C5"); len(messages) != 2 {
+		t.Fatalf("expected a reply to the new event, got %+v", messages)
+	}
+}
+
+func TestSlackBotChannelSurvivesRestart(t *testing.T) {
+	server := slacktest.NewServer()
+	t.Cleanup(server.Close)
+	sm := newTestSessionManager(t)
+	newBot := func() *bot.SlackBot {
+		slackBot, err := bot.NewSlackBot("xoxb-test", "secret", sm, nil, bot.WithSlackAPIURL(server.APIURL()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		slackBot.RegisterBotxHandler(&testHandler{})
+		return slackBot
+	}
+	client := &slacktest.Client{Handler: newBot(), SigningSecret: "secret"}
+	if err := client.SendText("C6", "hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newBot()
+	if err := restarted.SendMessage(context.Background(), bot.SlackChatID("C6"), &bot.Message{Text: "reminder"}); err != nil {
+		t.Fatalf("expected the channel to be read from the session, got %v", err)
+	}
+	if msg, _ := server.LastMessage("C6"); msg.Text != "reminder" {
+		t.Fatalf("got %+v", msg)
+	}
+	if err := restarted.SendMessage(context.Background(), bot.SlackChatID("C7"), &bot.Message{Text: "hi"}); !errors.Is(err, bot.ErrNotFound) {
+		t.Fatalf("expected an unknown channel to be not found, got %v", err)
+	}
+}
+
+// wideRowHandler answers with a row of 30 buttons.
+type wideRowHandler struct {
+	testHandler
+}
+
+func (h *wideRowHandler) HandleTextMessage(ctx context.Context, _ string, chatID int64, b bot.BotConnector) error {
+	row := make([]bot.Button, 30)
+	for i := range row {
+		row[i] = bot.Button{Label: strconv.Itoa(i), CallbackData: bot.RouteCallbackData("/todo/" + strconv.Itoa(i))}
+	}
+	return b.SendMessage(ctx, chatID, &bot.Message{Text: "pick", ButtonGrid: [][]bot.Button{row}})
+}
+
+func TestSlackBotSplitsLongButtonRows(t *testing.T) {
+	server := slacktest.NewServer()
+	t.Cleanup(server.Close)
+	slackBot, err := bot.NewSlackBot("xoxb-test", "secret", newTestSessionManager(t), nil, bot.WithSlackAPIURL(server.APIURL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slackBot.RegisterBotxHandler(&wideRowHandler{})
+	client := &slacktest.Client{Handler: slackBot, SigningSecret: "secret"}
+	if err := client.SendText("C10", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ := server.LastMessage("C10")
+	if len(msg.Buttons) != 2 || len(msg.Buttons[0]) != 25 || len(msg.Buttons[1]) != 5 {
+		t.Fatalf("expected the row to continue in a second block, got %+v", msg.Buttons)
+	}
+	if msg.Buttons[1][4].Value != bot.RouteCallbackData("/todo/29") {
+		t.Fatalf("expected the last button to be kept, got %+v", msg.Buttons[1][4])
+	}
+}
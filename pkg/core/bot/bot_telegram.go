@@ -2,7 +2,10 @@ package bot
 
 import (
 	"context"
-	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/anclax/botx/pkg/core/session"
 	tgbot "github.com/go-telegram/bot"
@@ -15,26 +18,142 @@ const (
 	TgSessionKeyInputState = "__tg_input_state"
 )
 
+// telegramPollTimeout matches the default poll timeout of go-telegram/bot.
+const telegramPollTimeout = time.Minute
+
 type TelegramBot struct {
 	tgbot *tgbot.Bot
 	log   *zap.Logger
 
 	handler BotxHandler
 
-	sm session.SessionManager
+	sm    session.SessionManager
+	forms *FormEngine
+
+	client    tgbot.HttpClient
+	recorder  *telegramRecorder
+	tgOptions []tgbot.Option
 }
 
+type TelegramOption func(*TelegramBot)
+
+// WithTelegramHTTPClient sets the client used for Bot API calls, including long polling.
+func WithTelegramHTTPClient(client tgbot.HttpClient) TelegramOption {
+	return func(b *TelegramBot) {
+		b.client = client
+	}
+}
+
+// WithTelegramRecorder writes every incoming update and every Bot API call made while handling it to w
+// as JSON lines, with the bot token replaced by `***`. Feed the recording to ReplayTelegram to reproduce
+// a session.
+func WithTelegramRecorder(w io.Writer) TelegramOption {
+	return func(b *TelegramBot) {
+		b.recorder = &telegramRecorder{w: w}
+	}
+}
+
+// WithTelegramAPIURL points the connector at a different Bot API server, e.g. a telegramtest server or a
+// self-hosted Bot API server.
+func WithTelegramAPIURL(apiURL string) TelegramOption {
+	return func(b *TelegramBot) {
+		b.tgOptions = append(b.tgOptions, tgbot.WithServerURL(strings.TrimSuffix(apiURL, "/")))
+	}
+}
+
+// Start registers the command menus and starts polling for updates. It blocks until ctx is done.
 func (b *TelegramBot) Start(ctx context.Context) {
+	if err := b.RegisterCommands(ctx); err != nil {
+		b.log.Error("failed to register telegram commands", zap.Error(err))
+	}
 	b.tgbot.Start(ctx)
 }
 
-func NewTelegramBot(token string, sm session.SessionManager, log *zap.Logger) (BotConnector, error) {
+// RegisterCommands calls setMyCommands for every menu of the registered handler. It is a no-op if the
+// handler does not implement CommandMenuProvider. Telegram only accepts two-letter language codes, so
+// `zh-hans` is registered as `zh`; the first menu wins when two languages share a code.
+func (b *TelegramBot) RegisterCommands(ctx context.Context) error {
+	provider, ok := b.handler.(CommandMenuProvider)
+	if !ok {
+		return nil
+	}
+	registered := make(map[string]struct{})
+	for _, menu := range provider.CommandMenus(ctx) {
+		scope, err := toTgCommandScope(menu.Scope)
+		if err != nil {
+			return err
+		}
+		language := strings.ToLower(menu.Language)
+		if idx := strings.IndexAny(language, "-_"); idx != -1 {
+			language = language[:idx]
+		}
+		key := menu.Scope + ":" + language
+		if _, ok := registered[key]; ok {
+			continue
+		}
+		registered[key] = struct{}{}
+
+		commands := make([]models.BotCommand, 0, len(menu.Commands))
+		for _, command := range menu.Commands {
+			commands = append(commands, models.BotCommand{
+				Command:     strings.TrimPrefix(command.Command, "/"),
+				Description: command.Description,
+			})
+		}
+		if _, err := b.tgbot.SetMyCommands(ctx, &tgbot.SetMyCommandsParams{
+			Commands:     commands,
+			Scope:        scope,
+			LanguageCode: language,
+		}); err != nil {
+			return errors.Wrapf(err, "failed to set commands for scope %s and language %q", menu.Scope, language)
+		}
+	}
+	return nil
+}
+
+func toTgCommandScope(scope string) (models.BotCommandScope, error) {
+	switch scope {
+	case CommandScopeDefault, "":
+		return &models.BotCommandScopeDefault{}, nil
+	case CommandScopePrivate:
+		return &models.BotCommandScopeAllPrivateChats{}, nil
+	case CommandScopeGroup:
+		return &models.BotCommandScopeAllGroupChats{}, nil
+	case CommandScopeAdmins:
+		return &models.BotCommandScopeAllChatAdministrators{}, nil
+	default:
+		return nil, errors.Errorf("unknown command scope: %s", scope)
+	}
+}
+
+func NewTelegramBot(token string, sm session.SessionManager, log *zap.Logger, opts ...TelegramOption) (BotConnector, error) {
+	if log == nil {
+		log = zap.NewNop()
+	}
 	t := &TelegramBot{
 		sm:  sm,
 		log: log,
 	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.forms = NewFormEngine(t, sm, TgSessionKeyInputState, func() BotxHandler { return t.handler })
 
-	tgbot, err := tgbot.New(token, tgbot.WithDefaultHandler(t.defaultHandler))
+	tgOptions := []tgbot.Option{tgbot.WithDefaultHandler(t.defaultHandler)}
+	client := t.client
+	if t.recorder != nil {
+		if client == nil {
+			client = &http.Client{Timeout: telegramPollTimeout}
+		}
+		t.recorder.token = token
+		client = &telegramRecordingClient{next: client, recorder: t.recorder}
+	}
+	if client != nil {
+		tgOptions = append(tgOptions, tgbot.WithHTTPClient(telegramPollTimeout, client))
+	}
+	tgOptions = append(tgOptions, t.tgOptions...)
+
+	tgbot, err := tgbot.New(token, tgOptions...)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create telegram bot")
 	}
@@ -50,6 +169,14 @@ func toTgMessage(chatID int64, message *Message) *tgbot.SendMessageParams {
 		ParseMode: models.ParseMode(message.ParseMode),
 	}
 
+	if keyboard := toTgKeyboard(message); keyboard != nil {
+		tgMessage.ReplyMarkup = keyboard
+	}
+
+	return tgMessage
+}
+
+func toTgKeyboard(message *Message) *models.InlineKeyboardMarkup {
 	var inlineKeyboard [][]models.InlineKeyboardButton
 	for _, btns := range message.ButtonGrid {
 		var row []models.InlineKeyboardButton
@@ -62,16 +189,33 @@ func toTgMessage(chatID int64, message *Message) *tgbot.SendMessageParams {
 		inlineKeyboard = append(inlineKeyboard, row)
 	}
 
-	if len(inlineKeyboard) > 0 {
-		tgMessage.ReplyMarkup = &models.InlineKeyboardMarkup{
-			InlineKeyboard: inlineKeyboard,
-		}
+	if len(inlineKeyboard) == 0 {
+		return nil
 	}
+	return &models.InlineKeyboardMarkup{InlineKeyboard: inlineKeyboard}
+}
 
-	return tgMessage
+type telegramCallbackMessageKey struct{}
+
+// telegramCallbackMessageID returns the ID of the message whose button sent the callback query being
+// handled, or 0.
+func telegramCallbackMessageID(ctx context.Context) int {
+	id, _ := ctx.Value(telegramCallbackMessageKey{}).(int)
+	return id
+}
+
+// HandleUpdate handles an update synchronously, e.g. one received by a webhook.
+func (b *TelegramBot) HandleUpdate(ctx context.Context, update *models.Update) {
+	b.defaultHandler(ctx, b.tgbot, update)
 }
 
 func (b *TelegramBot) defaultHandler(ctx context.Context, tgbot *tgbot.Bot, update *models.Update) {
+	if b.recorder != nil {
+		if err := b.recorder.record(&TelegramRecord{Kind: TelegramRecordUpdate, UpdateID: update.ID, Update: update}); err != nil {
+			b.log.Error("failed to record telegram update", zap.Error(err))
+		}
+	}
+	ctx = withTelegramUpdateID(ctx, update.ID)
 	ctx = updateLanguage(ctx, update)
 	chatID, err := fetchChatID(update)
 	if err != nil {
@@ -86,14 +230,12 @@ func (b *TelegramBot) defaultHandler(ctx context.Context, tgbot *tgbot.Bot, upda
 }
 
 func (b *TelegramBot) _defaultHandler(ctx context.Context, chatID int64, _ *tgbot.Bot, update *models.Update) error {
-	sess, err := b.sm.Get(ctx, chatID)
-	if err != nil {
-		return errors.Wrap(err, "failed to get session")
-	}
-
 	// handle callback query
 	if update.CallbackQuery != nil {
 		data := update.CallbackQuery.Data
+		if msg := update.CallbackQuery.Message.Message; msg != nil {
+			ctx = context.WithValue(ctx, telegramCallbackMessageKey{}, msg.ID)
+		}
 		return b.SendCallbackData(ctx, chatID, data)
 	}
 
@@ -104,32 +246,12 @@ func (b *TelegramBot) _defaultHandler(ctx context.Context, chatID int64, _ *tgbo
 	text := update.Message.Text
 
 	// check if we are in the middle of a form
-	val, err := sess.Get(ctx, TgSessionKeyInputState)
-	if err == nil {
-		form, ok := val.(*Form)
-		if !ok {
-			return errors.Errorf("invalid input state type: %T", val)
-		}
-		for {
-			validation, err := b.handleInProgressForm(ctx, chatID, sess, text, form)
-			if err != nil {
-				return errors.Wrap(err, "failed to handle form input")
-			}
-			if validation.Valid {
-				break
-			}
-			if err := b.SendMessage(ctx, chatID, &Message{
-				Text:       validation.ErrorMessage,
-				ParseMode:  string(models.ParseModeHTML),
-				ButtonGrid: [][]Button{},
-			}); err != nil {
-				return errors.Wrap(err, "failed to send validation error message")
-			}
-		}
-		return nil
+	result, err := b.forms.HandleText(ctx, chatID, text)
+	if err != nil {
+		return errors.Wrap(err, "failed to handle form input")
 	}
-	if !errors.Is(err, session.ErrKeyNotFound) {
-		return errors.Wrap(err, "failed to get input state from session")
+	if result != nil {
+		return nil
 	}
 
 	// normal text message
@@ -149,6 +271,28 @@ func (b *TelegramBot) SendMessage(ctx context.Context, chatID int64, message *Me
 	return err
 }
 
+// EditMessage edits the message whose button sent the callback query being handled.
+func (b *TelegramBot) EditMessage(ctx context.Context, chatID int64, message *Message) (bool, error) {
+	messageID := telegramCallbackMessageID(ctx)
+	if messageID == 0 {
+		return false, nil
+	}
+	params := &tgbot.EditMessageTextParams{
+		ChatID:    chatID,
+		MessageID: messageID,
+		Text:      message.Text,
+		ParseMode: models.ParseMode(message.ParseMode),
+	}
+	// without reply_markup the buttons are removed
+	if keyboard := toTgKeyboard(message); keyboard != nil {
+		params.ReplyMarkup = keyboard
+	}
+	if _, err := b.tgbot.EditMessageText(ctx, params); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (b *TelegramBot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
 	if b.handler == nil {
 		return errors.New("botx handler is not registered")
@@ -156,6 +300,9 @@ func (b *TelegramBot) SendCallbackData(ctx context.Context, chatID int64, data s
 	if data == "" {
 		return errors.New("callback data is required")
 	}
+	if ok, err := b.forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
 	if err := b.handler.HandleCallbackData(ctx, data, chatID, b); err != nil {
 		return errors.Wrap(err, "failed to handle callback data")
 	}
@@ -163,97 +310,9 @@ func (b *TelegramBot) SendCallbackData(ctx context.Context, chatID int64, data s
 }
 
 // SendForm sends a form piece by piece as Telegram does not support forms natively. It sends the first
-// field and set the state to expect the next text message to be the input for that field. More can be
-// found in handleInProgressForm.
+// field and the FormEngine expects the next text message to be the input for that field.
 func (b *TelegramBot) SendForm(ctx context.Context, chatID int64, form *Form) error {
-	if len(form.Fields) == 0 {
-		return errors.New("form has no fields")
-	}
-	if err := b.sendFormField(ctx, chatID, &form.Fields[0]); err != nil {
-		return errors.Wrap(err, "failed to send first form field")
-	}
-	sess, err := b.sm.Get(ctx, chatID)
-	if err != nil {
-		return errors.Wrap(err, "failed to get session")
-	}
-	if err := sess.Set(ctx, TgSessionKeyInputState, form); err != nil {
-		return errors.Wrap(err, "failed to set input state in session")
-	}
-	return nil
-}
-
-func (b *TelegramBot) sendFormField(ctx context.Context, chatID int64, field *FormField) error {
-	if field.Input != nil {
-		input := field.Input
-		msg := &Message{
-			Text:      input.Tip,
-			ParseMode: string(models.ParseModeHTML),
-		}
-		if err := b.SendMessage(ctx, chatID, msg); err != nil {
-			return errors.Wrap(err, "failed to send form field prompt")
-		}
-		return nil
-	} else {
-		return errors.Errorf("field has no type, should have `input`: %+v", field)
-	}
-}
-
-// handleInProgressForm processes the input for the current field in the form. If the input is valid,
-// it moves to the next field or submits the form if all fields are filled.
-func (b *TelegramBot) handleInProgressForm(ctx context.Context, chatID int64, sess session.Session, text string, form *Form) (*ValidateResult, error) {
-	field := form.Fields[form.Idx]
-	if field.Validator != nil {
-		result, err := b.handler.Validate(ctx, chatID, form.URL, *field.Validator, text)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to validate form input")
-		}
-		if !result.Valid {
-			return result, nil
-		}
-	}
-	if field.Input != nil {
-		field.Input.Value = text
-	}
-	form.Fields[form.Idx] = field
-	form.Idx++
-	if form.Idx == len(form.Fields) {
-		// form completed
-		if err := sess.Delete(ctx, TgSessionKeyInputState); err != nil {
-			return nil, errors.Wrap(err, "failed to clear input state from session")
-		}
-		raw, err := marshalFormValues(form)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to marshal form values to json")
-		}
-		query := form.URL.Query()
-		query.Add("values", string(raw))
-		form.URL.RawQuery = query.Encode()
-		// submit form
-		if err := b.SendCallbackData(ctx, chatID, SubmitForm(form.URL.String())); err != nil {
-			return nil, errors.Wrap(err, "failed to submit form data")
-		}
-	} else {
-		// save to session
-		if err := sess.Set(ctx, TgSessionKeyInputState, form); err != nil {
-			return nil, errors.Wrap(err, "failed to save input state to session")
-		}
-		// send next field
-		if err := b.sendFormField(ctx, chatID, &form.Fields[form.Idx]); err != nil {
-			return nil, errors.Wrap(err, "failed to send next form field")
-		}
-	}
-
-	return &ValidateResult{Valid: true}, nil
-}
-
-func marshalFormValues(form *Form) ([]byte, error) {
-	m := make(FormValues)
-	for _, field := range form.Fields {
-		if field.Input != nil {
-			m[field.ID] = field.Input.Value
-		}
-	}
-	return json.Marshal(m)
+	return b.forms.Start(ctx, chatID, form)
 }
 
 func updateLanguage(ctx context.Context, update *models.Update) context.Context {
@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"sync"
+
+	tgbot "github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
+)
+
+// kinds of TelegramRecord
+
+const (
+	TelegramRecordUpdate = "update"
+	TelegramRecordCall   = "call"
+)
+
+// TelegramRecord is one line of a Telegram recording: an incoming update, or an outgoing Bot API call
+// made while handling the update with UpdateID.
+type TelegramRecord struct {
+	Kind     string            `json:"kind"`
+	UpdateID int64             `json:"updateId,omitempty"`
+	Update   *models.Update    `json:"update,omitempty"`
+	Method   string            `json:"method,omitempty"`
+	Params   map[string]string `json:"params,omitempty"`
+	Response json.RawMessage   `json:"response,omitempty"`
+}
+
+type telegramUpdateIDKey struct{}
+
+func withTelegramUpdateID(ctx context.Context, updateID int64) context.Context {
+	return context.WithValue(ctx, telegramUpdateIDKey{}, updateID)
+}
+
+func telegramUpdateID(ctx context.Context) int64 {
+	id, _ := ctx.Value(telegramUpdateIDKey{}).(int64)
+	return id
+}
+
+// telegramRecorder writes records as JSON lines, replacing the bot token with `***`.
+type telegramRecorder struct {
+	mu    sync.Mutex
+	w     io.Writer
+	token string
+}
+
+func (r *telegramRecorder) record(rec *TelegramRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal telegram record")
+	}
+	if r.token != "" {
+		line = bytes.ReplaceAll(line, []byte(r.token), []byte("***"))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write telegram record")
+	}
+	return nil
+}
+
+// telegramRecordingClient records the Bot API calls going through it. Polling and the startup getMe
+// are not recorded.
+type telegramRecordingClient struct {
+	next     tgbot.HttpClient
+	recorder *telegramRecorder
+}
+
+func (c *telegramRecordingClient) Do(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+	if method == "getUpdates" || method == "getMe" {
+		return c.next.Do(req)
+	}
+	params, body, err := readTelegramParams(req)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	rec := &TelegramRecord{
+		Kind:     TelegramRecordCall,
+		UpdateID: telegramUpdateID(req.Context()),
+		Method:   method,
+		Params:   params,
+	}
+	resp, err := c.next.Do(req)
+	if err != nil {
+		_ = c.recorder.record(rec)
+		return nil, err
+	}
+	raw, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read telegram response")
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(raw))
+	if json.Valid(raw) {
+		rec.Response = raw
+	}
+	if err := c.recorder.record(rec); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// readTelegramParams decodes the multipart form of a Bot API request. Uploaded files are recorded by
+// name only. It returns the raw body so the request can be sent on.
+func readTelegramParams(req *http.Request) (map[string]string, []byte, error) {
+	if req.Body == nil {
+		return nil, nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read telegram request")
+	}
+	_, mediaParams, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaParams["boundary"] == "" || len(body) == 0 {
+		return nil, body, nil
+	}
+	params := make(map[string]string)
+	reader := multipart.NewReader(bytes.NewReader(body), mediaParams["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse telegram request")
+		}
+		if part.FileName() != "" {
+			params[part.FormName()] = "<file " + part.FileName() + ">"
+			continue
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to read telegram request field")
+		}
+		params[part.FormName()] = string(value)
+	}
+	if len(params) == 0 {
+		params = nil
+	}
+	return params, body, nil
+}
@@ -0,0 +1,254 @@
+package bot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/anclax/botx/pkg/core/session"
+	tgbot "github.com/go-telegram/bot"
+	"github.com/pkg/errors"
+)
+
+// TelegramCallDiff is a Bot API call that differs between a recording and its replay. Want is nil for
+// an extra call of the replay, Got is nil for a call the replay did not make.
+type TelegramCallDiff struct {
+	UpdateID int64
+	Index    int
+	Want     *TelegramRecord
+	Got      *TelegramRecord
+}
+
+func (d TelegramCallDiff) String() string {
+	return fmt.Sprintf("update %d, call %d:\n  want %s\n  got  %s", d.UpdateID, d.Index, formatTelegramCall(d.Want), formatTelegramCall(d.Got))
+}
+
+func formatTelegramCall(rec *TelegramRecord) string {
+	if rec == nil {
+		return "<none>"
+	}
+	keys := make([]string, 0, len(rec.Params))
+	for key := range rec.Params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		params = append(params, fmt.Sprintf("%s=%q", key, rec.Params[key]))
+	}
+	return rec.Method + "(" + strings.Join(params, ", ") + ")"
+}
+
+// TelegramReplayReport is the outcome of ReplayTelegram.
+type TelegramReplayReport struct {
+	Updates int
+	Calls   int
+	Diffs   []TelegramCallDiff
+}
+
+func (r *TelegramReplayReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "replayed %d updates, %d calls, %d differences", r.Updates, r.Calls, len(r.Diffs))
+	for _, diff := range r.Diffs {
+		sb.WriteString("\n")
+		sb.WriteString(diff.String())
+	}
+	return sb.String()
+}
+
+// ReadTelegramRecording reads a recording written with WithTelegramRecorder.
+func ReadTelegramRecording(r io.Reader) ([]TelegramRecord, error) {
+	var records []TelegramRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		var rec TelegramRecord
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return nil, errors.Wrapf(err, "invalid telegram record on line %d", line)
+		}
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read telegram recording")
+	}
+	return records, nil
+}
+
+// ReplayTelegram feeds the updates of a recording, in order, into a fresh TelegramBot with a memory
+// session manager and a fake Bot API, and diffs the calls made for each update against the recording.
+// register installs the handler under test, usually by calling the generated Register. The random nonces
+// of Confirm buttons are mapped from the recording to the replay, so confirm dialogs replay too.
+func ReplayTelegram(ctx context.Context, recording io.Reader, register func(connector BotConnector, sm session.SessionManager)) (*TelegramReplayReport, error) {
+	records, err := ReadTelegramRecording(recording)
+	if err != nil {
+		return nil, err
+	}
+	client := &telegramReplayClient{
+		want: make(map[int64][]TelegramRecord),
+		got:  make(map[int64][]TelegramRecord),
+	}
+	var updates []TelegramRecord
+	for _, rec := range records {
+		switch rec.Kind {
+		case TelegramRecordUpdate:
+			if rec.Update == nil {
+				return nil, errors.Errorf("telegram record of update %d has no update", rec.UpdateID)
+			}
+			updates = append(updates, rec)
+		case TelegramRecordCall:
+			if rec.UpdateID != 0 {
+				client.want[rec.UpdateID] = append(client.want[rec.UpdateID], rec)
+			}
+		}
+	}
+
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create session manager")
+	}
+	connector, err := NewTelegramBot("0:replay", sm, nil, WithTelegramHTTPClient(client), func(b *TelegramBot) {
+		b.tgOptions = append(b.tgOptions, tgbot.WithSkipGetMe())
+	})
+	if err != nil {
+		return nil, err
+	}
+	register(connector, sm)
+	telegramBot := connector.(*TelegramBot)
+
+	report := &TelegramReplayReport{Updates: len(updates)}
+	nonces := telegramConfirmNonces{}
+	for _, update := range updates {
+		if query := update.Update.CallbackQuery; query != nil {
+			query.Data = nonces.replace(query.Data)
+		}
+		telegramBot.HandleUpdate(ctx, update.Update)
+		want := client.want[update.UpdateID]
+		got := client.calls(update.UpdateID)
+		report.Calls += len(got)
+		for i := 0; i < len(want) || i < len(got); i++ {
+			diff := TelegramCallDiff{UpdateID: update.UpdateID, Index: i}
+			if i < len(want) {
+				diff.Want = &want[i]
+			}
+			if i < len(got) {
+				diff.Got = &got[i]
+			}
+			if diff.Want != nil && diff.Got != nil && diff.Want.Method == diff.Got.Method {
+				nonces.learn(diff.Want, diff.Got)
+				if reflect.DeepEqual(nonces.params(diff.Want.Params), diff.Got.Params) {
+					continue
+				}
+			}
+			report.Diffs = append(report.Diffs, diff)
+		}
+	}
+	return report, nil
+}
+
+// confirmNoncePattern matches the callback data of a Confirm button, whose nonce is random.
+var confirmNoncePattern = regexp.MustCompile(regexp.QuoteMeta(CallbackPrefixConfirm+":") + `([0-9a-f]{16})`)
+
+// telegramConfirmNonces maps the confirm nonces of a recording to the ones sent during its replay.
+type telegramConfirmNonces map[string]string
+
+// learn maps the nonces in the params of the recorded call want to the nonces in the same params of the
+// replayed call got.
+func (n telegramConfirmNonces) learn(want *TelegramRecord, got *TelegramRecord) {
+	for key, value := range want.Params {
+		recorded := confirmNoncePattern.FindAllStringSubmatch(value, -1)
+		replayed := confirmNoncePattern.FindAllStringSubmatch(got.Params[key], -1)
+		if len(recorded) != len(replayed) {
+			continue
+		}
+		for i := range recorded {
+			n[recorded[i][1]] = replayed[i][1]
+		}
+	}
+}
+
+// replace returns s with the recorded nonces in it replaced by the replayed ones.
+func (n telegramConfirmNonces) replace(s string) string {
+	return confirmNoncePattern.ReplaceAllStringFunc(s, func(match string) string {
+		if replayed, ok := n[strings.TrimPrefix(match, CallbackPrefixConfirm+":")]; ok {
+			return CallbackPrefixConfirm + ":" + replayed
+		}
+		return match
+	})
+}
+
+// params returns a copy of the recorded params with the nonces replaced.
+func (n telegramConfirmNonces) params(params map[string]string) map[string]string {
+	if params == nil {
+		return nil
+	}
+	replaced := make(map[string]string, len(params))
+	for key, value := range params {
+		replaced[key] = n.replace(value)
+	}
+	return replaced
+}
+
+// telegramReplayClient is a fake Bot API. It answers each call with the recorded response of the same
+// call, or with an empty result.
+type telegramReplayClient struct {
+	want map[int64][]TelegramRecord
+
+	mu  sync.Mutex
+	got map[int64][]TelegramRecord
+}
+
+func (c *telegramReplayClient) calls(updateID int64) []TelegramRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]TelegramRecord(nil), c.got[updateID]...)
+}
+
+func (c *telegramReplayClient) Do(req *http.Request) (*http.Response, error) {
+	method := path.Base(req.URL.Path)
+	params, _, err := readTelegramParams(req)
+	if err != nil {
+		return nil, err
+	}
+	updateID := telegramUpdateID(req.Context())
+
+	c.mu.Lock()
+	idx := len(c.got[updateID])
+	c.got[updateID] = append(c.got[updateID], TelegramRecord{Kind: TelegramRecordCall, UpdateID: updateID, Method: method, Params: params})
+	c.mu.Unlock()
+
+	body := []byte(`{"ok":true,"result":{}}`)
+	if want := c.want[updateID]; idx < len(want) && want[idx].Method == method && len(want[idx].Response) != 0 {
+		body = want[idx].Response
+	} else if telegramReturnsBool(method) {
+		body = []byte(`{"ok":true,"result":true}`)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func telegramReturnsBool(method string) bool {
+	for _, prefix := range []string{"answer", "set", "delete", "pin", "unpin", "ban", "unban", "restrict", "promote", "leave"} {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
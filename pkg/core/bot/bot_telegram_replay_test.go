@@ -0,0 +1,157 @@
+package bot_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/go-telegram/bot/models"
+)
+
+const testTelegramToken = "123:secret-token"
+
+// okClient answers every Bot API call with an empty result.
+type okClient struct{}
+
+func (okClient) Do(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`{"ok":true,"result":{"message_id":1}}`)),
+		Request:    req,
+	}, nil
+}
+
+// echoTokenHandler replies with the text it receives, which lets tests check the token redaction.
+type echoTokenHandler struct {
+	testHandler
+}
+
+func (h *echoTokenHandler) HandleTextMessage(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if data == "token" {
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: testTelegramToken})
+	}
+	return h.testHandler.HandleTextMessage(ctx, data, chatID, b)
+}
+
+func telegramTextUpdate(id int64, text string) *models.Update {
+	return &models.Update{ID: id, Message: &models.Message{Chat: models.Chat{ID: 7}, Date: 1, Text: text}}
+}
+
+func telegramCallbackUpdate(id int64, data string) *models.Update {
+	return &models.Update{ID: id, CallbackQuery: &models.CallbackQuery{
+		Data: data,
+		Message: models.MaybeInaccessibleMessage{
+			Type:    models.MaybeInaccessibleMessageTypeMessage,
+			Message: &models.Message{Chat: models.Chat{ID: 7}, Date: 1},
+		},
+	}}
+}
+
+func recordTelegramSession(t *testing.T) []byte {
+	t.Helper()
+	var recording bytes.Buffer
+	sm := newTestSessionManager(t)
+	connector, err := bot.NewTelegramBot(testTelegramToken, sm, nil, bot.WithTelegramHTTPClient(okClient{}), bot.WithTelegramRecorder(&recording))
+	if err != nil {
+		t.Fatalf("telegram bot: %v", err)
+	}
+	connector.RegisterBotxHandler(&echoTokenHandler{})
+	telegramBot := connector.(*bot.TelegramBot)
+
+	ctx := context.Background()
+	telegramBot.HandleUpdate(ctx, telegramTextUpdate(1, "hi"))
+	telegramBot.HandleUpdate(ctx, telegramCallbackUpdate(2, bot.RouteCallbackData("/add")))
+	telegramBot.HandleUpdate(ctx, telegramTextUpdate(3, "milk"))
+	telegramBot.HandleUpdate(ctx, telegramTextUpdate(4, "token"))
+	return recording.Bytes()
+}
+
+func TestTelegramRecording(t *testing.T) {
+	recording := recordTelegramSession(t)
+	if bytes.Contains(recording, []byte("secret-token")) {
+		t.Fatalf("expected the token to be redacted:\n%s", recording)
+	}
+	records, err := bot.ReadTelegramRecording(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatalf("read recording: %v", err)
+	}
+	var kinds []string
+	for _, rec := range records {
+		kinds = append(kinds, rec.Kind)
+	}
+	// getMe on startup is not recorded.
+	if strings.Join(kinds, ",") != "update,call,update,call,update,call,update,call" {
+		t.Fatalf("unexpected records: %v", kinds)
+	}
+	if records[5].Method != "sendMessage" || records[5].Params["text"] != "added milk" || records[5].UpdateID != 3 {
+		t.Fatalf("unexpected submit call: %+v", records[5])
+	}
+	if records[7].Params["text"] != "***" {
+		t.Fatalf("expected a redacted reply, got %+v", records[7])
+	}
+}
+
+func TestReplayTelegram(t *testing.T) {
+	recording := recordTelegramSession(t)
+
+	report, err := bot.ReplayTelegram(context.Background(), bytes.NewReader(recording), func(connector bot.BotConnector, _ session.SessionManager) {
+		connector.RegisterBotxHandler(&echoTokenHandler{})
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	// The replay runs with a different token, so only the redacted reply differs.
+	if report.Updates != 4 || report.Calls != 4 || len(report.Diffs) != 1 || report.Diffs[0].UpdateID != 4 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+
+	report, err = bot.ReplayTelegram(context.Background(), bytes.NewReader(recording), func(connector bot.BotConnector, _ session.SessionManager) {
+		connector.RegisterBotxHandler(&testHandler{})
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(report.Diffs) != 1 || report.Diffs[0].Got == nil || !strings.Contains(report.String(), `text="<b>Hello</b> token"`) {
+		t.Fatalf("expected the changed reply to be reported, got: %s", report)
+	}
+}
+
+func TestReplayTelegramConfirmDialog(t *testing.T) {
+	var recording bytes.Buffer
+	sm := newTestSessionManager(t)
+	connector, err := bot.NewTelegramBot(testTelegramToken, sm, nil, bot.WithTelegramHTTPClient(okClient{}), bot.WithTelegramRecorder(&recording))
+	if err != nil {
+		t.Fatalf("telegram bot: %v", err)
+	}
+	connector.RegisterBotxHandler(&confirmTestHandler{sm: sm})
+	telegramBot := connector.(*bot.TelegramBot)
+
+	ctx := context.Background()
+	telegramBot.HandleUpdate(ctx, telegramTextUpdate(1, "hi"))
+	sess, err := sm.Get(ctx, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, err := sess.Get(ctx, bot.SessionKeyConfirms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := bot.CallbackPrefixConfirm + ":" + pending.([]*bot.PendingConfirm)[0].Nonce
+	telegramBot.HandleUpdate(ctx, telegramCallbackUpdate(2, data))
+	telegramBot.HandleUpdate(ctx, telegramCallbackUpdate(3, data+":yes"))
+
+	report, err := bot.ReplayTelegram(ctx, bytes.NewReader(recording.Bytes()), func(connector bot.BotConnector, sm session.SessionManager) {
+		connector.RegisterBotxHandler(&confirmTestHandler{sm: sm})
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if report.Updates != 3 || len(report.Diffs) != 0 || !strings.Contains(recording.String(), "ran _route:/todo/1/delete") {
+		t.Fatalf("expected the confirm dialog to replay, got: %s", report)
+	}
+}
@@ -0,0 +1,134 @@
+package bot_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/telegramtest"
+)
+
+func newTelegramTestBot(t *testing.T, handler bot.BotxHandler) *telegramtest.Server {
+	t.Helper()
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+	sm := newTestSessionManager(t)
+	telegramBot, err := bot.NewTelegramBot("123:test", sm, nil, bot.WithTelegramAPIURL(server.APIURL()))
+	if err != nil {
+		t.Fatalf("telegram bot: %v", err)
+	}
+	telegramBot.RegisterBotxHandler(handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		telegramBot.(*bot.TelegramBot).Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return server
+}
+
+func TestTelegramBotPolling(t *testing.T) {
+	server := newTelegramTestBot(t, &testHandler{})
+
+	server.SendText(7, "hi")
+	messages, err := server.WaitMessages(7, 1, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages[0].Text != "<b>Hello</b> hi" || messages[0].ParseMode != "HTML" {
+		t.Fatalf("unexpected message: %+v", messages[0])
+	}
+	if len(messages[0].Buttons) != 1 || messages[0].Buttons[0][0].CallbackData != bot.RouteCallbackData("/add") {
+		t.Fatalf("unexpected buttons: %+v", messages[0].Buttons)
+	}
+
+	if err := server.Click(7, "Add"); err != nil {
+		t.Fatal(err)
+	}
+	if messages, err = server.WaitMessages(7, 2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if messages[1].Text != "Enter a title" {
+		t.Fatalf("expected the form prompt, got %+v", messages[1])
+	}
+
+	server.SendText(7, " ")
+	if messages, err = server.WaitMessages(7, 3, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if messages[2].Text != "title is required" {
+		t.Fatalf("expected a validation error, got %+v", messages[2])
+	}
+
+	server.SendText(7, "milk")
+	if messages, err = server.WaitMessages(7, 4, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if messages[3].Text != "added milk" {
+		t.Fatalf("expected the submit reply, got %+v", messages[3])
+	}
+	if other := server.Messages(8); len(other) != 0 {
+		t.Fatalf("expected no messages in another chat, got %+v", other)
+	}
+}
+
+func TestTelegramClickUnknownButton(t *testing.T) {
+	server := newTelegramTestBot(t, &testHandler{})
+	if err := server.Click(7, "Add"); err == nil {
+		t.Fatal("expected an error for a button that was never sent")
+	}
+}
+
+// menuTestHandler lists command menus for the scopes and languages below.
+type menuTestHandler struct {
+	testHandler
+	menus []bot.CommandMenu
+}
+
+func (h *menuTestHandler) CommandMenus(context.Context) []bot.CommandMenu {
+	return h.menus
+}
+
+func TestTelegramBotRegisterCommands(t *testing.T) {
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+	connector, err := bot.NewTelegramBot("123:test", newTestSessionManager(t), nil, bot.WithTelegramAPIURL(server.APIURL()))
+	if err != nil {
+		t.Fatalf("telegram bot: %v", err)
+	}
+	start := []bot.Command{{Command: "/start", Description: "Start"}}
+	connector.RegisterBotxHandler(&menuTestHandler{menus: []bot.CommandMenu{
+		{Scope: bot.CommandScopeDefault, Commands: start},
+		{Scope: bot.CommandScopePrivate, Language: "zh-hans", Commands: []bot.Command{{Command: "start", Description: "开始"}}},
+		{Scope: bot.CommandScopePrivate, Language: "zh-hant", Commands: []bot.Command{{Command: "start", Description: "開始"}}},
+		{Scope: bot.CommandScopeGroup, Language: "zh-hant", Commands: start},
+		{Scope: bot.CommandScopeAdmins, Language: "en", Commands: start},
+	}})
+	if err := connector.(*bot.TelegramBot).RegisterCommands(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, call := range server.Calls() {
+		if call.Method == "setMyCommands" {
+			got = append(got, call.Params["scope"]+" "+call.Params["language_code"]+" "+call.Params["commands"])
+		}
+	}
+	// the zh-hant menu of the private chats shares the code of zh-hans and is dropped
+	want := []string{
+		`{"type":"default"}  [{"command":"start","description":"Start"}]`,
+		`{"type":"all_private_chats"} zh [{"command":"start","description":"开始"}]`,
+		`{"type":"all_group_chats"} zh [{"command":"start","description":"Start"}]`,
+		`{"type":"all_chat_administrators"} en [{"command":"start","description":"Start"}]`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected setMyCommands calls:\n%s", strings.Join(got, "\n"))
+	}
+}
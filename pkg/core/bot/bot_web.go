@@ -0,0 +1,405 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	WebSessionKeyForm = "__web_form"
+
+	// web event types
+	WebEventMessage    = "message"
+	WebEventForm       = "form"
+	WebEventFormErrors = "formErrors"
+
+	defaultWebEventBuffer = 64
+	defaultWebKeepAlive   = 30 * time.Second
+)
+
+// WebAuthFunc resolves the chat of an HTTP request, e.g. from a session cookie or bearer token. Requests
+// for which it returns an error are rejected with 401.
+type WebAuthFunc func(r *http.Request) (int64, error)
+
+// WebUpdate is the body of `POST /updates`. Exactly one of Text, CallbackData or Form is set; Form submits
+// the values of the pending form keyed by field ID.
+type WebUpdate struct {
+	Text         string            `json:"text,omitempty"`
+	CallbackData string            `json:"callbackData,omitempty"`
+	Form         map[string]string `json:"form,omitempty"`
+}
+
+// WebEvent is a reply sent to web clients.
+type WebEvent struct {
+	Type    string            `json:"type"`
+	Message *WebMessage       `json:"message,omitempty"`
+	Form    *WebForm          `json:"form,omitempty"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+type WebMessage struct {
+	Text       string        `json:"text"`
+	ParseMode  string        `json:"parseMode,omitempty"`
+	ButtonGrid [][]WebButton `json:"buttonGrid,omitempty"`
+}
+
+type WebButton struct {
+	ID           string `json:"id,omitempty"`
+	Label        string `json:"label"`
+	CallbackData string `json:"callbackData"`
+}
+
+type WebForm struct {
+	URL    string         `json:"url"`
+	Fields []WebFormField `json:"fields"`
+}
+
+type WebFormField struct {
+	ID     string   `json:"id"`
+	Label  string   `json:"label,omitempty"`
+	Tip    string   `json:"tip,omitempty"`
+	Type   string   `json:"type,omitempty"`
+	Format string   `json:"format,omitempty"`
+	Enum   []string `json:"enum,omitempty"`
+	Value  string   `json:"value,omitempty"`
+	// Conditional fields have a `showIf` condition. Forms with one are sent one field at a time.
+	Conditional bool `json:"conditional,omitempty"`
+}
+
+// WebUpdateResponse is the body returned by `POST /updates`: the events sent while handling the update.
+type WebUpdateResponse struct {
+	Events []WebEvent `json:"events"`
+}
+
+// WebBot is a BotConnector for embedding bots in web apps. It serves:
+//
+//   - `POST /updates` to send a WebUpdate; the response carries the replies to it.
+//   - `GET /events` to stream every reply for the chat as server-sent events, including replies sent
+//     outside a request.
+//
+// Updates of one chat are handled one at a time; different chats are handled concurrently.
+type WebBot struct {
+	auth        WebAuthFunc
+	log         *zap.Logger
+	eventBuffer int
+	keepAlive   time.Duration
+	mux         *http.ServeMux
+
+	handler BotxHandler
+
+	sm    session.SessionManager
+	forms *FormEngine
+
+	mu          sync.Mutex
+	chatLocks   map[int64]*sync.Mutex
+	subscribers map[int64]map[chan WebEvent]struct{}
+}
+
+type WebOption func(*WebBot)
+
+// WithWebEventBuffer sets how many events are buffered per event stream before events are dropped for
+// a slow client.
+func WithWebEventBuffer(size int) WebOption {
+	return func(b *WebBot) {
+		b.eventBuffer = size
+	}
+}
+
+// WithWebKeepAlive sets the interval of keep-alive comments on event streams.
+func WithWebKeepAlive(interval time.Duration) WebOption {
+	return func(b *WebBot) {
+		b.keepAlive = interval
+	}
+}
+
+func NewWebBot(sm session.SessionManager, auth WebAuthFunc, log *zap.Logger, opts ...WebOption) (*WebBot, error) {
+	if sm == nil {
+		return nil, errors.New("session manager is required")
+	}
+	if auth == nil {
+		return nil, errors.New("web auth hook is required")
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+	b := &WebBot{
+		auth:        auth,
+		log:         log,
+		eventBuffer: defaultWebEventBuffer,
+		keepAlive:   defaultWebKeepAlive,
+		sm:          sm,
+		chatLocks:   make(map[int64]*sync.Mutex),
+		subscribers: make(map[int64]map[chan WebEvent]struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.mux = http.NewServeMux()
+	b.mux.HandleFunc("POST /updates", b.serveUpdate)
+	b.mux.HandleFunc("GET /events", b.serveEvents)
+	b.forms = NewFormEngine(b, sm, WebSessionKeyForm, func() BotxHandler { return b.handler }, WithFormPrompt(b.sendFormEvent))
+	return b, nil
+}
+
+func (b *WebBot) RegisterBotxHandler(handler BotxHandler) {
+	b.handler = handler
+}
+
+// ServeHTTP serves the web API. Mount it with http.StripPrefix when it does not live at the root.
+func (b *WebBot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mux.ServeHTTP(w, r)
+}
+
+type webCollector struct {
+	chatID int64
+	events []WebEvent
+}
+
+type webCollectorKey struct{}
+
+func (b *WebBot) SendMessage(ctx context.Context, chatID int64, message *Message) error {
+	event := WebEvent{
+		Type: WebEventMessage,
+		Message: &WebMessage{
+			Text:      message.Text,
+			ParseMode: message.ParseMode,
+		},
+	}
+	for _, row := range message.ButtonGrid {
+		buttons := make([]WebButton, 0, len(row))
+		for _, btn := range row {
+			buttons = append(buttons, WebButton{ID: btn.ID, Label: btn.Label, CallbackData: btn.CallbackData})
+		}
+		event.Message.ButtonGrid = append(event.Message.ButtonGrid, buttons)
+	}
+	b.publish(ctx, chatID, event)
+	return nil
+}
+
+func (b *WebBot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if data == "" {
+		return errors.New("callback data is required")
+	}
+	if ok, err := b.forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
+	if err := b.handler.HandleCallbackData(ctx, data, chatID, b); err != nil {
+		return errors.Wrap(err, "failed to handle callback data")
+	}
+	return nil
+}
+
+// SendForm sends the form to the client and keeps it in the session until it is submitted.
+func (b *WebBot) SendForm(ctx context.Context, chatID int64, form *Form) error {
+	return b.forms.Start(ctx, chatID, form)
+}
+
+// sendFormEvent sends every field of form with the values entered so far. A form with conditions is sent
+// one field at a time, as the conditions depend on the values before them.
+func (b *WebBot) sendFormEvent(ctx context.Context, chatID int64, form *Form) error {
+	event := WebEvent{Type: WebEventForm, Form: &WebForm{}}
+	if form.URL != nil {
+		event.Form.URL = form.URL.String()
+	}
+	fields := form.Fields
+	if form.HasConditions() {
+		fields = form.Fields[form.Idx : form.Idx+1]
+	}
+	for _, field := range fields {
+		webField := WebFormField{ID: field.ID, Label: field.Label, Conditional: field.Conditional}
+		if field.Input != nil {
+			webField.Tip = field.Input.Tip
+			webField.Value = field.Input.Value
+			if field.Input.Schema != nil {
+				webField.Type = field.Input.Schema.Type
+				webField.Format = field.Input.Schema.Format
+				webField.Enum = field.Input.Schema.Enum
+			}
+		}
+		event.Form.Fields = append(event.Form.Fields, webField)
+	}
+	b.publish(ctx, chatID, event)
+	return nil
+}
+
+// HandleUpdate handles an update for chatID and returns the events sent while handling it.
+func (b *WebBot) HandleUpdate(ctx context.Context, chatID int64, update *WebUpdate) ([]WebEvent, error) {
+	if update == nil {
+		return nil, errors.New("web update is required")
+	}
+	lock := b.chatLock(chatID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	collector := &webCollector{chatID: chatID}
+	ctx = context.WithValue(ctx, webCollectorKey{}, collector)
+	if err := b.handleUpdate(ctx, chatID, update); err != nil {
+		if b.handler == nil {
+			return nil, err
+		}
+		if handleErr := b.handler.HandleError(ctx, err, chatID, b); handleErr != nil {
+			return nil, handleErr
+		}
+	}
+	return collector.events, nil
+}
+
+func (b *WebBot) handleUpdate(ctx context.Context, chatID int64, update *WebUpdate) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	switch {
+	case update.Form != nil:
+		return b.handleFormSubmission(ctx, chatID, update.Form)
+	case update.CallbackData != "":
+		return b.SendCallbackData(ctx, chatID, update.CallbackData)
+	case update.Text != "":
+		if err := b.handler.HandleTextMessage(ctx, update.Text, chatID, b); err != nil {
+			return errors.Wrap(err, "failed to handle text message")
+		}
+		return nil
+	default:
+		return errors.Wrap(ErrEmptyMessage, "web update has no text, callback data or form")
+	}
+}
+
+func (b *WebBot) handleFormSubmission(ctx context.Context, chatID int64, values map[string]string) error {
+	validationErrors, err := b.forms.SubmitValues(ctx, chatID, values)
+	if err != nil {
+		return err
+	}
+	if len(validationErrors) != 0 {
+		b.publish(ctx, chatID, WebEvent{Type: WebEventFormErrors, Errors: validationErrors})
+	}
+	return nil
+}
+
+func (b *WebBot) chatLock(chatID int64) *sync.Mutex {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lock, ok := b.chatLocks[chatID]
+	if !ok {
+		lock = &sync.Mutex{}
+		b.chatLocks[chatID] = lock
+	}
+	return lock
+}
+
+// publish hands event to the request being handled for chatID, if any, and to every event stream of
+// the chat.
+func (b *WebBot) publish(ctx context.Context, chatID int64, event WebEvent) {
+	if collector, ok := ctx.Value(webCollectorKey{}).(*webCollector); ok && collector.chatID == chatID {
+		collector.events = append(collector.events, event)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers[chatID] {
+		select {
+		case ch <- event:
+		default:
+			b.log.Warn("dropped web event for slow client", zap.Int64("chatID", chatID), zap.String("type", event.Type))
+		}
+	}
+}
+
+func (b *WebBot) subscribe(chatID int64) chan WebEvent {
+	ch := make(chan WebEvent, b.eventBuffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers[chatID] == nil {
+		b.subscribers[chatID] = make(map[chan WebEvent]struct{})
+	}
+	b.subscribers[chatID][ch] = struct{}{}
+	return ch
+}
+
+func (b *WebBot) unsubscribe(chatID int64, ch chan WebEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers[chatID], ch)
+	if len(b.subscribers[chatID]) == 0 {
+		delete(b.subscribers, chatID)
+	}
+}
+
+func (b *WebBot) serveUpdate(w http.ResponseWriter, r *http.Request) {
+	chatID, err := b.auth(r)
+	if err != nil {
+		b.log.Debug("rejected web request", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var update WebUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+	events, err := b.HandleUpdate(r.Context(), chatID, &update)
+	if err != nil {
+		b.log.Error("failed to handle web update", zap.Error(err))
+		http.Error(w, "failed to handle update", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []WebEvent{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(WebUpdateResponse{Events: events})
+}
+
+func (b *WebBot) serveEvents(w http.ResponseWriter, r *http.Request) {
+	chatID, err := b.auth(r)
+	if err != nil {
+		b.log.Debug("rejected web request", zap.Error(err))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	ch := b.subscribe(chatID)
+	defer b.unsubscribe(chatID, ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(b.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case event := <-ch:
+			raw, err := json.Marshal(event)
+			if err != nil {
+				b.log.Error("failed to marshal web event", zap.Error(err))
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, raw); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
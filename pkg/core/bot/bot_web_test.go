@@ -0,0 +1,168 @@
+package bot_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/pkg/errors"
+)
+
+func newWebTestBot(t *testing.T) (*bot.WebBot, *httptest.Server) {
+	t.Helper()
+	sm := newTestSessionManager(t)
+	webBot, err := bot.NewWebBot(sm, func(r *http.Request) (int64, error) {
+		chatID, err := strconv.ParseInt(r.Header.Get("X-Chat-ID"), 10, 64)
+		if err != nil {
+			return 0, errors.New("missing chat id")
+		}
+		return chatID, nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("web bot: %v", err)
+	}
+	webBot.RegisterBotxHandler(&testHandler{})
+	server := httptest.NewServer(webBot)
+	t.Cleanup(server.Close)
+	return webBot, server
+}
+
+func postWebUpdate(t *testing.T, server *httptest.Server, chatID int64, update bot.WebUpdate) []bot.WebEvent {
+	t.Helper()
+	body, _ := json.Marshal(update)
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/updates", bytes.NewReader(body))
+	req.Header.Set("X-Chat-ID", strconv.FormatInt(chatID, 10))
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("post update: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	var result bot.WebUpdateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	return result.Events
+}
+
+func TestWebBotForm(t *testing.T) {
+	_, server := newWebTestBot(t)
+
+	events := postWebUpdate(t, server, 1, bot.WebUpdate{Text: "hi"})
+	if len(events) != 1 || events[0].Message == nil || events[0].Message.Text != "<b>Hello</b> hi" || events[0].Message.ParseMode != "HTML" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	callback := events[0].Message.ButtonGrid[0][0].CallbackData
+
+	events = postWebUpdate(t, server, 1, bot.WebUpdate{CallbackData: callback})
+	if len(events) != 1 || events[0].Type != bot.WebEventForm || events[0].Form.Fields[0].ID != "title" {
+		t.Fatalf("expected form event, got %+v", events)
+	}
+	events = postWebUpdate(t, server, 1, bot.WebUpdate{Form: map[string]string{"title": ""}})
+	if len(events) != 1 || events[0].Type != bot.WebEventFormErrors || events[0].Errors["title"] != "title is required" {
+		t.Fatalf("expected validation errors, got %+v", events)
+	}
+	events = postWebUpdate(t, server, 1, bot.WebUpdate{Form: map[string]string{"title": "milk"}})
+	if len(events) != 1 || events[0].Message == nil || events[0].Message.Text != "added milk" {
+		t.Fatalf("expected submit result, got %+v", events)
+	}
+}
+
+func TestWebBotConditionalFormSendsOneFieldAtATime(t *testing.T) {
+	webBot, server := newWebTestBot(t)
+	webBot.RegisterBotxHandler(&deliveryTestHandler{})
+
+	events := postWebUpdate(t, server, 2, bot.WebUpdate{CallbackData: bot.RouteCallbackData("/add")})
+	if len(events) != 1 || events[0].Form == nil || len(events[0].Form.Fields) != 1 || events[0].Form.Fields[0].ID != "delivery" {
+		t.Fatalf("expected the delivery field only, got %+v", events)
+	}
+	events = postWebUpdate(t, server, 2, bot.WebUpdate{Form: map[string]string{"delivery": "yes"}})
+	if len(events) != 1 || events[0].Form == nil || len(events[0].Form.Fields) != 1 {
+		t.Fatalf("expected the next field, got %+v", events)
+	}
+	if field := events[0].Form.Fields[0]; field.ID != "address" || !field.Conditional {
+		t.Fatalf("expected the conditional address field, got %+v", field)
+	}
+	events = postWebUpdate(t, server, 2, bot.WebUpdate{Form: map[string]string{"address": "Main St"}})
+	if len(events) != 1 || events[0].Form == nil || events[0].Form.Fields[0].ID != "note" {
+		t.Fatalf("expected the note field, got %+v", events)
+	}
+}
+
+func TestWebBotEventStream(t *testing.T) {
+	webBot, server := newWebTestBot(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("X-Chat-ID", "2")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	if err := webBot.SendMessage(context.Background(), 3, &bot.Message{Text: "other chat"}); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	if err := webBot.SendMessage(context.Background(), 2, &bot.Message{Text: "pushed"}); err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if lines[0] != "event: message" || !strings.Contains(lines[1], `"text":"pushed"`) {
+		t.Fatalf("unexpected stream: %q", lines)
+	}
+}
+
+func TestWebBotConcurrentClients(t *testing.T) {
+	_, server := newWebTestBot(t)
+
+	var wg sync.WaitGroup
+	for i := int64(1); i <= 20; i++ {
+		wg.Add(1)
+		go func(chatID int64) {
+			defer wg.Done()
+			events := postWebUpdate(t, server, chatID, bot.WebUpdate{Text: fmt.Sprint(chatID)})
+			if len(events) != 1 || events[0].Message.Text != fmt.Sprintf("<b>Hello</b> %d", chatID) {
+				t.Errorf("chat %d: unexpected events %+v", chatID, events)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWebBotRejectsUnauthenticated(t *testing.T) {
+	_, server := newWebTestBot(t)
+	resp, err := server.Client().Post(server.URL+"/updates", "application/json", strings.NewReader(`{"text":"hi"}`))
+	if err != nil {
+		t.Fatalf("post update: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+}
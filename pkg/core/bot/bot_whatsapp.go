@@ -0,0 +1,506 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	WhatsAppSessionKeyInputState  = "__wa_input_state"
+	WhatsAppSessionKeyLastInbound = "__wa_last_inbound"
+
+	DefaultWhatsAppAPIURL = "https://graph.facebook.com/v21.0/"
+
+	// WhatsAppSessionWindow is how long after the user's last message free-form messages may be sent.
+	// Outside the window only approved templates are delivered.
+	WhatsAppSessionWindow = 24 * time.Hour
+
+	whatsAppMaxReplyButtons = 3
+	whatsAppMaxListRows     = 10
+	whatsAppMaxButtonTitle  = 20
+	whatsAppMaxRowTitle     = 24
+	whatsAppMaxButtonID     = 256
+	whatsAppMaxRowID        = 200
+	whatsAppMaxBodyText     = 1024
+	whatsAppMaxText         = 4096
+)
+
+var ErrWhatsAppWindowClosed = errors.New("whatsapp 24-hour session window is closed")
+
+type WhatsAppConfig struct {
+	// AccessToken is the Cloud API access token.
+	AccessToken string
+	// AppSecret signs webhook deliveries (X-Hub-Signature-256).
+	AppSecret string
+	// VerifyToken is echoed back during the webhook subscription handshake.
+	VerifyToken string
+	// PhoneNumberID is the business phone number messages are sent from.
+	PhoneNumberID string
+	// ListButtonLabel labels the button that opens a list message. Defaults to "Options".
+	ListButtonLabel string
+}
+
+// WhatsAppTemplate is an approved message template sent instead of a free-form message when the 24-hour
+// session window is closed.
+type WhatsAppTemplate struct {
+	Name     string
+	Language string
+}
+
+// WhatsAppBot is a BotConnector backed by the WhatsApp Cloud API. Webhook deliveries are received by
+// ServeHTTP. Chat IDs are the users' WhatsApp IDs (phone numbers in international format).
+type WhatsAppBot struct {
+	config         WhatsAppConfig
+	apiURL         string
+	client         *http.Client
+	log            *zap.Logger
+	windowTemplate *WhatsAppTemplate
+	now            func() time.Time
+
+	handler BotxHandler
+
+	sm      session.SessionManager
+	forms   *FormEngine
+	updates chatQueue
+}
+
+type WhatsAppOption func(*WhatsAppBot)
+
+// WithWhatsAppAPIURL points the connector at a different Graph API base URL, e.g. a whatsapptest server.
+func WithWhatsAppAPIURL(apiURL string) WhatsAppOption {
+	return func(b *WhatsAppBot) {
+		if !strings.HasSuffix(apiURL, "/") {
+			apiURL += "/"
+		}
+		b.apiURL = apiURL
+	}
+}
+
+func WithWhatsAppHTTPClient(client *http.Client) WhatsAppOption {
+	return func(b *WhatsAppBot) {
+		b.client = client
+	}
+}
+
+// WithWhatsAppWindowTemplate sends the given template when a message is addressed to a user whose session
+// window is closed. Without it such messages fail with ErrWhatsAppWindowClosed.
+func WithWhatsAppWindowTemplate(template WhatsAppTemplate) WhatsAppOption {
+	return func(b *WhatsAppBot) {
+		b.windowTemplate = &template
+	}
+}
+
+// WithWhatsAppClock overrides the clock used for the session window, for tests.
+func WithWhatsAppClock(now func() time.Time) WhatsAppOption {
+	return func(b *WhatsAppBot) {
+		b.now = now
+	}
+}
+
+func NewWhatsAppBot(config WhatsAppConfig, sm session.SessionManager, log *zap.Logger, opts ...WhatsAppOption) (*WhatsAppBot, error) {
+	if config.AccessToken == "" {
+		return nil, errors.New("whatsapp access token is required")
+	}
+	if config.AppSecret == "" {
+		return nil, errors.New("whatsapp app secret is required")
+	}
+	if config.PhoneNumberID == "" {
+		return nil, errors.New("whatsapp phone number id is required")
+	}
+	if sm == nil {
+		return nil, errors.New("session manager is required")
+	}
+	if config.ListButtonLabel == "" {
+		config.ListButtonLabel = "Options"
+	}
+	if log == nil {
+		log = zap.NewNop()
+	}
+	b := &WhatsAppBot{
+		config: config,
+		apiURL: DefaultWhatsAppAPIURL,
+		client: http.DefaultClient,
+		log:    log,
+		now:    time.Now,
+		sm:     sm,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.forms = NewFormEngine(b, sm, WhatsAppSessionKeyInputState, func() BotxHandler { return b.handler }, WithFormClock(b.now))
+	return b, nil
+}
+
+func (b *WhatsAppBot) RegisterBotxHandler(handler BotxHandler) {
+	b.handler = handler
+}
+
+// SendMessage sends message as text, reply buttons (up to three buttons) or list messages (more than
+// three buttons, ten rows per message).
+func (b *WhatsAppBot) SendMessage(ctx context.Context, chatID int64, message *Message) error {
+	open, err := b.windowOpen(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if !open {
+		if b.windowTemplate == nil {
+			return errors.Wrapf(ErrWhatsAppWindowClosed, "cannot message chat %d", chatID)
+		}
+		b.log.Info("whatsapp session window closed, sending template instead", zap.Int64("chatID", chatID))
+		return b.send(ctx, chatID, map[string]any{
+			"type": "template",
+			"template": map[string]any{
+				"name":     b.windowTemplate.Name,
+				"language": map[string]any{"code": b.windowTemplate.Language},
+			},
+		})
+	}
+
+	text := toWhatsAppText(message.Text, message.ParseMode)
+	var buttons []Button
+	for _, row := range message.ButtonGrid {
+		buttons = append(buttons, row...)
+	}
+	for _, btn := range buttons {
+		limit := whatsAppMaxButtonID
+		if len(buttons) > whatsAppMaxReplyButtons {
+			limit = whatsAppMaxRowID
+		}
+		if len(btn.CallbackData) > limit {
+			return errors.Errorf("callback data of button %q exceeds %d bytes", btn.Label, limit)
+		}
+	}
+
+	switch {
+	case len(buttons) == 0:
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		for _, chunk := range chunkRunes(text, whatsAppMaxText) {
+			if err := b.send(ctx, chatID, map[string]any{
+				"type": "text",
+				"text": map[string]any{"body": chunk},
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case len(buttons) <= whatsAppMaxReplyButtons:
+		replies := make([]map[string]any, 0, len(buttons))
+		for _, btn := range buttons {
+			replies = append(replies, map[string]any{
+				"type": "reply",
+				"reply": map[string]any{
+					"id":    btn.CallbackData,
+					"title": truncateRunes(btn.Label, whatsAppMaxButtonTitle),
+				},
+			})
+		}
+		return b.send(ctx, chatID, map[string]any{
+			"type": "interactive",
+			"interactive": map[string]any{
+				"type":   "button",
+				"body":   map[string]any{"text": whatsAppBody(text)},
+				"action": map[string]any{"buttons": replies},
+			},
+		})
+	default:
+		pages := (len(buttons) + whatsAppMaxListRows - 1) / whatsAppMaxListRows
+		for page := 0; page < pages; page++ {
+			chunk := buttons[page*whatsAppMaxListRows : min((page+1)*whatsAppMaxListRows, len(buttons))]
+			rows := make([]map[string]any, 0, len(chunk))
+			for _, btn := range chunk {
+				rows = append(rows, map[string]any{
+					"id":    btn.CallbackData,
+					"title": truncateRunes(btn.Label, whatsAppMaxRowTitle),
+				})
+			}
+			body := text
+			if page != 0 {
+				body = fmt.Sprintf("%d/%d", page+1, pages)
+			}
+			if err := b.send(ctx, chatID, map[string]any{
+				"type": "interactive",
+				"interactive": map[string]any{
+					"type": "list",
+					"body": map[string]any{"text": whatsAppBody(body)},
+					"action": map[string]any{
+						"button":   truncateRunes(b.config.ListButtonLabel, whatsAppMaxButtonTitle),
+						"sections": []any{map[string]any{"rows": rows}},
+					},
+				},
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (b *WhatsAppBot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if data == "" {
+		return errors.New("callback data is required")
+	}
+	if ok, err := b.forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
+	if err := b.handler.HandleCallbackData(ctx, data, chatID, b); err != nil {
+		return errors.Wrap(err, "failed to handle callback data")
+	}
+	return nil
+}
+
+// SendForm asks for the fields one message at a time, as WhatsApp has no native forms.
+func (b *WhatsAppBot) SendForm(ctx context.Context, chatID int64, form *Form) error {
+	return b.forms.Start(ctx, chatID, form)
+}
+
+// ServeHTTP answers the webhook subscription handshake (GET) and receives signed message deliveries (POST).
+// Deliveries are acknowledged with 200 once the signature is verified, so Meta does not retry them, and
+// handled afterwards; use Wait to let pending messages finish.
+func (b *WhatsAppBot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		if query.Get("hub.mode") != "subscribe" || b.config.VerifyToken == "" ||
+			!hmac.Equal([]byte(query.Get("hub.verify_token")), []byte(b.config.VerifyToken)) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(query.Get("hub.challenge")))
+	case http.MethodPost:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := VerifyWhatsAppSignature(b.config.AppSecret, r.Header.Get("X-Hub-Signature-256"), body); err != nil {
+			b.log.Warn("rejected whatsapp webhook", zap.Error(err))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload whatsAppWebhook
+		if err := json.Unmarshal(body, &payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		ctx := context.WithoutCancel(r.Context())
+		for _, entry := range payload.Entry {
+			for _, change := range entry.Changes {
+				if change.Field != "messages" {
+					continue
+				}
+				for _, msg := range change.Value.Messages {
+					b.dispatch(ctx, msg)
+				}
+			}
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type whatsAppWebhook struct {
+	Entry []struct {
+		Changes []struct {
+			Field string `json:"field"`
+			Value struct {
+				Messages []whatsAppInbound `json:"messages"`
+			} `json:"value"`
+		} `json:"changes"`
+	} `json:"entry"`
+}
+
+type whatsAppInbound struct {
+	From string `json:"from"`
+	Type string `json:"type"`
+	Text struct {
+		Body string `json:"body"`
+	} `json:"text"`
+	Interactive struct {
+		Type        string `json:"type"`
+		ButtonReply struct {
+			ID string `json:"id"`
+		} `json:"button_reply"`
+		ListReply struct {
+			ID string `json:"id"`
+		} `json:"list_reply"`
+	} `json:"interactive"`
+	Button struct {
+		Payload string `json:"payload"`
+	} `json:"button"`
+}
+
+// dispatch queues msg after the messages of its chat received before it, which run one at a time.
+func (b *WhatsAppBot) dispatch(ctx context.Context, msg whatsAppInbound) {
+	chatID, err := strconv.ParseInt(msg.From, 10, 64)
+	if err != nil {
+		b.log.Warn("ignored whatsapp message from invalid wa_id", zap.String("from", msg.From))
+		return
+	}
+	b.updates.Go(chatID, func() {
+		if err := b.handleInbound(ctx, chatID, msg); err != nil {
+			if b.handler == nil {
+				b.log.Error("failed to handle whatsapp message", zap.Error(err))
+				return
+			}
+			if handleErr := b.handler.HandleError(ctx, err, chatID, b); handleErr != nil {
+				b.log.Error("failed to handle whatsapp error", zap.Error(err), zap.NamedError("handleError", handleErr))
+			}
+		}
+	})
+}
+
+// Wait blocks until the messages received so far are handled, e.g. before shutting down.
+func (b *WhatsAppBot) Wait() {
+	b.updates.Wait()
+}
+
+func (b *WhatsAppBot) handleInbound(ctx context.Context, chatID int64, msg whatsAppInbound) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	sess, err := b.sm.Get(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get session")
+	}
+	if err := sess.Set(ctx, WhatsAppSessionKeyLastInbound, b.now()); err != nil {
+		return errors.Wrap(err, "failed to record last inbound message")
+	}
+
+	switch msg.Type {
+	case "interactive":
+		data := msg.Interactive.ButtonReply.ID
+		if msg.Interactive.Type == "list_reply" {
+			data = msg.Interactive.ListReply.ID
+		}
+		return b.SendCallbackData(ctx, chatID, data)
+	case "button":
+		return b.SendCallbackData(ctx, chatID, msg.Button.Payload)
+	case "text":
+	default:
+		return errors.Wrapf(ErrEmptyMessage, "unsupported whatsapp message type %s", msg.Type)
+	}
+
+	text := msg.Text.Body
+	result, err := b.forms.HandleText(ctx, chatID, text)
+	if err != nil {
+		return errors.Wrap(err, "failed to handle form input")
+	}
+	if result != nil {
+		return nil
+	}
+
+	if err := b.handler.HandleTextMessage(ctx, text, chatID, b); err != nil {
+		return errors.Wrap(err, "failed to handle text message")
+	}
+	return nil
+}
+
+func (b *WhatsAppBot) windowOpen(ctx context.Context, chatID int64) (bool, error) {
+	sess, err := b.sm.Get(ctx, chatID)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to get session")
+	}
+	val, err := sess.Get(ctx, WhatsAppSessionKeyLastInbound)
+	if err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to get last inbound message time")
+	}
+	last, ok := val.(time.Time)
+	if !ok {
+		return false, errors.Errorf("invalid last inbound type: %T", val)
+	}
+	return b.now().Sub(last) < WhatsAppSessionWindow, nil
+}
+
+func (b *WhatsAppBot) send(ctx context.Context, chatID int64, message map[string]any) error {
+	message["messaging_product"] = "whatsapp"
+	message["recipient_type"] = "individual"
+	message["to"] = strconv.FormatInt(chatID, 10)
+	body, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal whatsapp message")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.apiURL+b.config.PhoneNumberID+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create whatsapp request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+b.config.AccessToken)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send whatsapp message")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		raw, _ := io.ReadAll(resp.Body)
+		return errors.Errorf("whatsapp api returned status %d: %s", resp.StatusCode, string(raw))
+	}
+	return nil
+}
+
+// VerifyWhatsAppSignature checks the `X-Hub-Signature-256` header of a webhook delivery.
+func VerifyWhatsAppSignature(appSecret string, signature string, body []byte) error {
+	if signature == "" {
+		return errors.Wrap(ErrBadRequest, "missing whatsapp signature header")
+	}
+	if !hmac.Equal([]byte(WhatsAppSignature(appSecret, body)), []byte(signature)) {
+		return errors.Wrap(ErrBadRequest, "whatsapp signature mismatch")
+	}
+	return nil
+}
+
+// WhatsAppSignature computes the `sha256=` signature Meta sends for a webhook body.
+func WhatsAppSignature(appSecret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// toWhatsAppText converts text in a Telegram parse mode into WhatsApp formatting, which uses the same
+// `*bold*`, `_italic_` and `~strike~` markers. Links become `label (url)`.
+func toWhatsAppText(text string, parseMode string) string {
+	switch strings.ToLower(parseMode) {
+	case "html":
+		text = replaceHTMLLinks(text, func(href string, label string) string {
+			return label + " (" + href + ")"
+		})
+		return htmlToMarkers(text)
+	case "markdownv2":
+		return markdownLinkPattern.ReplaceAllString(unescapeMarkdownV2(text), "$1 ($2)")
+	case "markdown":
+		return markdownLinkPattern.ReplaceAllString(text, "$1 ($2)")
+	default:
+		return text
+	}
+}
+
+// whatsAppBody returns a non-empty interactive body, which the Cloud API requires.
+func whatsAppBody(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return "…"
+	}
+	return truncateRunes(text, whatsAppMaxBodyText)
+}
@@ -0,0 +1,166 @@
+package bot_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/whatsapptest"
+)
+
+func newWhatsAppTestBot(t *testing.T, opts ...bot.WhatsAppOption) (*bot.WhatsAppBot, *whatsapptest.Server, *whatsapptest.Client) {
+	t.Helper()
+	server := whatsapptest.NewServer()
+	t.Cleanup(server.Close)
+	sm := newTestSessionManager(t)
+	waBot, err := bot.NewWhatsAppBot(bot.WhatsAppConfig{
+		AccessToken:   "token",
+		AppSecret:     "secret",
+		VerifyToken:   "verify",
+		PhoneNumberID: "100",
+	}, sm, nil, append(opts, bot.WithWhatsAppAPIURL(server.APIURL()))...)
+	if err != nil {
+		t.Fatalf("whatsapp bot: %v", err)
+	}
+	waBot.RegisterBotxHandler(&testHandler{})
+	return waBot, server, &whatsapptest.Client{Handler: waBot, AppSecret: "secret"}
+}
+
+func TestWhatsAppBotStepByStepForm(t *testing.T) {
+	_, server, client := newWhatsAppTestBot(t)
+
+	if err := client.SendText("15550001", "hi"); err != nil {
+		t.Fatalf("send text: %v", err)
+	}
+	msg, ok := server.LastMessage("15550001")
+	if !ok || msg.Type != "button" || msg.Text != "*Hello* hi" {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+	if len(msg.Buttons) != 1 || msg.Buttons[0].ID != "_route:/add" {
+		t.Fatalf("unexpected buttons: %+v", msg.Buttons)
+	}
+
+	if err := client.Click("15550001", msg.Buttons[0], false); err != nil {
+		t.Fatalf("click: %v", err)
+	}
+	if msg, _ := server.LastMessage("15550001"); msg.Text != "Enter a title" {
+		t.Fatalf("expected form prompt, got %+v", msg)
+	}
+	if err := client.SendText("15550001", " "); err != nil {
+		t.Fatalf("send blank title: %v", err)
+	}
+	if msg, _ := server.LastMessage("15550001"); msg.Text != "title is required" {
+		t.Fatalf("expected validation error, got %+v", msg)
+	}
+	if err := client.SendText("15550001", "milk"); err != nil {
+		t.Fatalf("send title: %v", err)
+	}
+	if msg, _ := server.LastMessage("15550001"); msg.Text != "added milk" {
+		t.Fatalf("expected submit result, got %+v", msg)
+	}
+}
+
+func TestWhatsAppBotAcknowledgesBeforeHandling(t *testing.T) {
+	waBot, server, _ := newWhatsAppTestBot(t)
+	handler := &blockingTestHandler{started: make(chan string, 10), release: make(chan struct{})}
+	waBot.RegisterBotxHandler(handler)
+	// a plain handler func, so the client does not wait for the messages to be handled
+	client := &whatsapptest.Client{Handler: http.HandlerFunc(waBot.ServeHTTP), AppSecret: "secret"}
+
+	for _, text := range []string{"one", "two"} {
+		if err := client.SendText("15550004", text); err != nil {
+			t.Fatalf("send text: %v", err)
+		}
+	}
+	if text := <-handler.started; text != "one" {
+		t.Fatalf("expected the first message to run first, got %q", text)
+	}
+	select {
+	case text := <-handler.started:
+		t.Fatalf("expected the second message to wait for the first, got %q", text)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, ok := server.LastMessage("15550004"); ok {
+		t.Fatal("expected the handler to run after the acknowledgement")
+	}
+	close(handler.release)
+	waBot.Wait()
+	if msg, _ := server.LastMessage("15550004"); msg.Text != "*Hello* two" {
+		t.Fatalf("expected both messages to be handled in order, got %+v", msg)
+	}
+}
+
+func TestWhatsAppBotListMessages(t *testing.T) {
+	waBot, server, client := newWhatsAppTestBot(t)
+	if err := client.SendText("15550002", "hi"); err != nil {
+		t.Fatalf("send text: %v", err)
+	}
+
+	var grid [][]bot.Button
+	for i := 0; i < 12; i++ {
+		grid = append(grid, []bot.Button{{Label: fmt.Sprintf("Item %d", i), CallbackData: bot.RouteCallbackData(fmt.Sprintf("/todo/%d", i))}})
+	}
+	if err := waBot.SendMessage(context.Background(), 15550002, &bot.Message{Text: "Todos", ButtonGrid: grid}); err != nil {
+		t.Fatalf("send list: %v", err)
+	}
+	messages := server.Messages("15550002")
+	lists := messages[len(messages)-2:]
+	if lists[0].Type != "list" || len(lists[0].Buttons) != 10 || lists[1].Type != "list" || len(lists[1].Buttons) != 2 {
+		t.Fatalf("expected rows split over two lists, got %+v", lists)
+	}
+	if lists[1].Buttons[1].ID != "_route:/todo/11" {
+		t.Fatalf("unexpected row id: %+v", lists[1].Buttons[1])
+	}
+}
+
+func TestWhatsAppBotSessionWindow(t *testing.T) {
+	now := time.Now()
+	clock := func() time.Time { return now }
+	waBot, _, client := newWhatsAppTestBot(t, bot.WithWhatsAppClock(clock))
+
+	if err := waBot.SendMessage(context.Background(), 15550003, &bot.Message{Text: "hello"}); err == nil {
+		t.Fatalf("expected closed window error before any inbound message")
+	}
+	if err := client.SendText("15550003", "hi"); err != nil {
+		t.Fatalf("send text: %v", err)
+	}
+	now = now.Add(bot.WhatsAppSessionWindow + time.Minute)
+	if err := waBot.SendMessage(context.Background(), 15550003, &bot.Message{Text: "late"}); err == nil {
+		t.Fatalf("expected closed window error after 24 hours")
+	}
+
+	waBot, server, _ := newWhatsAppTestBot(t, bot.WithWhatsAppClock(clock), bot.WithWhatsAppWindowTemplate(bot.WhatsAppTemplate{Name: "reengage", Language: "en"}))
+	if err := waBot.SendMessage(context.Background(), 15550003, &bot.Message{Text: "hello"}); err != nil {
+		t.Fatalf("send template: %v", err)
+	}
+	if msg, _ := server.LastMessage("15550003"); msg.Type != "template" || msg.Template != "reengage" {
+		t.Fatalf("expected template message, got %+v", msg)
+	}
+}
+
+func TestWhatsAppBotWebhookVerification(t *testing.T) {
+	waBot, server, client := newWhatsAppTestBot(t)
+
+	rec := httptest.NewRecorder()
+	waBot.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/whatsapp?hub.mode=subscribe&hub.verify_token=verify&hub.challenge=42", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "42" {
+		t.Fatalf("unexpected handshake response: %d %q", rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	waBot.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/whatsapp?hub.mode=subscribe&hub.verify_token=wrong&hub.challenge=42", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected forbidden handshake, got %d", rec.Code)
+	}
+
+	client.AppSecret = "wrong"
+	if err := client.SendText("15550004", "hi"); err == nil {
+		t.Fatalf("expected request with a bad signature to be rejected")
+	}
+	if len(server.Messages("15550004")) != 0 {
+		t.Fatalf("expected no messages to be sent")
+	}
+}
@@ -0,0 +1,379 @@
+// Package bottest provides an in-memory BotConnector for testing generated bots. Register the connector
+// like any other backend and drive chats through it:
+//
+//	conn, _ := bottest.New(sm)
+//	Register(conn, sm, stateProvider, formValidator, handler, commandHandler)
+//	chat := conn.Chat(1)
+//	_ = chat.SendText(ctx, "/start")
+//	_ = chat.Click(ctx, "Add")
+//	_ = chat.FillForm(ctx, bot.FormValues{"title": "milk"})
+//	chat.AssertTextContains(t, "added")
+package bottest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+)
+
+// SessionKeyForm is the session key of the pending form.
+const SessionKeyForm = "__bottest_form"
+
+// ValidationError is returned when a form input is rejected by its validator. The form stays on the
+// rejected field.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid value for field %q: %s", e.Field, e.Message)
+}
+
+// Connector is an in-memory BotConnector. Messages and forms sent by the handler are recorded per chat.
+type Connector struct {
+	sm      session.SessionManager
+	handler bot.BotxHandler
+	forms   *bot.FormEngine
+
+	mu    sync.Mutex
+	chats map[int64]*Chat
+}
+
+func New(sm session.SessionManager) (*Connector, error) {
+	if sm == nil {
+		return nil, errors.New("session manager is required")
+	}
+	c := &Connector{sm: sm, chats: make(map[int64]*Chat)}
+	// forms are inspected through Chat.Form rather than prompted for
+	c.forms = bot.NewFormEngine(c, sm, SessionKeyForm, func() bot.BotxHandler { return c.handler }, bot.WithFormPrompt(noPrompt))
+	return c, nil
+}
+
+func noPrompt(context.Context, int64, *bot.Form) error {
+	return nil
+}
+
+func (c *Connector) RegisterBotxHandler(handler bot.BotxHandler) {
+	c.handler = handler
+}
+
+// Chat returns the chat with the given ID, creating it on first use.
+func (c *Connector) Chat(chatID int64) *Chat {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chat, ok := c.chats[chatID]
+	if !ok {
+		chat = &Chat{conn: c, id: chatID}
+		c.chats[chatID] = chat
+	}
+	return chat
+}
+
+func (c *Connector) SendMessage(_ context.Context, chatID int64, message *bot.Message) error {
+	if message == nil {
+		return errors.New("message is required")
+	}
+	chat := c.Chat(chatID)
+	chat.mu.Lock()
+	defer chat.mu.Unlock()
+	chat.messages = append(chat.messages, message)
+	return nil
+}
+
+func (c *Connector) SendForm(ctx context.Context, chatID int64, form *bot.Form) error {
+	return c.forms.Start(ctx, chatID, form)
+}
+
+func (c *Connector) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	if c.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if data == "" {
+		return errors.New("callback data is required")
+	}
+	if ok, err := c.forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
+	if err := c.handler.HandleCallbackData(ctx, data, chatID, c); err != nil {
+		return errors.Wrap(err, "failed to handle callback data")
+	}
+	return nil
+}
+
+// Chat is one conversation with the bot.
+type Chat struct {
+	conn *Connector
+	id   int64
+
+	mu       sync.Mutex
+	messages []*bot.Message
+	errs     []error
+}
+
+func (c *Chat) ID() int64 {
+	return c.id
+}
+
+// SendText sends a text message. While a form is pending, the text is the input of its current field.
+func (c *Chat) SendText(ctx context.Context, text string) error {
+	if form := c.Form(); form != nil {
+		return c.input(ctx, text)
+	}
+	return c.handle(ctx, func() error {
+		return c.conn.handler.HandleTextMessage(ctx, text, c.id, c.conn)
+	})
+}
+
+// SendCallbackData presses a button with the given callback data, whether or not it is on screen.
+func (c *Chat) SendCallbackData(ctx context.Context, data string) error {
+	return c.handle(ctx, func() error {
+		return c.conn.SendCallbackData(ctx, c.id, data)
+	})
+}
+
+// Click presses the button labeled label on the last message with buttons.
+func (c *Chat) Click(ctx context.Context, label string) error {
+	button, err := c.findButton(func(b bot.Button) bool { return b.Label == label })
+	if err != nil {
+		return errors.Wrapf(err, "no button labeled %q", label)
+	}
+	return c.SendCallbackData(ctx, button.CallbackData)
+}
+
+// ClickRoute presses the button of the last message with buttons that routes to url, e.g. "/todo/42".
+func (c *Chat) ClickRoute(ctx context.Context, url string) error {
+	data := bot.RouteCallbackData(url)
+	button, err := c.findButton(func(b bot.Button) bool { return b.CallbackData == data })
+	if err != nil {
+		return errors.Wrapf(err, "no button routing to %q", url)
+	}
+	return c.SendCallbackData(ctx, button.CallbackData)
+}
+
+// FillForm enters the values of the remaining fields of the pending form in order and submits it.
+func (c *Chat) FillForm(ctx context.Context, values bot.FormValues) error {
+	form := c.Form()
+	if form == nil {
+		return errors.New("no form is pending")
+	}
+	for c.Form() == form && form.Idx < len(form.Fields) && !form.Reviewing && !form.Correcting {
+		field := form.Fields[form.Idx]
+		value, ok := values[field.ID]
+		if !ok {
+			return errors.Errorf("no value for form field %q", field.ID)
+		}
+		if err := c.input(ctx, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KeepCurrent keeps the current value of the pending field, like pressing its keep button.
+func (c *Chat) KeepCurrent(ctx context.Context) error {
+	return c.SendCallbackData(ctx, bot.CallbackFormKeep)
+}
+
+// Form returns the pending form, or nil. A form waiting for review has Reviewing set, and a form whose
+// validation sent the user back to the field at Idx has Correcting set.
+func (c *Chat) Form() *bot.Form {
+	form, err := c.conn.forms.Pending(context.Background(), c.id)
+	if err != nil {
+		return nil
+	}
+	return form
+}
+
+// Messages returns every message sent to the chat.
+func (c *Chat) Messages() []*bot.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*bot.Message(nil), c.messages...)
+}
+
+// LastMessage returns the last message sent to the chat, or nil.
+func (c *Chat) LastMessage() *bot.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.messages) == 0 {
+		return nil
+	}
+	return c.messages[len(c.messages)-1]
+}
+
+// Errors returns the errors returned by the handler. They have already been passed to HandleError.
+func (c *Chat) Errors() []error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]error(nil), c.errs...)
+}
+
+func (c *Chat) Session(ctx context.Context) (session.Session, error) {
+	sess, err := c.conn.sm.Get(ctx, c.id)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get session")
+	}
+	return sess, nil
+}
+
+// History returns the router history of the chat, oldest first.
+func (c *Chat) History(ctx context.Context) ([]string, error) {
+	sess, err := c.Session(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hist, err := sess.Get(ctx, bot.SessionKeyRouterHist)
+	if err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get router history")
+	}
+	return hist.([]string), nil
+}
+
+// AssertText fails the test unless the last message text, trimmed of surrounding space, equals want.
+func (c *Chat) AssertText(t testing.TB, want string) {
+	t.Helper()
+	msg := c.mustLastMessage(t)
+	if got := strings.TrimSpace(msg.Text); got != want {
+		t.Fatalf("chat %d: expected text %q, got %q", c.id, want, got)
+	}
+}
+
+// AssertTextContains fails the test unless the last message text contains substr.
+func (c *Chat) AssertTextContains(t testing.TB, substr string) {
+	t.Helper()
+	msg := c.mustLastMessage(t)
+	if !strings.Contains(msg.Text, substr) {
+		t.Fatalf("chat %d: expected text containing %q, got %q", c.id, substr, msg.Text)
+	}
+}
+
+func (c *Chat) AssertParseMode(t testing.TB, want string) {
+	t.Helper()
+	msg := c.mustLastMessage(t)
+	if msg.ParseMode != want {
+		t.Fatalf("chat %d: expected parse mode %q, got %q", c.id, want, msg.ParseMode)
+	}
+}
+
+// AssertButtons fails the test unless the button labels of the last message match want row by row.
+func (c *Chat) AssertButtons(t testing.TB, want [][]string) {
+	t.Helper()
+	msg := c.mustLastMessage(t)
+	got := Labels(msg)
+	if !equalGrid(got, want) {
+		t.Fatalf("chat %d: expected buttons %q, got %q", c.id, want, got)
+	}
+}
+
+// AssertHistory fails the test unless the router history equals want.
+func (c *Chat) AssertHistory(t testing.TB, want ...string) {
+	t.Helper()
+	got, err := c.History(context.Background())
+	if err != nil {
+		t.Fatalf("chat %d: %v", c.id, err)
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("chat %d: expected history %q, got %q", c.id, want, got)
+	}
+}
+
+// Labels returns the button labels of message row by row.
+func Labels(message *bot.Message) [][]string {
+	labels := make([][]string, 0, len(message.ButtonGrid))
+	for _, row := range message.ButtonGrid {
+		cols := make([]string, 0, len(row))
+		for _, button := range row {
+			cols = append(cols, button.Label)
+		}
+		labels = append(labels, cols)
+	}
+	return labels
+}
+
+func equalGrid(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if strings.Join(a[i], "\x00") != strings.Join(b[i], "\x00") || len(a[i]) != len(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Chat) mustLastMessage(t testing.TB) *bot.Message {
+	t.Helper()
+	msg := c.LastMessage()
+	if msg == nil {
+		t.Fatalf("chat %d: no message was sent", c.id)
+	}
+	return msg
+}
+
+func (c *Chat) findButton(match func(bot.Button) bool) (bot.Button, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if len(c.messages[i].ButtonGrid) == 0 {
+			continue
+		}
+		for _, row := range c.messages[i].ButtonGrid {
+			for _, button := range row {
+				if match(button) {
+					return button, nil
+				}
+			}
+		}
+		return bot.Button{}, bot.ErrNotFound
+	}
+	return bot.Button{}, errors.Wrap(bot.ErrNotFound, "no message with buttons")
+}
+
+// input enters text into the current field of the pending form, submitting the form after its last
+// field.
+func (c *Chat) input(ctx context.Context, text string) error {
+	var field string
+	if form := c.Form(); form != nil && form.Idx < len(form.Fields) {
+		field = form.Fields[form.Idx].ID
+	}
+	var result *bot.ValidateResult
+	if err := c.handle(ctx, func() (err error) {
+		result, err = c.conn.forms.HandleText(ctx, c.id, text)
+		return err
+	}); err != nil {
+		return err
+	}
+	if result != nil && !result.Valid {
+		return &ValidationError{Field: field, Message: result.ErrorMessage}
+	}
+	return nil
+}
+
+// handle runs fn and passes its error to the handler's HandleError like a real connector does.
+func (c *Chat) handle(ctx context.Context, fn func() error) error {
+	if c.conn.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	err := fn()
+	if err == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.errs = append(c.errs, err)
+	c.mu.Unlock()
+	if handleErr := c.conn.handler.HandleError(ctx, err, c.id, c.conn); handleErr != nil {
+		return errors.Wrap(handleErr, "failed to handle error")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package bottest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+)
+
+// UpdateSnapshotsEnv is the environment variable that makes AssertGolden and AssertGoldenDir rewrite the
+// golden files instead of comparing against them.
+const UpdateSnapshotsEnv = "BOTX_UPDATE_SNAPSHOTS"
+
+// Snapshot renders messages and a pending form as stable text for golden files. Buttons are listed with
+// their callback data, or with their confirmation in place of the random `_confirm:` nonce.
+func Snapshot(title string, messages []*bot.Message, form *bot.Form) string {
+	var sb strings.Builder
+	sb.WriteString(title)
+	sb.WriteString("\n")
+	for _, msg := range messages {
+		sb.WriteString("\n--- message")
+		if msg.ParseMode != "" {
+			sb.WriteString(" (" + msg.ParseMode + ")")
+		}
+		sb.WriteString("\n")
+		sb.WriteString(strings.TrimRight(msg.Text, "\n"))
+		sb.WriteString("\n")
+		if len(msg.ButtonGrid) == 0 {
+			continue
+		}
+		sb.WriteString("--- buttons\n")
+		for _, row := range msg.ButtonGrid {
+			cols := make([]string, 0, len(row))
+			for _, button := range row {
+				if button.Confirm != nil {
+					yes, no := button.Confirm.Labels()
+					cols = append(cols, fmt.Sprintf("[%s](%s %q [%s|%s])", button.Label, bot.CallbackPrefixConfirm, button.Confirm.Message, yes, no))
+					continue
+				}
+				cols = append(cols, fmt.Sprintf("[%s](%s)", button.Label, button.CallbackData))
+			}
+			sb.WriteString(strings.Join(cols, " "))
+			sb.WriteString("\n")
+		}
+	}
+	if form != nil {
+		sb.WriteString("\n--- form")
+		if form.URL != nil {
+			sb.WriteString(" " + form.URL.String())
+		}
+		sb.WriteString("\n")
+		for _, field := range form.Fields {
+			fmt.Fprintf(&sb, "%s: %s", field.ID, field.Label)
+			if field.Input != nil && field.Input.Schema != nil && field.Input.Schema.Type != "" {
+				fmt.Fprintf(&sb, " (%s)", field.Input.Schema.Type)
+			}
+			if field.Validator != nil {
+				fmt.Fprintf(&sb, " validator=%s", *field.Validator)
+			}
+			sb.WriteString("\n")
+			if field.Input == nil {
+				continue
+			}
+			if field.Input.Schema != nil && len(field.Input.Schema.Enum) != 0 {
+				fmt.Fprintf(&sb, "  enum: %s\n", strings.Join(field.Input.Schema.Enum, ", "))
+			}
+			if tip := strings.TrimRight(field.Input.Tip, "\n"); tip != "" {
+				sb.WriteString("  tip: " + strings.ReplaceAll(tip, "\n", "\n       ") + "\n")
+			}
+			if field.Input.Default != "" {
+				sb.WriteString("  default: " + field.Input.Default + "\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// AssertGolden fails the test unless got equals the content of the golden file at path.
+func AssertGolden(t testing.TB, path string, got string) {
+	t.Helper()
+	if os.Getenv(UpdateSnapshotsEnv) != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("create snapshot dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("write snapshot: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read snapshot %s: %v (run with %s=1 to create it)", path, err, UpdateSnapshotsEnv)
+	}
+	if string(want) != got {
+		t.Errorf("snapshot %s changed (run with %s=1 to update it):\n--- want\n%s\n--- got\n%s", path, UpdateSnapshotsEnv, want, got)
+	}
+}
+
+// AssertGoldenDir compares snapshots, keyed by file name, with the golden files in dir. Golden files
+// without a snapshot are reported as stale, or removed when updating.
+func AssertGoldenDir(t testing.TB, dir string, snapshots map[string]string) {
+	t.Helper()
+	names := make([]string, 0, len(snapshots))
+	for name := range snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		AssertGolden(t, filepath.Join(dir, name), snapshots[name])
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read snapshot dir: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if _, ok := snapshots[entry.Name()]; ok {
+			continue
+		}
+		if os.Getenv(UpdateSnapshotsEnv) != "" {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				t.Fatalf("remove stale snapshot: %v", err)
+			}
+			continue
+		}
+		t.Errorf("stale snapshot %s (run with %s=1 to remove it)", filepath.Join(dir, entry.Name()), UpdateSnapshotsEnv)
+	}
+}
@@ -0,0 +1,194 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+)
+
+// maxPendingConfirms is the number of confirmations kept per chat; the buttons of older messages expire.
+const maxPendingConfirms = 32
+
+// confirm actions
+const (
+	confirmActionYes = "yes"
+	confirmActionNo  = "no"
+)
+
+// Confirm is the question asked before the callback of a button runs, e.g. before deleting a todo. Empty
+// labels fall back to English defaults.
+type Confirm struct {
+	Message string
+	// YesLabel defaults to "Yes".
+	YesLabel string
+	// NoLabel defaults to "No".
+	NoLabel string
+}
+
+// PendingConfirm is a confirmation bound to the session under SessionKeyConfirms. Its fields are exported
+// so a persistent session manager can serialize it, like the pending Form.
+type PendingConfirm struct {
+	Nonce    string
+	Callback string
+	Confirm  Confirm
+	// Message is the message the button was sent with, shown again when the user says no.
+	Message *Message
+}
+
+func hasConfirms(message *Message) bool {
+	if message == nil {
+		return false
+	}
+	for _, row := range message.ButtonGrid {
+		for _, button := range row {
+			if button.Confirm != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// BindConfirms returns a copy of message whose Confirm buttons call `_confirm:<nonce>` instead of their
+// callback. The callback is kept in sess under the random nonce and runs at most once, when the user
+// confirms, so a recorded or forged callback cannot run it again.
+func BindConfirms(ctx context.Context, sess session.Session, message *Message) (*Message, error) {
+	pending, err := pendingConfirms(ctx, sess)
+	if err != nil {
+		return nil, err
+	}
+	bound := *message
+	bound.ButtonGrid = make([][]Button, len(message.ButtonGrid))
+	var added []*PendingConfirm
+	for i, row := range message.ButtonGrid {
+		bound.ButtonGrid[i] = append([]Button(nil), row...)
+		for j, button := range row {
+			if button.Confirm == nil {
+				continue
+			}
+			nonce, err := newConfirmNonce()
+			if err != nil {
+				return nil, err
+			}
+			added = append(added, &PendingConfirm{Nonce: nonce, Callback: button.CallbackData, Confirm: *button.Confirm, Message: &bound})
+			bound.ButtonGrid[i][j].CallbackData = CallbackPrefixConfirm + ":" + nonce
+		}
+	}
+	pending = append(pending, added...)
+	if len(pending) > maxPendingConfirms {
+		pending = pending[len(pending)-maxPendingConfirms:]
+	}
+	if err := sess.Set(ctx, SessionKeyConfirms, pending); err != nil {
+		return nil, errors.Wrap(err, "failed to store confirmations")
+	}
+	return &bound, nil
+}
+
+// HandleConfirm handles a `_confirm:` callback. Pressing a Confirm button shows its question with yes and
+// no buttons, in place of the message where the connector is a MessageEditor. No shows the message again.
+// Yes drops the confirmation and returns the callback of the button, which the caller runs.
+func HandleConfirm(ctx context.Context, connector BotConnector, sess session.Session, chatID int64, data string) (string, error) {
+	nonce, action, _ := strings.Cut(strings.TrimPrefix(data, CallbackPrefixConfirm+":"), ":")
+	pending, err := pendingConfirms(ctx, sess)
+	if err != nil {
+		return "", err
+	}
+	idx := -1
+	for i, p := range pending {
+		if p.Nonce == nonce {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		return "", errors.Wrap(ErrBadRequest, "the confirmation has expired or was already answered")
+	}
+	p := pending[idx]
+
+	switch action {
+	case "":
+		return "", EditOrSendMessage(ctx, connector, chatID, p.dialog())
+	case confirmActionNo:
+		return "", EditOrSendMessage(ctx, connector, chatID, p.Message)
+	case confirmActionYes:
+		pending = append(pending[:idx:idx], pending[idx+1:]...)
+		if err := sess.Set(ctx, SessionKeyConfirms, pending); err != nil {
+			return "", errors.Wrap(err, "failed to store confirmations")
+		}
+		// the answered question stays without its buttons where it can be edited
+		if editor, ok := connector.(MessageEditor); ok {
+			if _, err := editor.EditMessage(ctx, chatID, &Message{Text: p.Confirm.Message}); err != nil {
+				return "", errors.Wrap(err, "failed to edit confirmation")
+			}
+		}
+		return p.Callback, nil
+	default:
+		return "", errors.Wrapf(ErrBadRequest, "unknown confirm action: %s", action)
+	}
+}
+
+// Labels returns the labels of the yes and no buttons with the defaults applied.
+func (c *Confirm) Labels() (yes string, no string) {
+	yes, no = c.YesLabel, c.NoLabel
+	if yes == "" {
+		yes = "Yes"
+	}
+	if no == "" {
+		no = "No"
+	}
+	return yes, no
+}
+
+func (p *PendingConfirm) dialog() *Message {
+	yes, no := p.Confirm.Labels()
+	prefix := CallbackPrefixConfirm + ":" + p.Nonce + ":"
+	return &Message{
+		Text: p.Confirm.Message,
+		ButtonGrid: [][]Button{{
+			{Label: yes, CallbackData: prefix + confirmActionYes},
+			{Label: no, CallbackData: prefix + confirmActionNo},
+		}},
+	}
+}
+
+// EditOrSendMessage replaces the message whose button sent the callback being handled where connector is
+// a MessageEditor, and sends message otherwise.
+func EditOrSendMessage(ctx context.Context, connector BotConnector, chatID int64, message *Message) error {
+	if editor, ok := connector.(MessageEditor); ok {
+		edited, err := editor.EditMessage(ctx, chatID, message)
+		if err != nil {
+			return errors.Wrap(err, "failed to edit message")
+		}
+		if edited {
+			return nil
+		}
+	}
+	return connector.SendMessage(ctx, chatID, message)
+}
+
+func pendingConfirms(ctx context.Context, sess session.Session) ([]*PendingConfirm, error) {
+	value, err := sess.Get(ctx, SessionKeyConfirms)
+	if err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get confirmations")
+	}
+	pending, ok := value.([]*PendingConfirm)
+	if !ok {
+		return nil, errors.Errorf("invalid confirmations type: %T", value)
+	}
+	return pending, nil
+}
+
+func newConfirmNonce() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", errors.Wrap(err, "failed to generate confirmation nonce")
+	}
+	return hex.EncodeToString(buf), nil
+}
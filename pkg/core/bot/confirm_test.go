@@ -0,0 +1,204 @@
+package bot_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/telegramtest"
+	"github.com/anclax/botx/pkg/core/session"
+)
+
+type confirmTestHandler struct {
+	sm session.SessionManager
+}
+
+func (h *confirmTestHandler) HandleTextMessage(ctx context.Context, _ string, chatID int64, b bot.BotConnector) error {
+	return bot.NewBot(b, bot.WithBotSessionManager(h.sm)).SendMessage(ctx, chatID, &bot.Message{
+		Text: "milk",
+		ButtonGrid: [][]bot.Button{{{
+			Label:        "Delete",
+			CallbackData: bot.RouteCallbackData("/todo/1/delete"),
+			Confirm:      &bot.Confirm{Message: "Delete milk?"},
+		}}},
+	})
+}
+
+func (h *confirmTestHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	sess, err := h.sm.Get(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	callback, err := bot.HandleConfirm(ctx, b, sess, chatID, data)
+	if err != nil || callback == "" {
+		return err
+	}
+	return b.SendMessage(ctx, chatID, &bot.Message{Text: "ran " + callback})
+}
+
+func (h *confirmTestHandler) HandleError(ctx context.Context, err error, chatID int64, b bot.BotConnector) error {
+	return b.SendMessage(ctx, chatID, &bot.Message{Text: "error: " + err.Error()})
+}
+
+func (h *confirmTestHandler) Validate(context.Context, int64, *url.URL, string, string) (*bot.ValidateResult, error) {
+	return &bot.ValidateResult{Valid: true}, nil
+}
+
+func waitTelegramMessage(t *testing.T, server *telegramtest.Server, chatID int64, idx int, text string) telegramtest.Message {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages := server.Messages(chatID)
+		if len(messages) > idx && messages[idx].Text == text {
+			return messages[idx]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for message %d to be %q, got %+v", idx, text, messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConfirmEditsTelegramMessage(t *testing.T) {
+	sm := newTestSessionManager(t)
+	server := newTelegramTestBot(t, &confirmTestHandler{sm: sm})
+
+	server.SendText(7, "hi")
+	waitTelegramMessage(t, server, 7, 0, "milk")
+	if err := server.Click(7, "Delete"); err != nil {
+		t.Fatal(err)
+	}
+	dialog := waitTelegramMessage(t, server, 7, 0, "Delete milk?")
+	if !dialog.Edited || len(dialog.Buttons) != 1 || len(dialog.Buttons[0]) != 2 {
+		t.Fatalf("expected the message to be edited into the question, got %+v", dialog)
+	}
+
+	if err := server.Click(7, "No"); err != nil {
+		t.Fatal(err)
+	}
+	waitTelegramMessage(t, server, 7, 0, "milk")
+
+	if err := server.Click(7, "Delete"); err != nil {
+		t.Fatal(err)
+	}
+	waitTelegramMessage(t, server, 7, 0, "Delete milk?")
+	if err := server.Click(7, "Yes"); err != nil {
+		t.Fatal(err)
+	}
+	waitTelegramMessage(t, server, 7, 1, "ran "+bot.RouteCallbackData("/todo/1/delete"))
+	if answered := server.Messages(7)[0]; len(answered.Buttons) != 0 {
+		t.Fatalf("expected the answered question to lose its buttons, got %+v", answered)
+	}
+	if len(server.Messages(7)) != 2 {
+		t.Fatalf("expected only the result to be sent, got %+v", server.Messages(7))
+	}
+}
+
+func TestConfirmCannotBeReplayed(t *testing.T) {
+	ctx := context.Background()
+	sm := newTestSessionManager(t)
+	sess, err := sm.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := bot.BindConfirms(ctx, sess, &bot.Message{ButtonGrid: [][]bot.Button{{
+		{Label: "Delete", CallbackData: "_route:/todo/1/delete", Confirm: &bot.Confirm{Message: "Sure?"}},
+		{Label: "Back", CallbackData: "_route:back"},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := message.ButtonGrid[0][0].CallbackData
+	if message.ButtonGrid[0][1].CallbackData != "_route:back" {
+		t.Fatalf("expected buttons without a confirmation to keep their callback, got %+v", message.ButtonGrid)
+	}
+
+	conn := &confirmTestConnector{}
+	// a confirmation only exists in the session it was sent to
+	other, err := sm.Get(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.HandleConfirm(ctx, conn, other, 2, data+":yes"); !errors.Is(err, bot.ErrBadRequest) {
+		t.Fatalf("expected another chat to be rejected, got %v", err)
+	}
+
+	callback, err := bot.HandleConfirm(ctx, conn, sess, 1, data+":yes")
+	if err != nil || callback != "_route:/todo/1/delete" {
+		t.Fatalf("got %q, %v", callback, err)
+	}
+	if _, err := bot.HandleConfirm(ctx, conn, sess, 1, data+":yes"); !errors.Is(err, bot.ErrBadRequest) {
+		t.Fatalf("expected the replay to be rejected, got %v", err)
+	}
+}
+
+func TestConfirmSurvivesJSONSession(t *testing.T) {
+	ctx := context.Background()
+	sm := newTestSessionManager(t)
+	sess, err := sm.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := bot.BindConfirms(ctx, sess, &bot.Message{Text: "milk", ButtonGrid: [][]bot.Button{{
+		{Label: "Delete", CallbackData: "_route:/todo/1/delete", Confirm: &bot.Confirm{Message: "Sure?", YesLabel: "Delete"}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// store the confirmations the way a persistent session manager would
+	value, err := sess.Get(ctx, bot.SessionKeyConfirms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []*bot.PendingConfirm
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := sess.Set(ctx, bot.SessionKeyConfirms, decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	data := message.ButtonGrid[0][0].CallbackData
+	conn := &recordingConfirmConnector{}
+	if _, err := bot.HandleConfirm(ctx, conn, sess, 1, data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bot.HandleConfirm(ctx, conn, sess, 1, data+":no"); err != nil {
+		t.Fatal(err)
+	}
+	if len(conn.sent) != 2 || conn.sent[0].Text != "Sure?" || conn.sent[0].ButtonGrid[0][0].Label != "Delete" {
+		t.Fatalf("expected the question, got %+v", conn.sent)
+	}
+	if conn.sent[1].Text != "milk" || conn.sent[1].ButtonGrid[0][0].CallbackData != data {
+		t.Fatalf("expected the message again, got %+v", conn.sent[1])
+	}
+	callback, err := bot.HandleConfirm(ctx, conn, sess, 1, data+":yes")
+	if err != nil || callback != "_route:/todo/1/delete" {
+		t.Fatalf("got %q, %v", callback, err)
+	}
+}
+
+// recordingConfirmConnector records the messages sent.
+type recordingConfirmConnector struct {
+	bot.BotConnector
+	sent []*bot.Message
+}
+
+func (c *recordingConfirmConnector) SendMessage(_ context.Context, _ int64, message *bot.Message) error {
+	c.sent = append(c.sent, message)
+	return nil
+}
+
+// confirmTestConnector cannot edit messages.
+type confirmTestConnector struct {
+	bot.BotConnector
+}
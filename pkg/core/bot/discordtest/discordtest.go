@@ -0,0 +1,268 @@
+// Package discordtest provides a local fake of the Discord REST API and a client that sends signed
+// interactions to a DiscordBot's interactions endpoint.
+package discordtest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// interaction response types
+const (
+	ResponseMessage = 4
+	ResponseModal   = 9
+)
+
+type Button struct {
+	Label    string
+	CustomID string
+}
+
+// Message is a message posted to a channel or sent as an interaction response.
+type Message struct {
+	ChannelID string
+	Content   string
+	Buttons   [][]Button
+}
+
+type ModalField struct {
+	ID    string
+	Label string
+	// Kind is `text` or `select`.
+	Kind    string
+	Options []string
+	Value   string
+}
+
+type Modal struct {
+	CustomID string
+	Title    string
+	Fields   []ModalField
+}
+
+// Response is a decoded interaction response.
+type Response struct {
+	Type    int
+	Message *Message
+	Modal   *Modal
+}
+
+// Call is a recorded REST call.
+type Call struct {
+	Method string
+	Path   string
+	Body   any
+}
+
+// Server is a fake Discord REST API. Point a DiscordBot at it with bot.WithDiscordAPIURL(server.APIURL()).
+type Server struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) APIURL() string {
+	return s.URL + "/api/v10/"
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bot ") {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]any{"message": "401: Unauthorized", "code": 0})
+		return
+	}
+	var body any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"message": "invalid json", "code": 50109})
+		return
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: r.Method, Path: strings.TrimPrefix(r.URL.Path, "/api/v10/"), Body: body})
+	id := len(s.calls)
+	s.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]any{"id": strconv.Itoa(id)})
+}
+
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Messages returns the messages posted to channel through the REST API, in order.
+func (s *Server) Messages(channel string) []Message {
+	var messages []Message
+	for _, call := range s.Calls() {
+		if call.Method != http.MethodPost || call.Path != "channels/"+channel+"/messages" {
+			continue
+		}
+		body, _ := call.Body.(map[string]any)
+		msg := decodeMessage(body)
+		msg.ChannelID = channel
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// Commands returns the body of the last global command registration.
+func (s *Server) Commands() []any {
+	calls := s.Calls()
+	for i := len(calls) - 1; i >= 0; i-- {
+		if calls[i].Method == http.MethodPut && strings.HasSuffix(calls[i].Path, "/commands") {
+			commands, _ := calls[i].Body.([]any)
+			return commands
+		}
+	}
+	return nil
+}
+
+func decodeMessage(data map[string]any) Message {
+	msg := Message{Content: stringValue(data["content"])}
+	rows, _ := data["components"].([]any)
+	for _, row := range rows {
+		r, _ := row.(map[string]any)
+		components, _ := r["components"].([]any)
+		var buttons []Button
+		for _, component := range components {
+			c, _ := component.(map[string]any)
+			buttons = append(buttons, Button{Label: stringValue(c["label"]), CustomID: stringValue(c["custom_id"])})
+		}
+		msg.Buttons = append(msg.Buttons, buttons)
+	}
+	return msg
+}
+
+func decodeModal(data map[string]any) Modal {
+	modal := Modal{CustomID: stringValue(data["custom_id"]), Title: stringValue(data["title"])}
+	components, _ := data["components"].([]any)
+	for _, component := range components {
+		label, _ := component.(map[string]any)
+		input, _ := label["component"].(map[string]any)
+		field := ModalField{
+			ID:    stringValue(input["custom_id"]),
+			Label: stringValue(label["label"]),
+			Kind:  "text",
+			Value: stringValue(input["value"]),
+		}
+		if options, ok := input["options"].([]any); ok {
+			field.Kind = "select"
+			for _, option := range options {
+				o, _ := option.(map[string]any)
+				field.Options = append(field.Options, stringValue(o["value"]))
+				if o["default"] == true {
+					field.Value = stringValue(o["value"])
+				}
+			}
+		}
+		modal.Fields = append(modal.Fields, field)
+	}
+	return modal
+}
+
+func stringValue(value any) string {
+	text, _ := value.(string)
+	return text
+}
+
+// Client sends interactions signed with PrivateKey to Handler.
+type Client struct {
+	Handler    http.Handler
+	PrivateKey ed25519.PrivateKey
+}
+
+// NewClient generates a key pair and returns a client together with the public key to configure the
+// DiscordBot with.
+func NewClient(handler http.Handler) (*Client, ed25519.PublicKey) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		panic(err)
+	}
+	return &Client{Handler: handler, PrivateKey: privateKey}, publicKey
+}
+
+// Command invokes the slash command name, e.g. "start", with optional arguments.
+func (c *Client) Command(channel string, name string, args string) (Response, error) {
+	data := map[string]any{"name": name}
+	if args != "" {
+		data["options"] = []any{map[string]any{"name": "args", "type": 3, "value": args}}
+	}
+	return c.interact(2, channel, data)
+}
+
+// Click presses the button with customID.
+func (c *Client) Click(channel string, customID string) (Response, error) {
+	return c.interact(3, channel, map[string]any{"custom_id": customID, "component_type": 2})
+}
+
+// SubmitModal submits modal with values keyed by field ID.
+func (c *Client) SubmitModal(channel string, modal Modal, values map[string]string) (Response, error) {
+	components := make([]any, 0, len(modal.Fields))
+	for _, field := range modal.Fields {
+		input := map[string]any{"type": 4, "custom_id": field.ID, "value": values[field.ID]}
+		if field.Kind == "select" {
+			input = map[string]any{"type": 3, "custom_id": field.ID, "values": []string{values[field.ID]}}
+		}
+		components = append(components, map[string]any{"type": 18, "component": input})
+	}
+	return c.interact(5, channel, map[string]any{"custom_id": modal.CustomID, "components": components})
+}
+
+func (c *Client) interact(kind int, channel string, data map[string]any) (Response, error) {
+	body, err := json.Marshal(map[string]any{
+		"type":       kind,
+		"id":         strconv.FormatInt(time.Now().UnixNano(), 10),
+		"token":      "interaction-token",
+		"channel_id": channel,
+		"data":       data,
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest(http.MethodPost, "/discord", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(c.PrivateKey, append([]byte(timestamp), body...))))
+	rec := httptest.NewRecorder()
+	c.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return Response{}, errors.Errorf("discord interaction failed with status %d: %s", rec.Code, rec.Body.String())
+	}
+	var raw struct {
+		Type int            `json:"type"`
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &raw); err != nil {
+		return Response{}, errors.Wrap(err, "failed to decode interaction response")
+	}
+	resp := Response{Type: raw.Type}
+	switch raw.Type {
+	case ResponseMessage:
+		msg := decodeMessage(raw.Data)
+		msg.ChannelID = channel
+		resp.Message = &msg
+	case ResponseModal:
+		modal := decodeModal(raw.Data)
+		resp.Modal = &modal
+	}
+	return resp, nil
+}
@@ -0,0 +1,817 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+)
+
+// CallbackPrefixForm prefixes the callbacks of the buttons the form engine sends, e.g. `_form:submit`.
+const CallbackPrefixForm = "_form"
+
+// CallbackFormKeep is the callback of the button that keeps the current value of a field.
+const CallbackFormKeep = CallbackPrefixForm + ":" + formActionKeep
+
+// form engine actions
+const (
+	formActionEdit    = "edit"
+	formActionSubmit  = "submit"
+	formActionKeep    = "keep"
+	formActionResume  = "resume"
+	formActionRestart = "restart"
+)
+
+// FormReview asks the user to confirm the entered values before the form is submitted. Empty labels
+// fall back to English defaults.
+type FormReview struct {
+	// Title heads the list of entered values. Defaults to "Please review your answers:".
+	Title string
+	// EditLabel is followed by the field label on the button that changes a field. Defaults to "Edit".
+	EditLabel string
+	// SubmitLabel defaults to "Submit".
+	SubmitLabel string
+}
+
+// FormTimeout expires a form after After without input. The expired form is dropped, so the next message
+// is handled as usual, and the user gets Message, or the page at Route when it is set.
+type FormTimeout struct {
+	After time.Duration
+	// Message defaults to "This form has expired.".
+	Message string
+	Route   string
+}
+
+// FormResume asks whether to continue a saved draft. Empty labels fall back to English defaults.
+type FormResume struct {
+	// Message defaults to "You have an unfinished draft of this form.".
+	Message string
+	// ContinueLabel defaults to "Continue".
+	ContinueLabel string
+	// RestartLabel defaults to "Start over".
+	RestartLabel string
+}
+
+// FormPrompt asks for the field at form.Idx. Connectors with native forms show the whole form instead,
+// with the values entered so far.
+type FormPrompt func(ctx context.Context, chatID int64, form *Form) error
+
+// FormEngine runs forms for a connector. It keeps the pending form in the session, skips fields hidden
+// by their `showIf` condition, validates input, shows the optional review step and finally sends the
+// `_submit:` callback, passing the values with WithFormValues.
+//
+// Connectors that ask for one field per message pass text to HandleText. Connectors with native forms
+// validate with Validate or SubmitValues and call Complete once every field is valid. All connectors pass
+// callbacks to HandleCallback, which handles the buttons of the review step.
+type FormEngine struct {
+	connector  BotConnector
+	sm         session.SessionManager
+	sessionKey string
+	handler    func() BotxHandler
+	prompt     FormPrompt
+	now        func() time.Time
+}
+
+type FormEngineOption func(*FormEngine)
+
+// WithFormPrompt replaces the default prompt, which sends the tip of the field at form.Idx.
+func WithFormPrompt(prompt FormPrompt) FormEngineOption {
+	return func(e *FormEngine) {
+		e.prompt = prompt
+	}
+}
+
+// WithFormClock replaces time.Now for form timeouts.
+func WithFormClock(now func() time.Time) FormEngineOption {
+	return func(e *FormEngine) {
+		e.now = now
+	}
+}
+
+// NewFormEngine creates the form engine of connector. The pending form is kept under sessionKey and
+// validators run through the handler returned by handler, which may change until the first form starts.
+func NewFormEngine(connector BotConnector, sm session.SessionManager, sessionKey string, handler func() BotxHandler, opts ...FormEngineOption) *FormEngine {
+	e := &FormEngine{
+		connector:  connector,
+		sm:         sm,
+		sessionKey: sessionKey,
+		handler:    handler,
+		now:        time.Now,
+	}
+	e.prompt = e.sendFieldTip
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Start makes form the pending form of chatID and asks for its first field. A resumable form with a draft
+// asks whether to continue the draft instead.
+func (e *FormEngine) Start(ctx context.Context, chatID int64, form *Form) error {
+	if len(form.Fields) == 0 {
+		return errors.New("form has no fields")
+	}
+	pending, err := e.active(ctx, chatID)
+	if err != nil {
+		return err
+	}
+	if pending != nil && !sameForm(pending, form) && pending.Resume != nil && formStarted(pending) {
+		if err := e.saveDraft(ctx, chatID, pending); err != nil {
+			return err
+		}
+	}
+	if form.Resume != nil {
+		draft := pending
+		if draft == nil || !sameForm(draft, form) {
+			if draft, err = e.takeDraft(ctx, chatID, form); err != nil {
+				return err
+			}
+		}
+		if draft != nil && formStarted(draft) {
+			// the draft keeps its values, the labels and settings come from the form sent now
+			draft.Resume = form.Resume
+			draft.Timeout = form.Timeout
+			draft.Offering = true
+			if err := e.Save(ctx, chatID, draft); err != nil {
+				return err
+			}
+			return e.sendResumeOffer(ctx, chatID, draft)
+		}
+	}
+	return e.begin(ctx, chatID, form)
+}
+
+// begin asks for the first shown field of form.
+func (e *FormEngine) begin(ctx context.Context, chatID int64, form *Form) error {
+	form.Reviewing = false
+	form.Correcting = false
+	form.Offering = false
+	for i := range form.Fields {
+		field := &form.Fields[i]
+		field.Hidden = false
+		if field.Input != nil && field.Input.Value == "" {
+			field.Input.Value = field.Input.Default
+		}
+	}
+	idx, err := e.next(ctx, chatID, form, 0)
+	if err != nil {
+		return err
+	}
+	if idx == len(form.Fields) {
+		return e.Complete(ctx, chatID, form)
+	}
+	form.Idx = idx
+	if err := e.Save(ctx, chatID, form); err != nil {
+		return err
+	}
+	if err := e.prompt(ctx, chatID, form); err != nil {
+		return errors.Wrap(err, "failed to send first form field")
+	}
+	return nil
+}
+
+// Pending returns the pending form of chatID, or nil.
+func (e *FormEngine) Pending(ctx context.Context, chatID int64) (*Form, error) {
+	sess, err := e.sm.Get(ctx, chatID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get session")
+	}
+	val, err := sess.Get(ctx, e.sessionKey)
+	if err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to get input state from session")
+	}
+	form, ok := val.(*Form)
+	if !ok {
+		return nil, errors.Errorf("invalid input state type: %T", val)
+	}
+	return form, nil
+}
+
+// Save stores form as the pending form of chatID and records the time of the last input.
+func (e *FormEngine) Save(ctx context.Context, chatID int64, form *Form) error {
+	form.UpdatedAt = e.now()
+	sess, err := e.sm.Get(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get session")
+	}
+	if err := sess.Set(ctx, e.sessionKey, form); err != nil {
+		return errors.Wrap(err, "failed to save input state to session")
+	}
+	return nil
+}
+
+// Clear drops the pending form of chatID, if any.
+func (e *FormEngine) Clear(ctx context.Context, chatID int64) error {
+	sess, err := e.sm.Get(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get session")
+	}
+	if err := sess.Delete(ctx, e.sessionKey); err != nil {
+		return errors.Wrap(err, "failed to clear input state from session")
+	}
+	return nil
+}
+
+// HandleText enters text into the current field of the pending form. It returns nil when no form is
+// pending, so the text is an ordinary message. Rejected input is answered with the validator's message
+// and keeps the field open for another attempt.
+func (e *FormEngine) HandleText(ctx context.Context, chatID int64, text string) (*ValidateResult, error) {
+	form, err := e.active(ctx, chatID)
+	if err != nil || form == nil {
+		return nil, err
+	}
+	if form.Offering {
+		// the draft offer is shown, text does not answer anything
+		if err := e.sendResumeOffer(ctx, chatID, form); err != nil {
+			return nil, err
+		}
+		return &ValidateResult{Valid: true}, nil
+	}
+	if form.Idx >= len(form.Fields) {
+		// the review is shown, text does not answer anything
+		if err := e.sendReview(ctx, chatID, form); err != nil {
+			return nil, err
+		}
+		return &ValidateResult{Valid: true}, nil
+	}
+	return e.enter(ctx, chatID, form, text)
+}
+
+// enter validates value for the current field and accepts it, or sends the validator's message.
+func (e *FormEngine) enter(ctx context.Context, chatID int64, form *Form, value string) (*ValidateResult, error) {
+	result, err := e.Validate(ctx, chatID, form, form.Idx, value)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Valid {
+		if err := e.connector.SendMessage(ctx, chatID, &Message{
+			Text:      result.ErrorMessage,
+			ParseMode: "HTML",
+		}); err != nil {
+			return nil, errors.Wrap(err, "failed to send validation error message")
+		}
+		return result, nil
+	}
+	return result, e.accept(ctx, chatID, form, value)
+}
+
+// accept sets the value of the current field and asks for the next one, or completes the form.
+func (e *FormEngine) accept(ctx context.Context, chatID int64, form *Form, value string) error {
+	setFormValue(&form.Fields[form.Idx], value)
+	idx, err := e.next(ctx, chatID, form, form.Idx+1)
+	if err != nil {
+		return err
+	}
+	form.Idx = idx
+	if form.Idx == len(form.Fields) {
+		return e.Complete(ctx, chatID, form)
+	}
+	if err := e.Save(ctx, chatID, form); err != nil {
+		return err
+	}
+	if err := e.prompt(ctx, chatID, form); err != nil {
+		return errors.Wrap(err, "failed to send next form field")
+	}
+	return nil
+}
+
+// Validate runs the validator of the field at idx against value and the values before it. Fields without
+// a validator and fields hidden by the values before them accept any value.
+func (e *FormEngine) Validate(ctx context.Context, chatID int64, form *Form, idx int, value string) (*ValidateResult, error) {
+	shown, err := e.shown(ctx, chatID, form, idx)
+	if err != nil {
+		return nil, err
+	}
+	field := form.Fields[idx]
+	if field.Validator == nil || !shown {
+		return &ValidateResult{Valid: true}, nil
+	}
+	handler := e.handler()
+	if handler == nil {
+		return nil, errors.New("botx handler is not registered")
+	}
+	var result *ValidateResult
+	if fieldValidator, ok := handler.(FormFieldValidator); ok {
+		result, err = fieldValidator.ValidateFormField(ctx, chatID, form.URL, *field.Validator, field.ID, value, formValues(form, idx))
+	} else {
+		result, err = handler.Validate(ctx, chatID, form.URL, *field.Validator, value)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate form input")
+	}
+	return result, nil
+}
+
+// SubmitValues validates the values of a natively rendered form, keyed by field ID, then the whole form,
+// and completes the pending form when all of them are valid. Otherwise the error messages are returned by
+// field ID and the form stays pending with the values entered. Forms with conditions are rendered one
+// field at a time, so only the value of the current field is entered.
+func (e *FormEngine) SubmitValues(ctx context.Context, chatID int64, values FormValues) (map[string]string, error) {
+	form, err := e.Pending(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil && form.HasConditions() {
+		return e.submitField(ctx, chatID, form, values)
+	}
+	form, validationErrors, err := e.checkValues(ctx, chatID, values)
+	if err != nil || len(validationErrors) != 0 {
+		return validationErrors, err
+	}
+	return nil, e.finish(ctx, chatID, form)
+}
+
+// submitField enters the value of the current field of a form with conditions, which is rendered one field
+// at a time, and asks for the next shown field or completes the form.
+func (e *FormEngine) submitField(ctx context.Context, chatID int64, form *Form, values FormValues) (map[string]string, error) {
+	if form.Offering || form.Idx >= len(form.Fields) {
+		return nil, errors.Wrap(ErrBadRequest, "no form field is pending")
+	}
+	field := &form.Fields[form.Idx]
+	value := values[field.ID]
+	result, err := e.Validate(ctx, chatID, form, form.Idx, value)
+	if err != nil {
+		return nil, err
+	}
+	if !result.Valid {
+		setFormValue(field, value)
+		return map[string]string{field.ID: result.ErrorMessage}, e.Save(ctx, chatID, form)
+	}
+	return nil, e.accept(ctx, chatID, form, value)
+}
+
+// checkValues is the validation half of SubmitValues. It returns the filled form when all values are
+// valid, so a connector that must answer first can finish it afterwards.
+func (e *FormEngine) checkValues(ctx context.Context, chatID int64, values FormValues) (*Form, map[string]string, error) {
+	form, err := e.Pending(ctx, chatID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if form == nil {
+		return nil, nil, errors.Wrap(ErrBadRequest, "no form is pending")
+	}
+	validationErrors := map[string]string{}
+	for i := range form.Fields {
+		value := values[form.Fields[i].ID]
+		setFormValue(&form.Fields[i], value)
+		result, err := e.Validate(ctx, chatID, form, i, value)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !result.Valid {
+			validationErrors[form.Fields[i].ID] = result.ErrorMessage
+		}
+	}
+	if len(validationErrors) == 0 {
+		result, err := e.Check(ctx, chatID, form)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !result.Valid {
+			validationErrors[result.Field] = result.ErrorMessage
+		}
+	}
+	if len(validationErrors) != 0 {
+		return nil, validationErrors, e.Save(ctx, chatID, form)
+	}
+	form.Idx = len(form.Fields)
+	return form, nil, nil
+}
+
+// Check runs the form `validate` hook of the handler on the shown values of a filled form. The Field of
+// an invalid result is resolved to a shown field.
+func (e *FormEngine) Check(ctx context.Context, chatID int64, form *Form) (*ValidateResult, error) {
+	formValidator, ok := e.handler().(FormValuesValidator)
+	if !ok {
+		return &ValidateResult{Valid: true}, nil
+	}
+	result, err := formValidator.ValidateFormValues(ctx, chatID, form.URL, formValues(form, len(form.Fields)))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate form")
+	}
+	if result.Valid {
+		return result, nil
+	}
+	idx := slices.IndexFunc(form.Fields, func(field FormField) bool { return field.ID == result.Field && !field.Hidden })
+	if idx < 0 {
+		idx = slices.IndexFunc(form.Fields, func(field FormField) bool { return !field.Hidden })
+	}
+	if idx < 0 {
+		return nil, errors.Errorf("form validation failed without a shown field: %s", result.ErrorMessage)
+	}
+	return &ValidateResult{ErrorMessage: result.ErrorMessage, Field: form.Fields[idx].ID}, nil
+}
+
+// Complete finishes a form whose fields are all filled. When the form `validate` hook fails, the user is
+// sent back to the field it names, and the fields after it keep their values. Otherwise it shows the
+// review when the form asks for one, and submits it.
+func (e *FormEngine) Complete(ctx context.Context, chatID int64, form *Form) error {
+	for i := range form.Fields {
+		if _, err := e.shown(ctx, chatID, form, i); err != nil {
+			return err
+		}
+	}
+	result, err := e.Check(ctx, chatID, form)
+	if err != nil {
+		return err
+	}
+	if !result.Valid {
+		if err := e.connector.SendMessage(ctx, chatID, &Message{
+			Text:      result.ErrorMessage,
+			ParseMode: "HTML",
+		}); err != nil {
+			return errors.Wrap(err, "failed to send form validation error message")
+		}
+		form.Idx = slices.IndexFunc(form.Fields, func(field FormField) bool { return field.ID == result.Field })
+		form.Correcting = true
+		if err := e.Save(ctx, chatID, form); err != nil {
+			return err
+		}
+		if err := e.prompt(ctx, chatID, form); err != nil {
+			return errors.Wrap(err, "failed to send form field")
+		}
+		return nil
+	}
+	return e.finish(ctx, chatID, form)
+}
+
+// finish shows the review of a valid form when it asks for one, and submits it otherwise.
+func (e *FormEngine) finish(ctx context.Context, chatID int64, form *Form) error {
+	form.Correcting = false
+	if form.Review != nil {
+		form.Idx = len(form.Fields)
+		form.Reviewing = true
+		if err := e.Save(ctx, chatID, form); err != nil {
+			return err
+		}
+		return e.sendReview(ctx, chatID, form)
+	}
+	return e.submit(ctx, chatID, form)
+}
+
+// HandleCallback handles the `_form:` callbacks of the keep button, the draft offer and the review step
+// and reports whether data was one.
+func (e *FormEngine) HandleCallback(ctx context.Context, chatID int64, data string) (bool, error) {
+	action, ok := strings.CutPrefix(data, CallbackPrefixForm+":")
+	if !ok {
+		return false, nil
+	}
+	form, err := e.active(ctx, chatID)
+	if err != nil {
+		return true, err
+	}
+	if action == formActionResume || action == formActionRestart {
+		if form == nil || !form.Offering {
+			return true, errors.Wrap(ErrBadRequest, "no draft is waiting to be continued")
+		}
+		if action == formActionRestart {
+			for i := range form.Fields {
+				if input := form.Fields[i].Input; input != nil {
+					input.Value = ""
+				}
+			}
+			return true, e.begin(ctx, chatID, form)
+		}
+		form.Offering = false
+		if err := e.Save(ctx, chatID, form); err != nil {
+			return true, err
+		}
+		if form.Reviewing {
+			return true, e.sendReview(ctx, chatID, form)
+		}
+		if err := e.prompt(ctx, chatID, form); err != nil {
+			return true, errors.Wrap(err, "failed to send form field")
+		}
+		return true, nil
+	}
+	if action == formActionKeep {
+		if form == nil || form.Offering || form.Idx >= len(form.Fields) {
+			return true, errors.Wrap(ErrBadRequest, "no form field is waiting for input")
+		}
+		field := form.Fields[form.Idx]
+		if field.Input == nil || field.Input.Default == "" {
+			return true, errors.Wrapf(ErrBadRequest, "form field %q has no current value", field.ID)
+		}
+		// the current value may no longer pass, e.g. when the validator changed since it was saved
+		_, err := e.enter(ctx, chatID, form, field.Input.Default)
+		return true, err
+	}
+	if form == nil || !form.Reviewing {
+		return true, errors.Wrap(ErrBadRequest, "no form is waiting for review")
+	}
+	switch {
+	case action == formActionSubmit:
+		if form.Idx < len(form.Fields) {
+			return true, errors.Wrap(ErrBadRequest, "a form field is waiting for input")
+		}
+		return true, e.submit(ctx, chatID, form)
+	case strings.HasPrefix(action, formActionEdit+":"):
+		idx, err := strconv.Atoi(strings.TrimPrefix(action, formActionEdit+":"))
+		if err != nil || idx < 0 || idx >= len(form.Fields) || form.Fields[idx].Hidden {
+			return true, errors.Wrapf(ErrBadRequest, "invalid form field index in %q", data)
+		}
+		form.Idx = idx
+		if err := e.Save(ctx, chatID, form); err != nil {
+			return true, err
+		}
+		if err := e.prompt(ctx, chatID, form); err != nil {
+			return true, errors.Wrap(err, "failed to send form field")
+		}
+		return true, nil
+	default:
+		return true, errors.Wrapf(ErrBadRequest, "unknown form action %q", data)
+	}
+}
+
+// active returns the pending form of chatID, expiring it first when its timeout has passed.
+func (e *FormEngine) active(ctx context.Context, chatID int64) (*Form, error) {
+	form, err := e.Pending(ctx, chatID)
+	if err != nil || form == nil {
+		return form, err
+	}
+	if form.Timeout == nil || form.Timeout.After <= 0 || e.now().Sub(form.UpdatedAt) < form.Timeout.After {
+		return form, nil
+	}
+	return nil, e.expire(ctx, chatID, form)
+}
+
+// expire drops the pending form, keeping it as a draft when it is resumable, and tells the user.
+func (e *FormEngine) expire(ctx context.Context, chatID int64, form *Form) error {
+	if err := e.Clear(ctx, chatID); err != nil {
+		return err
+	}
+	if form.Resume != nil && formStarted(form) {
+		if err := e.saveDraft(ctx, chatID, form); err != nil {
+			return err
+		}
+	}
+	if route := form.Timeout.Route; route != "" {
+		data := RouteCallbackData(route)
+		if err := e.connector.SendCallbackData(withTrustedCallback(ctx, data), chatID, data); err != nil {
+			return errors.Wrap(err, "failed to open the form timeout route")
+		}
+		return nil
+	}
+	message := form.Timeout.Message
+	if message == "" {
+		message = "This form has expired."
+	}
+	if err := e.connector.SendMessage(ctx, chatID, &Message{Text: message, ParseMode: "HTML"}); err != nil {
+		return errors.Wrap(err, "failed to send form timeout notice")
+	}
+	return nil
+}
+
+func (e *FormEngine) draftsKey() string {
+	return e.sessionKey + "_drafts"
+}
+
+// saveDraft keeps form among the drafts of chatID, keyed by its URL.
+func (e *FormEngine) saveDraft(ctx context.Context, chatID int64, form *Form) error {
+	sess, err := e.sm.Get(ctx, chatID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get session")
+	}
+	drafts, err := formDrafts(ctx, sess, e.draftsKey())
+	if err != nil {
+		return err
+	}
+	form.Offering = false
+	drafts[form.URL.String()] = form
+	if err := sess.Set(ctx, e.draftsKey(), drafts); err != nil {
+		return errors.Wrap(err, "failed to save form draft to session")
+	}
+	return nil
+}
+
+// takeDraft removes and returns the draft of form, or nil.
+func (e *FormEngine) takeDraft(ctx context.Context, chatID int64, form *Form) (*Form, error) {
+	sess, err := e.sm.Get(ctx, chatID)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get session")
+	}
+	drafts, err := formDrafts(ctx, sess, e.draftsKey())
+	if err != nil {
+		return nil, err
+	}
+	draft, ok := drafts[form.URL.String()]
+	if !ok {
+		return nil, nil
+	}
+	delete(drafts, form.URL.String())
+	if err := sess.Set(ctx, e.draftsKey(), drafts); err != nil {
+		return nil, errors.Wrap(err, "failed to save form drafts to session")
+	}
+	return draft, nil
+}
+
+func formDrafts(ctx context.Context, sess session.Session, key string) (map[string]*Form, error) {
+	val, err := sess.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return map[string]*Form{}, nil
+		}
+		return nil, errors.Wrap(err, "failed to get form drafts from session")
+	}
+	drafts, ok := val.(map[string]*Form)
+	if !ok {
+		return nil, errors.Errorf("invalid form drafts type: %T", val)
+	}
+	return drafts, nil
+}
+
+// sameForm reports whether a and b are the same form, opened at the same URL.
+func sameForm(a *Form, b *Form) bool {
+	return a.URL != nil && b.URL != nil && a.URL.String() == b.URL.String()
+}
+
+// formStarted reports whether the user answered a field of form.
+func formStarted(form *Form) bool {
+	if form.Reviewing || form.Correcting {
+		return true
+	}
+	for i := 0; i < form.Idx && i < len(form.Fields); i++ {
+		if !form.Fields[i].Hidden {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *FormEngine) sendResumeOffer(ctx context.Context, chatID int64, form *Form) error {
+	resume := *form.Resume
+	if resume.Message == "" {
+		resume.Message = "You have an unfinished draft of this form."
+	}
+	if resume.ContinueLabel == "" {
+		resume.ContinueLabel = "Continue"
+	}
+	if resume.RestartLabel == "" {
+		resume.RestartLabel = "Start over"
+	}
+	if err := e.connector.SendMessage(ctx, chatID, &Message{
+		Text:      resume.Message,
+		ParseMode: "HTML",
+		ButtonGrid: [][]Button{{
+			{Label: resume.ContinueLabel, CallbackData: CallbackPrefixForm + ":" + formActionResume},
+			{Label: resume.RestartLabel, CallbackData: CallbackPrefixForm + ":" + formActionRestart},
+		}},
+	}); err != nil {
+		return errors.Wrap(err, "failed to send draft offer")
+	}
+	return nil
+}
+
+// next returns the index of the first shown field from idx on, or len(form.Fields) when there is none.
+// During the review and while correcting a field only fields that were hidden before are asked for, as
+// the others have a value.
+func (e *FormEngine) next(ctx context.Context, chatID int64, form *Form, idx int) (int, error) {
+	for ; idx < len(form.Fields); idx++ {
+		wasHidden := form.Fields[idx].Hidden
+		shown, err := e.shown(ctx, chatID, form, idx)
+		if err != nil {
+			return 0, err
+		}
+		if shown && (!(form.Reviewing || form.Correcting) || wasHidden) {
+			return idx, nil
+		}
+	}
+	return idx, nil
+}
+
+// shown evaluates the condition of the field at idx against the values before it and records the
+// result in Hidden.
+func (e *FormEngine) shown(ctx context.Context, chatID int64, form *Form, idx int) (bool, error) {
+	field := &form.Fields[idx]
+	if !field.Conditional {
+		return true, nil
+	}
+	filter, ok := e.handler().(FormFieldFilter)
+	if !ok {
+		return false, errors.Errorf("handler cannot evaluate the condition of form field %q", field.ID)
+	}
+	show, err := filter.ShowFormField(ctx, chatID, form.URL, field.ID, formValues(form, idx))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to evaluate the condition of form field %q", field.ID)
+	}
+	field.Hidden = !show
+	return show, nil
+}
+
+func (e *FormEngine) submit(ctx context.Context, chatID int64, form *Form) error {
+	if err := e.Clear(ctx, chatID); err != nil {
+		return err
+	}
+	data, values, err := FormSubmitCallbackData(form)
+	if err != nil {
+		return err
+	}
+	if err := e.connector.SendCallbackData(WithFormValues(ctx, values), chatID, data); err != nil {
+		return errors.Wrap(err, "failed to submit form data")
+	}
+	return nil
+}
+
+func (e *FormEngine) sendFieldTip(ctx context.Context, chatID int64, form *Form) error {
+	field := form.Fields[form.Idx]
+	if field.Input == nil {
+		return errors.Errorf("field has no type, should have `input`: %+v", field)
+	}
+	message := &Message{Text: field.Input.Tip, ParseMode: "HTML"}
+	if current := field.Input.Default; current != "" {
+		currentLabel := form.CurrentLabel
+		if currentLabel == "" {
+			currentLabel = "Current:"
+		}
+		keepLabel := form.KeepLabel
+		if keepLabel == "" {
+			keepLabel = "Keep current"
+		}
+		message.Text += fmt.Sprintf("\n\n%s <code>%s</code>", currentLabel, html.EscapeString(current))
+		message.ButtonGrid = [][]Button{{{
+			Label:        keepLabel,
+			CallbackData: CallbackFormKeep,
+		}}}
+	}
+	if err := e.connector.SendMessage(ctx, chatID, message); err != nil {
+		return errors.Wrap(err, "failed to send form field prompt")
+	}
+	return nil
+}
+
+func (e *FormEngine) sendReview(ctx context.Context, chatID int64, form *Form) error {
+	if err := e.connector.SendMessage(ctx, chatID, FormReviewMessage(form)); err != nil {
+		return errors.Wrap(err, "failed to send form review")
+	}
+	return nil
+}
+
+// FormReviewMessage lists the values of form with a button to change each field and a submit button.
+func FormReviewMessage(form *Form) *Message {
+	review := FormReview{}
+	if form.Review != nil {
+		review = *form.Review
+	}
+	if review.Title == "" {
+		review.Title = "Please review your answers:"
+	}
+	if review.EditLabel == "" {
+		review.EditLabel = "Edit"
+	}
+	if review.SubmitLabel == "" {
+		review.SubmitLabel = "Submit"
+	}
+	var sb strings.Builder
+	sb.WriteString(review.Title)
+	grid := make([][]Button, 0, len(form.Fields)+1)
+	for i, field := range form.Fields {
+		if field.Hidden {
+			continue
+		}
+		label := field.Label
+		if label == "" {
+			label = field.ID
+		}
+		value := ""
+		if field.Input != nil {
+			value = field.Input.Value
+		}
+		fmt.Fprintf(&sb, "\n<b>%s</b>: %s", label, html.EscapeString(value))
+		grid = append(grid, []Button{{
+			Label:        review.EditLabel + " " + label,
+			CallbackData: fmt.Sprintf("%s:%s:%d", CallbackPrefixForm, formActionEdit, i),
+		}})
+	}
+	grid = append(grid, []Button{{
+		Label:        review.SubmitLabel,
+		CallbackData: CallbackPrefixForm + ":" + formActionSubmit,
+	}})
+	return &Message{Text: sb.String(), ParseMode: "HTML", ButtonGrid: grid}
+}
+
+func setFormValue(field *FormField, value string) {
+	if field.Input == nil {
+		field.Input = &FormFieldInput{}
+	}
+	field.Input.Value = value
+}
+
+// formValues returns the values of the shown fields before idx.
+func formValues(form *Form, idx int) FormValues {
+	values := make(FormValues)
+	for _, field := range form.Fields[:idx] {
+		if field.Input != nil && !field.Hidden {
+			values[field.ID] = field.Input.Value
+		}
+	}
+	return values
+}
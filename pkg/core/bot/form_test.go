@@ -0,0 +1,526 @@
+package bot_test
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/telegramtest"
+)
+
+// reviewTestHandler sends a two-field form with a review step.
+type reviewTestHandler struct {
+	testHandler
+}
+
+func (h *reviewTestHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if values, ok := bot.FormValuesFromContext(ctx); ok && strings.HasPrefix(data, bot.CallbackPrefixSubmit+":") {
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: "added " + values["title"] + " " + values["note"]})
+	}
+	u, _ := url.Parse("/add")
+	return b.SendForm(ctx, chatID, &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{
+			{
+				ID:        "title",
+				Label:     "Title",
+				Input:     &bot.FormFieldInput{Tip: "Enter a title"},
+				Validator: func() *string { v := "title"; return &v }(),
+			},
+			{ID: "note", Label: "Note", Input: &bot.FormFieldInput{Tip: "Enter a note"}},
+		},
+		Review: &bot.FormReview{SubmitLabel: "Save"},
+	})
+}
+
+func TestFormReview(t *testing.T) {
+	server := newTelegramTestBot(t, &reviewTestHandler{})
+	// every step waits for its reply, as updates are not ordered across the poll
+	step := func(n int, do func() error) []telegramtest.Message {
+		t.Helper()
+		if err := do(); err != nil {
+			t.Fatal(err)
+		}
+		messages, err := server.WaitMessages(7, n, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messages
+	}
+	text := func(text string) func() error {
+		return func() error {
+			server.SendText(7, text)
+			return nil
+		}
+	}
+	click := func(label string) func() error {
+		return func() error { return server.Click(7, label) }
+	}
+
+	step(1, text("hi"))
+	step(2, click("Add"))
+	step(3, text("milk"))
+	messages := step(4, text("<2l>"))
+	review := messages[3]
+	if review.Text != "Please review your answers:\n<b>Title</b>: milk\n<b>Note</b>: &lt;2l&gt;" {
+		t.Fatalf("unexpected review: %+v", review)
+	}
+	if len(review.Buttons) != 3 || review.Buttons[1][0].Text != "Edit Note" || review.Buttons[2][0].Text != "Save" {
+		t.Fatalf("unexpected review buttons: %+v", review.Buttons)
+	}
+
+	// Editing a field asks for it again and returns to the review.
+	if messages = step(5, click("Edit Title")); messages[4].Text != "Enter a title" {
+		t.Fatalf("expected the title prompt, got %+v", messages[4])
+	}
+	if messages = step(6, text(" ")); messages[5].Text != "title is required" {
+		t.Fatalf("expected a validation error, got %+v", messages[5])
+	}
+	if messages = step(7, text("eggs")); !strings.Contains(messages[6].Text, "<b>Title</b>: eggs") {
+		t.Fatalf("expected the updated review, got %+v", messages[6])
+	}
+	if messages = step(8, click("Save")); messages[7].Text != "added eggs <2l>" {
+		t.Fatalf("expected the submit reply, got %+v", messages[7])
+	}
+
+	// The form is gone once submitted, so its buttons are stale.
+	messages = step(9, func() error { return server.ClickData(7, "_form:submit") })
+	if !strings.HasPrefix(messages[8].Text, "error: ") {
+		t.Fatalf("expected an error for a stale review button, got %+v", messages[8])
+	}
+}
+
+// deliveryTestHandler asks for an address only when the order is delivered.
+type deliveryTestHandler struct {
+	testHandler
+}
+
+func (h *deliveryTestHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if values, ok := bot.FormValuesFromContext(ctx); ok && strings.HasPrefix(data, bot.CallbackPrefixSubmit+":") {
+		raw, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: "ordered " + string(raw)})
+	}
+	u, _ := url.Parse("/order")
+	return b.SendForm(ctx, chatID, &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{
+			{ID: "delivery", Label: "Delivery", Input: &bot.FormFieldInput{Tip: "Deliver?"}},
+			{ID: "address", Label: "Address", Input: &bot.FormFieldInput{Tip: "Where to?"}, Conditional: true},
+			{ID: "note", Label: "Note", Input: &bot.FormFieldInput{Tip: "Any note?"}},
+		},
+		Review: &bot.FormReview{},
+	})
+}
+
+func (h *deliveryTestHandler) ShowFormField(_ context.Context, _ int64, _ *url.URL, field string, values bot.FormValues) (bool, error) {
+	return field != "address" || values["delivery"] == "yes", nil
+}
+
+func TestFormConditionalFields(t *testing.T) {
+	server := newTelegramTestBot(t, &deliveryTestHandler{})
+	step := func(n int, do func()) telegramtest.Message {
+		t.Helper()
+		do()
+		messages, err := server.WaitMessages(7, n, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messages[n-1]
+	}
+	text := func(text string) func() {
+		return func() { server.SendText(7, text) }
+	}
+	click := func(label string) func() {
+		return func() {
+			if err := server.Click(7, label); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	step(1, text("hi"))
+	step(2, click("Add"))
+	if message := step(3, text("no")); message.Text != "Any note?" {
+		t.Fatalf("expected the hidden address to be skipped, got %+v", message)
+	}
+	review := step(4, text("ring twice"))
+	if review.Text != "Please review your answers:\n<b>Delivery</b>: no\n<b>Note</b>: ring twice" || len(review.Buttons) != 3 {
+		t.Fatalf("expected the hidden address to be left out of the review, got %+v", review)
+	}
+
+	// Changing the answer shows the address, which is asked for before returning to the review.
+	step(5, click("Edit Delivery"))
+	if message := step(6, text("yes")); message.Text != "Where to?" {
+		t.Fatalf("expected the address prompt, got %+v", message)
+	}
+	if review = step(7, text("Main St")); !strings.Contains(review.Text, "<b>Address</b>: Main St") {
+		t.Fatalf("expected the address in the review, got %+v", review)
+	}
+	step(8, click("Edit Delivery"))
+	step(9, text("no"))
+	if message := step(10, click("Submit")); message.Text != `ordered {"delivery":"no","note":"ring twice"}` {
+		t.Fatalf("expected the hidden address to be left out of the values, got %+v", message)
+	}
+}
+
+// renameTestHandler renames a todo whose title is current, "milk & eggs" by default.
+type renameTestHandler struct {
+	testHandler
+	current   string
+	validator *string
+}
+
+func (h *renameTestHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if values, ok := bot.FormValuesFromContext(ctx); ok && strings.HasPrefix(data, bot.CallbackPrefixSubmit+":") {
+		raw, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: "renamed " + string(raw)})
+	}
+	u, _ := url.Parse("/rename")
+	return b.SendForm(ctx, chatID, &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{{
+			ID:        "title",
+			Input:     &bot.FormFieldInput{Tip: "New title?", Default: cmp.Or(h.current, "milk & eggs")},
+			Validator: h.validator,
+		}},
+		KeepLabel: "Keep",
+	})
+}
+
+func TestFormKeepCurrentValue(t *testing.T) {
+	server := newTelegramTestBot(t, &renameTestHandler{})
+	server.SendText(7, "hi")
+	if _, err := server.WaitMessages(7, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Click(7, "Add"); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := server.WaitMessages(7, 2, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt := messages[1]
+	if prompt.Text != "New title?\n\nCurrent: <code>milk &amp; eggs</code>" {
+		t.Fatalf("expected the current value in the prompt, got %+v", prompt)
+	}
+	if len(prompt.Buttons) != 1 || prompt.Buttons[0][0].CallbackData != bot.CallbackFormKeep {
+		t.Fatalf("expected a keep button, got %+v", prompt.Buttons)
+	}
+
+	if err := server.Click(7, "Keep"); err != nil {
+		t.Fatal(err)
+	}
+	if messages, err = server.WaitMessages(7, 3, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if messages[2].Text != `renamed {"title":"milk \u0026 eggs"}` {
+		t.Fatalf("expected the current value to be submitted, got %+v", messages[2])
+	}
+}
+
+func TestFormKeepValidatesCurrentValue(t *testing.T) {
+	server := newTelegramTestBot(t, &renameTestHandler{current: " ", validator: bot.Ptr("title")})
+	server.SendText(7, "hi")
+	if _, err := server.WaitMessages(7, 1, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Click(7, "Add"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.WaitMessages(7, 2, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := server.Click(7, "Keep"); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := server.WaitMessages(7, 3, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if messages[2].Text != "title is required" {
+		t.Fatalf("expected the current value to be rejected, got %+v", messages[2])
+	}
+	server.SendText(7, "eggs")
+	if messages, err = server.WaitMessages(7, 4, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if messages[3].Text != `renamed {"title":"eggs"}` {
+		t.Fatalf("expected the typed value to be submitted, got %+v", messages[3])
+	}
+}
+
+// routeRecorder records the callbacks it receives.
+type routeRecorder struct {
+	testHandler
+	callbacks []string
+}
+
+func (h *routeRecorder) HandleCallbackData(ctx context.Context, data string, _ int64, _ bot.BotConnector) error {
+	if values, ok := bot.FormValuesFromContext(ctx); ok {
+		raw, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+		data += " " + string(raw)
+	}
+	h.callbacks = append(h.callbacks, data)
+	return nil
+}
+
+func newFormEngineTest(t *testing.T, handler bot.BotxHandler) (*bot.FormEngine, *recordingFrontend, *time.Time) {
+	t.Helper()
+	sm := newTestSessionManager(t)
+	frontend := &recordingFrontend{}
+	cliBot, err := bot.NewCLIBot(sm, frontend)
+	if err != nil {
+		t.Fatalf("cli bot: %v", err)
+	}
+	cliBot.RegisterBotxHandler(handler)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine := bot.NewFormEngine(cliBot, sm, "__test_form", func() bot.BotxHandler { return handler }, bot.WithFormClock(func() time.Time { return now }))
+	return engine, frontend, &now
+}
+
+func draftTestForm(path string) *bot.Form {
+	u, _ := url.Parse(path)
+	return &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{
+			{ID: "title", Input: &bot.FormFieldInput{Tip: "Title?"}},
+			{ID: "note", Input: &bot.FormFieldInput{Tip: "Note?"}},
+		},
+		Timeout: &bot.FormTimeout{After: 10 * time.Minute},
+		Resume:  &bot.FormResume{},
+	}
+}
+
+func TestFormTimeout(t *testing.T) {
+	ctx := context.Background()
+	handler := &routeRecorder{}
+	engine, frontend, now := newFormEngineTest(t, handler)
+	form := draftTestForm("/add")
+	form.Resume = nil
+	if err := engine.Start(ctx, 1, form); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(5 * time.Minute)
+	if _, err := engine.HandleText(ctx, 1, "milk"); err != nil {
+		t.Fatal(err)
+	}
+	if frontend.last(1) != "Note?" {
+		t.Fatalf("expected the next field before the timeout, got %q", frontend.last(1))
+	}
+
+	*now = now.Add(10 * time.Minute)
+	result, err := engine.HandleText(ctx, 1, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != nil {
+		t.Fatalf("expected the text to be left to the handler, got %+v", result)
+	}
+	if frontend.last(1) != "This form has expired." {
+		t.Fatalf("expected the timeout notice, got %q", frontend.last(1))
+	}
+	if pending, err := engine.Pending(ctx, 1); err != nil || pending != nil {
+		t.Fatalf("expected the form to be dropped, got %+v, %v", pending, err)
+	}
+
+	form = draftTestForm("/add")
+	form.Resume = nil
+	form.Timeout.Route = "/"
+	if err := engine.Start(ctx, 1, form); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Hour)
+	if _, err := engine.HandleText(ctx, 1, "milk"); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.callbacks) != 1 || handler.callbacks[0] != bot.RouteCallbackData("/") {
+		t.Fatalf("expected the timeout route, got %v", handler.callbacks)
+	}
+}
+
+// signedRouteRecorder checks callbacks like a generated handler with a CallbackSigner.
+type signedRouteRecorder struct {
+	routeRecorder
+	signer *bot.CallbackSigner
+}
+
+func (h *signedRouteRecorder) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	data, err := bot.NewBot(b, bot.WithCallbackSigner(h.signer)).VerifyCallbackData(ctx, chatID, data)
+	if err != nil {
+		return err
+	}
+	return h.routeRecorder.HandleCallbackData(ctx, data, chatID, b)
+}
+
+func TestFormTimeoutRouteWithSigner(t *testing.T) {
+	ctx := context.Background()
+	signer, err := bot.NewCallbackSigner([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := &signedRouteRecorder{signer: signer}
+	engine, _, now := newFormEngineTest(t, handler)
+	form := draftTestForm("/add")
+	form.Resume = nil
+	form.Timeout.Route = "/"
+	if err := engine.Start(ctx, 1, form); err != nil {
+		t.Fatal(err)
+	}
+	*now = now.Add(time.Hour)
+	if _, err := engine.HandleText(ctx, 1, "milk"); err != nil {
+		t.Fatalf("expected the timeout route to pass verification, got %v", err)
+	}
+	if len(handler.callbacks) != 1 || handler.callbacks[0] != bot.RouteCallbackData("/") {
+		t.Fatalf("expected the timeout route, got %v", handler.callbacks)
+	}
+
+	// the exemption does not reach callbacks sent by the client
+	if _, err := bot.NewBot(nil, bot.WithCallbackSigner(signer)).VerifyCallbackData(ctx, 1, bot.RouteCallbackData("/")); !errors.Is(err, bot.ErrBadRequest) {
+		t.Fatalf("expected an unsigned route to be rejected, got %v", err)
+	}
+}
+
+func TestFormResumeDraft(t *testing.T) {
+	ctx := context.Background()
+	engine, frontend, now := newFormEngineTest(t, &routeRecorder{})
+	if err := engine.Start(ctx, 1, draftTestForm("/add")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.HandleText(ctx, 1, "milk"); err != nil {
+		t.Fatal(err)
+	}
+
+	// another form replaces the started one, which is kept as a draft
+	other := draftTestForm("/other")
+	if err := engine.Start(ctx, 1, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Start(ctx, 1, draftTestForm("/add")); err != nil {
+		t.Fatal(err)
+	}
+	offer := frontend.messages[1][len(frontend.messages[1])-1]
+	if offer.Text != "You have an unfinished draft of this form." || len(offer.ButtonGrid) != 1 || len(offer.ButtonGrid[0]) != 2 {
+		t.Fatalf("expected the draft offer, got %+v", offer)
+	}
+	if _, err := engine.HandleText(ctx, 1, "eggs"); err != nil {
+		t.Fatal(err)
+	}
+	if frontend.last(1) != offer.Text {
+		t.Fatalf("expected the offer again, got %q", frontend.last(1))
+	}
+	if ok, err := engine.HandleCallback(ctx, 1, offer.ButtonGrid[0][0].CallbackData); !ok || err != nil {
+		t.Fatalf("continue: %v, %v", ok, err)
+	}
+	if frontend.last(1) != "Note?" {
+		t.Fatalf("expected the draft to continue at the note, got %q", frontend.last(1))
+	}
+	form, err := engine.Pending(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Fields[0].Input.Value != "milk" {
+		t.Fatalf("expected the draft values, got %+v", form.Fields[0].Input)
+	}
+
+	// an expired draft is offered too, and can be started over
+	*now = now.Add(time.Hour)
+	if _, err := engine.HandleText(ctx, 1, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Start(ctx, 1, draftTestForm("/add")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := engine.HandleCallback(ctx, 1, offer.ButtonGrid[0][1].CallbackData); !ok || err != nil {
+		t.Fatalf("restart: %v, %v", ok, err)
+	}
+	if frontend.last(1) != "Title?" {
+		t.Fatalf("expected the form to start over, got %q", frontend.last(1))
+	}
+	if form, err = engine.Pending(ctx, 1); err != nil || form.Fields[0].Input.Value != "" {
+		t.Fatalf("expected the values to be reset, got %+v, %v", form, err)
+	}
+}
+
+// rangeTestHandler checks that the end of a range is after its start.
+type rangeTestHandler struct {
+	routeRecorder
+	fields []string
+}
+
+func (h *rangeTestHandler) ValidateFormField(_ context.Context, _ int64, _ *url.URL, _ string, field string, input string, values bot.FormValues) (*bot.ValidateResult, error) {
+	h.fields = append(h.fields, field+"="+input+" after "+values["from"])
+	return &bot.ValidateResult{Valid: true}, nil
+}
+
+func (h *rangeTestHandler) ValidateFormValues(_ context.Context, _ int64, _ *url.URL, values bot.FormValues) (*bot.ValidateResult, error) {
+	if values["to"] <= values["from"] {
+		return &bot.ValidateResult{ErrorMessage: "the end must be after the start", Field: "to"}, nil
+	}
+	return &bot.ValidateResult{Valid: true}, nil
+}
+
+func TestFormCrossFieldValidation(t *testing.T) {
+	ctx := context.Background()
+	handler := &rangeTestHandler{}
+	engine, frontend, _ := newFormEngineTest(t, handler)
+	validator := "date"
+	u, _ := url.Parse("/range")
+	form := &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{
+			{ID: "from", Input: &bot.FormFieldInput{Tip: "From?"}, Validator: &validator},
+			{ID: "to", Input: &bot.FormFieldInput{Tip: "To?"}, Validator: &validator},
+			{ID: "note", Input: &bot.FormFieldInput{Tip: "Note?"}},
+		},
+	}
+	if err := engine.Start(ctx, 1, form); err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"2024-05-02", "2024-05-01", "trip"} {
+		if _, err := engine.HandleText(ctx, 1, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(handler.fields, ",") != "from=2024-05-02 after ,to=2024-05-01 after 2024-05-02" {
+		t.Fatalf("unexpected validator calls: %v", handler.fields)
+	}
+	messages := frontend.messages[1]
+	if messages[len(messages)-2].Text != "the end must be after the start" || frontend.last(1) != "To?" {
+		t.Fatalf("expected to be sent back to the end, got %q, %q", messages[len(messages)-2].Text, frontend.last(1))
+	}
+	if pending, err := engine.Pending(ctx, 1); err != nil || !pending.Correcting || pending.Reviewing {
+		t.Fatalf("expected the form to be correcting without a review, got %+v, %v", pending, err)
+	}
+	// the form has no review, so the review buttons do not apply
+	if _, err := engine.HandleCallback(ctx, 1, bot.CallbackPrefixForm+":submit"); !errors.Is(err, bot.ErrBadRequest) {
+		t.Fatalf("expected the submit button to be rejected, got %v", err)
+	}
+
+	// only the field sent back to is asked again
+	if _, err := engine.HandleText(ctx, 1, "2024-05-03"); err != nil {
+		t.Fatal(err)
+	}
+	if len(handler.callbacks) != 1 || !strings.Contains(handler.callbacks[0], "note") || !strings.Contains(handler.callbacks[0], "2024-05-03") {
+		t.Fatalf("expected the fixed form to be submitted, got %v", handler.callbacks)
+	}
+	if pending, err := engine.Pending(ctx, 1); err != nil || pending != nil {
+		t.Fatalf("expected no pending form, got %+v, %v", pending, err)
+	}
+}
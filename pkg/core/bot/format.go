@@ -0,0 +1,71 @@
+package bot
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Helpers shared by connectors that translate Telegram parse modes into another platform's markup.
+
+var (
+	htmlLinkPattern     = regexp.MustCompile(`(?is)<a\s+href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlTagPattern      = regexp.MustCompile(`(?s)<[^>]+>`)
+	markdownLinkPattern = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
+	htmlMarkerReplacer  = strings.NewReplacer(
+		"<b>", "*", "</b>", "*", "<strong>", "*", "</strong>", "*",
+		"<i>", "_", "</i>", "_", "<em>", "_", "</em>", "_",
+		"<s>", "~", "</s>", "~", "<strike>", "~", "</strike>", "~", "<del>", "~", "</del>", "~",
+		"<pre>", "```", "</pre>", "```", "<code>", "`", "</code>", "`",
+	)
+)
+
+// htmlToMarkers maps Telegram HTML tags onto `*bold*`, `_italic_`, `~strike~` and backtick markers, drops
+// every other tag and unescapes entities.
+func htmlToMarkers(text string) string {
+	text = htmlMarkerReplacer.Replace(text)
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	return html.UnescapeString(text)
+}
+
+// replaceHTMLLinks rewrites every `<a href>` with the result of fn, given the unescaped href and the label
+// stripped of tags.
+func replaceHTMLLinks(text string, fn func(href string, label string) string) string {
+	return htmlLinkPattern.ReplaceAllStringFunc(text, func(match string) string {
+		parts := htmlLinkPattern.FindStringSubmatch(match)
+		return fn(html.UnescapeString(parts[1]), stripHTML(parts[2]))
+	})
+}
+
+func stripHTML(text string) string {
+	return html.UnescapeString(htmlTagPattern.ReplaceAllString(text, ""))
+}
+
+func unescapeMarkdownV2(text string) string {
+	var sb strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+1 < len(text) {
+			i++
+		}
+		sb.WriteByte(text[i])
+	}
+	return sb.String()
+}
+
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-1]) + "…"
+}
+
+func chunkRunes(text string, size int) []string {
+	runes := []rune(text)
+	var chunks []string
+	for len(runes) > size {
+		chunks = append(chunks, string(runes[:size]))
+		runes = runes[size:]
+	}
+	return append(chunks, string(runes))
+}
@@ -0,0 +1,72 @@
+package bot_test
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/session"
+)
+
+// testHandler greets text messages with an Add button, which opens a one-field form, and reports errors as
+// messages. Connector tests embed it and override what they need.
+type testHandler struct{}
+
+func (h *testHandler) HandleTextMessage(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	return b.SendMessage(ctx, chatID, &bot.Message{
+		Text:       "<b>Hello</b> " + data,
+		ParseMode:  "HTML",
+		ButtonGrid: [][]bot.Button{{{Label: "Add", CallbackData: bot.RouteCallbackData("/add")}}},
+	})
+}
+
+func (h *testHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if values, ok := bot.FormValuesFromContext(ctx); ok && strings.HasPrefix(data, bot.CallbackPrefixSubmit+":") {
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: "added " + values["title"]})
+	}
+	u, _ := url.Parse("/add")
+	return b.SendForm(ctx, chatID, &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{{
+			ID:        "title",
+			Label:     "Title",
+			Input:     &bot.FormFieldInput{Tip: "Enter a title"},
+			Validator: func() *string { v := "title"; return &v }(),
+		}},
+	})
+}
+
+func (h *testHandler) HandleError(ctx context.Context, err error, chatID int64, b bot.BotConnector) error {
+	return b.SendMessage(ctx, chatID, &bot.Message{Text: "error: " + err.Error()})
+}
+
+func (h *testHandler) Validate(_ context.Context, _ int64, _ *url.URL, _ string, input string) (*bot.ValidateResult, error) {
+	if strings.TrimSpace(input) == "" {
+		return &bot.ValidateResult{ErrorMessage: "title is required"}, nil
+	}
+	return &bot.ValidateResult{Valid: true}, nil
+}
+
+func newTestSessionManager(t *testing.T) session.SessionManager {
+	t.Helper()
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		t.Fatalf("session manager: %v", err)
+	}
+	return sm
+}
+
+// blockingTestHandler reports each text message on started and holds it until release is closed.
+type blockingTestHandler struct {
+	testHandler
+	started chan string
+	release chan struct{}
+}
+
+func (h *blockingTestHandler) HandleTextMessage(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	h.started <- data
+	<-h.release
+	return h.testHandler.HandleTextMessage(ctx, data, chatID, b)
+}
@@ -7,17 +7,36 @@ import (
 	"github.com/pkg/errors"
 )
 
+// DefaultMaxHistory is the number of history entries a router keeps unless WithMaxHistory is given.
+const DefaultMaxHistory = 50
+
 // Router is a wrapper of session store to manage routing history
 type Router struct {
-	chatID int64
-	sess   session.Session
+	chatID     int64
+	sess       session.Session
+	maxHistory int
 }
 
-func CreateRouter(ctx context.Context, chatID int64, sess session.Session) (*Router, error) {
-	if err := sess.Set(ctx, SessionKeyRouterHist, []string{"/"}); err != nil {
+type RouterOption func(*Router)
+
+// WithMaxHistory keeps at most n history entries, dropping the oldest ones first.
+func WithMaxHistory(n int) RouterOption {
+	return func(r *Router) {
+		if n > 0 {
+			r.maxHistory = n
+		}
+	}
+}
+
+func CreateRouter(ctx context.Context, chatID int64, sess session.Session, opts ...RouterOption) (*Router, error) {
+	r := &Router{chatID: chatID, sess: sess, maxHistory: DefaultMaxHistory}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if err := r.setHistory(ctx, []string{"/"}); err != nil {
 		return nil, errors.Wrap(err, "failed to create router history")
 	}
-	return &Router{chatID: chatID, sess: sess}, nil
+	return r, nil
 }
 
 func (r *Router) History(ctx context.Context) ([]string, error) {
@@ -29,6 +48,9 @@ func (r *Router) History(ctx context.Context) ([]string, error) {
 }
 
 func (r *Router) Push(ctx context.Context, url string) error {
+	if err := r.clearAction(ctx); err != nil {
+		return err
+	}
 	hist, err := r.History(ctx)
 	if err != nil {
 		return errors.Wrap(err, "failed to get router history")
@@ -37,25 +59,104 @@ func (r *Router) Push(ctx context.Context, url string) error {
 		return nil
 	}
 
-	hist = append(hist, url)
-	if err := r.sess.Set(ctx, SessionKeyRouterHist, hist); err != nil {
-		return errors.Wrap(err, "failed to set router history")
+	return r.setHistory(ctx, append(hist, url))
+}
+
+// Replace puts url in place of the last history entry, so going back skips the page on screen.
+func (r *Router) Replace(ctx context.Context, url string) error {
+	if err := r.clearAction(ctx); err != nil {
+		return err
+	}
+	hist, err := r.History(ctx)
+	if err != nil {
+		return errors.Wrap(err, "failed to get router history")
+	}
+	if len(hist) > 0 {
+		hist = hist[: len(hist)-1 : len(hist)-1]
+	}
+	return r.setHistory(ctx, append(hist, url))
+}
+
+// Reset clears the history and starts it over at url.
+func (r *Router) Reset(ctx context.Context, url string) error {
+	if err := r.clearAction(ctx); err != nil {
+		return err
+	}
+	return r.setHistory(ctx, []string{url})
+}
+
+// Current returns the last history entry: the page on screen, or the page an action on screen was run
+// from. It is the page to render again, e.g. after a language switch.
+func (r *Router) Current(ctx context.Context) (string, error) {
+	hist, err := r.History(ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get router history")
+	}
+	if len(hist) == 0 {
+		return "/", nil
+	}
+	return hist[len(hist)-1], nil
+}
+
+// PushAction records url, the route of an action page, as the page on screen without adding it to the
+// history, so going back or re-rendering never runs the action again.
+func (r *Router) PushAction(ctx context.Context, url string) error {
+	if err := r.sess.Set(ctx, SessionKeyRouterAction, url); err != nil {
+		return errors.Wrap(err, "failed to set router action")
 	}
 	return nil
 }
 
+// Back leaves the page on screen and returns the route to render instead. Leaving an action returns to
+// the page it was run from; otherwise the last history entry is dropped.
 func (r *Router) Back(ctx context.Context) (string, error) {
+	onAction, err := r.onAction(ctx)
+	if err != nil {
+		return "", err
+	}
 	hist, err := r.History(ctx)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to get router history")
 	}
+	if onAction {
+		if err := r.clearAction(ctx); err != nil {
+			return "", err
+		}
+		return hist[len(hist)-1], nil
+	}
 	if len(hist) > 1 {
-		last := hist[len(hist)-1]
 		hist = hist[:len(hist)-1]
-		if err := r.sess.Set(ctx, SessionKeyRouterHist, hist); err != nil {
-			return "", errors.Wrap(err, "failed to set router history")
+		if err := r.setHistory(ctx, hist); err != nil {
+			return "", err
 		}
-		return last, nil
+		return hist[len(hist)-1], nil
 	}
 	return "/", nil
 }
+
+func (r *Router) setHistory(ctx context.Context, hist []string) error {
+	if r.maxHistory > 0 && len(hist) > r.maxHistory {
+		hist = hist[len(hist)-r.maxHistory:]
+	}
+	if err := r.sess.Set(ctx, SessionKeyRouterHist, hist); err != nil {
+		return errors.Wrap(err, "failed to set router history")
+	}
+	return nil
+}
+
+func (r *Router) onAction(ctx context.Context) (bool, error) {
+	if _, err := r.sess.Get(ctx, SessionKeyRouterAction); err != nil {
+		if errors.Is(err, session.ErrKeyNotFound) {
+			return false, nil
+		}
+		return false, errors.Wrap(err, "failed to get router action")
+	}
+	return true, nil
+}
+
+func (r *Router) clearAction(ctx context.Context) error {
+	if err := r.sess.Delete(ctx, SessionKeyRouterAction); err != nil {
+		return errors.Wrap(err, "failed to clear router action")
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package bot_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+)
+
+func newTestRouter(t *testing.T) *bot.Router {
+	t.Helper()
+	ctx := context.Background()
+	sm := newTestSessionManager(t)
+	sess, err := sm.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := bot.CreateRouter(ctx, 1, sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestRouterBack(t *testing.T) {
+	ctx := context.Background()
+	router := newTestRouter(t)
+	for _, route := range []string{"/todo", "/todo/1"} {
+		if err := router.Push(ctx, route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, want := range []string{"/todo", "/", "/"} {
+		got, err := router.Back(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("got back to %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRouterActionsSkipHistory(t *testing.T) {
+	ctx := context.Background()
+	router := newTestRouter(t)
+	if err := router.Push(ctx, "/todo/1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.PushAction(ctx, "/todo/1/toggle"); err != nil {
+		t.Fatal(err)
+	}
+	hist, err := router.History(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(hist, []string{"/", "/todo/1"}) {
+		t.Fatalf("expected the action to stay out of the history, got %v", hist)
+	}
+
+	// leaving the action returns to the page it was run from
+	got, err := router.Back(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "/todo/1" {
+		t.Fatalf("got back to %q, want /todo/1", got)
+	}
+	if got, _ := router.Back(ctx); got != "/" {
+		t.Fatalf("got back to %q, want /", got)
+	}
+
+	// a page pushed after an action leaves it behind
+	if err := router.PushAction(ctx, "/todo/1/toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Push(ctx, "/todo/2"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := router.Back(ctx); got != "/" {
+		t.Fatalf("got back to %q, want /", got)
+	}
+}
+
+func TestRouterReplaceAndReset(t *testing.T) {
+	ctx := context.Background()
+	router := newTestRouter(t)
+	for _, route := range []string{"/todo", "/todo?page=1"} {
+		if err := router.Push(ctx, route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := router.Replace(ctx, "/todo?page=2"); err != nil {
+		t.Fatal(err)
+	}
+	if current, _ := router.Current(ctx); current != "/todo?page=2" {
+		t.Fatalf("got current route %q, want /todo?page=2", current)
+	}
+	if got, _ := router.Back(ctx); got != "/todo" {
+		t.Fatalf("got back to %q, want /todo", got)
+	}
+
+	if err := router.PushAction(ctx, "/todo/1/toggle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.Reset(ctx, "/"); err != nil {
+		t.Fatal(err)
+	}
+	hist, err := router.History(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(hist, []string{"/"}) {
+		t.Fatalf("expected reset to start the history over, got %v", hist)
+	}
+	// the action was cleared with the history, so going back stays home
+	if got, _ := router.Back(ctx); got != "/" {
+		t.Fatalf("got back to %q, want /", got)
+	}
+}
+
+func TestRouterMaxHistory(t *testing.T) {
+	ctx := context.Background()
+	sm := newTestSessionManager(t)
+	sess, err := sm.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := bot.CreateRouter(ctx, 1, sess, bot.WithMaxHistory(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range []string{"/a", "/b", "/c", "/d"} {
+		if err := router.Push(ctx, route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hist, err := router.History(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(hist, []string{"/b", "/c", "/d"}) {
+		t.Fatalf("expected the oldest entries to be dropped, got %v", hist)
+	}
+}
@@ -0,0 +1,120 @@
+package bot
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// callbackSignatureSep separates a callback from its signature, e.g. `_route:/todo/5/delete~<signature>`.
+// The base64url signature never contains it.
+const callbackSignatureSep = "~"
+
+// callbackSignatureLen is the number of HMAC bytes kept, short enough for the 64 bytes of Telegram
+// callback data.
+const callbackSignatureLen = 8
+
+// minCallbackKeyLen is the shortest key NewCallbackSigner accepts.
+const minCallbackKeyLen = 16
+
+// CallbackSigner signs route and submit callbacks with an HMAC key, so a client can only send back the
+// callbacks of the buttons the bot sent to its chat. Other callbacks, such as `lang:` or the `_form:`
+// and `_confirm:` callbacks bound to the session, are left as they are.
+type CallbackSigner struct {
+	key []byte
+}
+
+func NewCallbackSigner(key []byte) (*CallbackSigner, error) {
+	if len(key) < minCallbackKeyLen {
+		return nil, errors.Errorf("callback key must have at least %d bytes", minCallbackKeyLen)
+	}
+	return &CallbackSigner{key: append([]byte(nil), key...)}, nil
+}
+
+func isSignedCallback(data string) bool {
+	return strings.HasPrefix(data, CallbackPrefixRoute+":") || strings.HasPrefix(data, CallbackPrefixSubmit+":")
+}
+
+// Sign appends the signature of data in chatID to route and submit callbacks.
+func (s *CallbackSigner) Sign(chatID int64, data string) string {
+	if !isSignedCallback(data) {
+		return data
+	}
+	return data + callbackSignatureSep + s.signature(chatID, data)
+}
+
+// Verify checks the signature of a route or submit callback and returns the callback without it. A
+// missing or wrong signature, or one made for another chat, is an ErrBadRequest.
+func (s *CallbackSigner) Verify(chatID int64, data string) (string, error) {
+	if !isSignedCallback(data) {
+		return data, nil
+	}
+	idx := strings.LastIndex(data, callbackSignatureSep)
+	if idx == -1 {
+		return "", errors.Wrap(ErrBadRequest, "unsigned callback data")
+	}
+	unsigned, signature := data[:idx], data[idx+len(callbackSignatureSep):]
+	if !hmac.Equal([]byte(signature), []byte(s.signature(chatID, unsigned))) {
+		return "", errors.Wrap(ErrBadRequest, "invalid callback signature")
+	}
+	return unsigned, nil
+}
+
+func (s *CallbackSigner) signature(chatID int64, data string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strconv.FormatInt(chatID, 10)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSignatureLen])
+}
+
+// SignMessage returns a copy of message with the route and submit callbacks of its buttons signed.
+func (s *CallbackSigner) SignMessage(chatID int64, message *Message) *Message {
+	if message == nil || len(message.ButtonGrid) == 0 {
+		return message
+	}
+	signed := *message
+	signed.ButtonGrid = make([][]Button, len(message.ButtonGrid))
+	for i, row := range message.ButtonGrid {
+		signed.ButtonGrid[i] = append([]Button(nil), row...)
+		for j, button := range row {
+			signed.ButtonGrid[i][j].CallbackData = s.Sign(chatID, button.CallbackData)
+		}
+	}
+	return &signed
+}
+
+type trustedCallbackContextKey struct{}
+
+// withTrustedCallback marks data as sent by the server itself, e.g. the timeout route of a form, so
+// VerifyCallbackData accepts it unsigned.
+func withTrustedCallback(ctx context.Context, data string) context.Context {
+	return context.WithValue(ctx, trustedCallbackContextKey{}, data)
+}
+
+func isTrustedCallback(ctx context.Context, data string) bool {
+	trusted, ok := ctx.Value(trustedCallbackContextKey{}).(string)
+	return ok && trusted == data
+}
+
+type formValuesContextKey struct{}
+
+// WithFormValues passes the values of a completed form to the handler of its `_submit:` callback. The
+// values never leave the server, so a client cannot submit values that skipped validation.
+func WithFormValues(ctx context.Context, values FormValues) context.Context {
+	return context.WithValue(ctx, formValuesContextKey{}, values)
+}
+
+// FormValuesFromContext returns the values passed with WithFormValues.
+func FormValuesFromContext(ctx context.Context) (FormValues, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	values, ok := ctx.Value(formValuesContextKey{}).(FormValues)
+	return values, ok
+}
@@ -0,0 +1,44 @@
+package bot_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+)
+
+func TestCallbackSigner(t *testing.T) {
+	if _, err := bot.NewCallbackSigner([]byte("short")); err == nil {
+		t.Fatal("expected a short key to be refused")
+	}
+	signer, err := bot.NewCallbackSigner([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bot.RouteCallbackData("/todo/5/delete")
+	signed := signer.Sign(7, data)
+	if len(signed) > 64 {
+		t.Fatalf("signed callback %q does not fit Telegram callback data", signed)
+	}
+	if got, err := signer.Verify(7, signed); err != nil || got != data {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	for name, forged := range map[string]string{
+		"unsigned":   data,
+		"other chat": signer.Sign(8, data),
+		"tampered":   signer.Sign(7, bot.RouteCallbackData("/todo/6/delete"))[:len(data)] + signed[len(data):],
+	} {
+		if _, err := signer.Verify(7, forged); !errors.Is(err, bot.ErrBadRequest) {
+			t.Errorf("%s: expected a bad request, got %v", name, err)
+		}
+	}
+
+	// only route and submit callbacks are signed
+	if got := signer.Sign(7, "lang:en"); got != "lang:en" {
+		t.Fatalf("expected lang callbacks to stay unsigned, got %q", got)
+	}
+	if got, err := signer.Verify(7, "lang:en"); err != nil || got != "lang:en" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,319 @@
+// Package slacktest provides a local fake of the Slack Web API and a client that sends signed Events API,
+// interactivity and slash command requests to a SlackBot.
+package slacktest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/pkg/errors"
+)
+
+// Call is a recorded Web API call.
+type Call struct {
+	Method string
+	Params map[string]any
+}
+
+type Button struct {
+	Text  string
+	Value string
+}
+
+// Message is a decoded chat.postMessage call.
+type Message struct {
+	Channel string
+	Text    string
+	Buttons [][]Button
+}
+
+// View is a decoded views.open call.
+type View struct {
+	TriggerID       string
+	CallbackID      string
+	PrivateMetadata string
+	BlockIDs        []string
+}
+
+// Server is a fake Slack Web API. Point a SlackBot at it with bot.WithSlackAPIURL(server.APIURL()).
+type Server struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) APIURL() string {
+	return s.URL + "/api/"
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "not_authed"})
+		return
+	}
+	var params map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error": "invalid_json"})
+		return
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, Call{Method: method, Params: params})
+	s.mu.Unlock()
+
+	switch method {
+	case "chat.postMessage", "chat.update":
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ok":      true,
+			"channel": params["channel"],
+			"ts":      strconv.FormatInt(time.Now().UnixNano(), 10),
+		})
+	case "views.open":
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "view": params["view"]})
+	default:
+		_ = json.NewEncoder(w).Encode(map[string]any{"ok": true})
+	}
+}
+
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Messages returns the messages posted to channel in order.
+func (s *Server) Messages(channel string) []Message {
+	var messages []Message
+	for _, call := range s.Calls() {
+		if call.Method != "chat.postMessage" || call.Params["channel"] != channel {
+			continue
+		}
+		messages = append(messages, decodeMessage(call.Params))
+	}
+	return messages
+}
+
+func (s *Server) LastMessage(channel string) (Message, bool) {
+	messages := s.Messages(channel)
+	if len(messages) == 0 {
+		return Message{}, false
+	}
+	return messages[len(messages)-1], true
+}
+
+func (s *Server) LastView() (View, bool) {
+	calls := s.Calls()
+	for i := len(calls) - 1; i >= 0; i-- {
+		if calls[i].Method != "views.open" {
+			continue
+		}
+		params := calls[i].Params
+		view, _ := params["view"].(map[string]any)
+		result := View{
+			TriggerID:       stringValue(params["trigger_id"]),
+			CallbackID:      stringValue(view["callback_id"]),
+			PrivateMetadata: stringValue(view["private_metadata"]),
+		}
+		blocks, _ := view["blocks"].([]any)
+		for _, block := range blocks {
+			if m, ok := block.(map[string]any); ok {
+				result.BlockIDs = append(result.BlockIDs, stringValue(m["block_id"]))
+			}
+		}
+		return result, true
+	}
+	return View{}, false
+}
+
+func decodeMessage(params map[string]any) Message {
+	msg := Message{
+		Channel: stringValue(params["channel"]),
+		Text:    stringValue(params["text"]),
+	}
+	blocks, _ := params["blocks"].([]any)
+	for _, block := range blocks {
+		m, ok := block.(map[string]any)
+		if !ok || m["type"] != "actions" {
+			continue
+		}
+		elements, _ := m["elements"].([]any)
+		var row []Button
+		for _, element := range elements {
+			e, ok := element.(map[string]any)
+			if !ok {
+				continue
+			}
+			text, _ := e["text"].(map[string]any)
+			row = append(row, Button{Text: stringValue(text["text"]), Value: stringValue(e["value"])})
+		}
+		msg.Buttons = append(msg.Buttons, row)
+	}
+	return msg
+}
+
+func stringValue(value any) string {
+	text, _ := value.(string)
+	return text
+}
+
+// Client sends signed requests to a SlackBot's request URL. When the handler has a Wait method, like
+// SlackBot, each request returns once the update it carries is processed.
+type Client struct {
+	Handler       http.Handler
+	SigningSecret string
+	User          string
+
+	triggers  int
+	events    int
+	lastEvent []byte
+}
+
+// SendText delivers a `message` event with a new event ID.
+func (c *Client) SendText(channel string, text string) error {
+	c.events++
+	body, err := json.Marshal(map[string]any{
+		"type":     "event_callback",
+		"event_id": fmt.Sprintf("Ev%d", c.events),
+		"event": map[string]any{
+			"type":    "message",
+			"user":    c.user(),
+			"channel": channel,
+			"text":    text,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	c.lastEvent = body
+	_, err = c.do(body, "application/json")
+	return err
+}
+
+// RedeliverText delivers the last `message` event again, as Slack does when it missed the
+// acknowledgement.
+func (c *Client) RedeliverText() error {
+	if c.lastEvent == nil {
+		return errors.New("no event was sent")
+	}
+	_, err := c.send(c.lastEvent, "application/json", http.Header{"X-Slack-Retry-Num": {"1"}})
+	return err
+}
+
+// Click delivers a block_actions interaction for the button carrying value.
+func (c *Client) Click(channel string, value string) error {
+	return c.interact(map[string]any{
+		"type":       "block_actions",
+		"trigger_id": c.nextTrigger(),
+		"user":       map[string]any{"id": c.user()},
+		"channel":    map[string]any{"id": channel},
+		"actions":    []any{map[string]any{"action_id": "botx", "value": value}},
+	})
+}
+
+// Command delivers a slash command such as `/start todo_42`.
+func (c *Client) Command(channel string, command string, text string) error {
+	form := url.Values{
+		"command":    {command},
+		"text":       {text},
+		"channel_id": {channel},
+		"user_id":    {c.user()},
+		"trigger_id": {c.nextTrigger()},
+	}
+	_, err := c.do([]byte(form.Encode()), "application/x-www-form-urlencoded")
+	return err
+}
+
+// SubmitView delivers a view_submission for view with the given values keyed by block ID. It returns the
+// decoded response body, which carries `response_action: errors` when validation failed.
+func (c *Client) SubmitView(view View, values map[string]string) (map[string]any, error) {
+	state := map[string]any{}
+	for id, value := range values {
+		state[id] = map[string]any{id: map[string]any{"type": "plain_text_input", "value": value}}
+	}
+	body, err := json.Marshal(map[string]any{
+		"type": "view_submission",
+		"user": map[string]any{"id": c.user()},
+		"view": map[string]any{
+			"callback_id":      view.CallbackID,
+			"private_metadata": view.PrivateMetadata,
+			"state":            map[string]any{"values": state},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	raw, err := c.do([]byte(url.Values{"payload": {string(body)}}.Encode()), "application/x-www-form-urlencoded")
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]any{}
+	if len(raw) != 0 {
+		if err := json.Unmarshal(raw, &result); err != nil {
+			return nil, errors.Wrap(err, "failed to decode view submission response")
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) interact(payload map[string]any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = c.do([]byte(url.Values{"payload": {string(body)}}.Encode()), "application/x-www-form-urlencoded")
+	return err
+}
+
+func (c *Client) do(body []byte, contentType string) ([]byte, error) {
+	return c.send(body, contentType, nil)
+}
+
+func (c *Client) send(body []byte, contentType string, header http.Header) ([]byte, error) {
+	req := httptest.NewRequest(http.MethodPost, "/slack", strings.NewReader(string(body)))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", bot.SlackSignature(c.SigningSecret, timestamp, body))
+	rec := httptest.NewRecorder()
+	c.Handler.ServeHTTP(rec, req)
+	if waiter, ok := c.Handler.(interface{ Wait() }); ok {
+		waiter.Wait()
+	}
+	raw, _ := io.ReadAll(rec.Body)
+	if rec.Code != http.StatusOK {
+		return raw, errors.Errorf("slack request failed with status %d: %s", rec.Code, string(raw))
+	}
+	return raw, nil
+}
+
+func (c *Client) user() string {
+	if c.User == "" {
+		return "U0000000"
+	}
+	return c.User
+}
+
+func (c *Client) nextTrigger() string {
+	c.triggers++
+	return fmt.Sprintf("trigger-%d", c.triggers)
+}
@@ -0,0 +1,448 @@
+// Package telegramtest provides a local fake of the Telegram Bot API. The real go-telegram client polls
+// it for updates injected by the test and its calls are recorded for inspection.
+package telegramtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+	"github.com/pkg/errors"
+)
+
+// BotUsername is the username getMe reports.
+const BotUsername = "botx_test_bot"
+
+type Button struct {
+	Text         string
+	CallbackData string
+}
+
+// Message is a message the bot sent. Edits change it in place.
+type Message struct {
+	ID        int
+	ChatID    int64
+	Text      string
+	ParseMode string
+	Buttons   [][]Button
+	Edited    bool
+}
+
+// Call is a recorded Bot API call, with the form fields as sent. Objects such as reply_markup are JSON.
+type Call struct {
+	Method string
+	Params map[string]string
+}
+
+type CallbackAnswer struct {
+	CallbackQueryID string
+	Text            string
+	ShowAlert       bool
+}
+
+// Server is a fake Bot API. Point a TelegramBot at it with bot.WithTelegramAPIURL(server.APIURL()).
+type Server struct {
+	*httptest.Server
+
+	// LanguageCode is set on the sender of injected updates.
+	LanguageCode string
+
+	mu        sync.Mutex
+	changed   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	updateID  int64
+	messageID int
+	updates   []*models.Update
+	calls     []Call
+	messages  []*Message
+	answers   []CallbackAnswer
+}
+
+func NewServer() *Server {
+	s := &Server{
+		changed: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) APIURL() string {
+	return s.URL
+}
+
+// Close ends pending long polls and shuts the server down.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() { close(s.closed) })
+	s.Server.Close()
+}
+
+// notifyLocked wakes up long polls and waiters. s.mu must be held.
+func (s *Server) notifyLocked() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// AddUpdate queues update for the next getUpdates and returns its update ID.
+func (s *Server) AddUpdate(update *models.Update) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updateID++
+	update.ID = s.updateID
+	s.updates = append(s.updates, update)
+	s.notifyLocked()
+	return update.ID
+}
+
+func (s *Server) user(chatID int64) *models.User {
+	return &models.User{ID: chatID, FirstName: "Test", LanguageCode: s.LanguageCode}
+}
+
+// SendText injects a text message from the private chat chatID.
+func (s *Server) SendText(chatID int64, text string) int64 {
+	return s.AddUpdate(&models.Update{Message: &models.Message{
+		ID:   s.nextMessageID(),
+		From: s.user(chatID),
+		Chat: models.Chat{ID: chatID, Type: models.ChatTypePrivate},
+		Date: int(time.Now().Unix()),
+		Text: text,
+	}})
+}
+
+// Click presses the button with label on the last message in chatID that has it.
+func (s *Server) Click(chatID int64, label string) error {
+	msg, button, ok := s.findButton(chatID, func(b Button) bool { return b.Text == label })
+	if !ok {
+		return errors.Errorf("no button %q in chat %d", label, chatID)
+	}
+	s.press(msg, button.CallbackData)
+	return nil
+}
+
+// ClickData presses the button with callbackData on the last message in chatID that has it.
+func (s *Server) ClickData(chatID int64, callbackData string) error {
+	msg, _, ok := s.findButton(chatID, func(b Button) bool { return b.CallbackData == callbackData })
+	if !ok {
+		return errors.Errorf("no button with callback data %q in chat %d", callbackData, chatID)
+	}
+	s.press(msg, callbackData)
+	return nil
+}
+
+func (s *Server) findButton(chatID int64, match func(Button) bool) (Message, Button, bool) {
+	messages := s.Messages(chatID)
+	for i := len(messages) - 1; i >= 0; i-- {
+		for _, row := range messages[i].Buttons {
+			for _, button := range row {
+				if match(button) {
+					return messages[i], button, true
+				}
+			}
+		}
+	}
+	return Message{}, Button{}, false
+}
+
+func (s *Server) press(msg Message, data string) {
+	s.AddUpdate(&models.Update{CallbackQuery: &models.CallbackQuery{
+		ID:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		From: *s.user(msg.ChatID),
+		Message: models.MaybeInaccessibleMessage{
+			Type: models.MaybeInaccessibleMessageTypeMessage,
+			Message: &models.Message{
+				ID:   msg.ID,
+				Chat: models.Chat{ID: msg.ChatID, Type: models.ChatTypePrivate},
+				Date: int(time.Now().Unix()),
+				Text: msg.Text,
+			},
+		},
+		Data: data,
+	}})
+}
+
+func (s *Server) nextMessageID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messageID++
+	return s.messageID
+}
+
+func (s *Server) Calls() []Call {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// Messages returns the messages the bot sent to chatID, in order, with edits applied.
+func (s *Server) Messages(chatID int64) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []Message
+	for _, msg := range s.messages {
+		if msg.ChatID == chatID {
+			messages = append(messages, *msg)
+		}
+	}
+	return messages
+}
+
+func (s *Server) LastMessage(chatID int64) (Message, bool) {
+	messages := s.Messages(chatID)
+	if len(messages) == 0 {
+		return Message{}, false
+	}
+	return messages[len(messages)-1], true
+}
+
+func (s *Server) CallbackAnswers() []CallbackAnswer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]CallbackAnswer(nil), s.answers...)
+}
+
+// WaitMessages waits until the bot has sent at least n messages to chatID and returns them. Updates
+// are handled asynchronously, so tests wait for the reply before asserting.
+func (s *Server) WaitMessages(chatID int64, n int, timeout time.Duration) ([]Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		s.mu.Unlock()
+		if messages := s.Messages(chatID); len(messages) >= n {
+			return messages, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			return s.Messages(chatID), errors.Errorf("timed out waiting for %d messages in chat %d, got %d", n, chatID, len(s.Messages(chatID)))
+		}
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	token, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bot"), "/")
+	if !ok || token == "" || !strings.HasPrefix(r.URL.Path, "/bot") {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	params, err := readParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
+	}
+	if method == "getUpdates" {
+		s.getUpdates(w, r, params)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, Call{Method: method, Params: params})
+	defer s.notifyLocked()
+	switch method {
+	case "getMe":
+		writeResult(w, models.User{ID: 1, IsBot: true, FirstName: "Botx", Username: BotUsername})
+	case "sendMessage":
+		chatID, err := strconv.ParseInt(params["chat_id"], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+			return
+		}
+		s.messageID++
+		msg := &Message{ID: s.messageID, ChatID: chatID}
+		if err := applyEdit(msg, params); err != nil {
+			writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+		s.messages = append(s.messages, msg)
+		writeResult(w, toTgMessage(msg))
+	case "editMessageText", "editMessageReplyMarkup":
+		msg := s.findMessageLocked(params)
+		if msg == nil {
+			writeError(w, http.StatusBadRequest, "Bad Request: message to edit not found")
+			return
+		}
+		if method == "editMessageReplyMarkup" {
+			params["text"] = msg.Text
+			params["parse_mode"] = msg.ParseMode
+		}
+		if err := applyEdit(msg, params); err != nil {
+			writeError(w, http.StatusBadRequest, "Bad Request: "+err.Error())
+			return
+		}
+		msg.Edited = true
+		writeResult(w, toTgMessage(msg))
+	case "deleteMessage":
+		msg := s.findMessageLocked(params)
+		if msg == nil {
+			writeError(w, http.StatusBadRequest, "Bad Request: message to delete not found")
+			return
+		}
+		for i := range s.messages {
+			if s.messages[i] == msg {
+				s.messages = append(s.messages[:i], s.messages[i+1:]...)
+				break
+			}
+		}
+		writeResult(w, true)
+	case "answerCallbackQuery":
+		s.answers = append(s.answers, CallbackAnswer{
+			CallbackQueryID: params["callback_query_id"],
+			Text:            params["text"],
+			ShowAlert:       params["show_alert"] == "true",
+		})
+		writeResult(w, true)
+	case "setMyCommands", "deleteMyCommands", "sendChatAction", "setChatMenuButton":
+		writeResult(w, true)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// getUpdates answers a long poll with the queued updates from offset on, waiting up to the requested
+// timeout for new ones. Updates before offset are confirmed and dropped, like the real API does.
+func (s *Server) getUpdates(w http.ResponseWriter, r *http.Request, params map[string]string) {
+	offset, _ := strconv.ParseInt(params["offset"], 10, 64)
+	timeout, _ := strconv.Atoi(params["timeout"])
+	deadline := time.NewTimer(time.Duration(timeout) * time.Second)
+	defer deadline.Stop()
+	for {
+		s.mu.Lock()
+		for len(s.updates) > 0 && s.updates[0].ID < offset {
+			s.updates = s.updates[1:]
+		}
+		updates := append([]*models.Update(nil), s.updates...)
+		changed := s.changed
+		s.mu.Unlock()
+		if len(updates) > 0 {
+			writeResult(w, updates)
+			return
+		}
+		select {
+		case <-changed:
+		case <-deadline.C:
+			writeResult(w, []*models.Update{})
+			return
+		case <-r.Context().Done():
+			return
+		case <-s.closed:
+			writeResult(w, []*models.Update{})
+			return
+		}
+	}
+}
+
+func (s *Server) findMessageLocked(params map[string]string) *Message {
+	chatID, _ := strconv.ParseInt(params["chat_id"], 10, 64)
+	messageID, _ := strconv.Atoi(params["message_id"])
+	for _, msg := range s.messages {
+		if msg.ChatID == chatID && msg.ID == messageID {
+			return msg
+		}
+	}
+	return nil
+}
+
+func applyEdit(msg *Message, params map[string]string) error {
+	if params["text"] == "" {
+		return errors.New("message text is empty")
+	}
+	msg.Text = params["text"]
+	msg.ParseMode = params["parse_mode"]
+	msg.Buttons = nil
+	if raw := params["reply_markup"]; raw != "" {
+		var markup models.InlineKeyboardMarkup
+		if err := json.Unmarshal([]byte(raw), &markup); err != nil {
+			return errors.Wrap(err, "can't parse reply keyboard markup")
+		}
+		for _, row := range markup.InlineKeyboard {
+			buttons := make([]Button, 0, len(row))
+			for _, button := range row {
+				buttons = append(buttons, Button{Text: button.Text, CallbackData: button.CallbackData})
+			}
+			msg.Buttons = append(msg.Buttons, buttons)
+		}
+	}
+	return nil
+}
+
+func toTgMessage(msg *Message) *models.Message {
+	tgMessage := &models.Message{
+		ID:   msg.ID,
+		From: &models.User{ID: 1, IsBot: true, FirstName: "Botx", Username: BotUsername},
+		Chat: models.Chat{ID: msg.ChatID, Type: models.ChatTypePrivate},
+		Date: int(time.Now().Unix()),
+		Text: msg.Text,
+	}
+	if msg.Edited {
+		tgMessage.EditDate = int(time.Now().Unix())
+	}
+	if len(msg.Buttons) > 0 {
+		markup := &models.InlineKeyboardMarkup{}
+		for _, row := range msg.Buttons {
+			buttons := make([]models.InlineKeyboardButton, 0, len(row))
+			for _, button := range row {
+				buttons = append(buttons, models.InlineKeyboardButton{Text: button.Text, CallbackData: button.CallbackData})
+			}
+			markup.InlineKeyboard = append(markup.InlineKeyboard, buttons)
+		}
+		tgMessage.ReplyMarkup = markup
+	}
+	return tgMessage
+}
+
+// readParams decodes the multipart form go-telegram sends. Uploaded files are kept by name only.
+func readParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+	_, mediaParams, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaParams["boundary"] == "" {
+		return params, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) == 0 {
+		return params, nil
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), mediaParams["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return params, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			params[part.FormName()] = "<file " + part.FileName() + ">"
+			continue
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		params[part.FormName()] = string(value)
+	}
+}
+
+func writeResult(w http.ResponseWriter, result any) {
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"ok": false, "error_code": status, "description": description})
+}
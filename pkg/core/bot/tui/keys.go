@@ -0,0 +1,134 @@
+package tui
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type keyKind int
+
+const (
+	keyRune keyKind = iota
+	keyEnter
+	keyTab
+	keyBackTab
+	keyBackspace
+	keyUp
+	keyDown
+	keyLeft
+	keyRight
+	keyPageUp
+	keyPageDown
+	keyEsc
+	keyQuit
+	keyClick
+	keyWheelUp
+	keyWheelDown
+)
+
+// key is a decoded keypress or mouse event. Mouse coordinates are 1-based, as reported by the terminal.
+type key struct {
+	kind keyKind
+	r    rune
+	x, y int
+}
+
+// decodeKeys decodes as many keys as possible from buf and returns the bytes of an incomplete trailing
+// sequence, to be prepended to the next read.
+func decodeKeys(buf []byte) ([]key, []byte) {
+	var keys []key
+	for len(buf) > 0 {
+		switch c := buf[0]; {
+		case c == 0x1b:
+			if len(buf) == 1 {
+				return append(keys, key{kind: keyEsc}), nil
+			}
+			if buf[1] != '[' && buf[1] != 'O' {
+				keys = append(keys, key{kind: keyEsc})
+				buf = buf[1:]
+				continue
+			}
+			end := 2
+			for end < len(buf) && (buf[end] < 0x40 || buf[end] > 0x7e) {
+				end++
+			}
+			if end == len(buf) {
+				return keys, buf
+			}
+			if k, ok := decodeSequence(string(buf[2:end]), buf[end]); ok {
+				keys = append(keys, k)
+			}
+			buf = buf[end+1:]
+		case c == '\r' || c == '\n':
+			keys = append(keys, key{kind: keyEnter})
+			// a terminal in cooked mode sends "\r\n" for one Enter
+			if c == '\r' && len(buf) > 1 && buf[1] == '\n' {
+				buf = buf[1:]
+			}
+			buf = buf[1:]
+		case c == '\t':
+			keys = append(keys, key{kind: keyTab})
+			buf = buf[1:]
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, key{kind: keyBackspace})
+			buf = buf[1:]
+		case c == 0x03 || c == 0x04:
+			keys = append(keys, key{kind: keyQuit})
+			buf = buf[1:]
+		case c < 0x20:
+			buf = buf[1:]
+		default:
+			if !utf8.FullRune(buf) {
+				return keys, buf
+			}
+			r, size := utf8.DecodeRune(buf)
+			keys = append(keys, key{kind: keyRune, r: r})
+			buf = buf[size:]
+		}
+	}
+	return keys, nil
+}
+
+func decodeSequence(params string, final byte) (key, bool) {
+	switch final {
+	case 'A':
+		return key{kind: keyUp}, true
+	case 'B':
+		return key{kind: keyDown}, true
+	case 'C':
+		return key{kind: keyRight}, true
+	case 'D':
+		return key{kind: keyLeft}, true
+	case 'Z':
+		return key{kind: keyBackTab}, true
+	case '~':
+		switch params {
+		case "5":
+			return key{kind: keyPageUp}, true
+		case "6":
+			return key{kind: keyPageDown}, true
+		}
+	case 'M', 'm':
+		// SGR mouse report: ESC [ < button ; x ; y M (press) or m (release)
+		fields := strings.Split(strings.TrimPrefix(params, "<"), ";")
+		if !strings.HasPrefix(params, "<") || len(fields) != 3 {
+			return key{}, false
+		}
+		button, err1 := strconv.Atoi(fields[0])
+		x, err2 := strconv.Atoi(fields[1])
+		y, err3 := strconv.Atoi(fields[2])
+		if err1 != nil || err2 != nil || err3 != nil {
+			return key{}, false
+		}
+		switch {
+		case button == 64:
+			return key{kind: keyWheelUp}, true
+		case button == 65:
+			return key{kind: keyWheelDown}, true
+		case button == 0 && final == 'M':
+			return key{kind: keyClick, x: x, y: y}, true
+		}
+	}
+	return key{}, false
+}
@@ -0,0 +1,133 @@
+package tui
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	sgrReset     = "\x1b[0m"
+	sgrBold      = "\x1b[1m"
+	sgrDim       = "\x1b[2m"
+	sgrUnderline = "\x1b[4m"
+	sgrReverse   = "\x1b[7m"
+	sgrRed       = "\x1b[31m"
+	sgrCyan      = "\x1b[36m"
+)
+
+var (
+	htmlLinkPattern = regexp.MustCompile(`(?is)<a\s+href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlTagPattern  = regexp.MustCompile(`(?s)<[^>]+>`)
+	ansiPattern     = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+	htmlANSI        = strings.NewReplacer(
+		"<b>", "\x1b[1m", "</b>", "\x1b[22m", "<strong>", "\x1b[1m", "</strong>", "\x1b[22m",
+		"<i>", "\x1b[3m", "</i>", "\x1b[23m", "<em>", "\x1b[3m", "</em>", "\x1b[23m",
+		"<u>", "\x1b[4m", "</u>", "\x1b[24m", "<ins>", "\x1b[4m", "</ins>", "\x1b[24m",
+		"<s>", "\x1b[9m", "</s>", "\x1b[29m", "<strike>", "\x1b[9m", "</strike>", "\x1b[29m",
+		"<del>", "\x1b[9m", "</del>", "\x1b[29m",
+		"<code>", "\x1b[36m", "</code>", "\x1b[39m", "<pre>", "\x1b[36m", "</pre>", "\x1b[39m",
+		"<tg-spoiler>", "\x1b[2m", "</tg-spoiler>", "\x1b[22m",
+	)
+)
+
+// renderText converts message text into ANSI-styled text. HTML parse mode maps the Telegram tags onto
+// SGR attributes and shows link targets after their label; other parse modes are shown as is.
+func renderText(text string, parseMode string) string {
+	if !strings.EqualFold(parseMode, "html") {
+		return text
+	}
+	text = htmlLinkPattern.ReplaceAllString(text, sgrUnderline+"$2\x1b[24m "+sgrDim+"($1)\x1b[22m")
+	text = htmlANSI.Replace(text)
+	text = htmlTagPattern.ReplaceAllString(text, "")
+	return html.UnescapeString(text)
+}
+
+func stripANSI(text string) string {
+	return ansiPattern.ReplaceAllString(text, "")
+}
+
+// runeWidth returns the number of terminal columns r occupies.
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x1100:
+		return 1
+	case r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1faff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func textWidth(text string) int {
+	width := 0
+	for _, r := range stripANSI(text) {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// wrap splits ANSI-styled text into lines of at most width columns. Attributes active at a line break
+// are closed at the end of the line and reopened on the next one, so every line renders on its own.
+func wrap(text string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	var lines []string
+	active := ""
+	for _, logical := range strings.Split(text, "\n") {
+		var line strings.Builder
+		line.WriteString(active)
+		col := 0
+		for len(logical) > 0 {
+			if loc := ansiPattern.FindStringIndex(logical); loc != nil && loc[0] == 0 {
+				seq := logical[:loc[1]]
+				line.WriteString(seq)
+				if seq == sgrReset {
+					active = ""
+				} else if strings.HasSuffix(seq, "m") {
+					active += seq
+				}
+				logical = logical[loc[1]:]
+				continue
+			}
+			r, size := utf8.DecodeRuneInString(logical)
+			w := runeWidth(r)
+			if col+w > width {
+				lines = append(lines, closeLine(line.String(), active))
+				line.Reset()
+				line.WriteString(active)
+				col = 0
+			}
+			line.WriteRune(r)
+			col += w
+			logical = logical[size:]
+		}
+		lines = append(lines, closeLine(line.String(), active))
+	}
+	return lines
+}
+
+func closeLine(line string, active string) string {
+	if active == "" {
+		return line
+	}
+	return line + sgrReset
+}
+
+// pad fills line with spaces up to width columns.
+func pad(line string, width int) string {
+	if w := textWidth(line); w < width {
+		return line + strings.Repeat(" ", width-w)
+	}
+	return line
+}
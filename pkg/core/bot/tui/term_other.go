@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package tui
+
+import "os"
+
+// Raw mode is not supported on this platform: input is read line by line and the mouse is unavailable.
+
+func makeRaw(*os.File) (func(), bool) {
+	return nil, false
+}
+
+func terminalSize(*os.File) (int, int, bool) {
+	return 0, 0, false
+}
+
+func notifyResize(chan<- os.Signal) {}
@@ -0,0 +1,777 @@
+// Package tui provides a terminal UI connector for running generated bots locally. Messages are rendered
+// with their HTML formatting into a scrollback, buttons are navigated with the arrow keys or clicked with
+// the mouse, and forms are shown as input widgets with inline validation errors.
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/session"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultChatID int64 = 1
+
+	defaultWidth  = 80
+	defaultHeight = 24
+	wheelLines    = 3
+
+	sessionKeyForm = "__tui_form"
+)
+
+type focusZone int
+
+const (
+	focusInput focusZone = iota
+	focusButtons
+)
+
+type hitKind int
+
+const (
+	hitButton hitKind = iota
+	hitField
+	hitSubmit
+	hitCancel
+)
+
+// hitbox is a clickable region of the last rendered screen. y is 0-based; x0 and x1 are 1-based columns.
+type hitbox struct {
+	kind   hitKind
+	y      int
+	x0, x1 int
+	row    int
+	col    int
+}
+
+type formState struct {
+	form *bot.Form
+	// fields are the fields shown, starting at form.Fields[offset]
+	fields []bot.FormField
+	offset int
+	values [][]rune
+	errors []string
+	// focus is an index into fields, len(fields) for Submit or len(fields)+1 for Cancel
+	focus int
+}
+
+// Bot is a BotConnector drawing a full-screen terminal UI. Call Run to start reading input; updates are
+// handled on the Run goroutine.
+type Bot struct {
+	in     io.Reader
+	out    io.Writer
+	chatID int64
+	width  int
+	height int
+
+	handler bot.BotxHandler
+	forms   *bot.FormEngine
+
+	mu       sync.Mutex
+	history  []string
+	scroll   int
+	buttons  [][]bot.Button
+	zone     focusZone
+	row, col int
+	input    []rune
+	form     *formState
+	hitboxes []hitbox
+	screen   []string
+	pending  []byte
+	quit     bool
+}
+
+type Option func(*Bot)
+
+// WithChatID sets the chat ID updates are handled for. Defaults to DefaultChatID.
+func WithChatID(chatID int64) Option {
+	return func(b *Bot) {
+		b.chatID = chatID
+	}
+}
+
+// WithSize fixes the screen size instead of querying the terminal.
+func WithSize(width int, height int) Option {
+	return func(b *Bot) {
+		b.width = width
+		b.height = height
+	}
+}
+
+func New(in io.Reader, out io.Writer, opts ...Option) (*Bot, error) {
+	if in == nil || out == nil {
+		return nil, errors.New("tui input and output are required")
+	}
+	b := &Bot{
+		in:     in,
+		out:    out,
+		chatID: DefaultChatID,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	// the pending form only needs to outlive the review step, so it is kept in memory
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create session manager")
+	}
+	b.forms = bot.NewFormEngine(b, sm, sessionKeyForm, func() bot.BotxHandler { return b.handler }, bot.WithFormPrompt(b.showForm))
+	return b, nil
+}
+
+func (b *Bot) RegisterBotxHandler(handler bot.BotxHandler) {
+	b.handler = handler
+}
+
+func (b *Bot) SendMessage(_ context.Context, _ int64, message *bot.Message) error {
+	b.mu.Lock()
+	if len(b.history) != 0 {
+		b.history = append(b.history, "")
+	}
+	if message.Text != "" {
+		b.history = append(b.history, renderText(message.Text, message.ParseMode))
+	}
+	b.buttons = message.ButtonGrid
+	b.zone, b.row, b.col = focusInput, 0, 0
+	b.scroll = 0
+	b.mu.Unlock()
+	b.redraw()
+	return nil
+}
+
+func (b *Bot) SendCallbackData(ctx context.Context, chatID int64, data string) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	if data == "" {
+		return errors.New("callback data is required")
+	}
+	if ok, err := b.forms.HandleCallback(ctx, chatID, data); ok {
+		return err
+	}
+	if err := b.handler.HandleCallbackData(ctx, data, chatID, b); err != nil {
+		return errors.Wrap(err, "failed to handle callback data")
+	}
+	return nil
+}
+
+// SendForm shows every field of the form at once, keeping previously entered values. Forms with conditions
+// are shown one field at a time, as the conditions depend on the values before them.
+func (b *Bot) SendForm(ctx context.Context, chatID int64, form *bot.Form) error {
+	return b.forms.Start(ctx, chatID, form)
+}
+
+// showForm shows the form with the field at form.Idx focused.
+func (b *Bot) showForm(_ context.Context, _ int64, form *bot.Form) error {
+	state := &formState{form: form, fields: form.Fields}
+	if form.HasConditions() {
+		state.fields, state.offset = form.Fields[form.Idx:form.Idx+1], form.Idx
+	} else if form.Idx < len(form.Fields) {
+		state.focus = form.Idx
+	}
+	state.values = make([][]rune, len(state.fields))
+	state.errors = make([]string, len(state.fields))
+	for i, field := range state.fields {
+		if field.Input != nil {
+			state.values[i] = []rune(field.Input.Value)
+		}
+	}
+	b.mu.Lock()
+	b.form = state
+	b.buttons = nil
+	b.mu.Unlock()
+	b.redraw()
+	return nil
+}
+
+// Run draws the UI and handles input until ctx is done, the input ends or the user quits with Ctrl-C.
+// When the input is a terminal it is switched to raw mode with mouse reporting for the duration.
+func (b *Bot) Run(ctx context.Context) error {
+	if f, ok := b.in.(*os.File); ok {
+		if restore, ok := makeRaw(f); ok {
+			defer restore()
+			fmt.Fprint(b.out, "\x1b[?1049h\x1b[?25l\x1b[?1000h\x1b[?1006h")
+			defer fmt.Fprint(b.out, "\x1b[?1006l\x1b[?1000l\x1b[?25h\x1b[?1049l")
+		}
+	}
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	b.redraw()
+
+	chunks := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := b.in.Read(buf)
+			if n > 0 {
+				chunk := append([]byte(nil), buf[:n]...)
+				select {
+				case chunks <- chunk:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resize:
+			b.redraw()
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return errors.Wrap(err, "failed to read input")
+		case chunk := <-chunks:
+			if quit := b.HandleInput(ctx, chunk); quit {
+				return nil
+			}
+		}
+	}
+}
+
+// HandleInput handles raw terminal input and reports whether the user asked to quit. Run calls it for
+// every read; tests can call it directly.
+func (b *Bot) HandleInput(ctx context.Context, input []byte) bool {
+	b.mu.Lock()
+	keys, rest := decodeKeys(append(b.pending, input...))
+	b.pending = rest
+	b.mu.Unlock()
+	for _, k := range keys {
+		action := b.handleKey(k)
+		if action != nil {
+			b.perform(ctx, action)
+		}
+		b.redraw()
+		b.mu.Lock()
+		quit := b.quit
+		b.mu.Unlock()
+		if quit {
+			return true
+		}
+	}
+	return false
+}
+
+// Screen returns the last rendered screen without styling.
+func (b *Bot) Screen() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	lines := make([]string, len(b.screen))
+	for i, line := range b.screen {
+		lines[i] = strings.TrimRight(stripANSI(line), " ")
+	}
+	return lines
+}
+
+type actionKind int
+
+const (
+	actionText actionKind = iota
+	actionCallback
+	actionSubmit
+)
+
+type action struct {
+	kind actionKind
+	data string
+}
+
+// handleKey updates the UI state for k and returns the update to send to the handler, if any.
+func (b *Bot) handleKey(k key) *action {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch k.kind {
+	case keyQuit:
+		b.quit = true
+		return nil
+	case keyPageUp:
+		b.scroll += max(b.viewportHeight()-1, 1)
+		return nil
+	case keyPageDown:
+		b.scroll = max(b.scroll-max(b.viewportHeight()-1, 1), 0)
+		return nil
+	case keyWheelUp:
+		b.scroll += wheelLines
+		return nil
+	case keyWheelDown:
+		b.scroll = max(b.scroll-wheelLines, 0)
+		return nil
+	case keyClick:
+		return b.click(k.x, k.y)
+	}
+	if b.form != nil {
+		return b.handleFormKey(k)
+	}
+	return b.handleChatKey(k)
+}
+
+func (b *Bot) handleChatKey(k key) *action {
+	switch k.kind {
+	case keyRune:
+		b.zone = focusInput
+		b.input = append(b.input, k.r)
+	case keyBackspace:
+		if len(b.input) > 0 {
+			b.input = b.input[:len(b.input)-1]
+		}
+	case keyUp:
+		if b.zone == focusInput && len(b.buttons) > 0 {
+			b.zone, b.row = focusButtons, len(b.buttons)-1
+		} else if b.zone == focusButtons && b.row > 0 {
+			b.row--
+		}
+		b.clampColumn()
+	case keyDown:
+		if b.zone == focusButtons {
+			if b.row < len(b.buttons)-1 {
+				b.row++
+				b.clampColumn()
+			} else {
+				b.zone = focusInput
+			}
+		}
+	case keyLeft:
+		if b.zone == focusButtons && b.col > 0 {
+			b.col--
+		}
+	case keyRight:
+		if b.zone == focusButtons && b.col < len(b.buttons[b.row])-1 {
+			b.col++
+		}
+	case keyTab, keyBackTab:
+		b.cycleButtons(k.kind == keyTab)
+	case keyEsc:
+		b.zone = focusInput
+	case keyEnter:
+		if b.zone == focusButtons {
+			return b.pressButton(b.row, b.col)
+		}
+		text := strings.TrimSpace(string(b.input))
+		b.input = nil
+		if text == "" {
+			return nil
+		}
+		b.history = append(b.history, "", sgrBold+"› "+text+sgrReset)
+		return &action{kind: actionText, data: text}
+	}
+	return nil
+}
+
+// cycleButtons moves the focus through the buttons in reading order, then to the input line.
+func (b *Bot) cycleButtons(forward bool) {
+	var positions [][2]int
+	for r, row := range b.buttons {
+		for c := range row {
+			positions = append(positions, [2]int{r, c})
+		}
+	}
+	if len(positions) == 0 {
+		return
+	}
+	current := len(positions)
+	if b.zone == focusButtons {
+		for i, p := range positions {
+			if p == [2]int{b.row, b.col} {
+				current = i
+			}
+		}
+	}
+	next := current + 1
+	if !forward {
+		next = current - 1 + len(positions) + 1
+	}
+	next %= len(positions) + 1
+	if next == len(positions) {
+		b.zone = focusInput
+		return
+	}
+	b.zone, b.row, b.col = focusButtons, positions[next][0], positions[next][1]
+}
+
+func (b *Bot) clampColumn() {
+	if b.zone == focusButtons {
+		b.col = min(b.col, len(b.buttons[b.row])-1)
+	}
+}
+
+func (b *Bot) pressButton(row int, col int) *action {
+	if row >= len(b.buttons) || col < 0 || col >= len(b.buttons[row]) {
+		return nil
+	}
+	btn := b.buttons[row][col]
+	b.history = append(b.history, "", sgrBold+"› ["+btn.Label+"]"+sgrReset)
+	b.zone = focusInput
+	return &action{kind: actionCallback, data: btn.CallbackData}
+}
+
+func (b *Bot) handleFormKey(k key) *action {
+	f := b.form
+	fields := len(f.fields)
+	switch k.kind {
+	case keyUp, keyBackTab:
+		f.focus = (f.focus + fields + 1) % (fields + 2)
+	case keyDown, keyTab:
+		f.focus = (f.focus + 1) % (fields + 2)
+	case keyLeft, keyRight:
+		switch {
+		case f.focus >= fields:
+			f.focus = fields + (f.focus-fields+1)%2
+		case len(fieldEnum(f.fields[f.focus])) != 0:
+			f.values[f.focus] = []rune(cycleOption(fieldEnum(f.fields[f.focus]), string(f.values[f.focus]), k.kind == keyRight))
+		}
+	case keyRune:
+		if f.focus < fields && len(fieldEnum(f.fields[f.focus])) == 0 {
+			f.values[f.focus] = append(f.values[f.focus], k.r)
+		}
+	case keyBackspace:
+		if f.focus < fields && len(f.values[f.focus]) > 0 {
+			f.values[f.focus] = f.values[f.focus][:len(f.values[f.focus])-1]
+		}
+	case keyEsc:
+		return b.cancelForm()
+	case keyEnter:
+		switch {
+		case f.focus < fields:
+			f.focus++
+		case f.focus == fields:
+			return &action{kind: actionSubmit}
+		default:
+			return b.cancelForm()
+		}
+	}
+	return nil
+}
+
+func (b *Bot) cancelForm() *action {
+	b.form = nil
+	if err := b.forms.Clear(context.Background(), b.chatID); err != nil {
+		b.history = append(b.history, "", sgrRed+"error: "+err.Error()+sgrReset)
+	}
+	b.history = append(b.history, "", sgrDim+"form cancelled"+sgrReset)
+	return nil
+}
+
+func fieldEnum(field bot.FormField) []string {
+	if field.Input == nil || field.Input.Schema == nil {
+		return nil
+	}
+	return field.Input.Schema.Enum
+}
+
+func cycleOption(options []string, current string, forward bool) string {
+	idx := -1
+	for i, option := range options {
+		if option == current {
+			idx = i
+		}
+	}
+	if forward {
+		return options[(idx+1)%len(options)]
+	}
+	if idx <= 0 {
+		return options[len(options)-1]
+	}
+	return options[idx-1]
+}
+
+func (b *Bot) click(x int, y int) *action {
+	for _, hit := range b.hitboxes {
+		if hit.y != y-1 || x < hit.x0 || x > hit.x1 {
+			continue
+		}
+		switch hit.kind {
+		case hitButton:
+			return b.pressButton(hit.row, hit.col)
+		case hitField:
+			b.form.focus = hit.row
+		case hitSubmit:
+			b.form.focus = len(b.form.fields)
+			return &action{kind: actionSubmit}
+		case hitCancel:
+			return b.cancelForm()
+		}
+	}
+	return nil
+}
+
+func (b *Bot) perform(ctx context.Context, a *action) {
+	var err error
+	switch a.kind {
+	case actionText:
+		if b.handler == nil {
+			err = errors.New("botx handler is not registered")
+			break
+		}
+		err = b.handler.HandleTextMessage(ctx, a.data, b.chatID, b)
+	case actionCallback:
+		err = b.SendCallbackData(ctx, b.chatID, a.data)
+	case actionSubmit:
+		err = b.submitForm(ctx)
+	}
+	if err == nil {
+		return
+	}
+	if b.handler != nil {
+		if handleErr := b.handler.HandleError(ctx, err, b.chatID, b); handleErr == nil {
+			return
+		}
+	}
+	b.mu.Lock()
+	b.history = append(b.history, "", sgrRed+"error: "+err.Error()+sgrReset)
+	b.mu.Unlock()
+}
+
+// submitForm validates every field, shows the errors next to their fields and submits the form once all
+// fields are valid. The field shown of a form with conditions is entered through the FormEngine, which
+// asks for the next field or completes the form.
+func (b *Bot) submitForm(ctx context.Context) error {
+	if b.handler == nil {
+		return errors.New("botx handler is not registered")
+	}
+	b.mu.Lock()
+	state := b.form
+	b.mu.Unlock()
+	if state == nil {
+		return nil
+	}
+	form := state.form
+	if form.HasConditions() {
+		return b.submitFormField(ctx, state)
+	}
+	validationErrors := make([]string, len(form.Fields))
+	valid := true
+	for i := range form.Fields {
+		field := &form.Fields[i]
+		b.mu.Lock()
+		value := string(state.values[i])
+		b.mu.Unlock()
+		if field.Input == nil {
+			field.Input = &bot.FormFieldInput{}
+		}
+		field.Input.Value = value
+		result, err := b.forms.Validate(ctx, b.chatID, form, i, value)
+		if err != nil {
+			return err
+		}
+		if !result.Valid {
+			validationErrors[i] = result.ErrorMessage
+			valid = false
+		}
+	}
+
+	b.mu.Lock()
+	state.errors = validationErrors
+	if !valid {
+		for i, message := range validationErrors {
+			if message != "" {
+				state.focus = i
+				break
+			}
+		}
+		b.mu.Unlock()
+		return nil
+	}
+	b.form = nil
+	summary := make([]string, 0, len(form.Fields))
+	for _, field := range form.Fields {
+		label := field.Label
+		if label == "" {
+			label = field.ID
+		}
+		summary = append(summary, fmt.Sprintf("%s: %s", stripANSI(renderText(label, "HTML")), field.Input.Value))
+	}
+	b.history = append(b.history, "", sgrBold+"› "+strings.Join(summary, ", ")+sgrReset)
+	b.mu.Unlock()
+
+	return b.forms.Complete(ctx, b.chatID, form)
+}
+
+func (b *Bot) submitFormField(ctx context.Context, state *formState) error {
+	b.mu.Lock()
+	field := state.fields[0]
+	value := string(state.values[0])
+	b.mu.Unlock()
+	validationErrors, err := b.forms.SubmitValues(ctx, b.chatID, bot.FormValues{field.ID: value})
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if message, ok := validationErrors[field.ID]; ok {
+		state.errors[0] = message
+		state.focus = 0
+		return nil
+	}
+	if b.form == state {
+		// the form was completed rather than showing the next field
+		b.form = nil
+	}
+	return nil
+}
+
+func (b *Bot) size() (int, int) {
+	if b.width > 0 && b.height > 0 {
+		return b.width, b.height
+	}
+	if f, ok := b.out.(*os.File); ok {
+		if width, height, ok := terminalSize(f); ok {
+			return width, height
+		}
+	}
+	return defaultWidth, defaultHeight
+}
+
+func (b *Bot) viewportHeight() int {
+	_, height := b.size()
+	return max(height-len(b.renderControls()), 1)
+}
+
+// redraw renders the screen and writes it to the output.
+func (b *Bot) redraw() {
+	b.mu.Lock()
+	width, height := b.size()
+	b.hitboxes = nil
+	controls := b.renderControls()
+	viewport := max(height-len(controls), 1)
+
+	var lines []string
+	for _, entry := range b.history {
+		lines = append(lines, wrap(entry, width)...)
+	}
+	b.scroll = min(b.scroll, max(len(lines)-viewport, 0))
+	end := len(lines) - b.scroll
+	start := max(end-viewport, 0)
+	screen := append([]string(nil), lines[start:end]...)
+	for len(screen) < viewport {
+		screen = append(screen, "")
+	}
+	offset := len(screen)
+	for i := range b.hitboxes {
+		b.hitboxes[i].y += offset
+	}
+	screen = append(screen, controls...)
+	b.screen = screen
+	b.mu.Unlock()
+
+	var out strings.Builder
+	out.WriteString("\x1b[H")
+	for i, line := range screen {
+		out.WriteString(line)
+		out.WriteString(sgrReset + "\x1b[K")
+		if i < len(screen)-1 {
+			out.WriteString("\r\n")
+		}
+	}
+	_, _ = io.WriteString(b.out, out.String())
+}
+
+// renderControls renders everything below the scrollback: buttons or the form, the input line and the
+// status line. Hitboxes are recorded relative to the first control line.
+func (b *Bot) renderControls() []string {
+	width, _ := b.size()
+	var lines []string
+	lines = append(lines, sgrDim+strings.Repeat("─", width)+sgrReset)
+	if b.form != nil {
+		lines = append(lines, b.renderForm(len(lines))...)
+		lines = append(lines, sgrDim+"↑↓ move · ←→ choose · Enter next/submit · Esc cancel · Ctrl-C quit"+sgrReset)
+		return lines
+	}
+	lines = append(lines, b.renderButtons(len(lines), width)...)
+	prompt := "> " + string(b.input)
+	if b.zone == focusInput {
+		prompt += sgrReverse + " " + sgrReset
+	}
+	lines = append(lines, prompt)
+	lines = append(lines, sgrDim+"↑↓←→ move · Enter select/send · PgUp/PgDn scroll · Ctrl-C quit"+sgrReset)
+	return lines
+}
+
+func (b *Bot) renderButtons(y int, width int) []string {
+	var lines []string
+	for r, row := range b.buttons {
+		var line strings.Builder
+		col := 0
+		for c, btn := range row {
+			label := "[ " + stripANSI(btn.Label) + " ]"
+			w := textWidth(label)
+			if col > 0 && col+1+w > width {
+				lines = append(lines, line.String())
+				line.Reset()
+				col = 0
+			}
+			if col > 0 {
+				line.WriteString(" ")
+				col++
+			}
+			focused := b.zone == focusButtons && b.row == r && b.col == c
+			if focused {
+				line.WriteString(sgrReverse)
+			}
+			line.WriteString(label)
+			if focused {
+				line.WriteString(sgrReset)
+			}
+			b.hitboxes = append(b.hitboxes, hitbox{kind: hitButton, y: y + len(lines), x0: col + 1, x1: col + w, row: r, col: c})
+			col += w
+		}
+		lines = append(lines, line.String())
+	}
+	return lines
+}
+
+func (b *Bot) renderForm(y int) []string {
+	f := b.form
+	var lines []string
+	for i, field := range f.fields {
+		label := field.Label
+		if label == "" {
+			label = field.ID
+		}
+		lines = append(lines, sgrBold+renderText(label, "HTML")+sgrReset)
+		value := string(f.values[i])
+		if options := fieldEnum(field); len(options) != 0 {
+			value = "‹ " + value + " ›  " + sgrDim + strings.Join(options, " | ") + sgrReset
+		}
+		box := "  [" + value
+		if f.focus == i {
+			box = "  [" + sgrReverse + value + " " + sgrReset
+		}
+		b.hitboxes = append(b.hitboxes, hitbox{kind: hitField, y: y + len(lines), x0: 1, x1: textWidth(box) + 1, row: i})
+		lines = append(lines, box+"]")
+		if field.Input != nil && field.Input.Tip != "" {
+			lines = append(lines, "  "+sgrDim+stripANSI(renderText(field.Input.Tip, "HTML"))+sgrReset)
+		}
+		if f.errors[i] != "" {
+			lines = append(lines, "  "+sgrRed+"! "+stripANSI(renderText(f.errors[i], "HTML"))+sgrReset)
+		}
+	}
+	submit, cancel := "[ Submit ]", "[ Cancel ]"
+	fields := len(f.fields)
+	line := submit + " " + cancel
+	switch f.focus {
+	case fields:
+		line = sgrReverse + submit + sgrReset + " " + cancel
+	case fields + 1:
+		line = submit + " " + sgrReverse + cancel + sgrReset
+	}
+	b.hitboxes = append(b.hitboxes,
+		hitbox{kind: hitSubmit, y: y + len(lines), x0: 1, x1: textWidth(submit)},
+		hitbox{kind: hitCancel, y: y + len(lines), x0: textWidth(submit) + 2, x1: textWidth(submit) + 1 + textWidth(cancel)},
+	)
+	return append(lines, line)
+}
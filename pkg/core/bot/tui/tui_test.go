@@ -0,0 +1,234 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+)
+
+type testHandler struct{}
+
+func (h *testHandler) HandleTextMessage(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	return b.SendMessage(ctx, chatID, &bot.Message{
+		Text:      "<b>Hello</b> " + data + " &amp; <a href=\"https://example.com\">docs</a>",
+		ParseMode: "HTML",
+		ButtonGrid: [][]bot.Button{
+			{{Label: "Add", CallbackData: bot.RouteCallbackData("/add")}, {Label: "List", CallbackData: bot.RouteCallbackData("/list")}},
+		},
+	})
+}
+
+func (h *testHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if values, ok := bot.FormValuesFromContext(ctx); ok && strings.HasPrefix(data, bot.CallbackPrefixSubmit+":") {
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: "added " + values["title"] + " (" + values["color"] + ")"})
+	}
+	if data == bot.RouteCallbackData("/list") {
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: "list"})
+	}
+	u, _ := url.Parse("/add")
+	return b.SendForm(ctx, chatID, &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{
+			{
+				ID:        "title",
+				Label:     "Title",
+				Input:     &bot.FormFieldInput{Tip: "Enter a title"},
+				Validator: func() *string { v := "title"; return &v }(),
+			},
+			{
+				ID:    "color",
+				Label: "Color",
+				Input: &bot.FormFieldInput{Schema: &bot.FormSchema{Type: "string", Enum: []string{"red", "green"}}},
+			},
+		},
+	})
+}
+
+func (h *testHandler) HandleError(ctx context.Context, err error, chatID int64, b bot.BotConnector) error {
+	return b.SendMessage(ctx, chatID, &bot.Message{Text: "error: " + err.Error()})
+}
+
+func (h *testHandler) Validate(_ context.Context, _ int64, _ *url.URL, _ string, input string) (*bot.ValidateResult, error) {
+	if strings.TrimSpace(input) == "" {
+		return &bot.ValidateResult{ErrorMessage: "title is required"}, nil
+	}
+	return &bot.ValidateResult{Valid: true}, nil
+}
+
+// deliveryHandler asks for an address only when the order is delivered.
+type deliveryHandler struct {
+	testHandler
+}
+
+func (h *deliveryHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if values, ok := bot.FormValuesFromContext(ctx); ok && strings.HasPrefix(data, bot.CallbackPrefixSubmit+":") {
+		return b.SendMessage(ctx, chatID, &bot.Message{Text: "ordered " + values["delivery"] + " " + values["note"]})
+	}
+	u, _ := url.Parse("/order")
+	return b.SendForm(ctx, chatID, &bot.Form{
+		URL: u,
+		Fields: []bot.FormField{
+			{ID: "delivery", Label: "Delivery", Input: &bot.FormFieldInput{Tip: "Deliver?"}},
+			{ID: "address", Label: "Address", Input: &bot.FormFieldInput{Tip: "Where to?"}, Conditional: true},
+			{ID: "note", Label: "Note", Input: &bot.FormFieldInput{Tip: "Any note?"}},
+		},
+	})
+}
+
+func (h *deliveryHandler) ShowFormField(_ context.Context, _ int64, _ *url.URL, field string, values bot.FormValues) (bool, error) {
+	return field != "address" || values["delivery"] == "yes", nil
+}
+
+func newTestBot(t *testing.T, height int) *Bot {
+	t.Helper()
+	b, err := New(strings.NewReader(""), io.Discard, WithSize(40, height))
+	if err != nil {
+		t.Fatalf("tui bot: %v", err)
+	}
+	b.RegisterBotxHandler(&testHandler{})
+	return b
+}
+
+func screenContains(b *Bot, text string) bool {
+	for _, line := range b.Screen() {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// locate returns the 1-based mouse coordinates of text on the screen.
+func locate(t *testing.T, b *Bot, text string) (int, int) {
+	t.Helper()
+	for y, line := range b.Screen() {
+		if x := strings.Index(line, text); x >= 0 {
+			return len([]rune(line[:x])) + 1, y + 1
+		}
+	}
+	t.Fatalf("%q not on screen:\n%s", text, strings.Join(b.Screen(), "\n"))
+	return 0, 0
+}
+
+func TestRenderHTML(t *testing.T) {
+	b := newTestBot(t, 12)
+	b.HandleInput(context.Background(), []byte("hi\r"))
+	if !screenContains(b, "Hello hi & docs (https://example.com)") {
+		t.Fatalf("expected rendered message, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+	if !screenContains(b, "[ Add ] [ List ]") {
+		t.Fatalf("expected buttons, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+}
+
+func TestArrowKeysAndMouse(t *testing.T) {
+	b := newTestBot(t, 12)
+	ctx := context.Background()
+	b.HandleInput(ctx, []byte("hi\r"))
+
+	// Up focuses the button row, Right moves to "List".
+	b.HandleInput(ctx, []byte("\x1b[A\x1b[C\r"))
+	if !screenContains(b, "list") {
+		t.Fatalf("expected list page, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+
+	b.HandleInput(ctx, []byte("again\r"))
+	x, y := locate(t, b, "[ Add ]")
+	b.HandleInput(ctx, []byte(fmt.Sprintf("\x1b[<0;%d;%dM", x+2, y)))
+	if !screenContains(b, "[ Submit ] [ Cancel ]") {
+		t.Fatalf("expected form after click, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+}
+
+func TestFormWidgets(t *testing.T) {
+	b := newTestBot(t, 20)
+	ctx := context.Background()
+	b.HandleInput(ctx, []byte("hi\r"))
+	b.HandleInput(ctx, []byte("\x1b[A\r"))
+	if !screenContains(b, "Enter a title") {
+		t.Fatalf("expected form, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+
+	// Submit with an empty title.
+	b.HandleInput(ctx, []byte("\t\t\r"))
+	if !screenContains(b, "! title is required") {
+		t.Fatalf("expected inline validation error, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+
+	// The focus moved back to the invalid field; type a title, choose a color and submit.
+	b.HandleInput(ctx, []byte("milk\r\x1b[C\x1b[C\r"))
+	b.HandleInput(ctx, []byte("\r"))
+	if !screenContains(b, "added milk (green)") {
+		t.Fatalf("expected submit result, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+	if !screenContains(b, "› Title: milk, Color: green") {
+		t.Fatalf("expected submitted values in scrollback, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+}
+
+func TestConditionalFormShowsOneFieldAtATime(t *testing.T) {
+	b := newTestBot(t, 20)
+	b.RegisterBotxHandler(&deliveryHandler{})
+	ctx := context.Background()
+	b.HandleInput(ctx, []byte("hi\r"))
+	b.HandleInput(ctx, []byte("\x1b[A\r"))
+	if !screenContains(b, "Deliver?") || screenContains(b, "Any note?") {
+		t.Fatalf("expected the delivery field only, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+
+	b.HandleInput(ctx, []byte("no\r\r"))
+	if !screenContains(b, "Any note?") || screenContains(b, "Where to?") {
+		t.Fatalf("expected the hidden address to be skipped, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+	b.HandleInput(ctx, []byte("ring\r\r"))
+	if !screenContains(b, "ordered no ring") {
+		t.Fatalf("expected submit result, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+}
+
+func TestScrollback(t *testing.T) {
+	b := newTestBot(t, 10)
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		_ = b.SendMessage(ctx, DefaultChatID, &bot.Message{Text: fmt.Sprintf("message %d", i)})
+	}
+	if screenContains(b, "message 0") || !screenContains(b, "message 19") {
+		t.Fatalf("expected the latest messages, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+	for i := 0; i < 10; i++ {
+		b.HandleInput(ctx, []byte("\x1b[5~"))
+	}
+	if !screenContains(b, "message 0") {
+		t.Fatalf("expected the first message after scrolling up, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+	b.HandleInput(ctx, []byte("\x1b[<65;1;1M\x1b[6~\x1b[6~\x1b[6~\x1b[6~\x1b[6~\x1b[6~\x1b[6~\x1b[6~\x1b[6~\x1b[6~"))
+	if !screenContains(b, "message 19") {
+		t.Fatalf("expected the latest messages after scrolling down, got:\n%s", strings.Join(b.Screen(), "\n"))
+	}
+}
+
+func TestDecodeKeysKeepsIncompleteSequences(t *testing.T) {
+	keys, rest := decodeKeys([]byte("a\x1b[<0;3"))
+	if len(keys) != 1 || keys[0].r != 'a' || string(rest) != "\x1b[<0;3" {
+		t.Fatalf("unexpected decode: %+v %q", keys, rest)
+	}
+	keys, rest = decodeKeys(append(rest, []byte(";4M")...))
+	if len(keys) != 1 || keys[0].kind != keyClick || keys[0].x != 3 || keys[0].y != 4 || len(rest) != 0 {
+		t.Fatalf("unexpected decode: %+v %q", keys, rest)
+	}
+}
+
+func TestWrapKeepsStyles(t *testing.T) {
+	lines := wrap(sgrBold+"abcdef"+"\x1b[22m", 3)
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], sgrBold) || stripANSI(lines[1]) != "def" {
+		t.Fatalf("unexpected wrap: %q", lines)
+	}
+	if got := wrap("你好世界", 4); len(got) != 2 || got[0] != "你好" {
+		t.Fatalf("unexpected wide wrap: %q", got)
+	}
+}
@@ -0,0 +1,51 @@
+package bot
+
+import (
+	"sync"
+)
+
+// chatQueue runs the updates of each chat one at a time, in the order they were received, so that two
+// quick clicks cannot race on the router history or the pending form. Updates of different chats run
+// concurrently. The zero value is ready to use.
+type chatQueue struct {
+	mu      sync.Mutex
+	pending map[int64][]func()
+	wg      sync.WaitGroup
+}
+
+// Go queues fn behind the updates of chatID that are still running.
+func (q *chatQueue) Go(chatID int64, fn func()) {
+	q.wg.Add(1)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pending == nil {
+		q.pending = make(map[int64][]func())
+	}
+	queued, running := q.pending[chatID]
+	q.pending[chatID] = append(queued, fn)
+	if !running {
+		go q.drain(chatID)
+	}
+}
+
+func (q *chatQueue) drain(chatID int64) {
+	for {
+		q.mu.Lock()
+		queued := q.pending[chatID]
+		if len(queued) == 0 {
+			delete(q.pending, chatID)
+			q.mu.Unlock()
+			return
+		}
+		fn := queued[0]
+		q.pending[chatID] = queued[1:]
+		q.mu.Unlock()
+		fn()
+		q.wg.Done()
+	}
+}
+
+// Wait blocks until every queued update has run.
+func (q *chatQueue) Wait() {
+	q.wg.Wait()
+}
@@ -0,0 +1,208 @@
+// Package whatsapptest provides a local fake of the WhatsApp Cloud API and a client that delivers signed
+// webhook requests to a WhatsAppBot.
+package whatsapptest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/pkg/errors"
+)
+
+// Button is a reply button or a list row.
+type Button struct {
+	ID    string
+	Title string
+}
+
+// Message is a decoded `/messages` call.
+type Message struct {
+	To string
+	// Type is `text`, `button`, `list` or `template`.
+	Type     string
+	Text     string
+	Buttons  []Button
+	Template string
+}
+
+// Server is a fake Cloud API. Point a WhatsAppBot at it with bot.WithWhatsAppAPIURL(server.APIURL()).
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewServer() *Server {
+	s := &Server{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) APIURL() string {
+	return s.URL + "/v21.0/"
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"message": "missing access token"}})
+		return
+	}
+	if !strings.HasSuffix(r.URL.Path, "/messages") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	var params map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_ = json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"message": "invalid json"}})
+		return
+	}
+	msg := decodeMessage(params)
+	s.mu.Lock()
+	s.messages = append(s.messages, msg)
+	id := len(s.messages)
+	s.mu.Unlock()
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"messaging_product": "whatsapp",
+		"contacts":          []any{map[string]any{"wa_id": msg.To}},
+		"messages":          []any{map[string]any{"id": fmt.Sprintf("wamid.%d", id)}},
+	})
+}
+
+// Messages returns the messages sent to the WhatsApp ID to, in order.
+func (s *Server) Messages(to string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []Message
+	for _, msg := range s.messages {
+		if msg.To == to {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+func (s *Server) LastMessage(to string) (Message, bool) {
+	messages := s.Messages(to)
+	if len(messages) == 0 {
+		return Message{}, false
+	}
+	return messages[len(messages)-1], true
+}
+
+func decodeMessage(params map[string]any) Message {
+	msg := Message{
+		To:   stringValue(params["to"]),
+		Type: stringValue(params["type"]),
+	}
+	switch msg.Type {
+	case "text":
+		text, _ := params["text"].(map[string]any)
+		msg.Text = stringValue(text["body"])
+	case "template":
+		template, _ := params["template"].(map[string]any)
+		msg.Template = stringValue(template["name"])
+	case "interactive":
+		interactive, _ := params["interactive"].(map[string]any)
+		body, _ := interactive["body"].(map[string]any)
+		action, _ := interactive["action"].(map[string]any)
+		msg.Type = stringValue(interactive["type"])
+		msg.Text = stringValue(body["text"])
+		buttons, _ := action["buttons"].([]any)
+		for _, button := range buttons {
+			b, _ := button.(map[string]any)
+			reply, _ := b["reply"].(map[string]any)
+			msg.Buttons = append(msg.Buttons, Button{ID: stringValue(reply["id"]), Title: stringValue(reply["title"])})
+		}
+		sections, _ := action["sections"].([]any)
+		for _, section := range sections {
+			sec, _ := section.(map[string]any)
+			rows, _ := sec["rows"].([]any)
+			for _, row := range rows {
+				r, _ := row.(map[string]any)
+				msg.Buttons = append(msg.Buttons, Button{ID: stringValue(r["id"]), Title: stringValue(r["title"])})
+			}
+		}
+	}
+	return msg
+}
+
+func stringValue(value any) string {
+	text, _ := value.(string)
+	return text
+}
+
+// Client delivers signed webhook requests to a WhatsAppBot. When the handler has a Wait method, like
+// WhatsAppBot, each delivery returns once the message it carries is handled.
+type Client struct {
+	Handler   http.Handler
+	AppSecret string
+}
+
+// SendText delivers a text message from the WhatsApp ID from.
+func (c *Client) SendText(from string, text string) error {
+	return c.deliver(map[string]any{
+		"from": from,
+		"type": "text",
+		"text": map[string]any{"body": text},
+	})
+}
+
+// Click delivers a reply button press, or a list row selection when list is true.
+func (c *Client) Click(from string, button Button, list bool) error {
+	interactive := map[string]any{
+		"type":         "button_reply",
+		"button_reply": map[string]any{"id": button.ID, "title": button.Title},
+	}
+	if list {
+		interactive = map[string]any{
+			"type":       "list_reply",
+			"list_reply": map[string]any{"id": button.ID, "title": button.Title},
+		}
+	}
+	return c.deliver(map[string]any{
+		"from":        from,
+		"type":        "interactive",
+		"interactive": interactive,
+	})
+}
+
+func (c *Client) deliver(message map[string]any) error {
+	body, err := json.Marshal(map[string]any{
+		"object": "whatsapp_business_account",
+		"entry": []any{map[string]any{
+			"changes": []any{map[string]any{
+				"field": "messages",
+				"value": map[string]any{
+					"messaging_product": "whatsapp",
+					"messages":          []any{message},
+				},
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req := httptest.NewRequest(http.MethodPost, "/whatsapp", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Hub-Signature-256", bot.WhatsAppSignature(c.AppSecret, body))
+	rec := httptest.NewRecorder()
+	c.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		raw, _ := io.ReadAll(rec.Body)
+		return errors.Errorf("whatsapp webhook failed with status %d: %s", rec.Code, string(raw))
+	}
+	if waiter, ok := c.Handler.(interface{ Wait() }); ok {
+		waiter.Wait()
+	}
+	return nil
+}
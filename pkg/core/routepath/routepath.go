@@ -2,6 +2,7 @@ package routepath
 
 import (
 	"fmt"
+	"net/url"
 	"regexp"
 	"strings"
 )
@@ -18,6 +19,62 @@ func (p Params) Get(key string) (string, bool) {
 	return value, ok
 }
 
+// placeholder is a `{name}` in a pattern. `{name:int}` and `{name:regexp}` constrain the value, and
+// `{name...}` catches the rest of the path.
+type placeholder struct {
+	name       string
+	constraint string
+	catchAll   bool
+}
+
+// constraintRegexp returns the regexp matching the value of p, without anchors.
+func (p placeholder) constraintRegexp() string {
+	switch {
+	case p.catchAll:
+		return ".+"
+	case p.constraint == "int":
+		return "-?[0-9]+"
+	case p.constraint != "":
+		return p.constraint
+	default:
+		return "[^/]+"
+	}
+}
+
+// readPlaceholder reads the placeholder at the start of s, which begins with '{', and returns its length.
+// Constraints may contain braces, as in `{code:[0-9]{3}}`.
+func readPlaceholder(s string, pattern string) (placeholder, int, error) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			body := s[1:i]
+			var p placeholder
+			if name, ok := strings.CutSuffix(body, "..."); ok {
+				p = placeholder{name: name, catchAll: true}
+			} else {
+				p.name, p.constraint, _ = strings.Cut(body, ":")
+			}
+			if p.name == "" {
+				return placeholder{}, 0, fmt.Errorf("routepath: empty param name in %s", pattern)
+			}
+			if p.constraint != "" && p.constraint != "int" {
+				if _, err := regexp.Compile(p.constraint); err != nil {
+					return placeholder{}, 0, fmt.Errorf("routepath: invalid constraint of param %s in %s: %w", p.name, pattern, err)
+				}
+			}
+			return p, i + 1, nil
+		}
+	}
+	return placeholder{}, 0, fmt.Errorf("routepath: missing closing brace in %s", pattern)
+}
+
 type Matcher struct {
 	re     *regexp.Regexp
 	params []string
@@ -42,17 +99,14 @@ func Compile(pattern string) (Matcher, error) {
 	for i := 0; i < len(pattern); i++ {
 		ch := pattern[i]
 		if ch == '{' {
-			end := strings.IndexByte(pattern[i:], '}')
-			if end == -1 {
-				return Matcher{}, fmt.Errorf("routepath: missing closing brace in %s", pattern)
-			}
-			name := pattern[i+1 : i+end]
-			if name == "" {
-				return Matcher{}, fmt.Errorf("routepath: empty param name in %s", pattern)
+			p, n, err := readPlaceholder(pattern[i:], pattern)
+			if err != nil {
+				return Matcher{}, err
 			}
-			names = append(names, name)
-			sb.WriteString("([^/]+)")
-			i += end
+			// named groups keep the params apart from groups inside constraints
+			fmt.Fprintf(&sb, "(?P<p%d>%s)", len(names), p.constraintRegexp())
+			names = append(names, p.name)
+			i += n - 1
 			continue
 		}
 		sb.WriteString(regexp.QuoteMeta(string(ch)))
@@ -73,7 +127,46 @@ func (m Matcher) Match(path string) (Params, bool) {
 	}
 	params := make(map[string]string, len(m.params))
 	for i, name := range m.params {
-		params[name] = matches[i+1]
+		params[name] = matches[m.re.SubexpIndex(fmt.Sprintf("p%d", i))]
 	}
 	return Params{values: params}, true
 }
+
+// Expand replaces every {name} placeholder in pattern with its value from params. Values are
+// path-escaped in the path part and query-escaped after '?'. Catch-all values keep their slashes.
+func Expand(pattern string, params Params) (string, error) {
+	var sb strings.Builder
+	inQuery := false
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		if ch == '?' {
+			inQuery = true
+		}
+		if ch != '{' {
+			sb.WriteByte(ch)
+			continue
+		}
+		p, n, err := readPlaceholder(pattern[i:], pattern)
+		if err != nil {
+			return "", err
+		}
+		value, ok := params.Get(p.name)
+		if !ok {
+			return "", fmt.Errorf("routepath: missing value for param %s in %s", p.name, pattern)
+		}
+		switch {
+		case inQuery:
+			sb.WriteString(url.QueryEscape(value))
+		case p.catchAll:
+			segments := strings.Split(value, "/")
+			for i, segment := range segments {
+				segments[i] = url.PathEscape(segment)
+			}
+			sb.WriteString(strings.Join(segments, "/"))
+		default:
+			sb.WriteString(url.PathEscape(value))
+		}
+		i += n - 1
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,212 @@
+package routepath
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Router matches a path against many patterns in one walk over a tree of path segments. A static segment
+// wins over a param, an `int` param over a regexp one, a regexp param over a plain one and a plain param
+// over a catch-all; when a branch fails further down, the next one is tried. Params must span a whole
+// segment, and a catch-all must be the last one.
+type Router struct {
+	root *routerNode
+}
+
+type routerNode struct {
+	static   map[string]*routerNode
+	params   []*routerParam
+	catchAll *routerCatchAll
+	// pattern is the pattern ending at this node, if any.
+	pattern string
+}
+
+type routerParam struct {
+	placeholder
+	re *regexp.Regexp
+	// pattern is the first pattern that added the param, for conflict errors.
+	pattern string
+	node    *routerNode
+}
+
+type routerCatchAll struct {
+	name    string
+	pattern string
+}
+
+// rank orders the params of a node by precedence.
+func (p *routerParam) rank() int {
+	switch p.constraint {
+	case "int":
+		return 0
+	case "":
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (p *routerParam) accepts(segment string) bool {
+	switch {
+	case p.constraint == "int":
+		// ParseInt also takes a leading '+', which the `-?[0-9]+` of Compile does not
+		if strings.HasPrefix(segment, "+") {
+			return false
+		}
+		_, err := strconv.ParseInt(segment, 10, 64)
+		return err == nil
+	case p.re != nil:
+		return p.re.MatchString(segment)
+	default:
+		return true
+	}
+}
+
+// NewRouter builds a router from patterns and fails on the first invalid or conflicting one.
+func NewRouter(patterns ...string) (*Router, error) {
+	r := &Router{root: &routerNode{}}
+	for _, pattern := range patterns {
+		if err := r.Add(pattern); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func MustNewRouter(patterns ...string) *Router {
+	r, err := NewRouter(patterns...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Add adds pattern to the router. Two patterns conflict when they match the same paths with the same
+// precedence, such as `/todo/{ID}` and `/todo/{name}`.
+func (r *Router) Add(pattern string) error {
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("routepath: pattern must start with '/': %s", pattern)
+	}
+	node := r.root
+	names := map[string]bool{}
+	segments := splitPath(pattern)
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") {
+			if strings.Contains(segment, "{") {
+				return fmt.Errorf("routepath: param must span a whole segment in %s", pattern)
+			}
+			child := node.static[segment]
+			if child == nil {
+				if node.static == nil {
+					node.static = map[string]*routerNode{}
+				}
+				child = &routerNode{}
+				node.static[segment] = child
+			}
+			node = child
+			continue
+		}
+		p, n, err := readPlaceholder(segment, pattern)
+		if err != nil {
+			return err
+		}
+		if n != len(segment) {
+			return fmt.Errorf("routepath: param must span a whole segment in %s", pattern)
+		}
+		if names[p.name] {
+			return fmt.Errorf("routepath: duplicate param %s in %s", p.name, pattern)
+		}
+		names[p.name] = true
+		if p.catchAll {
+			if i != len(segments)-1 {
+				return fmt.Errorf("routepath: catch-all param %s must be the last segment of %s", p.name, pattern)
+			}
+			if node.catchAll != nil {
+				return fmt.Errorf("routepath: %s conflicts with %s", pattern, node.catchAll.pattern)
+			}
+			node.catchAll = &routerCatchAll{name: p.name, pattern: pattern}
+			return nil
+		}
+		node, err = node.param(p, pattern)
+		if err != nil {
+			return err
+		}
+	}
+	if node.pattern != "" {
+		return fmt.Errorf("routepath: %s conflicts with %s", pattern, node.pattern)
+	}
+	node.pattern = pattern
+	return nil
+}
+
+// param returns the child of n for p, adding it in precedence order.
+func (n *routerNode) param(p placeholder, pattern string) (*routerNode, error) {
+	for _, existing := range n.params {
+		if existing.constraint != p.constraint {
+			continue
+		}
+		if existing.name != p.name {
+			return nil, fmt.Errorf("routepath: %s conflicts with %s: param %s is named %s there", pattern, existing.pattern, p.name, existing.name)
+		}
+		return existing.node, nil
+	}
+	param := &routerParam{placeholder: p, pattern: pattern, node: &routerNode{}}
+	if p.constraint != "" && p.constraint != "int" {
+		param.re = regexp.MustCompile("^(?:" + p.constraint + ")$")
+	}
+	n.params = append(n.params, param)
+	slices.SortStableFunc(n.params, func(a, b *routerParam) int { return a.rank() - b.rank() })
+	return param.node, nil
+}
+
+// Match returns the pattern path matches and its params.
+func (r *Router) Match(path string) (string, Params, bool) {
+	values := map[string]string{}
+	pattern, ok := r.root.match(splitPath(path), values)
+	if !ok {
+		return "", Params{}, false
+	}
+	return pattern, Params{values: values}, true
+}
+
+func (n *routerNode) match(segments []string, values map[string]string) (string, bool) {
+	if len(segments) == 0 {
+		return n.pattern, n.pattern != ""
+	}
+	segment := segments[0]
+	if child := n.static[segment]; child != nil {
+		if pattern, ok := child.match(segments[1:], values); ok {
+			return pattern, true
+		}
+	}
+	if segment == "" {
+		return "", false
+	}
+	for _, param := range n.params {
+		if !param.accepts(segment) {
+			continue
+		}
+		values[param.name] = segment
+		if pattern, ok := param.node.match(segments[1:], values); ok {
+			return pattern, true
+		}
+		delete(values, param.name)
+	}
+	if n.catchAll != nil {
+		values[n.catchAll.name] = strings.Join(segments, "/")
+		return n.catchAll.pattern, true
+	}
+	return "", false
+}
+
+// splitPath splits a path into its segments; "/" has none.
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
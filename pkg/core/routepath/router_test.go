@@ -0,0 +1,117 @@
+package routepath_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/routepath"
+)
+
+func TestRouterPrecedence(t *testing.T) {
+	router, err := routepath.NewRouter(
+		"/",
+		"/todo/{ID:int}",
+		"/todo/add",
+		"/todo/{slug:[a-z-]+}",
+		"/todo/{name}",
+		"/todo/{ID:int}/delete",
+		"/todo/{name}/edit",
+		"/files/{rest...}",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path    string
+		pattern string
+		param   string
+		value   string
+	}{
+		{"/", "/", "", ""},
+		{"/todo/add", "/todo/add", "", ""},
+		{"/todo/42", "/todo/{ID:int}", "ID", "42"},
+		{"/todo/milk-eggs", "/todo/{slug:[a-z-]+}", "slug", "milk-eggs"},
+		{"/todo/Milk", "/todo/{name}", "name", "Milk"},
+		// like the regexp of Compile, the int constraint takes no sign but '-'
+		{"/todo/+5", "/todo/{name}", "name", "+5"},
+		{"/todo/-5", "/todo/{ID:int}", "ID", "-5"},
+		{"/todo/42/delete", "/todo/{ID:int}/delete", "ID", "42"},
+		// the int branch has no edit page, so the plain param is tried next
+		{"/todo/42/edit", "/todo/{name}/edit", "name", "42"},
+		{"/files/a/b.txt", "/files/{rest...}", "rest", "a/b.txt"},
+	} {
+		pattern, params, ok := router.Match(tc.path)
+		if !ok || pattern != tc.pattern {
+			t.Errorf("%s: got %q, %v, want %q", tc.path, pattern, ok, tc.pattern)
+			continue
+		}
+		if tc.param == "" {
+			continue
+		}
+		if value, _ := params.Get(tc.param); value != tc.value {
+			t.Errorf("%s: got %s=%q, want %q", tc.path, tc.param, value, tc.value)
+		}
+		if tc.param == "name" {
+			if _, ok := params.Get("ID"); ok {
+				t.Errorf("%s: expected the params of the failed branch to be dropped", tc.path)
+			}
+		}
+	}
+	for _, path := range []string{"/todo", "/todo/", "/files", "/files/", "/todo/42/delete/x"} {
+		if pattern, _, ok := router.Match(path); ok {
+			t.Errorf("%s: expected no match, got %q", path, pattern)
+		}
+	}
+}
+
+func TestRouterConflicts(t *testing.T) {
+	for _, tc := range []struct {
+		patterns []string
+		err      string
+	}{
+		{[]string{"/todo/{ID}", "/todo/{name}"}, "conflicts with /todo/{ID}"},
+		{[]string{"/todo/{ID:int}", "/todo/{ID:int}"}, "conflicts with"},
+		{[]string{"/files/{rest...}", "/files/{path...}"}, "conflicts with"},
+		{[]string{"/todo_{ID}"}, "whole segment"},
+		{[]string{"/files/{rest...}/x"}, "must be the last segment"},
+		{[]string{"/a/{ID}/{ID}"}, "duplicate param"},
+		{[]string{"/a/{ID:[}"}, "invalid constraint"},
+		{[]string{"todo"}, "must start with '/'"},
+	} {
+		_, err := routepath.NewRouter(tc.patterns...)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: got %v, want an error containing %q", tc.patterns, err, tc.err)
+		}
+	}
+}
+
+func TestCompileConstraints(t *testing.T) {
+	matcher := routepath.MustCompile("/todo_{ID:int}_{code:[0-9]{3}}")
+	params, ok := matcher.Match("/todo_42_007")
+	if !ok {
+		t.Fatal("expected a match")
+	}
+	if id, _ := params.Get("ID"); id != "42" {
+		t.Fatalf("got ID %q", id)
+	}
+	if code, _ := params.Get("code"); code != "007" {
+		t.Fatalf("got code %q", code)
+	}
+	if _, ok := matcher.Match("/todo_x_007"); ok {
+		t.Fatal("expected the int constraint to reject x")
+	}
+
+	route, err := routepath.Expand("/todo/{ID:int}/{rest...}", mustMatch(t, "/x/{ID}/{rest...}", "/x/7/a b/c"))
+	if err != nil || route != "/todo/7/a%20b/c" {
+		t.Fatalf("got %q, %v", route, err)
+	}
+}
+
+func mustMatch(t *testing.T, pattern string, path string) routepath.Params {
+	t.Helper()
+	params, ok := routepath.MustCompile(pattern).Match(path)
+	if !ok {
+		t.Fatalf("%s does not match %s", path, pattern)
+	}
+	return params
+}
@@ -3,12 +3,14 @@ package main
 import (
 	"context"
 	stdErrors "errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 
 	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/tui"
 	"github.com/anclax/botx/pkg/core/session"
 	clifront "github.com/anclax/botx/samples/cli/frontend"
 	"github.com/anclax/botx/samples/common"
@@ -38,16 +40,26 @@ type sampleCommandHandler struct {
 }
 
 func (h *sampleCommandHandler) HandleCommandStart(ctx context.Context, chatID int64, b *bot.Bot) error {
-	return b.Route(ctx, chatID, "/")
+	return b.Route(ctx, chatID, common.RouteRoot())
 }
 
 func main() {
+	useTUI := flag.Bool("tui", false, "run the full-screen terminal UI")
+	flag.Parse()
+
 	ctx := context.Background()
 	sm, err := session.NewMemorySessionManager()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	if *useTUI {
+		if err := runTUI(ctx, sm); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
 	frontend := clifront.New(os.Stdin, os.Stdout)
 	cliBot, err := bot.NewCLIBot(sm, frontend)
@@ -58,7 +70,7 @@ func main() {
 
 	store := common.NewAddressStore()
 	stateProvider := common.NewSampleStateProvider(store)
-	formValidator := &common.SampleFormValidator{}
+	formValidator := common.NewSampleFormValidator(store)
 	defaultHandler := &sampleHandler{cli: cliBot}
 	commandHandler := &sampleCommandHandler{cli: cliBot}
 
@@ -83,3 +95,14 @@ func main() {
 		}
 	}
 }
+
+func runTUI(ctx context.Context, sm session.SessionManager) error {
+	tuiBot, err := tui.New(os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+	store := common.NewAddressStore()
+	common.Register(tuiBot, sm, common.NewSampleStateProvider(store), common.NewSampleFormValidator(store), &sampleHandler{}, &sampleCommandHandler{})
+	_ = tuiBot.SendMessage(ctx, tui.DefaultChatID, &bot.Message{Text: "Type /start to begin."})
+	return tuiBot.Run(ctx)
+}
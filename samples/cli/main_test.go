@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/bottest"
 	"github.com/anclax/botx/pkg/core/session"
 	clifront "github.com/anclax/botx/samples/cli/frontend"
 	"github.com/anclax/botx/samples/common"
@@ -26,6 +27,7 @@ func TestCLISampleFlow(t *testing.T) {
 		"1",
 		"1",
 		"T1234 note",
+		"2",
 		"/address",
 		"1",
 		"3",
@@ -47,7 +49,7 @@ func TestCLISampleFlow(t *testing.T) {
 
 	store := common.NewAddressStore()
 	stateProvider := common.NewSampleStateProvider(store)
-	formValidator := &common.SampleFormValidator{}
+	formValidator := common.NewSampleFormValidator(store)
 	defaultHandler := &sampleHandler{cli: cliBot}
 	commandHandler := &sampleCommandHandler{cli: cliBot}
 
@@ -92,3 +94,99 @@ func TestCLISampleFlow(t *testing.T) {
 		t.Fatalf("expected updated name in output, got: %s", log)
 	}
 }
+
+func TestSampleFlowWithBottest(t *testing.T) {
+	ctx := context.Background()
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		t.Fatalf("session manager: %v", err)
+	}
+	conn, err := bottest.New(sm)
+	if err != nil {
+		t.Fatalf("bottest connector: %v", err)
+	}
+	store := common.NewAddressStore()
+	common.Register(conn, sm, common.NewSampleStateProvider(store), common.NewSampleFormValidator(store), &sampleHandler{}, &sampleCommandHandler{})
+
+	chat := conn.Chat(1)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(chat.SendText(ctx, "/start"))
+	chat.AssertTextContains(t, "欢迎使用地址管理机器人")
+	chat.AssertButtons(t, [][]string{{"管理地址"}, {"返回", "返回主页"}})
+
+	must(chat.Click(ctx, "管理地址"))
+	chat.AssertTextContains(t, "暂无地址，请添加地址")
+	chat.AssertParseMode(t, "HTML")
+
+	must(chat.ClickRoute(ctx, "/address/add"))
+	if chat.Form() == nil {
+		t.Fatal("expected the add form")
+	}
+	var validationErr *bottest.ValidationError
+	if err := chat.SendText(ctx, " "); !stdErrors.As(err, &validationErr) || validationErr.Field != "address" {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+	must(chat.FillForm(ctx, bot.FormValues{"address": "T1 note"}))
+	if form := chat.Form(); form == nil || !form.Reviewing {
+		t.Fatal("expected the form to wait for review")
+	}
+	chat.AssertText(t, "请确认地址信息：\n<b>地址</b>: T1 note")
+	chat.AssertButtons(t, [][]string{{"修改 地址"}, {"提交"}})
+	must(chat.Click(ctx, "修改 地址"))
+	must(chat.SendText(ctx, "T1234 note"))
+	chat.AssertTextContains(t, "<b>地址</b>: T1234 note")
+	must(chat.Click(ctx, "提交"))
+	chat.AssertTextContains(t, "地址添加成功")
+	if hist, err := chat.History(ctx); err != nil || hist[len(hist)-1] != "/address/add" {
+		t.Fatalf("expected /address/add on top of the history, got %v (%v)", hist, err)
+	}
+
+	must(chat.SendText(ctx, "/address"))
+	must(chat.Click(ctx, "note"))
+	chat.AssertText(t, "地址: T1234\n备注: note\n\n请点击下列按钮进行操作")
+	must(chat.Click(ctx, "编辑备注"))
+	if form := chat.Form(); form == nil || form.Fields[0].Input.Default != "note" || form.KeepLabel != "保持不变" {
+		t.Fatalf("expected the current name in the edit form, got %+v", form)
+	}
+	must(chat.KeepCurrent(ctx))
+	chat.AssertTextContains(t, "地址修改成功")
+	must(chat.Click(ctx, "返回地址详情"))
+	chat.AssertTextContains(t, "备注: note")
+	must(chat.Click(ctx, "编辑备注"))
+	must(chat.SendText(ctx, "home"))
+	chat.AssertTextContains(t, "地址修改成功")
+	must(chat.Click(ctx, "返回地址详情"))
+	chat.AssertTextContains(t, "备注: home")
+
+	// the form validation sends a duplicate back to the address
+	must(chat.SendText(ctx, "/address"))
+	must(chat.ClickRoute(ctx, "/address/add"))
+	must(chat.FillForm(ctx, bot.FormValues{"address": "T1234 again"}))
+	chat.AssertText(t, "该地址已存在，请输入其他地址")
+	if form := chat.Form(); form == nil || form.Fields[form.Idx].ID != "address" {
+		t.Fatalf("expected the address to be asked again, got %+v", form)
+	}
+	must(chat.SendText(ctx, "T42 again"))
+	chat.AssertTextContains(t, "<b>地址</b>: T42 again")
+	must(chat.Click(ctx, "提交"))
+	chat.AssertTextContains(t, "地址添加成功")
+
+	must(chat.SendText(ctx, "/address"))
+	must(chat.Click(ctx, "home"))
+	must(chat.Click(ctx, "删除地址"))
+	chat.AssertTextContains(t, "地址删除成功")
+
+	// Chats are independent.
+	other := conn.Chat(2)
+	must(other.SendText(ctx, "/start"))
+	other.AssertHistory(t, "/")
+	if len(chat.Errors()) != 0 || len(other.Messages()) != 1 {
+		t.Fatalf("unexpected errors %v or messages %d", chat.Errors(), len(other.Messages()))
+	}
+}
@@ -148,6 +148,17 @@ func (s *SampleStateProvider) ProvideAddressDeleteState(_ context.Context, _ int
 	return NewStatePageAddressDelete(true, ""), nil
 }
 
+func (s *SampleStateProvider) ProvideAddressEditFormState(_ context.Context, _ int64, parameters *ParametersPageAddressEdit) (*StateFormAddressEdit, error) {
+	item, ok := s.store.get(parameters.GetID())
+	if !ok {
+		return nil, errors.Wrapf(bot.ErrNotFound, "address %d not found", parameters.GetID())
+	}
+	if parameters.GetField() == "name" {
+		return NewStateFormAddressEdit(item.Name), nil
+	}
+	return NewStateFormAddressEdit(item.Address), nil
+}
+
 func (s *SampleStateProvider) ProvideAddressEditState(_ context.Context, _ int64, form *FormAddressEdit, parameters *ParametersPageAddressEdit) (*StatePageAddressEdit, error) {
 	value := strings.TrimSpace(form.GetValue())
 	if value == "" {
@@ -159,9 +170,15 @@ func (s *SampleStateProvider) ProvideAddressEditState(_ context.Context, _ int64
 	return NewStatePageAddressEdit(true, ""), nil
 }
 
-type SampleFormValidator struct{}
+type SampleFormValidator struct {
+	store *AddressStore
+}
+
+func NewSampleFormValidator(store *AddressStore) *SampleFormValidator {
+	return &SampleFormValidator{store: store}
+}
 
-func (v *SampleFormValidator) ValidateFormAddressAdd(_ context.Context, _ int64, _ *url.URL, input string) (*bot.ValidateResult, error) {
+func (v *SampleFormValidator) ValidateAddressOrName(_ context.Context, _ int64, _ *url.URL, _ string, input string, _ bot.FormValues) (*bot.ValidateResult, error) {
 	if strings.TrimSpace(input) == "" {
 		return &bot.ValidateResult{
 			Valid:        false,
@@ -171,6 +188,16 @@ func (v *SampleFormValidator) ValidateFormAddressAdd(_ context.Context, _ int64,
 	return &bot.ValidateResult{Valid: true}, nil
 }
 
+func (v *SampleFormValidator) ValidateFormAddressAdd(_ context.Context, _ int64, form *FormAddressAdd, _ *ParametersPageAddressAdd) (*bot.ValidateResult, error) {
+	address, _ := parseAddressInput(form.GetAddress())
+	for _, item := range v.store.list() {
+		if item.Address == address {
+			return &bot.ValidateResult{ErrorMessage: "该地址已存在，请输入其他地址", Field: "address"}, nil
+		}
+	}
+	return &bot.ValidateResult{Valid: true}, nil
+}
+
 func parseAddressInput(input string) (string, string) {
 	parts := strings.Fields(strings.TrimSpace(input))
 	if len(parts) == 0 {
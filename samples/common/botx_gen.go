@@ -2,10 +2,11 @@ package common
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/anclax/botx/pkg/core/bot"
 	"github.com/anclax/botx/pkg/core/routepath"
@@ -62,8 +63,9 @@ type CommandHandler interface {
 }
 
 // Register bot handler to bot. the param bot and param stateProvider is implemented by user
-func Register(connector bot.BotConnector, sm session.SessionManager, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler) {
-	wrapped := bot.NewBot(connector)
+// Options such as bot.WithCallbackSigner configure the bot passed to the handlers.
+func Register(connector bot.BotConnector, sm session.SessionManager, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler, opts ...bot.BotOption) {
+	wrapped := bot.NewBot(connector, append([]bot.BotOption{bot.WithBotSessionManager(sm)}, opts...)...)
 	botxHandler := &BotxHandler{
 		renderer:       &PageRenderer{wrapped},
 		bot:            wrapped,
@@ -92,6 +94,9 @@ func (h *BotxHandler) getRouter(ctx context.Context, chatID int64) (*bot.Router,
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create router")
 		}
+		if err := sess.Set(ctx, bot.SessionKeyRouter, router); err != nil {
+			return nil, errors.Wrap(err, "failed to store router")
+		}
 	}
 	return router.(*bot.Router), nil
 }
@@ -136,15 +141,38 @@ func (h *BotxHandler) handleRoute(ctx context.Context, chatID int64, data string
 	}
 
 	routeURL := strings.TrimPrefix(data, fmt.Sprintf("%s:", bot.CallbackPrefixRoute))
+	mode, target, ok := strings.Cut(routeURL, ":")
+	if ok && (mode == bot.RouteModeReplace || mode == bot.RouteModeReset) {
+		routeURL = target
+	} else {
+		mode = ""
+	}
 
-	if routeURL == "back" {
+	switch {
+	case routeURL == "back":
 		last, err := router.Back(ctx)
 		if err != nil {
 			return errors.Wrap(err, "failed to go back")
 		}
 		routeURL = last
-	} else {
-		if err := router.Push(ctx, routeURL); err != nil {
+	case isActionRoute(routeURL):
+		if err := router.PushAction(ctx, routeURL); err != nil {
+			return errors.Wrap(err, "failed to push action route")
+		}
+	case mode == bot.RouteModeReset:
+		if err := router.Reset(ctx, routeURL); err != nil {
+			return errors.Wrap(err, "failed to reset route")
+		}
+	default:
+		current, err := router.Current(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get current route")
+		}
+		if mode == bot.RouteModeReplace || isReplaceHistoryRoute(routeURL, current) {
+			if err := router.Replace(ctx, routeURL); err != nil {
+				return errors.Wrap(err, "failed to replace route")
+			}
+		} else if err := router.Push(ctx, routeURL); err != nil {
 			return errors.Wrap(err, "failed to push route")
 		}
 	}
@@ -178,6 +206,14 @@ func (h *BotxHandler) handleSubmit(ctx context.Context, chatID int64, data strin
 	return nil
 }
 
+func (h *BotxHandler) handleConfirm(ctx context.Context, chatID int64, data string, b bot.BotConnector) (string, error) {
+	sess, err := h.sm.Get(ctx, chatID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get session")
+	}
+	return bot.HandleConfirm(ctx, b, sess, chatID, data)
+}
+
 func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data string) error {
 	lang := strings.TrimSpace(strings.TrimPrefix(data, "lang:"))
 	if lang == "" {
@@ -198,13 +234,9 @@ func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data str
 	if err != nil {
 		return errors.Wrap(err, "failed to get router")
 	}
-	hist, err := router.History(ctx)
+	current, err := router.Current(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to get router history")
-	}
-	current := "/"
-	if len(hist) != 0 {
-		current = hist[len(hist)-1]
+		return errors.Wrap(err, "failed to get current route")
 	}
 	url, err := url.Parse(current)
 	if err != nil {
@@ -230,6 +262,10 @@ func (h *BotxHandler) HandleTextMessage(ctx context.Context, data string, chatID
 }
 
 func (h *BotxHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	data, err := h.bot.VerifyCallbackData(ctx, chatID, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify callback data")
+	}
 	if strings.HasPrefix(data, "lang:") {
 		if err := h.handleLanguage(ctx, chatID, data); err != nil {
 			return errors.Wrap(err, "failed to handle language switch")
@@ -248,6 +284,16 @@ func (h *BotxHandler) HandleCallbackData(ctx context.Context, data string, chatI
 		}
 		return nil
 	}
+	if strings.HasPrefix(data, fmt.Sprintf("%s:", bot.CallbackPrefixConfirm)) {
+		callback, err := h.handleConfirm(ctx, chatID, data, b)
+		if err != nil {
+			return errors.Wrap(err, "failed to handle confirmation")
+		}
+		if callback == "" {
+			return nil
+		}
+		return h.HandleCallbackData(ctx, callback, chatID, b)
+	}
 	if err := h.defaultHandler.HandleCallbackData(ctx, data, chatID, h.bot); err != nil {
 		return errors.Wrap(err, "failed to handle callback data in default handler")
 	}
@@ -268,9 +314,14 @@ func (h *BotxHandler) HandleError(ctx context.Context, err error, chatID int64,
 }
 
 func (h *BotxHandler) Validate(ctx context.Context, chatID int64, url *url.URL, validator string, input string) (*bot.ValidateResult, error) {
+	return h.ValidateFormField(ctx, chatID, url, validator, "", input, nil)
+}
+
+// ValidateFormField runs validator on the input of field, given the values entered before it.
+func (h *BotxHandler) ValidateFormField(ctx context.Context, chatID int64, url *url.URL, validator string, field string, input string, values bot.FormValues) (*bot.ValidateResult, error) {
 	switch validator {
 	case "validateAddressOrName":
-		return h.formValidator.ValidateFormAddressAdd(ctx, chatID, url, input)
+		return h.formValidator.ValidateAddressOrName(ctx, chatID, url, field, input, values)
 	default:
 		return nil, errors.Wrapf(bot.ErrNotFound, "unknown validator: %s", validator)
 	}
@@ -278,19 +329,51 @@ func (h *BotxHandler) Validate(ctx context.Context, chatID int64, url *url.URL,
 
 // code generated for pages
 
-var (
-	addressIDMatcher     = routepath.MustCompile("/address/{ID}")
-	addressDeleteMatcher = routepath.MustCompile("/address/{ID}/delete")
-	addressEditMatcher   = routepath.MustCompile("/address/{ID}/edit")
+// pageRouter matches a route against the page paths; static segments win over params.
+var pageRouter = routepath.MustNewRouter(
+	"/",
+	"/address",
+	"/address/add",
+	"/address/{ID}",
+	"/address/{ID}/delete",
+	"/address/{ID}/edit",
 )
 
-func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) error {
-	paramsAddressID, okAddressID := addressIDMatcher.Match(url.Path)
-	paramsAddressDelete, okAddressDelete := addressDeleteMatcher.Match(url.Path)
-	paramsAddressEdit, okAddressEdit := addressEditMatcher.Match(url.Path)
+// actionPages are the pages with side effects. They stay out of the router history, so going back,
+// switching language or re-rendering never runs them again.
+var actionPages = map[string]bool{
+	"/address/{ID}/delete": true,
+}
 
-	switch {
-	case url.Path == "/":
+func isActionRoute(route string) bool {
+	path, _, _ := strings.Cut(route, "?")
+	pattern, _, ok := pageRouter.Match(path)
+	return ok && actionPages[pattern]
+}
+
+// replaceHistoryPages are the pages with `history: replace`. Opening one from itself with other query
+// params, e.g. the next page of a list, replaces its history entry instead of adding one.
+var replaceHistoryPages = map[string]bool{
+	"/address": true,
+}
+
+func isReplaceHistoryRoute(route string, current string) bool {
+	path, _, _ := strings.Cut(route, "?")
+	currentPath, _, _ := strings.Cut(current, "?")
+	if path != currentPath {
+		return false
+	}
+	pattern, _, ok := pageRouter.Match(path)
+	return ok && replaceHistoryPages[pattern]
+}
+
+func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) error {
+	pattern, routeParams, ok := pageRouter.Match(url.Path)
+	if !ok {
+		return errors.Wrapf(bot.ErrNotFound, "unknown route: %s", url.String())
+	}
+	switch pattern {
+	case "/":
 		params, err := ParseParametersPageRoot(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /")
@@ -302,7 +385,7 @@ func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) e
 		if err := h.renderer.pageRoot(ctx, chatID, state, params); err != nil {
 			return errors.Wrap(err, "failed to render page /")
 		}
-	case url.Path == "/address":
+	case "/address":
 		params, err := ParseParametersPageAddress(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /address")
@@ -314,7 +397,7 @@ func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) e
 		if err := h.renderer.pageAddress(ctx, chatID, state, params); err != nil {
 			return errors.Wrap(err, "failed to render page /address")
 		}
-	case url.Path == "/address/add":
+	case "/address/add":
 		params, err := ParseParametersPageAddressAdd(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /address/add")
@@ -322,8 +405,20 @@ func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) e
 		if err := h.renderer.formAddressAdd(ctx, chatID, url, params); err != nil {
 			return errors.Wrap(err, "failed to render form for page /address/add")
 		}
-	case okAddressDelete:
-		params, err := ParseParametersPageAddressDelete(paramsAddressDelete)
+	case "/address/{ID}":
+		params, err := ParseParametersPageAddressID(routeParams)
+		if err != nil {
+			return errors.Wrap(err, "invalid parameters for page /address/{ID}")
+		}
+		state, err := h.sp.ProvideAddressIDState(ctx, chatID, params)
+		if err != nil {
+			return errors.Wrap(err, "failed to provide state for page /address/{ID}")
+		}
+		if err := h.renderer.pageAddressID(ctx, chatID, state, params); err != nil {
+			return errors.Wrap(err, "failed to render page /address/{ID}")
+		}
+	case "/address/{ID}/delete":
+		params, err := ParseParametersPageAddressDelete(routeParams)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /address/{ID}/delete")
 		}
@@ -334,46 +429,32 @@ func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) e
 		if err := h.renderer.pageAddressDelete(ctx, chatID, state, params); err != nil {
 			return errors.Wrap(err, "failed to render page /address/{ID}/delete")
 		}
-	case okAddressEdit:
-		params, err := ParseParametersPageAddressEdit(url, paramsAddressEdit)
+	case "/address/{ID}/edit":
+		params, err := ParseParametersPageAddressEdit(url, routeParams)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /address/{ID}/edit")
 		}
-		if err := h.renderer.formAddressEdit(ctx, chatID, url, params); err != nil {
-			return errors.Wrap(err, "failed to render form for page /address/{ID}/edit")
-		}
-	case okAddressID:
-		params, err := ParseParametersPageAddressID(paramsAddressID)
-		if err != nil {
-			return errors.Wrap(err, "invalid parameters for page /address/{ID}")
-		}
-		state, err := h.sp.ProvideAddressIDState(ctx, chatID, params)
+		state, err := h.sp.ProvideAddressEditFormState(ctx, chatID, params)
 		if err != nil {
-			return errors.Wrap(err, "failed to provide state for page /address/{ID}")
+			return errors.Wrap(err, "failed to provide form state for page /address/{ID}/edit")
 		}
-		if err := h.renderer.pageAddressID(ctx, chatID, state, params); err != nil {
-			return errors.Wrap(err, "failed to render page /address/{ID}")
+		if err := h.renderer.formAddressEdit(ctx, chatID, url, params, state); err != nil {
+			return errors.Wrap(err, "failed to render form for page /address/{ID}/edit")
 		}
-	default:
-		return errors.Wrapf(bot.ErrNotFound, "unknown route: %s", url.String())
 	}
 	return nil
 }
 
 func (h *BotxHandler) onSubmit(ctx context.Context, chatID int64, url *url.URL) error {
-	raw := url.Query().Get("values")
-	if raw == "" {
+	// the values come from the form engine, never from the callback data
+	values, ok := bot.FormValuesFromContext(ctx)
+	if !ok {
 		return errors.Wrap(bot.ErrBadRequest, "missing form values")
 	}
-	var values bot.FormValues
-	if err := json.Unmarshal([]byte(raw), &values); err != nil {
-		return errors.Wrap(err, "failed to unmarshal form values")
-	}
-
-	paramsAddressEdit, okAddressEdit := addressEditMatcher.Match(url.Path)
 
-	switch {
-	case url.Path == "/address/add":
+	pattern, routeParams, _ := pageRouter.Match(url.Path)
+	switch pattern {
+	case "/address/add":
 		params, err := ParseParametersPageAddressAdd(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for form /address/add")
@@ -389,8 +470,8 @@ func (h *BotxHandler) onSubmit(ctx context.Context, chatID int64, url *url.URL)
 		if err := h.renderer.pageAddressAdd(ctx, chatID, state, params); err != nil {
 			return errors.Wrap(err, "failed to render page for form /address/add")
 		}
-	case okAddressEdit:
-		params, err := ParseParametersPageAddressEdit(url, paramsAddressEdit)
+	case "/address/{ID}/edit":
+		params, err := ParseParametersPageAddressEdit(url, routeParams)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for form /address/{ID}/edit")
 		}
@@ -411,6 +492,24 @@ func (h *BotxHandler) onSubmit(ctx context.Context, chatID int64, url *url.URL)
 	return nil
 }
 
+// command menus
+
+// CommandMenus lists the commands with a description for every i18n language and scope. Connectors
+// register them with the platform, e.g. Telegram setMyCommands. An empty language is the fallback menu.
+func (h *BotxHandler) CommandMenus(ctx context.Context) []bot.CommandMenu {
+	var menus []bot.CommandMenu
+	for _, lang := range []string{""} {
+		menus = append(menus, bot.CommandMenu{
+			Language: lang,
+			Scope:    bot.CommandScopeDefault,
+			Commands: []bot.Command{
+				{Command: "start", Description: "打开主页"},
+			},
+		})
+	}
+	return menus
+}
+
 // url to params
 
 func ParseParametersPageRoot(url *url.URL) (*ParametersPageRoot, error) {
@@ -418,17 +517,17 @@ func ParseParametersPageRoot(url *url.URL) (*ParametersPageRoot, error) {
 }
 
 func ParseParametersPageAddress(url *url.URL) (*ParametersPageAddress, error) {
-	column, err := parseOptionalIntQuery(url, "column", defaultAddressColumns)
+	column, err := parseQueryParam(url, "column", false, defaultAddressColumns, ToInt)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid column parameter: %s", err.Error())
+		return nil, err
 	}
-	page, err := parseOptionalIntQuery(url, "page", defaultAddressPage)
+	page, err := parseQueryParam(url, "page", false, defaultAddressPage, ToInt)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid page parameter: %s", err.Error())
+		return nil, err
 	}
-	row, err := parseOptionalIntQuery(url, "row", defaultAddressRows)
+	row, err := parseQueryParam(url, "row", false, defaultAddressRows, ToInt)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid row parameter: %s", err.Error())
+		return nil, err
 	}
 	return &ParametersPageAddress{
 		column: column,
@@ -442,9 +541,9 @@ func ParseParametersPageAddressAdd(url *url.URL) (*ParametersPageAddressAdd, err
 }
 
 func ParseParametersPageAddressID(params routepath.Params) (*ParametersPageAddressID, error) {
-	id, err := parseParamInt64(params, "ID")
+	id, err := parsePathParam(params, "ID", ToInt64)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid id parameter: %s", err.Error())
+		return nil, err
 	}
 	return &ParametersPageAddressID{
 		ID: id,
@@ -452,9 +551,9 @@ func ParseParametersPageAddressID(params routepath.Params) (*ParametersPageAddre
 }
 
 func ParseParametersPageAddressDelete(params routepath.Params) (*ParametersPageAddressDelete, error) {
-	id, err := parseParamInt64(params, "ID")
+	id, err := parsePathParam(params, "ID", ToInt64)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid id parameter: %s", err.Error())
+		return nil, err
 	}
 	return &ParametersPageAddressDelete{
 		ID: id,
@@ -462,13 +561,13 @@ func ParseParametersPageAddressDelete(params routepath.Params) (*ParametersPageA
 }
 
 func ParseParametersPageAddressEdit(url *url.URL, params routepath.Params) (*ParametersPageAddressEdit, error) {
-	id, err := parseParamInt64(params, "ID")
+	id, err := parsePathParam(params, "ID", ToInt64)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid id parameter: %s", err.Error())
+		return nil, err
 	}
-	field := url.Query().Get("field")
-	if field == "" {
-		return nil, errors.Wrap(bot.ErrBadRequest, "missing field parameter")
+	field, err := parseQueryParam(url, "field", true, "", parseString)
+	if err != nil {
+		return nil, err
 	}
 	return &ParametersPageAddressEdit{
 		ID:    id,
@@ -476,6 +575,76 @@ func ParseParametersPageAddressEdit(url *url.URL, params routepath.Params) (*Par
 	}, nil
 }
 
+// route builders
+
+// RouteRoot returns the route of page /.
+func RouteRoot() string {
+	return "/"
+}
+
+// AddressParams holds the query parameters of RouteAddress; zero values are left out. Parameters with a
+// default are pointers, set with bot.Ptr, so their zero value can be sent.
+type AddressParams struct {
+	Column *int
+	Page   int
+	Row    *int
+}
+
+// RouteAddress returns the route of page /address.
+func RouteAddress(params ...AddressParams) string {
+	query := url.Values{}
+	for _, p := range params {
+		if p.Column != nil {
+			query.Set("column", fmt.Sprint(*p.Column))
+		}
+		if p.Page != 0 {
+			query.Set("page", fmt.Sprint(p.Page))
+		}
+		if p.Row != nil {
+			query.Set("row", fmt.Sprint(*p.Row))
+		}
+	}
+	if len(query) == 0 {
+		return "/address"
+	}
+	return "/address?" + query.Encode()
+}
+
+// RouteAddressAdd returns the route of page /address/add.
+func RouteAddressAdd() string {
+	return "/address/add"
+}
+
+// RouteAddressID returns the route of page /address/{ID}.
+func RouteAddressID(id int64) string {
+	return "/address/" + url.PathEscape(fmt.Sprint(id))
+}
+
+// RouteAddressDelete returns the route of page /address/{ID}/delete.
+func RouteAddressDelete(id int64) string {
+	return "/address/" + url.PathEscape(fmt.Sprint(id)) + "/delete"
+}
+
+// AddressEditParams holds the query parameters of RouteAddressEdit; zero values are left out. Parameters with a
+// default are pointers, set with bot.Ptr, so their zero value can be sent.
+type AddressEditParams struct {
+	Field string
+}
+
+// RouteAddressEdit returns the route of page /address/{ID}/edit.
+func RouteAddressEdit(id int64, params ...AddressEditParams) string {
+	query := url.Values{}
+	for _, p := range params {
+		if p.Field != "" {
+			query.Set("field", p.Field)
+		}
+	}
+	if len(query) == 0 {
+		return "/address/" + url.PathEscape(fmt.Sprint(id)) + "/edit"
+	}
+	return "/address/" + url.PathEscape(fmt.Sprint(id)) + "/edit?" + query.Encode()
+}
+
 // forms
 
 type FormAddressAdd struct {
@@ -514,10 +683,29 @@ func unmarshalFormAddressEdit(values bot.FormValues) (*FormAddressEdit, error) {
 	}, nil
 }
 
+// ValidateFormValues runs the validate hook of a filled form before it is reviewed or submitted.
+func (h *BotxHandler) ValidateFormValues(ctx context.Context, chatID int64, url *url.URL, values bot.FormValues) (*bot.ValidateResult, error) {
+	pattern, _, _ := pageRouter.Match(url.Path)
+	switch pattern {
+	case "/address/add":
+		params, err := ParseParametersPageAddressAdd(url)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid parameters for form /address/add")
+		}
+		form, err := unmarshalFormAddressAdd(values)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid values for form /address/add")
+		}
+		return h.formValidator.ValidateFormAddressAdd(ctx, chatID, form, params)
+	}
+	return &bot.ValidateResult{Valid: true}, nil
+}
+
 // FormValidator
 
 type FormValidator interface {
-	ValidateFormAddressAdd(ctx context.Context, chatID int64, url *url.URL, input string) (*bot.ValidateResult, error)
+	ValidateAddressOrName(ctx context.Context, chatID int64, url *url.URL, field string, input string, values bot.FormValues) (*bot.ValidateResult, error)
+	ValidateFormAddressAdd(ctx context.Context, chatID int64, form *FormAddressAdd, parameters *ParametersPageAddressAdd) (*bot.ValidateResult, error)
 }
 
 // StateProvider provides state views.
@@ -527,6 +715,7 @@ type StateProvider interface {
 	ProvideAddressAddState(ctx context.Context, chatID int64, form *FormAddressAdd, parameters *ParametersPageAddressAdd) (*StatePageAddressAdd, error)
 	ProvideAddressIDState(ctx context.Context, chatID int64, parameters *ParametersPageAddressID) (*StatePageAddressID, error)
 	ProvideAddressDeleteState(ctx context.Context, chatID int64, parameters *ParametersPageAddressDelete) (*StatePageAddressDelete, error)
+	ProvideAddressEditFormState(ctx context.Context, chatID int64, parameters *ParametersPageAddressEdit) (*StateFormAddressEdit, error)
 	ProvideAddressEditState(ctx context.Context, chatID int64, form *FormAddressEdit, parameters *ParametersPageAddressEdit) (*StatePageAddressEdit, error)
 }
 type PageRenderer struct {
@@ -545,13 +734,13 @@ func (p *PageRenderer) pageRoot(ctx context.Context, chatID int64, state *StateP
 		ButtonGrid: appendButtonGrids(
 			[][]bot.Button{
 				{
-					{Label: "管理地址", CallbackData: bot.CallbackData("route:/address")},
+					{Label: "管理地址", CallbackData: bot.CallbackData("route:" + RouteAddress())},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: "返回", CallbackData: bot.CallbackData("route:back")},
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+					{Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -622,21 +811,24 @@ func (p *PageRenderer) pageAddress(ctx context.Context, chatID int64, state *Sta
 				func(item Address) bot.Button {
 					return bot.Button{
 						Label:        fmt.Sprintf("%v", cond(item.GetName() == "", item.GetAddress(), item.GetName())),
-						CallbackData: bot.CallbackData(fmt.Sprintf("route:/address/%v", item.ID)),
+						CallbackData: bot.CallbackData("route:" + RouteAddressID(item.ID)),
 					}
 				},
+				func(page int) string {
+					return RouteAddress(AddressParams{Column: bot.Ptr(parameters.GetColumn()), Page: page, Row: bot.Ptr(parameters.GetRow())})
+				},
 				"上一页",
 				"下一页",
 			),
 			[][]bot.Button{
 				{
-					{Label: "添加新地址", CallbackData: bot.CallbackData("route:/address/add")},
+					{Label: "添加新地址", CallbackData: bot.CallbackData("route:" + RouteAddressAdd())},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: "返回", CallbackData: bot.CallbackData("route:back")},
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+					{Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -679,7 +871,7 @@ func (p *PageRenderer) pageAddressAdd(ctx context.Context, chatID int64, state *
 		ButtonGrid: [][]bot.Button{
 			{
 				{Label: "返回", CallbackData: bot.CallbackData("route:back")},
-				{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+				{Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 			},
 		},
 	}); err != nil {
@@ -706,6 +898,20 @@ func (p *PageRenderer) formAddressAdd(ctx context.Context, chatID int64, url *ur
 				Validator: ptr("validateAddressOrName"),
 			},
 		},
+		Review: &bot.FormReview{
+			Title:       "请确认地址信息：",
+			EditLabel:   "修改",
+			SubmitLabel: "提交",
+		},
+		Timeout: &bot.FormTimeout{
+			After:   30 * time.Minute,
+			Message: "地址输入已超时，请重新添加。",
+		},
+		Resume: &bot.FormResume{
+			Message:       "你有一个未完成的地址草稿。",
+			ContinueLabel: "继续填写",
+			RestartLabel:  "重新开始",
+		},
 	}
 	if err := p.b.SendForm(ctx, chatID, form); err != nil {
 		return errors.Wrap(err, "failed to send formAddressAdd")
@@ -750,16 +956,16 @@ func (p *PageRenderer) pageAddressID(ctx context.Context, chatID int64, state *S
 		ButtonGrid: appendButtonGrids(
 			[][]bot.Button{
 				{
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
-					{Label: "删除地址", CallbackData: bot.CallbackData(fmt.Sprintf("route:/address/%v/delete", parameters.GetID()))},
-					{Label: "编辑备注", CallbackData: bot.CallbackData(fmt.Sprintf("route:/address/%v/edit?field=name", parameters.GetID()))},
-					{Label: "编辑地址", CallbackData: bot.CallbackData(fmt.Sprintf("route:/address/%v/edit?field=address", parameters.GetID()))},
+					{Label: "返回主页", CallbackData: bot.CallbackData("route:" + RouteRoot())},
+					{Label: "删除地址", CallbackData: bot.CallbackData("route:" + RouteAddressDelete(parameters.GetID()))},
+					{Label: "编辑备注", CallbackData: bot.CallbackData("route:" + RouteAddressEdit(parameters.GetID(), AddressEditParams{Field: "name"}))},
+					{Label: "编辑地址", CallbackData: bot.CallbackData("route:" + RouteAddressEdit(parameters.GetID(), AddressEditParams{Field: "address"}))},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: "返回", CallbackData: bot.CallbackData("route:back")},
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+					{Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -807,14 +1013,14 @@ func (p *PageRenderer) pageAddressDelete(ctx context.Context, chatID int64, stat
 		ButtonGrid: appendButtonGrids(
 			[][]bot.Button{
 				{
-					{Label: "返回地址列表", CallbackData: bot.CallbackData("route:/address")},
-					{Label: "返回地址详情", CallbackData: bot.CallbackData(fmt.Sprintf("route:/address/%v", parameters.GetID()))},
+					{Label: "返回地址列表", CallbackData: bot.CallbackData("route:" + RouteAddress())},
+					{Label: "返回地址详情", CallbackData: bot.CallbackData("route:" + RouteAddressID(parameters.GetID()))},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: "返回", CallbackData: bot.CallbackData("route:back")},
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+					{Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -857,6 +1063,20 @@ func (s *StatePageAddressEdit) GetError() string {
 	return s.errMsg
 }
 
+type StateFormAddressEdit struct {
+	value string
+}
+
+func NewStateFormAddressEdit(value string) *StateFormAddressEdit {
+	return &StateFormAddressEdit{
+		value: value,
+	}
+}
+
+func (s *StateFormAddressEdit) GetValue() string {
+	return s.value
+}
+
 func (p *PageRenderer) pageAddressEdit(ctx context.Context, chatID int64, state *StatePageAddressEdit, parameters *ParametersPageAddressEdit) error {
 	if err := p.b.SendMessage(ctx, chatID, &bot.Message{
 		Text: fmt.Sprintf("%v\n", cond(
@@ -867,15 +1087,15 @@ func (p *PageRenderer) pageAddressEdit(ctx context.Context, chatID int64, state
 		ButtonGrid: appendButtonGrids(
 			[][]bot.Button{
 				{
-					{Label: "返回地址详情", CallbackData: bot.CallbackData(fmt.Sprintf("route:/address/%v", parameters.GetID()))},
-					{Label: "返回地址列表", CallbackData: bot.CallbackData("route:/address")},
-					{Label: "重新编辑", CallbackData: bot.CallbackData(fmt.Sprintf("route:/address/%v/edit?field=%v", parameters.GetID(), parameters.GetField()))},
+					{Label: "返回地址详情", CallbackData: bot.CallbackData("route:" + RouteAddressID(parameters.GetID()))},
+					{Label: "返回地址列表", CallbackData: bot.CallbackData("route:" + RouteAddress())},
+					{Label: "重新编辑", CallbackData: bot.CallbackData("route:" + RouteAddressEdit(parameters.GetID(), AddressEditParams{Field: parameters.GetField()}))},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: "返回", CallbackData: bot.CallbackData("route:back")},
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+					{Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -885,7 +1105,7 @@ func (p *PageRenderer) pageAddressEdit(ctx context.Context, chatID int64, state
 	return nil
 }
 
-func (p *PageRenderer) formAddressEdit(ctx context.Context, chatID int64, url *url.URL, parameters *ParametersPageAddressEdit) error {
+func (p *PageRenderer) formAddressEdit(ctx context.Context, chatID int64, url *url.URL, parameters *ParametersPageAddressEdit, state *StateFormAddressEdit) error {
 	form := &bot.Form{
 		URL: url,
 		Idx: 0,
@@ -898,10 +1118,13 @@ func (p *PageRenderer) formAddressEdit(ctx context.Context, chatID int64, url *u
 						Type:   "string",
 						Format: "",
 					},
-					Tip: fmt.Sprintf("%v", cond(parameters.GetField() == "name", "请输入新的备注名称", "请输入新的地址")),
+					Tip:     fmt.Sprintf("%v", cond(parameters.GetField() == "name", "请输入新的备注名称", "请输入新的地址")),
+					Default: fmt.Sprintf("%v", state.GetValue()),
 				},
 			},
 		},
+		CurrentLabel: "当前值：",
+		KeepLabel:    "保持不变",
 	}
 	if err := p.b.SendForm(ctx, chatID, form); err != nil {
 		return errors.Wrap(err, "failed to send formAddressEdit")
@@ -916,13 +1139,13 @@ func (p *PageRenderer) pageError(ctx context.Context, chatID int64, err error) e
 			[][]bot.Button{
 				{
 					{Label: "返回上一页", CallbackData: bot.CallbackData("route:back")},
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+					{Label: "返回主页", CallbackData: bot.CallbackData("route:" + RouteRoot())},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: "返回", CallbackData: bot.CallbackData("route:back")},
-					{Label: "返回主页", CallbackData: bot.CallbackData("route:/")},
+					{Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -955,9 +1178,9 @@ func forEach[T any](slice []T, fn func(index int, item T) string) string {
 	return sb.String()
 }
 
-var navbar = []bot.Button{{Label: "返回", CallbackData: bot.CallbackData("route:back")}, {Label: "返回主页", CallbackData: bot.CallbackData("route:/")}}
+var navbar = []bot.Button{{Label: "返回", CallbackData: bot.CallbackData("route:back")}, {Label: "返回主页", CallbackData: bot.CallbackData("reset:" + RouteRoot())}}
 
-func pagination[T any](columns int, rows int, total int, page int, items []T, castFunc func(item T) bot.Button, prevLabel string, nextLabel string) [][]bot.Button {
+func pagination[T any](columns int, rows int, total int, page int, items []T, castFunc func(item T) bot.Button, link func(page int) string, prevLabel string, nextLabel string) [][]bot.Button {
 	var grid [][]bot.Button
 	if rows <= 0 || columns <= 0 {
 		return grid
@@ -986,14 +1209,14 @@ func pagination[T any](columns int, rows int, total int, page int, items []T, ca
 	if page != 0 {
 		ctrlRow = append(ctrlRow, bot.Button{
 			Label:        prevLabel,
-			CallbackData: bot.CallbackData(fmt.Sprintf("/address?row=%d&column=%d&page=%d", rows, columns, page-1)),
+			CallbackData: bot.CallbackData(link(page - 1)),
 		})
 	}
 
 	if !isLastPage {
 		ctrlRow = append(ctrlRow, bot.Button{
 			Label:        nextLabel,
-			CallbackData: bot.CallbackData(fmt.Sprintf("/address?row=%d&column=%d&page=%d", rows, columns, page+1)),
+			CallbackData: bot.CallbackData(link(page + 1)),
 		})
 	}
 
@@ -1010,63 +1233,170 @@ const (
 	defaultAddressRows    = 5
 )
 
-func parseOptionalIntQuery(url *url.URL, key string, defaultValue int) (int, error) {
+// parseQueryParam parses the query parameter key, falling back to defaultValue when it is absent.
+func parseQueryParam[T any](url *url.URL, key string, required bool, defaultValue T, parse func(string) (T, error)) (T, error) {
 	value := url.Query().Get(key)
 	if value == "" {
+		if required {
+			return defaultValue, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
+		}
 		return defaultValue, nil
 	}
-	return ToInt(value)
+	return parseParamValue(key, value, parse)
 }
 
-func parseOptionalInt64Query(url *url.URL, key string, defaultValue int64) (int64, error) {
-	value := url.Query().Get(key)
-	if value == "" {
+// parseQueryParams parses an array query parameter given as repeated or comma-separated values.
+func parseQueryParams[T any](url *url.URL, key string, required bool, defaultValue []T, parse func(string) (T, error)) ([]T, error) {
+	raw := url.Query()[key]
+	var values []string
+	if len(raw) == 1 {
+		values = splitParamValues(raw[0])
+	} else {
+		// repeated keys hold one item each, commas included
+		for _, value := range raw {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		if required {
+			return defaultValue, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
+		}
 		return defaultValue, nil
 	}
-	return ToInt64(value)
+	return parseParamValues(key, values, parse)
 }
 
-func parseOptionalStringQuery(url *url.URL, key string, defaultValue string) string {
-	value := url.Query().Get(key)
-	if value == "" {
-		return defaultValue
+func parsePathParam[T any](params routepath.Params, key string, parse func(string) (T, error)) (T, error) {
+	value, ok := params.Get(key)
+	if !ok || value == "" {
+		var zero T
+		return zero, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
 	}
-	return value
+	return parseParamValue(key, value, parse)
 }
 
-func parseParamInt64(params routepath.Params, key string) (int64, error) {
-	value, ok := params.Get(key)
-	if !ok || value == "" {
-		return 0, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", key)
+// parsePathParams parses an array path parameter given as comma-separated values.
+func parsePathParams[T any](params routepath.Params, key string, parse func(string) (T, error)) ([]T, error) {
+	value, _ := params.Get(key)
+	values := splitParamValues(value)
+	if len(values) == 0 {
+		return nil, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
 	}
-	return ToInt64(value)
+	return parseParamValues(key, values, parse)
 }
 
-func ToInt(s string) (int, error) {
-	var i int
-	_, err := fmt.Sscanf(s, "%d", &i)
+func parseParamValue[T any](key string, value string, parse func(string) (T, error)) (T, error) {
+	parsed, err := parse(value)
 	if err != nil {
-		return 0, err
+		return parsed, errors.Wrapf(bot.ErrBadRequest, "invalid %s parameter: %s", strings.ToLower(key), err.Error())
 	}
-	return i, nil
+	return parsed, nil
 }
 
-func ToInt32(s string) (int32, error) {
-	var i int32
-	_, err := fmt.Sscanf(s, "%d", &i)
-	if err != nil {
-		return 0, err
+func parseParamValues[T any](key string, values []string, parse func(string) (T, error)) ([]T, error) {
+	parsed := make([]T, 0, len(values))
+	for _, value := range values {
+		item, err := parseParamValue(key, value, parse)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, item)
 	}
-	return i, nil
+	return parsed, nil
 }
 
-func ToInt64(s string) (int64, error) {
-	var i int64
-	_, err := fmt.Sscanf(s, "%d", &i)
-	if err != nil {
-		return 0, err
+func splitParamValues(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func formatParam[T any](value T) string {
+	return fmt.Sprint(value)
+}
+
+// addParamValues adds the items of an array query parameter as repeated keys. A single item with a
+// comma gets an empty value after it, so it is not split like a comma-separated list.
+func addParamValues(query url.Values, key string, values []string) {
+	for _, value := range values {
+		query.Add(key, value)
+	}
+	if len(values) == 1 && strings.Contains(values[0], ",") {
+		query.Add(key, "")
+	}
+}
+
+// formatParamValues formats the items of an array parameter.
+func formatParamValues[T any](values []T, format func(T) string) []string {
+	formatted := make([]string, 0, len(values))
+	for _, value := range values {
+		formatted = append(formatted, format(value))
+	}
+	return formatted
+}
+
+func parseString(s string) (string, error) {
+	return s, nil
+}
+
+func ToFloat32(s string) (float32, error) {
+	f, err := strconv.ParseFloat(s, 32)
+	return float32(f), err
+}
+
+func ToFloat64(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func ToBool(s string) (bool, error) {
+	return strconv.ParseBool(s)
+}
+
+// ToUUID checks that s is a UUID such as 123e4567-e89b-12d3-a456-426614174000.
+func ToUUID(s string) (string, error) {
+	if len(s) != 36 {
+		return "", fmt.Errorf("%q is not a uuid", s)
+	}
+	for i, r := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if r != '-' {
+				return "", fmt.Errorf("%q is not a uuid", s)
+			}
+		case !strings.ContainsRune("0123456789abcdefABCDEF", r):
+			return "", fmt.Errorf("%q is not a uuid", s)
+		}
+	}
+	return s, nil
+}
+
+// parseInt parses a base 10 integer of bitSize bits. A leading "+" is rejected, so each value has
+// one spelling.
+func parseInt(s string, bitSize int) (int64, error) {
+	if strings.HasPrefix(s, "+") {
+		return 0, errors.Errorf("invalid integer %q", s)
 	}
-	return i, nil
+	return strconv.ParseInt(s, 10, bitSize)
+}
+
+func ToInt(s string) (int, error) {
+	i, err := parseInt(s, strconv.IntSize)
+	return int(i), err
+}
+
+func ToInt32(s string) (int32, error) {
+	i, err := parseInt(s, 32)
+	return int32(i), err
+}
+
+func ToInt64(s string) (int64, error) {
+	return parseInt(s, 64)
 }
 
 func ptr[T any](v T) *T {
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/bottest"
+	"github.com/anclax/botx/pkg/core/session"
+)
+
+// TestDriver drives the bot through an in-memory connector. It has one method per page and form, so
+// tests stop compiling when botx.yaml changes.
+type TestDriver struct {
+	Conn *bottest.Connector
+	Chat *bottest.Chat
+
+	states *testDriverStates
+}
+
+// NewTestDriver registers the bot on a bottest connector with a memory session manager and returns a
+// driver for chat 1.
+func NewTestDriver(t testing.TB, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler) *TestDriver {
+	t.Helper()
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		t.Fatalf("session manager: %v", err)
+	}
+	conn, err := bottest.New(sm)
+	if err != nil {
+		t.Fatalf("bottest connector: %v", err)
+	}
+	states := &testDriverStates{StateProvider: stateProvider, last: make(map[int64]testDriverState)}
+	Register(conn, sm, states, formValidator, handler, commandHandler)
+	return &TestDriver{Conn: conn, Chat: conn.Chat(1), states: states}
+}
+
+// WithChat returns a driver for another chat of the same bot.
+func (d *TestDriver) WithChat(chatID int64) *TestDriver {
+	return &TestDriver{Conn: d.Conn, Chat: d.Conn.Chat(chatID), states: d.states}
+}
+
+// Back presses the back button.
+func (d *TestDriver) Back(ctx context.Context) error {
+	return d.route(ctx, "back")
+}
+
+func (d *TestDriver) route(ctx context.Context, route string) error {
+	return d.do(func() error {
+		return d.Chat.SendCallbackData(ctx, bot.RouteCallbackData(route))
+	})
+}
+
+// do runs fn and returns the first error the handler returned meanwhile.
+func (d *TestDriver) do(fn func() error) error {
+	before := len(d.Chat.Errors())
+	if err := fn(); err != nil {
+		return err
+	}
+	if errs := d.Chat.Errors(); len(errs) > before {
+		return errs[before]
+	}
+	return nil
+}
+
+func (d *TestDriver) lastState(page string) any {
+	d.states.mu.Lock()
+	defer d.states.mu.Unlock()
+	last, ok := d.states.last[d.Chat.ID()]
+	if !ok || last.page != page {
+		return nil
+	}
+	return last.state
+}
+
+type testDriverState struct {
+	page  string
+	state any
+}
+
+// testDriverStates records the last state provided in each chat.
+type testDriverStates struct {
+	StateProvider
+
+	mu   sync.Mutex
+	last map[int64]testDriverState
+}
+
+func (s *testDriverStates) record(chatID int64, page string, state any, err error) {
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last[chatID] = testDriverState{page: page, state: state}
+}
+
+func (s *testDriverStates) ProvideRootState(ctx context.Context, chatID int64, parameters *ParametersPageRoot) (*StatePageRoot, error) {
+	state, err := s.StateProvider.ProvideRootState(ctx, chatID, parameters)
+	s.record(chatID, "/", state, err)
+	return state, err
+}
+
+func (s *testDriverStates) ProvideI18nState(ctx context.Context, chatID int64, parameters *ParametersPageI18n) (*StatePageI18n, error) {
+	state, err := s.StateProvider.ProvideI18nState(ctx, chatID, parameters)
+	s.record(chatID, "/i18n", state, err)
+	return state, err
+}
+
+func (s *testDriverStates) ProvideTodoAddState(ctx context.Context, chatID int64, form *FormTodoAdd, parameters *ParametersPageTodoAdd) (*StatePageTodoAdd, error) {
+	state, err := s.StateProvider.ProvideTodoAddState(ctx, chatID, form, parameters)
+	s.record(chatID, "/todo/add", state, err)
+	return state, err
+}
+
+func (s *testDriverStates) ProvideTodoIDState(ctx context.Context, chatID int64, parameters *ParametersPageTodoID) (*StatePageTodoID, error) {
+	state, err := s.StateProvider.ProvideTodoIDState(ctx, chatID, parameters)
+	s.record(chatID, "/todo/{ID:int}", state, err)
+	return state, err
+}
+
+func (s *testDriverStates) ProvideTodoDeleteState(ctx context.Context, chatID int64, parameters *ParametersPageTodoDelete) (*StatePageTodoDelete, error) {
+	state, err := s.StateProvider.ProvideTodoDeleteState(ctx, chatID, parameters)
+	s.record(chatID, "/todo/{ID:int}/delete", state, err)
+	return state, err
+}
+
+func (s *testDriverStates) ProvideTodoToggleState(ctx context.Context, chatID int64, parameters *ParametersPageTodoToggle) (*StatePageTodoToggle, error) {
+	state, err := s.StateProvider.ProvideTodoToggleState(ctx, chatID, parameters)
+	s.record(chatID, "/todo/{ID:int}/toggle", state, err)
+	return state, err
+}
+
+// OpenRoot opens /.
+func (d *TestDriver) OpenRoot(ctx context.Context, params ...RootParams) error {
+	return d.route(ctx, RouteRoot(params...))
+}
+
+// LastPageRoot returns the state of / if it is the last page provided in the chat, or nil.
+func (d *TestDriver) LastPageRoot() *StatePageRoot {
+	state, _ := d.lastState("/").(*StatePageRoot)
+	return state
+}
+
+// OpenI18n opens /i18n.
+func (d *TestDriver) OpenI18n(ctx context.Context) error {
+	return d.route(ctx, RouteI18n())
+}
+
+// LastPageI18n returns the state of /i18n if it is the last page provided in the chat, or nil.
+func (d *TestDriver) LastPageI18n() *StatePageI18n {
+	state, _ := d.lastState("/i18n").(*StatePageI18n)
+	return state
+}
+
+// OpenTodoAdd opens the form of /todo/add.
+func (d *TestDriver) OpenTodoAdd(ctx context.Context) error {
+	return d.route(ctx, RouteTodoAdd())
+}
+
+// SubmitTodoAdd opens the form of /todo/add and fills in every field.
+func (d *TestDriver) SubmitTodoAdd(ctx context.Context, form FormTodoAdd) error {
+	if err := d.OpenTodoAdd(ctx); err != nil {
+		return err
+	}
+	return d.do(func() error {
+		return d.Chat.FillForm(ctx, bot.FormValues{
+			"title": form.title,
+		})
+	})
+}
+
+// LastPageTodoAdd returns the state of /todo/add if it is the last page provided in the chat, or nil.
+func (d *TestDriver) LastPageTodoAdd() *StatePageTodoAdd {
+	state, _ := d.lastState("/todo/add").(*StatePageTodoAdd)
+	return state
+}
+
+// OpenTodoID opens /todo/{ID:int}.
+func (d *TestDriver) OpenTodoID(ctx context.Context, id int64) error {
+	return d.route(ctx, RouteTodoID(id))
+}
+
+// LastPageTodoID returns the state of /todo/{ID:int} if it is the last page provided in the chat, or nil.
+func (d *TestDriver) LastPageTodoID() *StatePageTodoID {
+	state, _ := d.lastState("/todo/{ID:int}").(*StatePageTodoID)
+	return state
+}
+
+// OpenTodoDelete opens /todo/{ID:int}/delete.
+func (d *TestDriver) OpenTodoDelete(ctx context.Context, id int64) error {
+	return d.route(ctx, RouteTodoDelete(id))
+}
+
+// LastPageTodoDelete returns the state of /todo/{ID:int}/delete if it is the last page provided in the chat, or nil.
+func (d *TestDriver) LastPageTodoDelete() *StatePageTodoDelete {
+	state, _ := d.lastState("/todo/{ID:int}/delete").(*StatePageTodoDelete)
+	return state
+}
+
+// OpenTodoToggle opens /todo/{ID:int}/toggle.
+func (d *TestDriver) OpenTodoToggle(ctx context.Context, id int64) error {
+	return d.route(ctx, RouteTodoToggle(id))
+}
+
+// LastPageTodoToggle returns the state of /todo/{ID:int}/toggle if it is the last page provided in the chat, or nil.
+func (d *TestDriver) LastPageTodoToggle() *StatePageTodoToggle {
+	state, _ := d.lastState("/todo/{ID:int}/toggle").(*StatePageTodoToggle)
+	return state
+}
+
+// snapshots
+
+// SnapshotFixture is a state a page is rendered with for snapshots. Route fills the page parameters and
+// defaults to the page path.
+type SnapshotFixture[S any] struct {
+	Name  string
+	Route string
+	State *S
+}
+
+// SnapshotFixtures holds the fixtures of each page. Pages without fixtures are rendered once with a zero
+// state; form pages render their form, followed by the view when State is set.
+type SnapshotFixtures struct {
+	Root       []SnapshotFixture[StatePageRoot]
+	I18n       []SnapshotFixture[StatePageI18n]
+	TodoAdd    []SnapshotFixture[StatePageTodoAdd]
+	TodoID     []SnapshotFixture[StatePageTodoID]
+	TodoDelete []SnapshotFixture[StatePageTodoDelete]
+	TodoToggle []SnapshotFixture[StatePageTodoToggle]
+}
+
+var snapshotLanguages = []string{"en", "es", "zh-hans"}
+
+// RenderSnapshots renders every page in every language and returns the snapshots keyed by file name,
+// e.g. "TodoID.default.en.txt".
+func RenderSnapshots(ctx context.Context, fixtures SnapshotFixtures) (map[string]string, error) {
+	snapshots := make(map[string]string)
+	for _, lang := range snapshotLanguages {
+		ctx := bot.WithLanguage(ctx, lang)
+		for _, fixture := range snapshotDefaults(fixtures.Root, "/", true) {
+			if err := renderSnapshot(snapshots, "Root", "/", lang, fixture.Name, fixture.Route, func(p *PageRenderer, u *url.URL) error {
+				return snapshotPageRoot(ctx, p, u, fixture.State)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		for _, fixture := range snapshotDefaults(fixtures.I18n, "/i18n", true) {
+			if err := renderSnapshot(snapshots, "I18n", "/i18n", lang, fixture.Name, fixture.Route, func(p *PageRenderer, u *url.URL) error {
+				return snapshotPageI18n(ctx, p, u, fixture.State)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		for _, fixture := range snapshotDefaults(fixtures.TodoAdd, "/todo/add", false) {
+			if err := renderSnapshot(snapshots, "TodoAdd", "/todo/add", lang, fixture.Name, fixture.Route, func(p *PageRenderer, u *url.URL) error {
+				return snapshotPageTodoAdd(ctx, p, u, fixture.State)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		for _, fixture := range snapshotDefaults(fixtures.TodoID, "/todo/0", true) {
+			if err := renderSnapshot(snapshots, "TodoID", "/todo/{ID:int}", lang, fixture.Name, fixture.Route, func(p *PageRenderer, u *url.URL) error {
+				return snapshotPageTodoID(ctx, p, u, fixture.State)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		for _, fixture := range snapshotDefaults(fixtures.TodoDelete, "/todo/0/delete", true) {
+			if err := renderSnapshot(snapshots, "TodoDelete", "/todo/{ID:int}/delete", lang, fixture.Name, fixture.Route, func(p *PageRenderer, u *url.URL) error {
+				return snapshotPageTodoDelete(ctx, p, u, fixture.State)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		for _, fixture := range snapshotDefaults(fixtures.TodoToggle, "/todo/0/toggle", true) {
+			if err := renderSnapshot(snapshots, "TodoToggle", "/todo/{ID:int}/toggle", lang, fixture.Name, fixture.Route, func(p *PageRenderer, u *url.URL) error {
+				return snapshotPageTodoToggle(ctx, p, u, fixture.State)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if err := renderSnapshot(snapshots, "Error", "/error", lang, "default", "/error", func(p *PageRenderer, _ *url.URL) error {
+			return p.pageError(ctx, 1, errors.New("something went wrong"))
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return snapshots, nil
+}
+
+// AssertSnapshots compares RenderSnapshots with the golden files in dir. Run the tests with
+// BOTX_UPDATE_SNAPSHOTS=1 to write them.
+func AssertSnapshots(t testing.TB, dir string, fixtures SnapshotFixtures) {
+	t.Helper()
+	snapshots, err := RenderSnapshots(context.Background(), fixtures)
+	if err != nil {
+		t.Fatalf("render snapshots: %v", err)
+	}
+	bottest.AssertGoldenDir(t, dir, snapshots)
+}
+
+func snapshotDefaults[S any](fixtures []SnapshotFixture[S], route string, zeroState bool) []SnapshotFixture[S] {
+	if len(fixtures) == 0 {
+		fixtures = make([]SnapshotFixture[S], 1)
+	}
+	result := make([]SnapshotFixture[S], 0, len(fixtures))
+	for _, fixture := range fixtures {
+		if fixture.Name == "" {
+			fixture.Name = "default"
+		}
+		if fixture.Route == "" {
+			fixture.Route = route
+		}
+		if fixture.State == nil && zeroState {
+			fixture.State = new(S)
+		}
+		result = append(result, fixture)
+	}
+	return result
+}
+
+func renderSnapshot(snapshots map[string]string, page string, path string, lang string, name string, route string, render func(p *PageRenderer, u *url.URL) error) error {
+	u, err := url.Parse(route)
+	if err != nil {
+		return fmt.Errorf("snapshot %s %s: %w", path, name, err)
+	}
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		return err
+	}
+	conn, err := bottest.New(sm)
+	if err != nil {
+		return err
+	}
+	if err := render(&PageRenderer{b: bot.NewBot(conn, bot.WithBotSessionManager(sm))}, u); err != nil {
+		return fmt.Errorf("snapshot %s %s: %w", path, name, err)
+	}
+	title := path + " " + name + " " + route
+	file := page + "." + name
+	if lang != "" {
+		title += " [" + lang + "]"
+		file += "." + lang
+	}
+	chat := conn.Chat(1)
+	snapshots[file+".txt"] = bottest.Snapshot(title, chat.Messages(), chat.Form())
+	return nil
+}
+
+func snapshotPageRoot(ctx context.Context, p *PageRenderer, u *url.URL, state *StatePageRoot) error {
+	parameters, err := ParseParametersPageRoot(u)
+	if err != nil {
+		return err
+	}
+	return p.pageRoot(ctx, 1, state, parameters)
+}
+
+func snapshotPageI18n(ctx context.Context, p *PageRenderer, u *url.URL, state *StatePageI18n) error {
+	parameters, err := ParseParametersPageI18n(u)
+	if err != nil {
+		return err
+	}
+	return p.pageI18n(ctx, 1, state, parameters)
+}
+
+func snapshotPageTodoAdd(ctx context.Context, p *PageRenderer, u *url.URL, state *StatePageTodoAdd) error {
+	parameters, err := ParseParametersPageTodoAdd(u)
+	if err != nil {
+		return err
+	}
+	if err := p.formTodoAdd(ctx, 1, u, parameters); err != nil {
+		return err
+	}
+	if state == nil {
+		return nil
+	}
+	return p.pageTodoAdd(ctx, 1, state, parameters)
+}
+
+func snapshotPageTodoID(ctx context.Context, p *PageRenderer, u *url.URL, state *StatePageTodoID) error {
+	pattern, params, ok := pageRouter.Match(u.Path)
+	if !ok || pattern != "/todo/{ID:int}" {
+		return fmt.Errorf("route %s does not match %s", u, "/todo/{ID:int}")
+	}
+	parameters, err := ParseParametersPageTodoID(params)
+	if err != nil {
+		return err
+	}
+	return p.pageTodoID(ctx, 1, state, parameters)
+}
+
+func snapshotPageTodoDelete(ctx context.Context, p *PageRenderer, u *url.URL, state *StatePageTodoDelete) error {
+	pattern, params, ok := pageRouter.Match(u.Path)
+	if !ok || pattern != "/todo/{ID:int}/delete" {
+		return fmt.Errorf("route %s does not match %s", u, "/todo/{ID:int}/delete")
+	}
+	parameters, err := ParseParametersPageTodoDelete(params)
+	if err != nil {
+		return err
+	}
+	return p.pageTodoDelete(ctx, 1, state, parameters)
+}
+
+func snapshotPageTodoToggle(ctx context.Context, p *PageRenderer, u *url.URL, state *StatePageTodoToggle) error {
+	pattern, params, ok := pageRouter.Match(u.Path)
+	if !ok || pattern != "/todo/{ID:int}/toggle" {
+		return fmt.Errorf("route %s does not match %s", u, "/todo/{ID:int}/toggle")
+	}
+	parameters, err := ParseParametersPageTodoToggle(params)
+	if err != nil {
+		return err
+	}
+	return p.pageTodoToggle(ctx, 1, state, parameters)
+}
@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/anclax/botx/pkg/core/bot"
@@ -62,8 +62,9 @@ type CommandHandler interface {
 }
 
 // Register bot handler to bot. the param bot and param stateProvider is implemented by user
-func Register(connector bot.BotConnector, sm session.SessionManager, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler) {
-	wrapped := bot.NewBot(connector)
+// Options such as bot.WithCallbackSigner configure the bot passed to the handlers.
+func Register(connector bot.BotConnector, sm session.SessionManager, stateProvider StateProvider, formValidator FormValidator, handler Handler, commandHandler CommandHandler, opts ...bot.BotOption) {
+	wrapped := bot.NewBot(connector, append([]bot.BotOption{bot.WithBotSessionManager(sm)}, opts...)...)
 	botxHandler := &BotxHandler{
 		renderer:       &PageRenderer{wrapped},
 		bot:            wrapped,
@@ -88,10 +89,13 @@ func (h *BotxHandler) getRouter(ctx context.Context, chatID int64) (*bot.Router,
 		if !errors.Is(err, session.ErrKeyNotFound) {
 			return nil, errors.Wrap(err, "failed to get router")
 		}
-		router, err = bot.CreateRouter(ctx, chatID, sess)
+		router, err = bot.CreateRouter(ctx, chatID, sess, bot.WithMaxHistory(20))
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to create router")
 		}
+		if err := sess.Set(ctx, bot.SessionKeyRouter, router); err != nil {
+			return nil, errors.Wrap(err, "failed to store router")
+		}
 	}
 	return router.(*bot.Router), nil
 }
@@ -136,15 +140,38 @@ func (h *BotxHandler) handleRoute(ctx context.Context, chatID int64, data string
 	}
 
 	routeURL := strings.TrimPrefix(data, fmt.Sprintf("%s:", bot.CallbackPrefixRoute))
+	mode, target, ok := strings.Cut(routeURL, ":")
+	if ok && (mode == bot.RouteModeReplace || mode == bot.RouteModeReset) {
+		routeURL = target
+	} else {
+		mode = ""
+	}
 
-	if routeURL == "back" {
+	switch {
+	case routeURL == "back":
 		last, err := router.Back(ctx)
 		if err != nil {
 			return errors.Wrap(err, "failed to go back")
 		}
 		routeURL = last
-	} else {
-		if err := router.Push(ctx, routeURL); err != nil {
+	case isActionRoute(routeURL):
+		if err := router.PushAction(ctx, routeURL); err != nil {
+			return errors.Wrap(err, "failed to push action route")
+		}
+	case mode == bot.RouteModeReset:
+		if err := router.Reset(ctx, routeURL); err != nil {
+			return errors.Wrap(err, "failed to reset route")
+		}
+	default:
+		current, err := router.Current(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to get current route")
+		}
+		if mode == bot.RouteModeReplace || isReplaceHistoryRoute(routeURL, current) {
+			if err := router.Replace(ctx, routeURL); err != nil {
+				return errors.Wrap(err, "failed to replace route")
+			}
+		} else if err := router.Push(ctx, routeURL); err != nil {
 			return errors.Wrap(err, "failed to push route")
 		}
 	}
@@ -178,6 +205,14 @@ func (h *BotxHandler) handleSubmit(ctx context.Context, chatID int64, data strin
 	return nil
 }
 
+func (h *BotxHandler) handleConfirm(ctx context.Context, chatID int64, data string, b bot.BotConnector) (string, error) {
+	sess, err := h.sm.Get(ctx, chatID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get session")
+	}
+	return bot.HandleConfirm(ctx, b, sess, chatID, data)
+}
+
 func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data string) error {
 	lang := strings.TrimSpace(strings.TrimPrefix(data, "lang:"))
 	if lang == "" {
@@ -198,13 +233,9 @@ func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data str
 	if err != nil {
 		return errors.Wrap(err, "failed to get router")
 	}
-	hist, err := router.History(ctx)
+	current, err := router.Current(ctx)
 	if err != nil {
-		return errors.Wrap(err, "failed to get router history")
-	}
-	current := "/"
-	if len(hist) != 0 {
-		current = hist[len(hist)-1]
+		return errors.Wrap(err, "failed to get current route")
 	}
 	url, err := url.Parse(current)
 	if err != nil {
@@ -217,6 +248,16 @@ func (h *BotxHandler) handleLanguage(ctx context.Context, chatID int64, data str
 }
 
 func (h *BotxHandler) HandleTextMessage(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	if payload, ok := strings.CutPrefix(data, bot.DeepLinkCommand+" "); ok {
+		handled, err := h.handleDeepLink(ctx, chatID, strings.TrimSpace(payload))
+		if err != nil {
+			return errors.Wrap(err, "failed to handle deep link")
+		}
+		if handled {
+			return nil
+		}
+		data = bot.DeepLinkCommand
+	}
 	if data == "/start" && h.commandHandler != nil {
 		if err := h.commandHandler.HandleCommandStart(ctx, chatID, h.bot); err != nil {
 			return errors.Wrap(err, "failed to handle /start command")
@@ -230,6 +271,10 @@ func (h *BotxHandler) HandleTextMessage(ctx context.Context, data string, chatID
 }
 
 func (h *BotxHandler) HandleCallbackData(ctx context.Context, data string, chatID int64, b bot.BotConnector) error {
+	data, err := h.bot.VerifyCallbackData(ctx, chatID, data)
+	if err != nil {
+		return errors.Wrap(err, "failed to verify callback data")
+	}
 	if strings.HasPrefix(data, "lang:") {
 		if err := h.handleLanguage(ctx, chatID, data); err != nil {
 			return errors.Wrap(err, "failed to handle language switch")
@@ -248,6 +293,16 @@ func (h *BotxHandler) HandleCallbackData(ctx context.Context, data string, chatI
 		}
 		return nil
 	}
+	if strings.HasPrefix(data, fmt.Sprintf("%s:", bot.CallbackPrefixConfirm)) {
+		callback, err := h.handleConfirm(ctx, chatID, data, b)
+		if err != nil {
+			return errors.Wrap(err, "failed to handle confirmation")
+		}
+		if callback == "" {
+			return nil
+		}
+		return h.HandleCallbackData(ctx, callback, chatID, b)
+	}
 	if err := h.defaultHandler.HandleCallbackData(ctx, data, chatID, h.bot); err != nil {
 		return errors.Wrap(err, "failed to handle callback data in default handler")
 	}
@@ -268,7 +323,14 @@ func (h *BotxHandler) HandleError(ctx context.Context, err error, chatID int64,
 }
 
 func (h *BotxHandler) Validate(ctx context.Context, chatID int64, url *url.URL, validator string, input string) (*bot.ValidateResult, error) {
+	return h.ValidateFormField(ctx, chatID, url, validator, "", input, nil)
+}
+
+// ValidateFormField runs validator on the input of field, given the values entered before it.
+func (h *BotxHandler) ValidateFormField(ctx context.Context, chatID int64, url *url.URL, validator string, field string, input string, values bot.FormValues) (*bot.ValidateResult, error) {
 	switch validator {
+	case "validateTitle":
+		return h.formValidator.ValidateTitle(ctx, chatID, url, field, input, values)
 	default:
 		return nil, errors.Wrapf(bot.ErrNotFound, "unknown validator: %s", validator)
 	}
@@ -276,19 +338,52 @@ func (h *BotxHandler) Validate(ctx context.Context, chatID int64, url *url.URL,
 
 // code generated for pages
 
-var (
-	todoIDMatcher     = routepath.MustCompile("/todo/{ID}")
-	todoDeleteMatcher = routepath.MustCompile("/todo/{ID}/delete")
-	todoToggleMatcher = routepath.MustCompile("/todo/{ID}/toggle")
+// pageRouter matches a route against the page paths; static segments win over params.
+var pageRouter = routepath.MustNewRouter(
+	"/",
+	"/i18n",
+	"/todo/add",
+	"/todo/{ID:int}",
+	"/todo/{ID:int}/delete",
+	"/todo/{ID:int}/toggle",
 )
 
-func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) error {
-	paramsTodoID, okTodoID := todoIDMatcher.Match(url.Path)
-	paramsTodoDelete, okTodoDelete := todoDeleteMatcher.Match(url.Path)
-	paramsTodoToggle, okTodoToggle := todoToggleMatcher.Match(url.Path)
+// actionPages are the pages with side effects. They stay out of the router history, so going back,
+// switching language or re-rendering never runs them again.
+var actionPages = map[string]bool{
+	"/todo/{ID:int}/delete": true,
+	"/todo/{ID:int}/toggle": true,
+}
 
-	switch {
-	case url.Path == "/":
+func isActionRoute(route string) bool {
+	path, _, _ := strings.Cut(route, "?")
+	pattern, _, ok := pageRouter.Match(path)
+	return ok && actionPages[pattern]
+}
+
+// replaceHistoryPages are the pages with `history: replace`. Opening one from itself with other query
+// params, e.g. the next page of a list, replaces its history entry instead of adding one.
+var replaceHistoryPages = map[string]bool{
+	"/": true,
+}
+
+func isReplaceHistoryRoute(route string, current string) bool {
+	path, _, _ := strings.Cut(route, "?")
+	currentPath, _, _ := strings.Cut(current, "?")
+	if path != currentPath {
+		return false
+	}
+	pattern, _, ok := pageRouter.Match(path)
+	return ok && replaceHistoryPages[pattern]
+}
+
+func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) error {
+	pattern, routeParams, ok := pageRouter.Match(url.Path)
+	if !ok {
+		return errors.Wrapf(bot.ErrNotFound, "unknown route: %s", url.String())
+	}
+	switch pattern {
+	case "/":
 		params, err := ParseParametersPageRoot(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /")
@@ -300,7 +395,7 @@ func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) e
 		if err := h.renderer.pageRoot(ctx, chatID, state, params); err != nil {
 			return errors.Wrap(err, "failed to render page /")
 		}
-	case url.Path == "/i18n":
+	case "/i18n":
 		params, err := ParseParametersPageI18n(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /i18n")
@@ -312,7 +407,7 @@ func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) e
 		if err := h.renderer.pageI18n(ctx, chatID, state, params); err != nil {
 			return errors.Wrap(err, "failed to render page /i18n")
 		}
-	case url.Path == "/todo/add":
+	case "/todo/add":
 		params, err := ParseParametersPageTodoAdd(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for page /todo/add")
@@ -320,60 +415,56 @@ func (h *BotxHandler) onRoute(ctx context.Context, chatID int64, url *url.URL) e
 		if err := h.renderer.formTodoAdd(ctx, chatID, url, params); err != nil {
 			return errors.Wrap(err, "failed to render form for page /todo/add")
 		}
-	case okTodoDelete:
-		params, err := ParseParametersPageTodoDelete(paramsTodoDelete)
+	case "/todo/{ID:int}":
+		params, err := ParseParametersPageTodoID(routeParams)
 		if err != nil {
-			return errors.Wrap(err, "invalid parameters for page /todo/{ID}/delete")
+			return errors.Wrap(err, "invalid parameters for page /todo/{ID:int}")
 		}
-		state, err := h.sp.ProvideTodoDeleteState(ctx, chatID, params)
+		state, err := h.sp.ProvideTodoIDState(ctx, chatID, params)
 		if err != nil {
-			return errors.Wrap(err, "failed to provide state for page /todo/{ID}/delete")
+			return errors.Wrap(err, "failed to provide state for page /todo/{ID:int}")
 		}
-		if err := h.renderer.pageTodoDelete(ctx, chatID, state, params); err != nil {
-			return errors.Wrap(err, "failed to render page /todo/{ID}/delete")
+		if err := h.renderer.pageTodoID(ctx, chatID, state, params); err != nil {
+			return errors.Wrap(err, "failed to render page /todo/{ID:int}")
 		}
-	case okTodoToggle:
-		params, err := ParseParametersPageTodoToggle(paramsTodoToggle)
+	case "/todo/{ID:int}/delete":
+		params, err := ParseParametersPageTodoDelete(routeParams)
 		if err != nil {
-			return errors.Wrap(err, "invalid parameters for page /todo/{ID}/toggle")
+			return errors.Wrap(err, "invalid parameters for page /todo/{ID:int}/delete")
 		}
-		state, err := h.sp.ProvideTodoToggleState(ctx, chatID, params)
+		state, err := h.sp.ProvideTodoDeleteState(ctx, chatID, params)
 		if err != nil {
-			return errors.Wrap(err, "failed to provide state for page /todo/{ID}/toggle")
+			return errors.Wrap(err, "failed to provide state for page /todo/{ID:int}/delete")
 		}
-		if err := h.renderer.pageTodoToggle(ctx, chatID, state, params); err != nil {
-			return errors.Wrap(err, "failed to render page /todo/{ID}/toggle")
+		if err := h.renderer.pageTodoDelete(ctx, chatID, state, params); err != nil {
+			return errors.Wrap(err, "failed to render page /todo/{ID:int}/delete")
 		}
-	case okTodoID:
-		params, err := ParseParametersPageTodoID(paramsTodoID)
+	case "/todo/{ID:int}/toggle":
+		params, err := ParseParametersPageTodoToggle(routeParams)
 		if err != nil {
-			return errors.Wrap(err, "invalid parameters for page /todo/{ID}")
+			return errors.Wrap(err, "invalid parameters for page /todo/{ID:int}/toggle")
 		}
-		state, err := h.sp.ProvideTodoIDState(ctx, chatID, params)
+		state, err := h.sp.ProvideTodoToggleState(ctx, chatID, params)
 		if err != nil {
-			return errors.Wrap(err, "failed to provide state for page /todo/{ID}")
+			return errors.Wrap(err, "failed to provide state for page /todo/{ID:int}/toggle")
 		}
-		if err := h.renderer.pageTodoID(ctx, chatID, state, params); err != nil {
-			return errors.Wrap(err, "failed to render page /todo/{ID}")
+		if err := h.renderer.pageTodoToggle(ctx, chatID, state, params); err != nil {
+			return errors.Wrap(err, "failed to render page /todo/{ID:int}/toggle")
 		}
-	default:
-		return errors.Wrapf(bot.ErrNotFound, "unknown route: %s", url.String())
 	}
 	return nil
 }
 
 func (h *BotxHandler) onSubmit(ctx context.Context, chatID int64, url *url.URL) error {
-	raw := url.Query().Get("values")
-	if raw == "" {
+	// the values come from the form engine, never from the callback data
+	values, ok := bot.FormValuesFromContext(ctx)
+	if !ok {
 		return errors.Wrap(bot.ErrBadRequest, "missing form values")
 	}
-	var values bot.FormValues
-	if err := json.Unmarshal([]byte(raw), &values); err != nil {
-		return errors.Wrap(err, "failed to unmarshal form values")
-	}
 
-	switch {
-	case url.Path == "/todo/add":
+	pattern, _, _ := pageRouter.Match(url.Path)
+	switch pattern {
+	case "/todo/add":
 		params, err := ParseParametersPageTodoAdd(url)
 		if err != nil {
 			return errors.Wrap(err, "invalid parameters for form /todo/add")
@@ -395,23 +486,76 @@ func (h *BotxHandler) onSubmit(ctx context.Context, chatID int64, url *url.URL)
 	return nil
 }
 
+// deep links
+
+var (
+	deepLinkTodoIDMatcher = routepath.MustCompile("/todo_{ID:int}")
+)
+
+func (h *BotxHandler) handleDeepLink(ctx context.Context, chatID int64, payload string) (bool, error) {
+	if params, ok := deepLinkTodoIDMatcher.Match("/" + payload); ok {
+		route, err := routepath.Expand("/todo/{ID}", params)
+		if err != nil {
+			return false, errors.Wrap(err, "failed to expand deep link route /todo/{ID}")
+		}
+		return true, h.handleRoute(ctx, chatID, bot.RouteCallbackData(route))
+	}
+	return false, nil
+}
+
+// DeepLinkTodoID builds a Telegram deep link that opens /todo/{ID}.
+func DeepLinkTodoID(botUsername string, id int64) string {
+	return bot.TelegramDeepLink(botUsername, fmt.Sprintf("todo_%v", id))
+}
+
+// command menus
+
+// CommandMenus lists the commands with a description for every i18n language and scope. Connectors
+// register them with the platform, e.g. Telegram setMyCommands. An empty language is the fallback menu.
+func (h *BotxHandler) CommandMenus(ctx context.Context) []bot.CommandMenu {
+	var menus []bot.CommandMenu
+	for _, lang := range append([]string{""}, i18nLanguages...) {
+		ctx := bot.WithLanguage(ctx, cond(lang == "", i18nDefault, lang))
+		menus = append(menus, bot.CommandMenu{
+			Language: lang,
+			Scope:    bot.CommandScopePrivate,
+			Commands: []bot.Command{
+				{Command: "start", Description: fmt.Sprintf("%v", i18n(ctx, 0, "content.nav.start"))},
+			},
+		})
+		menus = append(menus, bot.CommandMenu{
+			Language: lang,
+			Scope:    bot.CommandScopeGroup,
+			Commands: []bot.Command{
+				{Command: "start", Description: fmt.Sprintf("%v", i18n(ctx, 0, "content.nav.start"))},
+			},
+		})
+	}
+	return menus
+}
+
 // url to params
 
 func ParseParametersPageRoot(url *url.URL) (*ParametersPageRoot, error) {
-	column, err := parseOptionalIntQuery(url, "column", defaultRootColumns)
+	column, err := parseQueryParam(url, "column", false, defaultRootColumns, ToInt)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseQueryParam(url, "filter", false, defaultRootFilter, parseRootFilter)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid column parameter: %s", err.Error())
+		return nil, err
 	}
-	page, err := parseOptionalIntQuery(url, "page", defaultRootPage)
+	page, err := parseQueryParam(url, "page", false, defaultRootPage, ToInt)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid page parameter: %s", err.Error())
+		return nil, err
 	}
-	row, err := parseOptionalIntQuery(url, "row", defaultRootRows)
+	row, err := parseQueryParam(url, "row", false, defaultRootRows, ToInt)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid row parameter: %s", err.Error())
+		return nil, err
 	}
 	return &ParametersPageRoot{
 		column: column,
+		filter: filter,
 		page:   page,
 		row:    row,
 	}, nil
@@ -426,9 +570,9 @@ func ParseParametersPageTodoAdd(url *url.URL) (*ParametersPageTodoAdd, error) {
 }
 
 func ParseParametersPageTodoID(params routepath.Params) (*ParametersPageTodoID, error) {
-	id, err := parseParamInt64(params, "ID")
+	id, err := parsePathParam(params, "ID", ToInt64)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid id parameter: %s", err.Error())
+		return nil, err
 	}
 	return &ParametersPageTodoID{
 		ID: id,
@@ -436,9 +580,9 @@ func ParseParametersPageTodoID(params routepath.Params) (*ParametersPageTodoID,
 }
 
 func ParseParametersPageTodoDelete(params routepath.Params) (*ParametersPageTodoDelete, error) {
-	id, err := parseParamInt64(params, "ID")
+	id, err := parsePathParam(params, "ID", ToInt64)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid id parameter: %s", err.Error())
+		return nil, err
 	}
 	return &ParametersPageTodoDelete{
 		ID: id,
@@ -446,15 +590,74 @@ func ParseParametersPageTodoDelete(params routepath.Params) (*ParametersPageTodo
 }
 
 func ParseParametersPageTodoToggle(params routepath.Params) (*ParametersPageTodoToggle, error) {
-	id, err := parseParamInt64(params, "ID")
+	id, err := parsePathParam(params, "ID", ToInt64)
 	if err != nil {
-		return nil, errors.Wrapf(bot.ErrBadRequest, "invalid id parameter: %s", err.Error())
+		return nil, err
 	}
 	return &ParametersPageTodoToggle{
 		ID: id,
 	}, nil
 }
 
+// route builders
+
+// RootParams holds the query parameters of RouteRoot; zero values are left out. Parameters with a
+// default are pointers, set with bot.Ptr, so their zero value can be sent.
+type RootParams struct {
+	Column *int
+	Filter *RootFilter
+	Page   int
+	Row    *int
+}
+
+// RouteRoot returns the route of page /.
+func RouteRoot(params ...RootParams) string {
+	query := url.Values{}
+	for _, p := range params {
+		if p.Column != nil {
+			query.Set("column", fmt.Sprint(*p.Column))
+		}
+		if p.Filter != nil {
+			query.Set("filter", string(*p.Filter))
+		}
+		if p.Page != 0 {
+			query.Set("page", fmt.Sprint(p.Page))
+		}
+		if p.Row != nil {
+			query.Set("row", fmt.Sprint(*p.Row))
+		}
+	}
+	if len(query) == 0 {
+		return "/"
+	}
+	return "/?" + query.Encode()
+}
+
+// RouteI18n returns the route of page /i18n.
+func RouteI18n() string {
+	return "/i18n"
+}
+
+// RouteTodoAdd returns the route of page /todo/add.
+func RouteTodoAdd() string {
+	return "/todo/add"
+}
+
+// RouteTodoID returns the route of page /todo/{ID:int}.
+func RouteTodoID(id int64) string {
+	return "/todo/" + url.PathEscape(fmt.Sprint(id))
+}
+
+// RouteTodoDelete returns the route of page /todo/{ID:int}/delete.
+func RouteTodoDelete(id int64) string {
+	return "/todo/" + url.PathEscape(fmt.Sprint(id)) + "/delete"
+}
+
+// RouteTodoToggle returns the route of page /todo/{ID:int}/toggle.
+func RouteTodoToggle(id int64) string {
+	return "/todo/" + url.PathEscape(fmt.Sprint(id)) + "/toggle"
+}
+
 // forms
 
 type FormTodoAdd struct {
@@ -478,6 +681,7 @@ func unmarshalFormTodoAdd(values bot.FormValues) (*FormTodoAdd, error) {
 // FormValidator
 
 type FormValidator interface {
+	ValidateTitle(ctx context.Context, chatID int64, url *url.URL, field string, input string, values bot.FormValues) (*bot.ValidateResult, error)
 }
 
 // StateProvider provides state views.
@@ -493,8 +697,26 @@ type PageRenderer struct {
 	b *bot.Bot
 }
 
+// RootFilter is a value of the filter parameter of page /.
+type RootFilter string
+
+const (
+	RootFilterAll  RootFilter = "all"
+	RootFilterOpen RootFilter = "open"
+	RootFilterDone RootFilter = "done"
+)
+
+func parseRootFilter(s string) (RootFilter, error) {
+	switch value := RootFilter(s); value {
+	case RootFilterAll, RootFilterOpen, RootFilterDone:
+		return value, nil
+	}
+	return "", fmt.Errorf("unknown value %q", s)
+}
+
 type ParametersPageRoot struct {
 	column int
+	filter RootFilter
 	page   int
 	row    int
 }
@@ -503,6 +725,10 @@ func (p *ParametersPageRoot) GetColumn() int {
 	return p.column
 }
 
+func (p *ParametersPageRoot) GetFilter() RootFilter {
+	return p.filter
+}
+
 func (p *ParametersPageRoot) GetPage() int {
 	return p.page
 }
@@ -554,22 +780,25 @@ func (p *PageRenderer) pageRoot(ctx context.Context, chatID int64, state *StateP
 				func(item Todo) bot.Button {
 					return bot.Button{
 						Label:        fmt.Sprintf("%v", cond(item.GetDone(), "[x] "+item.GetTitle(), "[ ] "+item.GetTitle())),
-						CallbackData: bot.CallbackData(fmt.Sprintf("route:/todo/%v", item.ID)),
+						CallbackData: bot.CallbackData("route:" + RouteTodoID(item.ID)),
 					}
 				},
+				func(page int) string {
+					return RouteRoot(RootParams{Column: bot.Ptr(parameters.GetColumn()), Filter: bot.Ptr(parameters.GetFilter()), Page: page, Row: bot.Ptr(parameters.GetRow())})
+				},
 				fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.prev")),
 				fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.next")),
 			),
 			[][]bot.Button{
 				{
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.add_button")), CallbackData: bot.CallbackData("route:/todo/add")},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.i18n")), CallbackData: bot.CallbackData("route:/i18n")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.add_button")), CallbackData: bot.CallbackData("route:" + RouteTodoAdd())},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.i18n")), CallbackData: bot.CallbackData("route:" + RouteI18n())},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.back")), CallbackData: bot.CallbackData("route:back")},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -599,7 +828,7 @@ func (p *PageRenderer) pageI18n(ctx context.Context, chatID int64, state *StateP
 			[][]bot.Button{
 				{
 					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.back")), CallbackData: bot.CallbackData("route:back")},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -642,7 +871,7 @@ func (p *PageRenderer) pageTodoAdd(ctx context.Context, chatID int64, state *Sta
 		ButtonGrid: [][]bot.Button{
 			{
 				{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.back")), CallbackData: bot.CallbackData("route:back")},
-				{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("route:/")},
+				{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 			},
 		},
 	}); err != nil {
@@ -666,6 +895,7 @@ func (p *PageRenderer) formTodoAdd(ctx context.Context, chatID int64, url *url.U
 					},
 					Tip: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.add.title_tip")),
 				},
+				Validator: ptr("validateTitle"),
 			},
 		},
 	}
@@ -712,15 +942,15 @@ func (p *PageRenderer) pageTodoID(ctx context.Context, chatID int64, state *Stat
 		ButtonGrid: appendButtonGrids(
 			[][]bot.Button{
 				{
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.toggle_button")), CallbackData: bot.CallbackData(fmt.Sprintf("route:/todo/%v/toggle", parameters.GetID()))},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.delete_button")), CallbackData: bot.CallbackData(fmt.Sprintf("route:/todo/%v/delete", parameters.GetID()))},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.back_list")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.toggle_button")), CallbackData: bot.CallbackData("route:" + RouteTodoToggle(parameters.GetID()))},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.delete_button")), CallbackData: bot.CallbackData("route:" + RouteTodoDelete(parameters.GetID())), Confirm: &bot.Confirm{Message: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.delete_confirm")), YesLabel: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.delete_yes")), NoLabel: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.delete_no"))}},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.back_list")), CallbackData: bot.CallbackData("route:" + RouteRoot())},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.back")), CallbackData: bot.CallbackData("route:back")},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -768,13 +998,13 @@ func (p *PageRenderer) pageTodoDelete(ctx context.Context, chatID int64, state *
 		ButtonGrid: appendButtonGrids(
 			[][]bot.Button{
 				{
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.back_list")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.back_list")), CallbackData: bot.CallbackData("route:" + RouteRoot())},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.back")), CallbackData: bot.CallbackData("route:back")},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -828,14 +1058,14 @@ func (p *PageRenderer) pageTodoToggle(ctx context.Context, chatID int64, state *
 		ButtonGrid: appendButtonGrids(
 			[][]bot.Button{
 				{
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.toggle.back_todo")), CallbackData: bot.CallbackData(fmt.Sprintf("route:/todo/%v", parameters.GetID()))},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.back_list")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.toggle.back_todo")), CallbackData: bot.CallbackData("route:" + RouteTodoID(parameters.GetID()))},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.todo.detail.back_list")), CallbackData: bot.CallbackData("route:" + RouteRoot())},
 				},
 			},
 			[][]bot.Button{
 				{
 					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.back")), CallbackData: bot.CallbackData("route:back")},
-					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("route:/")},
+					{Label: fmt.Sprintf("%v", i18n(ctx, chatID, "content.nav.home")), CallbackData: bot.CallbackData("reset:" + RouteRoot())},
 				},
 			},
 		),
@@ -879,6 +1109,8 @@ func forEach[T any](slice []T, fn func(index int, item T) string) string {
 
 const i18nDefault = "en"
 
+var i18nLanguages = []string{"en", "es", "zh-hans"}
+
 var i18nEntries = map[string]map[string]string{
 	"content.i18n.en": {
 		"en":      "English",
@@ -915,6 +1147,11 @@ var i18nEntries = map[string]map[string]string{
 		"es":      "🌐 Idioma",
 		"zh-hans": "🌐 语言",
 	},
+	"content.nav.start": {
+		"en":      "Open the todo list",
+		"es":      "Abrir la lista de tareas",
+		"zh-hans": "打开待办列表",
+	},
 	"content.todo.add.fail": {
 		"en":      "Failed to add todo: %s ❌",
 		"es":      "No se pudo agregar la tarea: %s ❌",
@@ -960,6 +1197,21 @@ var i18nEntries = map[string]map[string]string{
 		"es":      "🗑️ Eliminar",
 		"zh-hans": "🗑️ 删除",
 	},
+	"content.todo.detail.delete_confirm": {
+		"en":      "Delete this todo?",
+		"es":      "¿Eliminar esta tarea?",
+		"zh-hans": "确定删除这条待办吗？",
+	},
+	"content.todo.detail.delete_no": {
+		"en":      "Cancel",
+		"es":      "Cancelar",
+		"zh-hans": "取消",
+	},
+	"content.todo.detail.delete_yes": {
+		"en":      "🗑️ Delete",
+		"es":      "🗑️ Eliminar",
+		"zh-hans": "🗑️ 删除",
+	},
 	"content.todo.detail.status_done": {
 		"en":      "done ✅",
 		"es":      "completada ✅",
@@ -1071,9 +1323,9 @@ func i18nFallback(key string) string {
 	return key
 }
 
-var navbar = []bot.Button{{Label: fmt.Sprintf("%v", i18nStatic("content.nav.back")), CallbackData: bot.CallbackData("route:back")}, {Label: fmt.Sprintf("%v", i18nStatic("content.nav.home")), CallbackData: bot.CallbackData("route:/")}}
+var navbar = []bot.Button{{Label: fmt.Sprintf("%v", i18nStatic("content.nav.back")), CallbackData: bot.CallbackData("route:back")}, {Label: fmt.Sprintf("%v", i18nStatic("content.nav.home")), CallbackData: bot.CallbackData("reset:" + RouteRoot())}}
 
-func pagination[T any](columns int, rows int, total int, page int, items []T, castFunc func(item T) bot.Button, prevLabel string, nextLabel string) [][]bot.Button {
+func pagination[T any](columns int, rows int, total int, page int, items []T, castFunc func(item T) bot.Button, link func(page int) string, prevLabel string, nextLabel string) [][]bot.Button {
 	var grid [][]bot.Button
 	if rows <= 0 || columns <= 0 {
 		return grid
@@ -1102,14 +1354,14 @@ func pagination[T any](columns int, rows int, total int, page int, items []T, ca
 	if page != 0 {
 		ctrlRow = append(ctrlRow, bot.Button{
 			Label:        prevLabel,
-			CallbackData: bot.CallbackData(fmt.Sprintf("/?row=%d&column=%d&page=%d", rows, columns, page-1)),
+			CallbackData: bot.CallbackData(link(page - 1)),
 		})
 	}
 
 	if !isLastPage {
 		ctrlRow = append(ctrlRow, bot.Button{
 			Label:        nextLabel,
-			CallbackData: bot.CallbackData(fmt.Sprintf("/?row=%d&column=%d&page=%d", rows, columns, page+1)),
+			CallbackData: bot.CallbackData(link(page + 1)),
 		})
 	}
 
@@ -1122,67 +1374,175 @@ func pagination[T any](columns int, rows int, total int, page int, items []T, ca
 
 const (
 	defaultRootColumns = 2
+	defaultRootFilter  = RootFilterAll
 	defaultRootPage    = 0
 	defaultRootRows    = 5
 )
 
-func parseOptionalIntQuery(url *url.URL, key string, defaultValue int) (int, error) {
+// parseQueryParam parses the query parameter key, falling back to defaultValue when it is absent.
+func parseQueryParam[T any](url *url.URL, key string, required bool, defaultValue T, parse func(string) (T, error)) (T, error) {
 	value := url.Query().Get(key)
 	if value == "" {
+		if required {
+			return defaultValue, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
+		}
 		return defaultValue, nil
 	}
-	return ToInt(value)
+	return parseParamValue(key, value, parse)
 }
 
-func parseOptionalInt64Query(url *url.URL, key string, defaultValue int64) (int64, error) {
-	value := url.Query().Get(key)
-	if value == "" {
+// parseQueryParams parses an array query parameter given as repeated or comma-separated values.
+func parseQueryParams[T any](url *url.URL, key string, required bool, defaultValue []T, parse func(string) (T, error)) ([]T, error) {
+	raw := url.Query()[key]
+	var values []string
+	if len(raw) == 1 {
+		values = splitParamValues(raw[0])
+	} else {
+		// repeated keys hold one item each, commas included
+		for _, value := range raw {
+			if value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		if required {
+			return defaultValue, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
+		}
 		return defaultValue, nil
 	}
-	return ToInt64(value)
+	return parseParamValues(key, values, parse)
 }
 
-func parseOptionalStringQuery(url *url.URL, key string, defaultValue string) string {
-	value := url.Query().Get(key)
-	if value == "" {
-		return defaultValue
+func parsePathParam[T any](params routepath.Params, key string, parse func(string) (T, error)) (T, error) {
+	value, ok := params.Get(key)
+	if !ok || value == "" {
+		var zero T
+		return zero, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
 	}
-	return value
+	return parseParamValue(key, value, parse)
 }
 
-func parseParamInt64(params routepath.Params, key string) (int64, error) {
-	value, ok := params.Get(key)
-	if !ok || value == "" {
-		return 0, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", key)
+// parsePathParams parses an array path parameter given as comma-separated values.
+func parsePathParams[T any](params routepath.Params, key string, parse func(string) (T, error)) ([]T, error) {
+	value, _ := params.Get(key)
+	values := splitParamValues(value)
+	if len(values) == 0 {
+		return nil, errors.Wrapf(bot.ErrBadRequest, "missing %s parameter", strings.ToLower(key))
 	}
-	return ToInt64(value)
+	return parseParamValues(key, values, parse)
 }
 
-func ToInt(s string) (int, error) {
-	var i int
-	_, err := fmt.Sscanf(s, "%d", &i)
+func parseParamValue[T any](key string, value string, parse func(string) (T, error)) (T, error) {
+	parsed, err := parse(value)
 	if err != nil {
-		return 0, err
+		return parsed, errors.Wrapf(bot.ErrBadRequest, "invalid %s parameter: %s", strings.ToLower(key), err.Error())
 	}
-	return i, nil
+	return parsed, nil
 }
 
-func ToInt32(s string) (int32, error) {
-	var i int32
-	_, err := fmt.Sscanf(s, "%d", &i)
-	if err != nil {
-		return 0, err
+func parseParamValues[T any](key string, values []string, parse func(string) (T, error)) ([]T, error) {
+	parsed := make([]T, 0, len(values))
+	for _, value := range values {
+		item, err := parseParamValue(key, value, parse)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, item)
 	}
-	return i, nil
+	return parsed, nil
 }
 
-func ToInt64(s string) (int64, error) {
-	var i int64
-	_, err := fmt.Sscanf(s, "%d", &i)
-	if err != nil {
-		return 0, err
+func splitParamValues(value string) []string {
+	var values []string
+	for _, item := range strings.Split(value, ",") {
+		if item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+func formatParam[T any](value T) string {
+	return fmt.Sprint(value)
+}
+
+// addParamValues adds the items of an array query parameter as repeated keys. A single item with a
+// comma gets an empty value after it, so it is not split like a comma-separated list.
+func addParamValues(query url.Values, key string, values []string) {
+	for _, value := range values {
+		query.Add(key, value)
+	}
+	if len(values) == 1 && strings.Contains(values[0], ",") {
+		query.Add(key, "")
+	}
+}
+
+// formatParamValues formats the items of an array parameter.
+func formatParamValues[T any](values []T, format func(T) string) []string {
+	formatted := make([]string, 0, len(values))
+	for _, value := range values {
+		formatted = append(formatted, format(value))
+	}
+	return formatted
+}
+
+func parseString(s string) (string, error) {
+	return s, nil
+}
+
+func ToFloat32(s string) (float32, error) {
+	f, err := strconv.ParseFloat(s, 32)
+	return float32(f), err
+}
+
+func ToFloat64(s string) (float64, error) {
+	return strconv.ParseFloat(s, 64)
+}
+
+func ToBool(s string) (bool, error) {
+	return strconv.ParseBool(s)
+}
+
+// ToUUID checks that s is a UUID such as 123e4567-e89b-12d3-a456-426614174000.
+func ToUUID(s string) (string, error) {
+	if len(s) != 36 {
+		return "", fmt.Errorf("%q is not a uuid", s)
+	}
+	for i, r := range s {
+		switch {
+		case i == 8 || i == 13 || i == 18 || i == 23:
+			if r != '-' {
+				return "", fmt.Errorf("%q is not a uuid", s)
+			}
+		case !strings.ContainsRune("0123456789abcdefABCDEF", r):
+			return "", fmt.Errorf("%q is not a uuid", s)
+		}
+	}
+	return s, nil
+}
+
+// parseInt parses a base 10 integer of bitSize bits. A leading "+" is rejected, so each value has
+// one spelling.
+func parseInt(s string, bitSize int) (int64, error) {
+	if strings.HasPrefix(s, "+") {
+		return 0, errors.Errorf("invalid integer %q", s)
 	}
-	return i, nil
+	return strconv.ParseInt(s, 10, bitSize)
+}
+
+func ToInt(s string) (int, error) {
+	i, err := parseInt(s, strconv.IntSize)
+	return int(i), err
+}
+
+func ToInt32(s string) (int32, error) {
+	i, err := parseInt(s, 32)
+	return int32(i), err
+}
+
+func ToInt64(s string) (int64, error) {
+	return parseInt(s, 64)
 }
 
 func ptr[T any](v T) *T {
@@ -33,7 +33,7 @@ func (h *sampleHandler) HandleError(_ context.Context, _ error, _ int64, _ *bot.
 type sampleCommandHandler struct{}
 
 func (h *sampleCommandHandler) HandleCommandStart(ctx context.Context, chatID int64, b *bot.Bot) error {
-	return b.Route(ctx, chatID, "/")
+	return b.Route(ctx, chatID, RouteRoot())
 }
 
 func main() {
@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/anclax/botx/pkg/core/bot"
+	"github.com/anclax/botx/pkg/core/bot/bottest"
+	"github.com/anclax/botx/pkg/core/bot/telegramtest"
+	"github.com/anclax/botx/pkg/core/session"
+)
+
+func TestTodoFlow(t *testing.T) {
+	ctx := context.Background()
+	driver := NewTestDriver(t, NewTodoStateProvider(NewTodoStore()), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{})
+
+	if err := driver.OpenRoot(ctx); err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	if state := driver.LastPageRoot(); state == nil || state.GetTotal() != 0 {
+		t.Fatalf("expected an empty list, got %+v", state)
+	}
+
+	if err := driver.SubmitTodoAdd(ctx, FormTodoAdd{title: "milk"}); err != nil {
+		t.Fatalf("submit todo: %v", err)
+	}
+	if state := driver.LastPageTodoAdd(); state == nil || !state.GetSuccess() {
+		t.Fatalf("expected the todo to be added, got %+v", state)
+	}
+	driver.Chat.AssertTextContains(t, "Todo added.")
+
+	if err := driver.OpenRoot(ctx); err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	items := driver.LastPageRoot().GetItems()
+	if len(items) != 1 {
+		t.Fatalf("expected one todo, got %+v", items)
+	}
+	if err := driver.Chat.ClickRoute(ctx, "/todo/1"); err != nil {
+		t.Fatalf("click todo: %v", err)
+	}
+	if state := driver.LastPageTodoID(); state == nil || state.GetTitle() != "milk" || state.GetDone() {
+		t.Fatalf("unexpected todo page state: %+v", state)
+	}
+	driver.Chat.AssertParseMode(t, "HTML")
+
+	if err := driver.OpenTodoToggle(ctx, items[0].ID); err != nil {
+		t.Fatalf("toggle todo: %v", err)
+	}
+	if state := driver.LastPageTodoToggle(); state == nil || !state.GetDone() {
+		t.Fatalf("expected the todo to be done, got %+v", state)
+	}
+	if driver.LastPageTodoID() != nil {
+		t.Fatal("expected the todo page to be replaced by the toggle page")
+	}
+
+	if err := driver.OpenTodoID(ctx, 42); err == nil {
+		t.Fatal("expected an error for a missing todo")
+	}
+
+	other := driver.WithChat(2)
+	if err := other.OpenTodoDelete(ctx, items[0].ID); err != nil {
+		t.Fatalf("delete todo: %v", err)
+	}
+	if state := other.LastPageTodoDelete(); state == nil || !state.GetSuccess() {
+		t.Fatalf("expected the todo to be deleted, got %+v", state)
+	}
+	if driver.LastPageTodoDelete() != nil {
+		t.Fatal("expected chats to keep their own pages")
+	}
+}
+
+func TestActionPagesAreNotReplayed(t *testing.T) {
+	ctx := context.Background()
+	store := NewTodoStore()
+	driver := NewTestDriver(t, NewTodoStateProvider(store), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{})
+
+	if err := driver.SubmitTodoAdd(ctx, FormTodoAdd{title: "milk"}); err != nil {
+		t.Fatalf("submit todo: %v", err)
+	}
+	if err := driver.OpenTodoID(ctx, 1); err != nil {
+		t.Fatalf("open todo: %v", err)
+	}
+	if err := driver.OpenTodoToggle(ctx, 1); err != nil {
+		t.Fatalf("toggle todo: %v", err)
+	}
+	done := func() bool {
+		t.Helper()
+		for _, item := range store.List() {
+			if item.ID == 1 {
+				return item.done
+			}
+		}
+		t.Fatal("todo 1 is gone")
+		return false
+	}
+	if !done() {
+		t.Fatal("expected the todo to be done")
+	}
+
+	// switching language re-renders the page the action was run from
+	if err := driver.Chat.SendCallbackData(ctx, "lang:en"); err != nil {
+		t.Fatalf("switch language: %v", err)
+	}
+	if state := driver.LastPageTodoID(); state == nil || !state.GetDone() {
+		t.Fatalf("expected the todo page, got %+v", state)
+	}
+	if !done() {
+		t.Fatal("expected switching language not to toggle the todo again")
+	}
+	driver.Chat.AssertHistory(t, "/", "/todo/add", "/todo/1")
+
+	if err := driver.OpenTodoToggle(ctx, 1); err != nil {
+		t.Fatalf("toggle todo: %v", err)
+	}
+	if err := driver.Back(ctx); err != nil {
+		t.Fatalf("go back: %v", err)
+	}
+	if state := driver.LastPageTodoID(); state == nil || state.GetDone() {
+		t.Fatalf("expected going back to show the open todo, got %+v", state)
+	}
+	if done() {
+		t.Fatal("expected going back not to toggle the todo again")
+	}
+}
+
+func TestPagingReplacesHistory(t *testing.T) {
+	ctx := context.Background()
+	driver := NewTestDriver(t, NewTodoStateProvider(NewTodoStore()), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{})
+
+	if err := driver.OpenI18n(ctx); err != nil {
+		t.Fatalf("open i18n: %v", err)
+	}
+	for page := 0; page < 3; page++ {
+		if err := driver.OpenRoot(ctx, RootParams{Page: page}); err != nil {
+			t.Fatalf("open page %d: %v", page, err)
+		}
+	}
+	driver.Chat.AssertHistory(t, "/", "/i18n", "/?page=2")
+
+	if err := driver.Back(ctx); err != nil {
+		t.Fatalf("go back: %v", err)
+	}
+	if driver.LastPageI18n() == nil {
+		t.Fatal("expected going back to skip the earlier list pages")
+	}
+
+	// the home button starts the history over
+	if err := driver.Chat.SendCallbackData(ctx, bot.CallbackData("reset:"+RouteRoot())); err != nil {
+		t.Fatalf("go home: %v", err)
+	}
+	driver.Chat.AssertHistory(t, "/")
+}
+
+func TestPagingKeepsFilter(t *testing.T) {
+	ctx := context.Background()
+	store := NewTodoStore()
+	for i := 0; i < 12; i++ {
+		store.Add("todo " + strconv.Itoa(i))
+	}
+	driver := NewTestDriver(t, NewTodoStateProvider(store), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{})
+
+	if err := driver.OpenRoot(ctx, RootParams{Filter: bot.Ptr(RootFilterOpen)}); err != nil {
+		t.Fatalf("open root: %v", err)
+	}
+	if err := driver.Chat.Click(ctx, "Next ➡️"); err != nil {
+		t.Fatalf("next page: %v", err)
+	}
+	driver.Chat.AssertHistory(t, "/?column=2&filter=open&page=1&row=5")
+	if err := driver.Chat.Click(ctx, "⬅️ Prev"); err != nil {
+		t.Fatalf("previous page: %v", err)
+	}
+	driver.Chat.AssertHistory(t, "/?column=2&filter=open&row=5")
+}
+
+func TestSnapshots(t *testing.T) {
+	todos := []Todo{*NewTodo(1, "milk", false), *NewTodo(2, "bread", true)}
+	AssertSnapshots(t, "testdata/snapshots", SnapshotFixtures{
+		Root: []SnapshotFixture[StatePageRoot]{
+			{Name: "empty"},
+			{Name: "items", State: NewStatePageRoot(todos, len(todos))},
+		},
+		TodoAdd: []SnapshotFixture[StatePageTodoAdd]{
+			{Name: "success", State: NewStatePageTodoAdd(true, "")},
+			{Name: "failure", State: NewStatePageTodoAdd(false, "title is required")},
+		},
+		TodoID: []SnapshotFixture[StatePageTodoID]{
+			{Route: "/todo/1", State: NewStatePageTodoID(todos[0])},
+		},
+		TodoToggle: []SnapshotFixture[StatePageTodoToggle]{
+			{Route: "/todo/2/toggle", State: NewStatePageTodoToggle(true, "", true)},
+		},
+		TodoDelete: []SnapshotFixture[StatePageTodoDelete]{
+			{Route: "/todo/2/delete", State: NewStatePageTodoDelete(true, "")},
+		},
+	})
+}
+
+func TestRouteBuilders(t *testing.T) {
+	for got, want := range map[string]string{
+		RouteRoot():                    "/",
+		RouteRoot(RootParams{Page: 2}): "/?page=2",
+		RouteRoot(RootParams{Column: bot.Ptr(3), Page: 1}): "/?column=3&page=1",
+		RouteTodoID(42):    "/todo/42",
+		RouteTodoDelete(7): "/todo/7/delete",
+		RouteRoot(RootParams{Filter: bot.Ptr(RootFilterDone)}): "/?filter=done",
+	} {
+		if got != want {
+			t.Errorf("got route %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRouteBuilderSendsZeroOverDefault(t *testing.T) {
+	route := RouteRoot(RootParams{Column: bot.Ptr(0), Filter: bot.Ptr(RootFilterAll)})
+	if route != "/?column=0&filter=all" {
+		t.Fatalf("got route %q", route)
+	}
+	u, err := url.Parse(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := ParseParametersPageRoot(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.GetColumn() != 0 || params.GetRow() != 5 {
+		t.Fatalf("expected column 0 and the default row, got %+v", params)
+	}
+}
+
+func TestArrayQueryParamsKeepCommas(t *testing.T) {
+	for _, items := range [][]string{{"a,b"}, {"a,b", "c"}, {"a", "b"}} {
+		query := url.Values{}
+		addParamValues(query, "tag", items)
+		got, err := parseQueryParams(&url.URL{RawQuery: query.Encode()}, "tag", true, nil, parseString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, "|") != strings.Join(items, "|") {
+			t.Fatalf("sent %q, got %q", items, got)
+		}
+	}
+	// a single value is still read as a comma-separated list
+	got, err := parseQueryParams(&url.URL{RawQuery: "tag=a,b"}, "tag", true, nil, parseString)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("got %q, %v", got, err)
+	}
+}
+
+func TestParseEnumParameter(t *testing.T) {
+	params, err := ParseParametersPageRoot(&url.URL{Path: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.GetFilter() != RootFilterAll {
+		t.Fatalf("expected the default filter, got %q", params.GetFilter())
+	}
+	params, err = ParseParametersPageRoot(&url.URL{Path: "/", RawQuery: "filter=open"})
+	if err != nil || params.GetFilter() != RootFilterOpen {
+		t.Fatalf("got %+v, %v", params, err)
+	}
+	if _, err := ParseParametersPageRoot(&url.URL{Path: "/", RawQuery: "filter=later"}); !errors.Is(err, bot.ErrBadRequest) {
+		t.Fatalf("expected a bad request for an unknown filter, got %v", err)
+	}
+}
+
+func TestParseIntParameter(t *testing.T) {
+	params, err := ParseParametersPageRoot(&url.URL{Path: "/", RawQuery: "page=2"})
+	if err != nil || params.GetPage() != 2 {
+		t.Fatalf("got %+v, %v", params, err)
+	}
+	for _, query := range []string{"page=2abc", "page=+1", "page=1.5", "row=99999999999999999999"} {
+		if _, err := ParseParametersPageRoot(&url.URL{Path: "/", RawQuery: query}); !errors.Is(err, bot.ErrBadRequest) {
+			t.Errorf("expected a bad request for %q, got %v", query, err)
+		}
+	}
+}
+
+func TestDeleteAsksForConfirmation(t *testing.T) {
+	ctx := context.Background()
+	store := NewTodoStore()
+	driver := NewTestDriver(t, NewTodoStateProvider(store), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{})
+
+	if err := driver.SubmitTodoAdd(ctx, FormTodoAdd{title: "milk"}); err != nil {
+		t.Fatalf("submit todo: %v", err)
+	}
+	if err := driver.OpenTodoID(ctx, 1); err != nil {
+		t.Fatalf("open todo: %v", err)
+	}
+
+	if err := driver.Chat.Click(ctx, "🗑️ Delete"); err != nil {
+		t.Fatalf("click delete: %v", err)
+	}
+	driver.Chat.AssertText(t, "Delete this todo?")
+	driver.Chat.AssertButtons(t, [][]string{{"🗑️ Delete", "Cancel"}})
+	if err := driver.Chat.Click(ctx, "Cancel"); err != nil {
+		t.Fatalf("click cancel: %v", err)
+	}
+	driver.Chat.AssertTextContains(t, "milk")
+	if len(store.List()) != 1 {
+		t.Fatal("expected cancel to keep the todo")
+	}
+
+	if err := driver.Chat.Click(ctx, "🗑️ Delete"); err != nil {
+		t.Fatalf("click delete: %v", err)
+	}
+	yes := driver.Chat.LastMessage().ButtonGrid[0][0].CallbackData
+	if err := driver.Chat.SendCallbackData(ctx, yes); err != nil {
+		t.Fatalf("confirm delete: %v", err)
+	}
+	if state := driver.LastPageTodoDelete(); state == nil || !state.GetSuccess() {
+		t.Fatalf("expected the todo to be deleted, got %+v", state)
+	}
+
+	// the answered confirmation cannot be replayed
+	if err := driver.Chat.SendCallbackData(ctx, yes); err != nil {
+		t.Fatalf("replay confirmation: %v", err)
+	}
+	if errs := driver.Chat.Errors(); len(errs) != 1 || !errors.Is(errs[0], bot.ErrBadRequest) {
+		t.Fatalf("expected the replay to be rejected, got %v", errs)
+	}
+}
+
+func TestSignedCallbacks(t *testing.T) {
+	ctx := context.Background()
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := bottest.New(sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := bot.NewCallbackSigner([]byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewTodoStore()
+	Register(conn, sm, NewTodoStateProvider(store), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{}, bot.WithCallbackSigner(signer))
+
+	chat := conn.Chat(1)
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(chat.SendText(ctx, "/start"))
+	must(chat.Click(ctx, "➕ Add Todo"))
+	must(chat.FillForm(ctx, bot.FormValues{"title": "milk"}))
+	chat.AssertTextContains(t, "Todo added.")
+	must(chat.SendText(ctx, "/start"))
+	must(chat.Click(ctx, "[ ] milk"))
+	// the confirmation keeps the signed callback of the delete button
+	must(chat.Click(ctx, "🗑️ Delete"))
+	must(chat.Click(ctx, "🗑️ Delete"))
+	chat.AssertTextContains(t, "Todo deleted.")
+	if errs := chat.Errors(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	for _, data := range []string{
+		bot.RouteCallbackData("/todo/add"),
+		signer.Sign(2, bot.RouteCallbackData("/todo/add")),
+		signer.Sign(1, bot.RouteCallbackData("/todo/add")) + "x",
+		signer.Sign(1, bot.SubmitForm(`/todo/add?values={"title":""}`)),
+	} {
+		must(chat.SendCallbackData(ctx, data))
+	}
+	errs := chat.Errors()
+	if len(errs) != 4 {
+		t.Fatalf("expected every forged callback to fail, got %v", errs)
+	}
+	for _, err := range errs {
+		if !errors.Is(err, bot.ErrBadRequest) {
+			t.Fatalf("expected a bad request, got %v", err)
+		}
+	}
+	if len(store.List()) != 0 {
+		t.Fatalf("expected the forged submit to add nothing, got %+v", store.List())
+	}
+}
+
+func TestDeepLinks(t *testing.T) {
+	ctx := context.Background()
+	store := NewTodoStore()
+	for i := 1; i <= 42; i++ {
+		store.Add("todo " + strconv.Itoa(i))
+	}
+	driver := NewTestDriver(t, NewTodoStateProvider(store), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{})
+
+	if link := DeepLinkTodoID("@todolist_bot", 42); link != "https://t.me/todolist_bot?start=todo_42" {
+		t.Fatalf("got deep link %q", link)
+	}
+
+	if err := driver.Chat.SendText(ctx, "/start todo_42"); err != nil {
+		t.Fatalf("open deep link: %v", err)
+	}
+	if state := driver.LastPageTodoID(); state == nil || state.GetTitle() != "todo 42" {
+		t.Fatalf("expected the deep link to open todo 42, got %+v", state)
+	}
+	driver.Chat.AssertHistory(t, "/", "/todo/42")
+
+	// a payload that matches no deep link is an ordinary /start
+	other := driver.WithChat(2)
+	if err := other.Chat.SendText(ctx, "/start todo_abc"); err != nil {
+		t.Fatalf("open unknown deep link: %v", err)
+	}
+	if state := other.LastPageRoot(); state == nil || state.GetTotal() != 42 {
+		t.Fatalf("expected /start to open the list, got %+v", state)
+	}
+	other.Chat.AssertHistory(t, "/")
+}
+
+func TestCommandMenusRegisterWithTelegram(t *testing.T) {
+	server := telegramtest.NewServer()
+	t.Cleanup(server.Close)
+	sm, err := session.NewMemorySessionManager()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connector, err := bot.NewTelegramBot("123:test", sm, nil, bot.WithTelegramAPIURL(server.APIURL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Register(connector, sm, NewTodoStateProvider(NewTodoStore()), &TodoFormValidator{}, &sampleHandler{}, &sampleCommandHandler{})
+	if err := connector.(*bot.TelegramBot).RegisterCommands(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	menus := map[string]string{}
+	for _, call := range server.Calls() {
+		if call.Method == "setMyCommands" {
+			menus[call.Params["scope"]+" "+call.Params["language_code"]] = call.Params["commands"]
+		}
+	}
+	// the fallback menu and one per i18n language, zh-hans registered as zh, in both scopes of /start
+	if len(menus) != 8 {
+		t.Fatalf("expected 8 menus, got %v", menus)
+	}
+	for key, want := range map[string]string{
+		`{"type":"all_private_chats"} zh`: `[{"command":"start","description":"打开待办列表"}]`,
+		`{"type":"all_group_chats"} `:     `[{"command":"start","description":"Open the todo list"}]`,
+	} {
+		if got := menus[key]; got != want {
+			t.Fatalf("menu %s: got %s, want %s", key, got, want)
+		}
+	}
+}
@@ -17,7 +17,20 @@ func NewTodoStateProvider(store *TodoStore) *TodoStateProvider {
 }
 
 func (p *TodoStateProvider) ProvideRootState(ctx context.Context, chatID int64, parameters *ParametersPageRoot) (*StatePageRoot, error) {
-	items := p.store.List()
+	items := make([]Todo, 0)
+	for _, item := range p.store.List() {
+		switch parameters.GetFilter() {
+		case RootFilterOpen:
+			if item.done {
+				continue
+			}
+		case RootFilterDone:
+			if !item.done {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
 	return NewStatePageRoot(items, len(items)), nil
 }
 
@@ -71,7 +84,7 @@ func (p *TodoStateProvider) ProvideTodoDeleteState(ctx context.Context, chatID i
 
 type TodoFormValidator struct{}
 
-func (v *TodoFormValidator) ValidateFormTodoAdd(ctx context.Context, chatID int64, url *url.URL, input string) (*bot.ValidateResult, error) {
+func (v *TodoFormValidator) ValidateTitle(ctx context.Context, chatID int64, url *url.URL, field string, input string, values bot.FormValues) (*bot.ValidateResult, error) {
 	value := strings.TrimSpace(input)
 	if value == "" {
 		return &bot.ValidateResult{Valid: false, ErrorMessage: "title is required"}, nil